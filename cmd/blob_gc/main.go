@@ -0,0 +1,110 @@
+// main package for the orphaned blob garbage collector
+// This script lists the objects in the blob store, compares them with the blobs
+// nodes reference, and reports orphaned objects and references to missing ones.
+// It runs as a dry run by default; pass -dry-run=false to delete orphans older
+// than the grace period.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/services"
+)
+
+func init() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("Warning: Error loading .env file")
+	}
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", true, "only report orphans, do not delete them")
+	grace := flag.Duration("grace", services.DefaultBlobGCGracePeriod, "keep orphans younger than this")
+	yes := flag.Bool("yes", false, "do not ask for confirmation before deleting")
+	reportPath := flag.String("report", "", "write the full report as JSON to this file")
+	flag.Parse()
+
+	log.Println("=== Orphaned Blob Collection ===")
+	database.ConnectDB()
+	if err := services.InitBlobStore(); err != nil {
+		log.Fatalf("Failed to initialize blob storage: %v", err)
+	}
+
+	if !*dryRun && !*yes {
+		fmt.Printf("Delete unreferenced blobs older than %s? (y/n): ", *grace)
+		var confirmation string
+		fmt.Scanln(&confirmation)
+		if confirmation != "y" && confirmation != "Y" {
+			log.Println("Aborted by user")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := services.CollectOrphanedBlobs(ctx, services.BlobGCOptions{GracePeriod: *grace, DryRun: *dryRun})
+	if err != nil {
+		log.Fatalf("Blob collection failed: %v", err)
+	}
+	printReport(report)
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, report); err != nil {
+			log.Printf("Failed to write report: %v", err)
+		} else {
+			log.Printf("Report written to %s", *reportPath)
+		}
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *services.BlobGCReport) {
+	action := "deleted"
+	if report.DryRun {
+		action = "would delete"
+	}
+	for _, blob := range report.Orphans {
+		log.Printf("  [orphan] %s %q (%d bytes, created %s): %s", blob.ID, blob.Name, blob.Size, blob.CreatedAt.Format(time.RFC3339), action)
+	}
+	for _, blob := range report.Recent {
+		log.Printf("  [recent] %s %q (%d bytes, created %s): kept", blob.ID, blob.Name, blob.Size, blob.CreatedAt.Format(time.RFC3339))
+	}
+	for _, ref := range report.Missing {
+		log.Printf("  [missing] %s referenced by note %s of %s", ref.BlobID, ref.NoteID, ref.OwnerID)
+	}
+
+	log.Printf("\n=== Summary ===")
+	if report.DryRun {
+		log.Printf("Dry run - nothing was deleted (use -dry-run=false to delete)")
+	}
+	log.Printf("Backend: %s", report.Backend)
+	log.Printf("Blobs listed: %d", report.Listed)
+	log.Printf("Blobs referenced: %d", report.Referenced)
+	log.Printf("Orphans: %d", len(report.Orphans))
+	log.Printf("Orphans within grace period: %d", len(report.Recent))
+	log.Printf("Missing: %d", len(report.Missing))
+	if !report.DryRun {
+		log.Printf("Deleted: %d", report.Deleted)
+		log.Printf("Failed: %d", report.Failed)
+	}
+}
+
+func writeReport(path string, report *services.BlobGCReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
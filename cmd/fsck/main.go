@@ -0,0 +1,123 @@
+// main package for the node tree consistency checker
+// This script checks every user's node tree for orphans, dangling children, cycles,
+// drifted note counts, mastery and storage usage, and missing or orphaned caption embeddings.
+// It runs as a dry run by default; pass -fix to repair what it finds.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/services"
+)
+
+func init() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("Warning: Error loading .env file")
+	}
+}
+
+func main() {
+	owner := flag.String("owner", "", "only check this user's tree (email)")
+	fix := flag.Bool("fix", false, "repair the issues found (default is a dry run)")
+	yes := flag.Bool("yes", false, "do not ask for confirmation before repairing")
+	reportPath := flag.String("report", "", "write the full report as JSON to this file")
+	flag.Parse()
+
+	log.Println("=== Node Tree Consistency Check ===")
+	database.ConnectDB()
+
+	if *fix {
+		// Missing captions are repaired by queueing caption jobs
+		if err := services.InitQueueService(); err != nil {
+			log.Printf("Warning: queue service unavailable, missing captions will not be re-queued: %v", err)
+		}
+
+		if !*yes {
+			fmt.Print("Repair the node trees in place? (y/n): ")
+			var confirmation string
+			fmt.Scanln(&confirmation)
+			if confirmation != "y" && confirmation != "Y" {
+				log.Println("Aborted by user")
+				return
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := services.CheckTree(ctx, services.TreeCheckOptions{OwnerID: *owner, Fix: *fix})
+	if report != nil {
+		printReport(report)
+		if *reportPath != "" {
+			if err := writeReport(*reportPath, report); err != nil {
+				log.Printf("Failed to write report: %v", err)
+			} else {
+				log.Printf("Report written to %s", *reportPath)
+			}
+		}
+	}
+	if err != nil {
+		log.Fatalf("Tree check failed: %v", err)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *services.TreeCheckReport) {
+	for _, issue := range report.Issues {
+		status := "would " + issue.Fix
+		if !report.DryRun {
+			switch {
+			case issue.Fixed:
+				status = "fixed: " + issue.Fix
+			case issue.Error != "":
+				status = "FAILED: " + issue.Error
+			default:
+				status = "not fixed"
+			}
+		}
+		log.Printf("  [%s] %s %s: %s (%s)", issue.Kind, issue.OwnerID, issue.NodeID, issue.Detail, status)
+	}
+
+	log.Printf("\n=== Summary ===")
+	if report.DryRun {
+		log.Printf("Dry run - nothing was changed (use -fix to repair)")
+	}
+	log.Printf("Owners checked: %d", report.Owners)
+	log.Printf("Nodes checked: %d", report.NodesChecked)
+
+	kinds := make([]string, 0, len(report.Counts))
+	for kind := range report.Counts {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		log.Printf("  %s: %d", kind, report.Counts[services.TreeIssueKind(kind)])
+	}
+	log.Printf("Issues: %d", len(report.Issues))
+	if !report.DryRun {
+		log.Printf("Fixed: %d", report.Fixed)
+		log.Printf("Failed: %d", report.Failed)
+	}
+}
+
+func writeReport(path string, report *services.TreeCheckReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
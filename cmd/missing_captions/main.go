@@ -1,66 +1,23 @@
 // main package for missing captions script
-// This script truncates caption_embeddings and regenerates captions/embeddings for all note nodes
+// This script generates captions and embeddings for note nodes that have none yet.
+// Existing captions are left alone: moving them to a new embedding model is done
+// by the server's re-embedding worker (see services.InitEmbeddingVersions).
 package main
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"time"
 
-	"encoding/json"
-
 	"github.com/joho/godotenv"
-	"github.com/openai/openai-go"
-	oaioption "github.com/openai/openai-go/option"
-	"github.com/openai/openai-go/shared"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/oauth2"
-	"google.golang.org/api/drive/v3"
-	"google.golang.org/api/option"
-)
-
-type NodeType string
-
-const (
-	NodeTypeFolder NodeType = "folder"
-	NodeTypeNote   NodeType = "note"
-)
-
-type NodeMetadata struct {
-	Type    NodeType `bson:"type"`
-	DriveID string   `bson:"driveId,omitempty"`
-}
-
-type Node struct {
-	ID        string       `bson:"_id"`
-	Name      string       `bson:"name"`
-	ParentID  string       `bson:"parentId"`
-	Metadata  NodeMetadata `bson:"metadata"`
-	OwnerID   string       `bson:"ownerId"`
-	PublicURL string       `bson:"publicUrl,omitempty"`
-}
 
-type CaptionEmbedding struct {
-	ID        string    `bson:"_id,omitempty"`
-	NoteID    string    `bson:"noteId"`
-	FolderID  string    `bson:"folderId"`
-	OwnerID   string    `bson:"ownerId"`
-	Caption   string    `bson:"caption"`
-	Vector    []float32 `bson:"vector"`
-	CreatedAt time.Time `bson:"createdAt"`
-	UpdatedAt time.Time `bson:"updatedAt"`
-}
-
-var (
-	mongoClient *mongo.Client
-	aiClient    openai.Client
-	driveSrv    *drive.Service
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/models"
+	"cogniscan/backend/internal/services"
 )
 
 func init() {
@@ -72,7 +29,7 @@ func init() {
 
 func main() {
 	log.Println("=== Missing Captions Script ===")
-	log.Println("This script will truncate caption_embeddings and regenerate captions for all note nodes.")
+	log.Println("This script will generate captions for note nodes that do not have one yet.")
 
 	// Initialize services
 	if err := initServices(); err != nil {
@@ -80,52 +37,22 @@ func main() {
 	}
 	log.Println("All services initialized successfully")
 
-	// Truncate caption_embeddings collection
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		dbName = "cogniscan"
-	}
-
-	log.Println("\n=== Truncating caption_embeddings collection ===")
-	embeddingsCollection := mongoClient.Database(dbName).Collection("caption_embeddings")
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	count, err := embeddingsCollection.CountDocuments(ctx, bson.M{})
-	if err != nil {
-		log.Fatalf("Failed to count caption_embeddings: %v", err)
-	}
-
-	if count > 0 {
-		fmt.Printf("Found %d existing caption embeddings. Delete and regenerate? (y/n): ", count)
-		var confirmation string
-		fmt.Scanln(&confirmation)
-		if confirmation != "y" && confirmation != "Y" {
-			log.Println("Aborted by user")
-			return
-		}
-
-		if err := embeddingsCollection.Drop(ctx); err != nil {
-			log.Fatalf("Failed to drop caption_embeddings collection: %v", err)
-		}
-		log.Printf("Dropped caption_embeddings collection (deleted %d documents)", count)
-	} else {
-		log.Println("caption_embeddings collection is empty")
-	}
-
-	// Find all note nodes
-	log.Println("\n=== Finding all note nodes ===")
-	nodes, err := findAllNoteNodes(ctx, dbName)
+	// Find note nodes without a caption
+	log.Println("\n=== Finding note nodes without captions ===")
+	nodes, err := findUncaptionedNoteNodes(ctx)
 	if err != nil {
 		log.Fatalf("Failed to find note nodes: %v", err)
 	}
 
 	if len(nodes) == 0 {
-		log.Println("No note nodes found!")
+		log.Println("Every note node already has a caption!")
 		return
 	}
 
-	log.Printf("Found %d note nodes\n", len(nodes))
+	log.Printf("Found %d note nodes without captions\n", len(nodes))
 
 	// Ask for confirmation
 	fmt.Printf("Process %d note nodes? (y/n): ", len(nodes))
@@ -141,9 +68,9 @@ func main() {
 	failureCount := 0
 
 	for i, node := range nodes {
-		log.Printf("\n[%d/%d] Processing note node: %s (ID: %s)", i+1, len(nodes), node.Name, node.ID)
+		log.Printf("\n[%d/%d] Processing note node: %s (ID: %s)", i+1, len(nodes), node.Name, node.ID.Hex())
 
-		if err := processNoteNode(node, dbName); err != nil {
+		if err := processNoteNode(node); err != nil {
 			log.Printf("  ERROR: Failed to process note node: %v", err)
 			failureCount++
 		} else {
@@ -164,229 +91,120 @@ func main() {
 }
 
 func initServices() error {
-	if err := initMongoDB(); err != nil {
-		return fmt.Errorf("MongoDB: %w", err)
+	database.ConnectDB()
+
+	if err := services.InitBlobStore(); err != nil {
+		return fmt.Errorf("blob storage: %w", err)
 	}
-	if err := initAIService(); err != nil {
+	if err := services.InitAIService(); err != nil {
 		return fmt.Errorf("AI Service: %w", err)
 	}
-	if err := initDriveService(); err != nil {
-		return fmt.Errorf("Drive Service: %w", err)
-	}
-	return nil
-}
-
-func initMongoDB() error {
-	log.Println("Initializing MongoDB...")
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		return fmt.Errorf("MONGO_URI environment variable not set")
-	}
-
-	client, err := mongo.NewClient(options.Client().ApplyURI(mongoURI))
-	if err != nil {
-		return err
+	if services.GetAIProvider() == nil {
+		return fmt.Errorf("AI Service: no AI provider configured")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// New captions are embedded with every writable embedding version
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
-
-	if err := client.Connect(ctx); err != nil {
-		return err
-	}
-
-	if err := client.Ping(ctx, nil); err != nil {
-		return err
-	}
-
-	mongoClient = client
-	log.Println("  MongoDB connected")
-	return nil
-}
-
-func initAIService() error {
-	log.Println("Initializing AI Service...")
-	apiKey := os.Getenv("NVIDIA_API_KEY")
-	if apiKey == "" {
-		return fmt.Errorf("NVIDIA_API_KEY environment variable not set")
-	}
-
-	aiClient = openai.NewClient(
-		oaioption.WithAPIKey(apiKey),
-		oaioption.WithBaseURL("https://integrate.api.nvidia.com/v1/"),
-	)
-
-	log.Println("  AI Service initialized")
-	return nil
-}
-
-func initDriveService() error {
-	log.Println("Initializing Drive Service...")
-	credentialsJSONString := os.Getenv("COGNI_BACKEND")
-	if credentialsJSONString == "" {
-		return fmt.Errorf("COGNI_BACKEND environment variable not set")
-	}
-
-	srv, err := drive.NewService(context.Background(), option.WithCredentialsFile("service-account.json"))
-	if err != nil {
-		return fmt.Errorf("unable to retrieve Drive client: %v", err)
+	if err := services.InitEmbeddingVersions(ctx); err != nil {
+		return fmt.Errorf("embedding versions: %w", err)
 	}
-
-	driveSrv = srv
-	log.Println("  Drive Service initialized")
 	return nil
 }
 
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
+func findUncaptionedNoteNodes(ctx context.Context) ([]models.Node, error) {
+	captioned, err := database.Client.Database(os.Getenv("DB_NAME")).Collection("caption_embeddings").
+		Distinct(ctx, "noteId", bson.M{})
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-func saveToken(file string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", file)
-	f, err := os.Create(file)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
-
-func findAllNoteNodes(ctx context.Context, dbName string) ([]Node, error) {
-	nodesCollection := mongoClient.Database(dbName).Collection("nodes")
-
-	filter := bson.M{
-		"metadata.type": NodeTypeNote,
+	captionedIDs := make(map[string]bool, len(captioned))
+	for _, id := range captioned {
+		if s, ok := id.(string); ok {
+			captionedIDs[s] = true
+		}
 	}
 
-	cursor, err := nodesCollection.Find(ctx, filter)
+	cursor, err := services.GetNodesCollection().Find(ctx, bson.M{"metadata.type": models.NodeTypeNote})
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var nodes []Node
-	if err := cursor.All(ctx, &nodes); err != nil {
-		return nil, err
+	var nodes []models.Node
+	for cursor.Next(ctx) {
+		var node models.Node
+		if err := cursor.Decode(&node); err != nil {
+			continue
+		}
+		if !captionedIDs[node.ID.Hex()] {
+			nodes = append(nodes, node)
+		}
 	}
-
-	return nodes, nil
+	return nodes, cursor.Err()
 }
 
-func processNoteNode(node Node, dbName string) error {
-	if node.Metadata.DriveID == "" {
-		return fmt.Errorf("note node has no Drive ID")
-	}
-
-	// 1. Download image from Drive
-	resp, err := driveSrv.Files.Get(node.Metadata.DriveID).Download()
-	if err != nil {
-		return fmt.Errorf("failed to download image: %w", err)
+func processNoteNode(node models.Node) error {
+	if len(services.NotePages(&node)) == 0 {
+		return fmt.Errorf("note node has no pages")
 	}
-	defer resp.Body.Close()
 
-	imageBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read image bytes: %w", err)
-	}
-
-	// 2. Generate caption
-	caption, err := generateCaption(imageBytes)
-	if err != nil {
-		return fmt.Errorf("failed to generate caption: %w", err)
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
 
-	log.Printf("  Generated caption: %s", caption)
+	if len(node.Pages) == 0 {
+		transcription, err := transcribe(ctx, node.Metadata.DriveID)
+		if err != nil {
+			return err
+		}
 
-	// 3. Generate embedding
-	vector, err := generateEmbedding(caption)
-	if err != nil {
-		return fmt.Errorf("failed to generate embedding: %w", err)
+		// Embed and store it in caption_embeddings / caption_chunks
+		caption := services.TranscriptionText(transcription)
+		if err := services.EmbedAndStoreCaption(node.ID.Hex(), node.ParentID, node.OwnerID, caption, nil); err != nil {
+			return fmt.Errorf("failed to store embedding: %w", err)
+		}
+		return services.SetNoteTranscription(ctx, node.ID.Hex(), transcription)
 	}
 
-	log.Printf("  Generated embedding (dim: %d)", len(vector))
-
-	// 4. Store in caption_embeddings collection
-	embeddingsCollection := mongoClient.Database(dbName).Collection("caption_embeddings")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	embedding := CaptionEmbedding{
-		NoteID:    node.ID,
-		FolderID:  node.ParentID,
-		OwnerID:   node.OwnerID,
-		Caption:   caption,
-		Vector:    vector,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	// Multi-page notes: transcribe the pages still missing one, then embed the assembled caption
+	latest := &node
+	for _, page := range node.Pages {
+		if page.Caption != "" || page.DriveID == "" {
+			continue
+		}
+		transcription, err := transcribe(ctx, page.DriveID)
+		if err != nil {
+			return fmt.Errorf("page %s: %w", page.ID, err)
+		}
+		caption := services.TranscriptionText(transcription)
+		if latest, err = services.SetNotePageCaption(ctx, node.ID.Hex(), page.ID, caption, transcription); err != nil {
+			return fmt.Errorf("failed to store caption of page %s: %w", page.ID, err)
+		}
 	}
-
-	if _, err := embeddingsCollection.InsertOne(ctx, embedding); err != nil {
+	if err := services.EmbedNoteCaption(ctx, latest); err != nil {
 		return fmt.Errorf("failed to store embedding: %w", err)
 	}
-
 	return nil
 }
 
-func generateCaption(imageBytes []byte) (string, error) {
-	base64Image := base64.StdEncoding.EncodeToString(imageBytes)
-	content := fmt.Sprintf(`<img src="data:image/jpeg;base64,%s">
-
-Transcribe all visible text from this image. Describe any diagrams or tables with their labels. Copy mathematical formulas exactly. Do not use templates or placeholders - provide actual content only.`, base64Image)
-
-	ctx := context.Background()
-	completion, err := aiClient.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(content),
-		},
-		// Model:       shared.ChatModel("microsoft/phi-3.5-vision-instruct"),
-		Model:       shared.ChatModel("microsoft/phi-4-multimodal-instruct"),
-		MaxTokens:   openai.Int(2048),
-		Temperature: openai.Float(0.30),
-		TopP:        openai.Float(0.70),
-	})
-
+// transcribe downloads an image from blob storage and generates its transcription
+func transcribe(ctx context.Context, driveID string) (*models.Transcription, error) {
+	blob, err := services.DownloadBlob(ctx, driveID)
 	if err != nil {
-		return "", err
-	}
-
-	if len(completion.Choices) == 0 {
-		return "", fmt.Errorf("no response from AI model")
+		return nil, fmt.Errorf("failed to download image: %w", err)
 	}
+	defer blob.Body.Close()
 
-	result := completion.Choices[0].Message.Content
-	return result, nil
-}
-
-func generateEmbedding(text string) ([]float32, error) {
-	ctx := context.Background()
-
-	embeddingsResponse, err := aiClient.Embeddings.New(ctx, openai.EmbeddingNewParams{
-		Input: openai.EmbeddingNewParamsInputUnion{
-			OfArrayOfStrings: []string{text},
-		},
-		Model: openai.EmbeddingModel("nvidia/llama-nemotron-embed-1b-v2"),
-	}, oaioption.WithJSONSet("input_type", "passage"), oaioption.WithJSONSet("truncate", "NONE"))
-
+	imageBytes, err := io.ReadAll(blob.Body)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read image bytes: %w", err)
 	}
 
-	if len(embeddingsResponse.Data) == 0 {
-		return nil, fmt.Errorf("no embedding data returned from AI model")
-	}
-
-	vec := embeddingsResponse.Data[0].Embedding
-	result := make([]float32, len(vec))
-	for i, v := range vec {
-		result[i] = float32(v)
+	transcription, err := services.GenerateTranscription(imageBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate caption: %w", err)
 	}
 
-	return result, nil
+	log.Printf("  Generated caption: %s", services.TranscriptionText(transcription))
+	return transcription, nil
 }
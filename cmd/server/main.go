@@ -3,15 +3,18 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"cogniscan/backend/internal/cache"
 	"cogniscan/backend/internal/database"
 	"cogniscan/backend/internal/handlers"
 	"cogniscan/backend/internal/middleware"
+	"cogniscan/backend/internal/migrations"
 	"cogniscan/backend/internal/queue"
 	"cogniscan/backend/internal/services"
 	"cogniscan/backend/internal/workers"
@@ -35,35 +38,16 @@ func main() {
 		// Continue without Redis - graceful degradation
 	}
 
-	// Check if token.json exists, if not create it
-	if _, err := os.Stat("service-account.json"); os.IsNotExist(err) {
-		token := os.Getenv("KEY_DATA_N")
-		if token == "" {
-			log.Println("KEY_DATA_N environment variable not set")
-			return
-		}
-
-		var tokenJson map[string]interface{}
-		err = json.Unmarshal([]byte(token), &tokenJson)
-		if err != nil {
-			log.Fatalf("error parsing service-account.json: %v\n", err)
-		}
-		tokenJson["private_key"] = strings.ReplaceAll(tokenJson["private_key"].(string), "\\n", "\n")
-
-		tokenBytes, err := json.Marshal(tokenJson)
-		if err != nil {
-			log.Fatalf("error marshaling service-account.json: %v\n", err)
-		}
-
-		err = os.WriteFile("service-account.json", tokenBytes, 0644)
-		if err != nil {
-			log.Fatalf("error writing service-account.json: %v\n", err)
+	// The Drive backend authenticates with service-account.json, written from KEY_DATA_N if missing
+	if services.StorageBackend() == services.StorageBackendDrive {
+		if err := writeServiceAccountFile(); err != nil {
+			log.Fatalf("Failed to prepare service-account.json: %v", err)
 		}
 	}
 
-	// Initialize Google Drive Service
-	if err := services.InitDriveService(); err != nil {
-		log.Fatalf("Failed to initialize Drive Service: %v", err)
+	// Initialize blob storage (Google Drive, MEGA or local disk)
+	if err := services.InitBlobStore(); err != nil {
+		log.Fatalf("Failed to initialize blob storage: %v", err)
 	}
 
 	// Initialize AI Service for caption generation
@@ -84,6 +68,44 @@ func main() {
 		// Continue without queue service - caption generation will be disabled
 	}
 
+	// Initialize the trash (deleted nodes waiting to be purged)
+	trashCtx, trashCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := services.InitTrashService(trashCtx); err != nil {
+		log.Printf("Warning: Failed to initialize Trash Service: %v", err)
+	}
+	trashCancel()
+
+	// Node mutations run in transactions when the deployment supports them, else via the outbox
+	mutationsCtx, mutationsCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := services.InitNodeMutations(mutationsCtx); err != nil {
+		log.Printf("Warning: Failed to initialize node mutations: %v", err)
+	}
+	mutationsCancel()
+
+	// Initialize node tags and smart collections
+	tagsCtx, tagsCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := services.InitTagService(tagsCtx); err != nil {
+		log.Printf("Warning: Failed to initialize Tag Service: %v", err)
+	}
+	tagsCancel()
+
+	// Give nodes written before ancestor paths existed their path
+	pathsCtx, pathsCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	if err := migrations.BackfillAncestorPaths(pathsCtx); err != nil {
+		log.Printf("Warning: Failed to backfill node ancestor paths: %v", err)
+	}
+	pathsCancel()
+
+	// Expire finished job records
+	jobsCtx, jobsCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := services.InitJobService(jobsCtx); err != nil {
+		log.Printf("Warning: Failed to initialize Job Service: %v", err)
+	}
+	jobsCancel()
+
+	// Relay job events between server instances for the /events stream
+	services.StartJobEventRelay(context.Background())
+
 	// Initialize Mastery Queue for background mastery propagation
 	masteryWorkerCount := 2
 	if mwc := os.Getenv("MASTERY_WORKER_COUNT"); mwc != "" {
@@ -91,7 +113,13 @@ func main() {
 			masteryWorkerCount = n
 		}
 	}
-	masteryQueue := queue.NewMasteryQueue(masteryWorkerCount, nil)
+	masteryMaxRetry := 3
+	if mmr := os.Getenv("MASTERY_MAX_RETRY"); mmr != "" {
+		if n, err := strconv.Atoi(mmr); err == nil && n >= 0 {
+			masteryMaxRetry = n
+		}
+	}
+	masteryQueue := queue.NewMasteryQueue(masteryWorkerCount, masteryMaxRetry, services.GetMasteryJobsCollection(), services.UpdateAncestorMasteryAsync)
 	services.SetMasteryQueue(masteryQueue)
 	masteryQueue.Start()
 
@@ -145,6 +173,59 @@ func main() {
 
 	log.Printf("[Main] Quiz workers started with count: %d", quizWorkerCount)
 
+	// Start the re-embedding worker, which moves captions to a new embedding model
+	reembedInterval := 30 * time.Second
+	if ri := os.Getenv("REEMBED_INTERVAL"); ri != "" {
+		if d, err := time.ParseDuration(ri); err == nil && d > 0 {
+			reembedInterval = d
+		}
+	}
+	reembedBatchSize := 50
+	if rbs := os.Getenv("REEMBED_BATCH_SIZE"); rbs != "" {
+		if n, err := strconv.Atoi(rbs); err == nil && n > 0 {
+			reembedBatchSize = n
+		}
+	}
+	workers.StartReembedWorker(mainCtx, reembedInterval, reembedBatchSize)
+
+	// Start the trash purger, which deletes trashed nodes once their retention is over
+	trashPurgeInterval := time.Hour
+	if tpi := os.Getenv("TRASH_PURGE_INTERVAL"); tpi != "" {
+		if d, err := time.ParseDuration(tpi); err == nil && d > 0 {
+			trashPurgeInterval = d
+		}
+	}
+	workers.StartTrashPurger(mainCtx, trashPurgeInterval)
+
+	// Start the orphaned blob collector, off unless BLOB_GC_INTERVAL is set. It only
+	// reports unless BLOB_GC_DRY_RUN=false; cmd/blob_gc runs the same collection once.
+	var blobGCInterval time.Duration
+	if bgi := os.Getenv("BLOB_GC_INTERVAL"); bgi != "" {
+		if d, err := time.ParseDuration(bgi); err == nil && d > 0 {
+			blobGCInterval = d
+		}
+	}
+	blobGCGrace := services.DefaultBlobGCGracePeriod
+	if bgg := os.Getenv("BLOB_GC_GRACE"); bgg != "" {
+		if d, err := time.ParseDuration(bgg); err == nil && d > 0 {
+			blobGCGrace = d
+		}
+	}
+	blobGCDryRun := true
+	if dr, err := strconv.ParseBool(os.Getenv("BLOB_GC_DRY_RUN")); err == nil {
+		blobGCDryRun = dr
+	}
+	workers.StartBlobGC(mainCtx, blobGCInterval, blobGCGrace, blobGCDryRun)
+
+	// Start the node outbox recovery, which undoes node mutations left unfinished by a crash
+	outboxRecoveryInterval := time.Minute
+	if ori := os.Getenv("NODE_OUTBOX_RECOVERY_INTERVAL"); ori != "" {
+		if d, err := time.ParseDuration(ori); err == nil && d > 0 {
+			outboxRecoveryInterval = d
+		}
+	}
+	workers.StartNodeOutboxRecovery(mainCtx, outboxRecoveryInterval)
+
 	// Initialize Gin Router
 	router := gin.Default()
 	router.GET("/health", handlers.HealthCheck)
@@ -181,6 +262,10 @@ func main() {
 			protected.PUT("/session/:sessionId/complete", handlers.CompleteQuizSession)
 			protected.GET("/session/active/:userId", handlers.GetActiveSession)
 
+			// JOB ROUTES
+			protected.GET("/jobs/:id", handlers.GetJob)
+			protected.GET("/events", handlers.StreamJobEvents)
+
 			// SEARCH ROUTES
 			protected.GET("/search", handlers.SearchItems)
 
@@ -188,18 +273,44 @@ func main() {
 			protected.POST("/nodes", handlers.CreateNode)
 			protected.GET("/nodes/:id", handlers.GetNode)
 			protected.GET("/nodes", handlers.GetNodeChildren)
+			protected.GET("/nodes/duplicates", handlers.GetDuplicateNotes)
 			protected.PUT("/nodes/:id", handlers.UpdateNode)
+			protected.POST("/nodes/:id/move", handlers.MoveNode)
 			protected.DELETE("/nodes/:id", handlers.DeleteNode)
 			protected.GET("/nodes/tree/:id", handlers.GetNodeTree)
 			protected.POST("/nodes/notes", handlers.CreateNoteNode)
 			protected.GET("/nodes/:id/image", handlers.GetNodeImage)
+			protected.POST("/nodes/:id/pages", handlers.AppendNotePages)
+			protected.PUT("/nodes/:id/pages", handlers.ReorderNotePages)
+			protected.DELETE("/nodes/:id/pages/:pageId", handlers.DeleteNotePage)
 			protected.PUT("/nodes/:id/caption", handlers.RegenerateNodeCaption)
+			protected.GET("/nodes/:id/transcription", handlers.GetNoteTranscription)
 			protected.POST("/nodes/:id/review", handlers.ReviewNoteNode)
 			protected.GET("/nodes/:id/name-suggestions", handlers.GetNameSuggestionsForFolder)
 
+			// TAG ROUTES
+			protected.POST("/nodes/:id/tags", handlers.TagNode)
+			protected.DELETE("/nodes/:id/tags/:tag", handlers.UntagNode)
+			protected.GET("/tags", handlers.GetTags)
+			protected.GET("/tags/:tag/nodes", handlers.GetNodesByTag)
+
+			// SMART COLLECTION ROUTES
+			protected.POST("/collections", handlers.CreateSmartCollection)
+			protected.GET("/collections", handlers.GetSmartCollections)
+			protected.GET("/collections/:id", handlers.GetSmartCollection)
+			protected.PUT("/collections/:id", handlers.UpdateSmartCollection)
+			protected.DELETE("/collections/:id", handlers.DeleteSmartCollection)
+
+			// TRASH ROUTES
+			protected.GET("/trash", handlers.GetTrash)
+			protected.POST("/trash/:id/restore", handlers.RestoreTrashItem)
+			protected.DELETE("/trash/:id", handlers.PurgeTrashItem)
+
 			// QUIZ ROUTES
 			protected.POST("/quizzes/folders/:folderId", handlers.CreateQuiz)
 			protected.POST("/quizzes/folders/:folderId/request", handlers.RequestQuizGeneration)
+			protected.POST("/quizzes/tags/:tag", handlers.CreateTagQuiz)
+			protected.POST("/quizzes/collections/:collectionId", handlers.CreateCollectionQuiz)
 			protected.GET("/quizzes/folders/:folderId/status", handlers.GetQuizStatus)
 			protected.GET("/quizzes/:quizId", handlers.GetQuiz)
 			protected.GET("/quizzes/:quizId/questions", handlers.GetQuizQuestions)
@@ -212,6 +323,20 @@ func main() {
 			protected.GET("/reviews/note/:noteId/history", handlers.GetNoteReviewHistory)
 			protected.PUT("/reviews/note/:noteId/status", handlers.UpdateReviewStatus)
 		}
+
+		admin := api.Group("/admin").Use(middleware.AuthMiddleware(authClient), middleware.AdminMiddleware())
+		{
+			// QUEUE OPERATOR ROUTES
+			admin.GET("/queues", handlers.GetQueueStats)
+			admin.GET("/queues/:queue/dead", handlers.GetDeadLetterJobs)
+			admin.POST("/queues/:queue/dead/requeue", handlers.RequeueAllDeadLetterJobs)
+			admin.POST("/queues/:queue/dead/:jobId/requeue", handlers.RequeueDeadLetterJob)
+			admin.DELETE("/queues/:queue/dead", handlers.PurgeAllDeadLetterJobs)
+			admin.DELETE("/queues/:queue/dead/:jobId", handlers.PurgeDeadLetterJob)
+
+			// Node tree consistency check (dry run unless ?fix=true)
+			admin.POST("/fsck", handlers.CheckNodeTree)
+		}
 	}
 
 	port := os.Getenv("PORT")
@@ -227,3 +352,31 @@ func main() {
 		log.Fatalf("Failed to run server: %v", err)
 	}
 }
+
+// writeServiceAccountFile creates service-account.json from KEY_DATA_N when it does not exist yet
+func writeServiceAccountFile() error {
+	if _, err := os.Stat("service-account.json"); !os.IsNotExist(err) {
+		return nil
+	}
+
+	token := os.Getenv("KEY_DATA_N")
+	if token == "" {
+		return fmt.Errorf("KEY_DATA_N environment variable not set")
+	}
+
+	var tokenJson map[string]interface{}
+	if err := json.Unmarshal([]byte(token), &tokenJson); err != nil {
+		return fmt.Errorf("error parsing service-account.json: %v", err)
+	}
+	tokenJson["private_key"] = strings.ReplaceAll(tokenJson["private_key"].(string), "\\n", "\n")
+
+	tokenBytes, err := json.Marshal(tokenJson)
+	if err != nil {
+		return fmt.Errorf("error marshaling service-account.json: %v", err)
+	}
+
+	if err := os.WriteFile("service-account.json", tokenBytes, 0644); err != nil {
+		return fmt.Errorf("error writing service-account.json: %v", err)
+	}
+	return nil
+}
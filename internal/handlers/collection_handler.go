@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cogniscan/backend/internal/models"
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SmartCollectionPayload defines the expected JSON for creating or updating a smart collection
+type SmartCollectionPayload struct {
+	Name   string                  `json:"name" binding:"required"`
+	Filter models.CollectionFilter `json:"filter"`
+}
+
+// CreateSmartCollection saves a new smart collection
+func CreateSmartCollection(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var payload SmartCollectionPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection, err := services.CreateSmartCollection(ctx, userID, payload.Name, payload.Filter)
+	if err != nil {
+		writeTagError(c, "CreateSmartCollection", err)
+		return
+	}
+	c.JSON(http.StatusCreated, collection)
+}
+
+// GetSmartCollections lists the user's smart collections
+func GetSmartCollections(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collections, err := services.ListSmartCollections(ctx, userID)
+	if err != nil {
+		writeTagError(c, "GetSmartCollections", err)
+		return
+	}
+	c.JSON(http.StatusOK, collections)
+}
+
+// GetSmartCollection returns a smart collection with the nodes it currently matches
+func GetSmartCollection(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection, err := services.GetSmartCollection(ctx, c.Param("id"), userID)
+	if err != nil {
+		writeTagError(c, "GetSmartCollection", err)
+		return
+	}
+	nodes, err := services.GetCollectionNodes(ctx, c.Param("id"), userID)
+	if err != nil {
+		writeTagError(c, "GetSmartCollection", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"collection": collection, "nodes": nodes})
+}
+
+// UpdateSmartCollection replaces a smart collection's name and filter
+func UpdateSmartCollection(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var payload SmartCollectionPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection, err := services.UpdateSmartCollection(ctx, c.Param("id"), userID, payload.Name, payload.Filter)
+	if err != nil {
+		writeTagError(c, "UpdateSmartCollection", err)
+		return
+	}
+	c.JSON(http.StatusOK, collection)
+}
+
+// DeleteSmartCollection deletes a smart collection; the nodes it matched are untouched
+func DeleteSmartCollection(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := services.DeleteSmartCollection(ctx, c.Param("id"), userID); err != nil {
+		writeTagError(c, "DeleteSmartCollection", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Collection deleted"})
+}
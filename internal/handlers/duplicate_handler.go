@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDuplicateNotes lists groups of the user's notes that are likely copies of each
+// other: "exact" groups share identical uploads, "similar" ones have pages that look
+// the same. Notes in a group are oldest first.
+func GetDuplicateNotes(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	groups, err := services.FindDuplicateNotes(ctx, userID)
+	if err != nil {
+		log.Printf("[GetDuplicateNotes] %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find duplicate notes"})
+		return
+	}
+	if groups == nil {
+		groups = []services.DuplicateGroup{}
+	}
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDuplicateNotesUnauthorizedAccess(t *testing.T) {
+	// The static route must win over /nodes/:id
+	router := setupTestRouterNoAuth()
+	router.GET("/nodes/:id", GetNode)
+	router.GET("/nodes/duplicates", GetDuplicateNotes)
+
+	req, _ := http.NewRequest("GET", "/nodes/duplicates", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventStreamHeartbeat keeps idle SSE connections open through proxies
+const eventStreamHeartbeat = 25 * time.Second
+
+// GetJob returns the tracked state of a caption, quiz or mastery job owned by the user
+func GetJob(c *gin.Context) {
+	jobID := c.Param("id")
+	userID := c.GetString("userId")
+	if jobID == "" || userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "job ID and userId required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job, err := services.GetJob(ctx, jobID)
+	if err != nil {
+		if err == services.ErrJobNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	// Report other users' jobs as missing rather than forbidden so IDs can't be probed
+	if job.OwnerID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// StreamJobEvents streams the signed-in user's job transitions as server-sent events.
+// Each event is named "job" and carries the full job record as JSON.
+func StreamJobEvents(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	events, unsubscribe := services.SubscribeJobEvents(userID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+
+	// Tell the client the stream is live before the first job event arrives
+	c.SSEvent("ready", gin.H{"userId": userID})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case job := <-events:
+			c.SSEvent("job", job)
+			return true
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+			return true
+		}
+	})
+}
@@ -97,7 +97,7 @@ func GetAllFoldersMastery(c *gin.Context) {
 
 	// Get all folder nodes owned by user
 	nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
-	cursor, err := nodesCollection.Find(ctx, bson.M{"ownerId": userID, "metadata.type": models.NodeTypeFolder})
+	cursor, err := nodesCollection.Find(ctx, services.ExcludeTrashed(bson.M{"ownerId": userID, "metadata.type": models.NodeTypeFolder}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch folders"})
 		return
@@ -180,7 +180,7 @@ func UpdateNoteMastery(c *gin.Context) {
 	}
 
 	// Enqueue mastery update for ancestors
-	services.EnqueueAncestorMasteryUpdate(req.NoteID)
+	services.EnqueueAncestorMasteryUpdate(req.NoteID, userID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Mastery updated",
@@ -244,7 +244,7 @@ func GetAllNodesMastery(c *gin.Context) {
 
 	// Get all nodes owned by user
 	nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
-	cursor, err := nodesCollection.Find(ctx, bson.M{"ownerId": userID})
+	cursor, err := nodesCollection.Find(ctx, services.ExcludeTrashed(bson.M{"ownerId": userID}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch nodes"})
 		return
@@ -315,7 +315,7 @@ func RefreshNodeMastery(c *gin.Context) {
 
 	// Trigger background update for ancestors (if not root)
 	if node.ParentID != "" {
-		services.EnqueueAncestorMasteryUpdate(nodeID)
+		services.EnqueueAncestorMasteryUpdate(nodeID, userID)
 	}
 
 	// Get updated node
@@ -353,7 +353,7 @@ func GetMasteryStats(c *gin.Context) {
 
 	// Get all nodes owned by user
 	nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
-	cursor, err := nodesCollection.Find(ctx, bson.M{"ownerId": userID})
+	cursor, err := nodesCollection.Find(ctx, services.ExcludeTrashed(bson.M{"ownerId": userID}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch nodes"})
 		return
@@ -7,12 +7,13 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"cogniscan/backend/internal/database"
 	"cogniscan/backend/internal/middleware"
 	"cogniscan/backend/internal/models"
-	"cogniscan/backend/internal/queue"
 	"cogniscan/backend/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -58,6 +59,7 @@ func CreateNode(c *gin.Context) {
 	}
 
 	// If parentId is provided, verify it exists and is not the same as the node being created
+	ancestors := []string{}
 	if payload.ParentID != "" {
 		parentObjectID, err := primitive.ObjectIDFromHex(payload.ParentID)
 		if err != nil {
@@ -69,11 +71,11 @@ func CreateNode(c *gin.Context) {
 		nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
 		var parent models.Node
 		verifyCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		err = nodesCollection.FindOne(verifyCtx, bson.M{
+		err = nodesCollection.FindOne(verifyCtx, services.ExcludeTrashed(bson.M{
 			"_id":      parentObjectID,
 			"ownerId":  firebaseUser.Claims["email"].(string),
 			"parentId": bson.M{"$ne": payload.ParentID}, // Parent is not self-referencing
-		}).Decode(&parent)
+		})).Decode(&parent)
 		cancel()
 
 		if err != nil {
@@ -90,6 +92,7 @@ func CreateNode(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent must be a folder"})
 			return
 		}
+		ancestors = services.ChildAncestors(&parent)
 	}
 
 	// For notes, validate driveId is present
@@ -125,6 +128,7 @@ func CreateNode(c *gin.Context) {
 		ID:             nodeID,
 		Name:           payload.Name,
 		ParentID:       payload.ParentID,
+		Ancestors:      ancestors,
 		Children:       []string{},
 		TotalNoteCount: 0,
 		Metadata:       metadata,
@@ -134,33 +138,18 @@ func CreateNode(c *gin.Context) {
 		Mastery:        mastery,
 	}
 
-	nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	_, err := nodesCollection.InsertOne(ctx, newNode)
-	if err != nil {
+	if err := insertNodeUnderParent(ctx, "create", &newNode); err != nil {
+		log.Printf("[CreateNode] Failed to create node: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create node"})
 		return
 	}
 
-	// Add this node to parent's children array
-	if payload.ParentID != "" {
-		_, err = nodesCollection.UpdateOne(ctx,
-			bson.M{"_id": payload.ParentID, "ownerId": firebaseUser.Claims["email"]},
-			bson.M{"$push": bson.M{"children": nodeID.Hex()}, "$set": bson.M{"updatedAt": now}})
-		if err != nil {
-			log.Printf("[CreateNode] Failed to add node to parent's children: %v", err)
-		}
-
-		// Update parent's totalNoteCount
-		if payload.Type == models.NodeTypeNote {
-			// Update TotalNoteCount for all ancestors
-			updateAncestorTotalNoteCount(ctx, payload.ParentID, firebaseUser.Claims["email"].(string), 1)
-
-			// Enqueue mastery update for ancestors
-			services.EnqueueAncestorMasteryUpdate(nodeID.Hex())
-		}
+	// Enqueue mastery update for ancestors
+	if payload.ParentID != "" && payload.Type == models.NodeTypeNote {
+		services.EnqueueAncestorMasteryUpdate(nodeID.Hex(), newNode.OwnerID)
 	}
 
 	c.JSON(http.StatusCreated, newNode)
@@ -316,8 +305,13 @@ func UpdateNode(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Node updated successfully"})
 }
 
-// DeleteNode deletes a node and all its descendants
-func DeleteNode(c *gin.Context) {
+// MoveNodePayload defines the expected JSON for moving a node
+type MoveNodePayload struct {
+	TargetID string `json:"targetId"` // Folder to move into; empty or "root" for the top level
+}
+
+// MoveNode moves a note or folder (with its subtree) under another folder
+func MoveNode(c *gin.Context) {
 	nodeIDHex := c.Param("id")
 	nodeID, err := primitive.ObjectIDFromHex(nodeIDHex)
 	if err != nil {
@@ -325,121 +319,209 @@ func DeleteNode(c *gin.Context) {
 		return
 	}
 
+	var payload MoveNodePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+	targetID := payload.TargetID
+	if targetID == "root" {
+		targetID = ""
+	}
+	if targetID != "" {
+		if _, err := primitive.ObjectIDFromHex(targetID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target ID format"})
+			return
+		}
+	}
+
 	firebaseUser := middleware.ForContext(c.Request.Context())
 	if firebaseUser == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
+	userID := firebaseUser.Claims["email"].(string)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	userID := firebaseUser.Claims["email"].(string)
-
-	// Get the node to check its type and parent
 	nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
 	var node models.Node
-	err = nodesCollection.FindOne(ctx, bson.M{"_id": nodeID, "ownerId": userID}).Decode(&node)
+	err = nodesCollection.FindOne(ctx, services.ExcludeTrashed(bson.M{"_id": nodeID, "ownerId": userID})).Decode(&node)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
 		return
 	}
 
-	// Recursively delete all descendants
-	deletedNoteCount := deleteNodeRecursively(ctx, nodeIDHex, userID)
+	oldParentID := node.ParentID
+	if targetID == oldParentID {
+		c.JSON(http.StatusOK, node)
+		return
+	}
 
-	// Update parent's children array and totalNoteCount
-	if node.ParentID != "" {
-		_, err = nodesCollection.UpdateOne(ctx,
-			bson.M{"_id": node.ParentID, "ownerId": userID},
-			bson.M{"$pull": bson.M{"children": nodeIDHex}, "$set": bson.M{"updatedAt": time.Now()}})
-		if err != nil {
-			log.Printf("[DeleteNode] Failed to remove node from parent's children: %v", err)
+	target, err := services.ValidateMoveTarget(ctx, &node, targetID)
+	if err != nil {
+		switch err {
+		case services.ErrParentNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Target folder not found"})
+		case services.ErrMoveTargetNotFolder, services.ErrMoveIntoDescendant:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Printf("[MoveNode] Failed to validate target for node %s: %v", nodeIDHex, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate target folder"})
 		}
+		return
+	}
 
-		// Update ancestor TotalNoteCounts
-		updateAncestorTotalNoteCount(ctx, node.ParentID, userID, -deletedNoteCount)
-
-		// Enqueue mastery update for ancestors
-		services.EnqueueAncestorMasteryUpdate(node.ParentID)
+	movedNoteCount, err := services.CountSubtreeNotes(ctx, &node)
+	if err != nil {
+		log.Printf("[MoveNode] Failed to count notes under node %s: %v", nodeIDHex, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move node"})
+		return
 	}
 
-	// Finally delete the main node
-	_, err = nodesCollection.DeleteOne(ctx, bson.M{"_id": nodeID, "ownerId": userID})
+	// Re-point the node and its subtree, both parents' children arrays and the note
+	// counts of both ancestor chains together
+	newAncestors := services.ChildAncestors(target)
+	err = services.RunNodeMutation(ctx, "move", userID, func(ctx context.Context) error {
+		if err := services.ReparentNode(ctx, &node, targetID, newAncestors); err != nil {
+			return err
+		}
+		if err := services.RemoveNodeChild(ctx, oldParentID, userID, nodeIDHex); err != nil {
+			return err
+		}
+		if err := services.AddNodeChild(ctx, targetID, userID, nodeIDHex); err != nil {
+			return err
+		}
+		if err := services.IncrementAncestorNoteCounts(ctx, node.Ancestors, userID, -movedNoteCount); err != nil {
+			return err
+		}
+		if err := services.IncrementAncestorNoteCounts(ctx, newAncestors, userID, movedNoteCount); err != nil {
+			return err
+		}
+		// The cycle check ran before the mutation, and a transaction may be retried
+		// without it, so the target must still be where it was checked
+		return services.ConfirmMoveTarget(ctx, target)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete the main node"})
+		if err == services.ErrNodeMovedConcurrently {
+			c.JSON(http.StatusConflict, gin.H{"error": "Node was moved concurrently, please retry"})
+			return
+		}
+		log.Printf("[MoveNode] Failed to move node %s: %v", nodeIDHex, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move node"})
 		return
 	}
+	node.ParentID = targetID
+	node.Ancestors = newAncestors
+	node.UpdatedAt = time.Now()
 
-	c.JSON(http.StatusOK, gin.H{"message": "Node deleted successfully"})
-}
+	// Folder-scoped search keys captions by their note's direct parent, and storage
+	// usage is broken down the same way
+	if node.Metadata.Type == models.NodeTypeNote {
+		if err := services.MoveCaptionEmbedding(nodeIDHex, targetID); err != nil {
+			log.Printf("[MoveNode] Failed to move caption embedding for note %s: %v", nodeIDHex, err)
+		}
+		if err := services.MoveStorageUsage(ctx, userID, oldParentID, targetID, services.NotePagesBytes(node.Pages)); err != nil {
+			log.Printf("[MoveNode] Failed to move storage usage for note %s: %v", nodeIDHex, err)
+		}
+	}
 
-// deleteNodeRecursively deletes a node and all its descendants
-// Returns the number of note nodes deleted
-func deleteNodeRecursively(ctx context.Context, nodeIDHex, ownerID string) int {
-	dbName := os.Getenv("DB_NAME")
-	nodesCollection := database.Client.Database(dbName).Collection("nodes")
+	// Recalculate mastery on both chains
+	refreshFolderMastery(ctx, oldParentID, userID)
+	services.EnqueueAncestorMasteryUpdate(nodeIDHex, userID)
 
-	// Find the node
-	var node models.Node
-	err := nodesCollection.FindOne(ctx, bson.M{"_id": nodeIDHex, "ownerId": ownerID}).Decode(&node)
+	c.JSON(http.StatusOK, node)
+}
+
+// DeleteNode moves a node and all its descendants to the trash
+func DeleteNode(c *gin.Context) {
+	nodeIDHex := c.Param("id")
+	nodeID, err := primitive.ObjectIDFromHex(nodeIDHex)
 	if err != nil {
-		log.Printf("Failed to find node %s: %v", nodeIDHex, err)
-		return 0
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid node ID"})
+		return
 	}
 
-	deletedNoteCount := 0
-
-	// If it's a note, delete from Google Drive
-	if node.Metadata.Type == models.NodeTypeNote && node.Metadata.DriveID != "" {
-		err := services.DeleteFile(node.Metadata.DriveID)
-		if err != nil {
-			log.Printf("Failed to delete file from Drive: %v", err)
-		}
-		deletedNoteCount = 1
+	firebaseUser := middleware.ForContext(c.Request.Context())
+	if firebaseUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
 	}
 
-	// Recursively delete all children
-	for _, childID := range node.Children {
-		deletedNoteCount += deleteNodeRecursively(ctx, childID, ownerID)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	userID := firebaseUser.Claims["email"].(string)
 
-		// Delete child node
-		_, err = nodesCollection.DeleteOne(ctx, bson.M{"_id": childID, "ownerId": ownerID})
-		if err != nil {
-			log.Printf("Failed to delete child node %s: %v", childID, err)
+	// Get the node to check its type and parent
+	nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
+	var node models.Node
+	err = nodesCollection.FindOne(ctx, services.ExcludeTrashed(bson.M{"_id": nodeID, "ownerId": userID})).Decode(&node)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	// Trash the subtree and detach it from its parent and ancestor counts together.
+	// Blobs and embeddings stay until the trash entry is purged.
+	var entry *models.TrashEntry
+	err = services.RunNodeMutation(ctx, "delete", userID, func(ctx context.Context) error {
+		var err error
+		if entry, err = services.TrashNode(ctx, &node); err != nil {
+			return err
+		}
+		if err := services.RemoveNodeChild(ctx, node.ParentID, userID, nodeIDHex); err != nil {
+			return err
+		}
+		return services.IncrementAncestorNoteCounts(ctx, node.Ancestors, userID, -entry.NoteCount)
+	})
+	if err != nil {
+		if err == services.ErrNodeNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
 		}
+		log.Printf("[DeleteNode] Failed to trash node %s: %v", nodeIDHex, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete node"})
+		return
 	}
 
-	return deletedNoteCount
+	refreshFolderMastery(ctx, node.ParentID, userID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Node moved to trash", "trash": entry})
 }
 
-// updateAncestorTotalNoteCount updates the totalNoteCount for all ancestors
-// delta can be positive (add) or negative (remove)
-func updateAncestorTotalNoteCount(ctx context.Context, parentID, ownerID string, delta int) {
-	nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
+// refreshFolderMastery recalculates a folder's mastery after it gained or lost
+// children and queues the update of its ancestors
+func refreshFolderMastery(ctx context.Context, folderID, ownerID string) {
+	if folderID == "" {
+		return
+	}
+	if err := services.UpdateNodeMastery(ctx, folderID); err != nil {
+		log.Printf("[refreshFolderMastery] Failed to update mastery for folder %s: %v", folderID, err)
+	}
+	services.EnqueueAncestorMasteryUpdate(folderID, ownerID)
+}
 
-	currentParentID := parentID
-	for currentParentID != "" {
-		_, err := nodesCollection.UpdateOne(ctx,
-			bson.M{"_id": currentParentID, "ownerId": ownerID},
-			bson.M{"$inc": bson.M{"totalNoteCount": delta}, "$set": bson.M{"updatedAt": time.Now()}})
-		if err != nil {
-			log.Printf("[updateAncestorTotalNoteCount] Failed to update node %s: %v", currentParentID, err)
-			break
+// insertNodeUnderParent inserts a new node, adds it to its parent's children and
+// counts it on its ancestors as one node mutation
+func insertNodeUnderParent(ctx context.Context, kind string, node *models.Node) error {
+	return services.RunNodeMutation(ctx, kind, node.OwnerID, func(ctx context.Context) error {
+		if err := services.InsertNode(ctx, node); err != nil {
+			return err
 		}
-
-		// Get parent of this node
-		var node models.Node
-		err = nodesCollection.FindOne(ctx, bson.M{"_id": currentParentID, "ownerId": ownerID}).Decode(&node)
-		if err != nil {
-			break
+		if err := services.AddNodeChild(ctx, node.ParentID, node.OwnerID, node.ID.Hex()); err != nil {
+			return err
 		}
-
-		currentParentID = node.ParentID
-	}
+		if node.Metadata.Type != models.NodeTypeNote {
+			return nil
+		}
+		return services.IncrementAncestorNoteCounts(ctx, node.Ancestors, node.OwnerID, 1)
+	})
 }
 
-// CreateNoteNode creates a new note node with file upload
+// CreateNoteNode creates a new note node from one or more uploaded images, one page
+// each, or from a PDF with a page per PDF page
 func CreateNoteNode(c *gin.Context) {
 	firebaseUser := middleware.ForContext(c.Request.Context())
 	if firebaseUser == nil {
@@ -455,25 +537,42 @@ func CreateNoteNode(c *gin.Context) {
 
 	name := c.Request.FormValue("name")
 	parentID := c.Request.FormValue("parentId")
-	file, header, err := c.Request.FormFile("image")
-	if err != nil {
+	// Every "image" field becomes a page, in form order; a lone PDF is split into pages
+	files := c.Request.MultipartForm.File["image"]
+	if len(files) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Image file is required"})
 		return
 	}
-	defer file.Close()
+	if len(files) > services.MaxNotePages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrTooManyPages.Error()})
+		return
+	}
 
-	// Read image bytes for AI processing
-	imageBytes, err := io.ReadAll(file)
+	ancestors, err := services.ParentAncestors(c.Request.Context(), parentID, firebaseUser.Claims["email"].(string))
 	if err != nil {
-		log.Printf("[NoteNodeHandler] Failed to read image bytes: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process image"})
+		if err == services.ErrParentNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent folder not found"})
+			return
+		}
+		log.Printf("[NoteNodeHandler] Failed to resolve parent %s: %v", parentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify parent"})
+		return
+	}
+
+	ownerID := firebaseUser.Claims["email"].(string)
+	if err := services.CheckStorageQuota(c.Request.Context(), ownerID, uploadBytes(files)); err != nil {
+		writeStorageError(c.Request.Context(), c, "NoteNodeHandler", ownerID, err)
 		return
 	}
 
-	driveID, err := services.UploadFile(header.Filename, bytes.NewReader(imageBytes))
+	pages, err := uploadNotePages(c.Request.Context(), ownerID, files)
 	if err != nil {
-		log.Printf("[NoteNodeHandler] Failed to upload to Google Drive: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+		writeUploadError(c, "NoteNodeHandler", err)
+		return
+	}
+	// Counted once the real sizes of the stored blobs are known
+	if err := reserveStorage(c.Request.Context(), ownerID, parentID, pages); err != nil {
+		writeStorageError(c.Request.Context(), c, "NoteNodeHandler", ownerID, err)
 		return
 	}
 
@@ -494,55 +593,56 @@ func CreateNoteNode(c *gin.Context) {
 		ID:             nodeID,
 		Name:           name,
 		ParentID:       parentID,
+		Ancestors:      ancestors,
 		Children:       []string{},
 		TotalNoteCount: 1,
 		Metadata: models.NodeMetadata{
 			Type:    models.NodeTypeNote,
-			DriveID: driveID,
+			DriveID: services.FirstPageDriveID(pages),
 		},
-		OwnerID:   firebaseUser.Claims["email"].(string),
+		OwnerID:   ownerID,
 		CreatedAt: now,
 		UpdatedAt: now,
 		Mastery:   mastery,
+		Pages:     pages,
+	}
+	allCaptioned := services.NotePagesCaptioned(&newNode)
+	if allCaptioned {
+		newNode.CaptionStatus = models.CaptionStatusCompleted
+	} else if services.IsQueueServiceInitialized() {
+		newNode.CaptionStatus = models.CaptionStatusPending
 	}
 
-	nodesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err = nodesCollection.InsertOne(ctx, newNode)
-	if err != nil {
+	if err := insertNodeUnderParent(ctx, "create_note", &newNode); err != nil {
+		log.Printf("[NoteNodeHandler] Failed to save node record: %v", err)
+		// Do not leave behind uploads no other note shares
+		discardReservedPages("NoteNodeHandler", ownerID, parentID, pages)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save node record"})
 		return
 	}
 
-	// Add to parent's children array
-	if parentID != "" {
-		_, err = nodesCollection.UpdateOne(ctx,
-			bson.M{"_id": parentID, "ownerId": firebaseUser.Claims["email"]},
-			bson.M{"$push": bson.M{"children": nodeID.Hex()}, "$set": bson.M{"updatedAt": now}})
-		if err != nil {
-			log.Printf("[NoteNodeHandler] Failed to add node to parent's children: %v", err)
-		}
-
-		// Update ancestor TotalNoteCounts
-		updateAncestorTotalNoteCount(ctx, parentID, firebaseUser.Claims["email"].(string), 1)
-	}
-
-	// Enqueue caption generation job
-	job := queue.CaptionJob{
-		ID:      primitive.NewObjectID().Hex(),
-		NoteID:  nodeID.Hex(),
-		DriveID: driveID,
-	}
-	if err := services.EnqueueCaptionJob(job); err != nil {
+	// Enqueue a caption generation job per page image not transcribed already (re-uploads)
+	if _, err := services.EnqueuePageCaptionJobs(&newNode, services.UncaptionedPages(pages)); err != nil {
 		log.Printf("[NoteNodeHandler] Failed to enqueue caption job: %v", err)
 	}
+	// A PDF read entirely from its text layer, or a re-upload, has no job to embed its caption
+	if allCaptioned {
+		if err := services.RefreshNoteCaption(ctx, &newNode); err != nil {
+			log.Printf("[NoteNodeHandler] Failed to stage caption of note %s: %v", nodeID.Hex(), err)
+		}
+	}
+	syncDocumentIndex(ctx, "NoteNodeHandler", &newNode)
 
 	c.JSON(http.StatusCreated, newNode)
 }
 
-// GetNodeImage serves a node's image as a secure proxy
+// GetNodeImage serves a note's image as a secure proxy; ?page=n selects a page (default 1),
+// ?size=small|medium a thumbnail and ?original=true the upload as received instead of the
+// normalised image. Stored blobs never change, so their ID is the ETag; conditional and
+// Range requests are answered without re-sending the whole image.
 func GetNodeImage(c *gin.Context) {
 	nodeID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
@@ -567,29 +667,109 @@ func GetNodeImage(c *gin.Context) {
 		return
 	}
 
-	if node.Metadata.Type != models.NodeTypeNote || node.Metadata.DriveID == "" {
+	if node.Metadata.Type != models.NodeTypeNote || len(services.NotePages(&node)) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "No image associated with this node"})
 		return
 	}
 
-	log.Printf("[GetNodeImage] Downloading DriveID: %s", node.Metadata.DriveID)
-	resp, err := services.DownloadFileContent(node.Metadata.DriveID)
+	pageNumber, err := strconv.Atoi(c.DefaultQuery("page", "1"))
 	if err != nil {
-		log.Printf("[GetNodeImage] Error downloading from Drive: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve image from storage"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
 		return
 	}
-	defer resp.Body.Close()
+	page, err := services.NotePageAt(&node, pageNumber)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	}
+	if page.DriveID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This page was read from a PDF's text and has no image"})
+		return
+	}
+	driveID := page.DriveID
+	size := c.DefaultQuery("size", "full")
+	switch {
+	case c.Query("original") == "true":
+		// Pages stored before preprocessing only have the upload as received
+		if page.OriginalDriveID != "" {
+			driveID = page.OriginalDriveID
+		}
+	case size != "full":
+		if _, ok := services.ThumbnailSizes[size]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidThumbnailSize.Error()})
+			return
+		}
+		thumbCtx, thumbCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		thumbnailID, err := services.NotePageThumbnail(thumbCtx, &node, page, size)
+		thumbCancel()
+		if err != nil {
+			// The full image still does the job, just with more bytes
+			log.Printf("[GetNodeImage] Failed to get %s thumbnail of node %s: %v", size, nodeID.Hex(), err)
+		} else {
+			driveID = thumbnailID
+		}
+	}
 
-	c.Header("Content-Type", resp.Header.Get("Content-Type"))
-	c.Header("Content-Length", resp.Header.Get("Content-Length"))
-	_, err = io.Copy(c.Writer, resp.Body)
+	etag := `"` + driveID + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	if imageNotModified(c.Request, etag, page.CreatedAt) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	log.Printf("[GetNodeImage] Downloading DriveID: %s", driveID)
+	blob, err := services.DownloadBlob(ctx, driveID)
 	if err != nil {
-		log.Printf("[GetNodeImage] Error streaming file: %v", err)
+		log.Printf("[GetNodeImage] Error downloading from storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve image from storage"})
+		return
+	}
+	defer blob.Body.Close()
+
+	serveBlob(c, blob, page.CreatedAt)
+}
+
+// serveBlob writes a downloaded blob, answering Range and conditional requests
+func serveBlob(c *gin.Context, blob *services.Blob, modified time.Time) {
+	// Range requests need to seek; local files can, other stores are buffered
+	content, ok := blob.Body.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(blob.Body)
+		if err != nil {
+			log.Printf("[GetNodeImage] Error reading file: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve image from storage"})
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	c.Header("Content-Type", blob.ContentType)
+	http.ServeContent(c.Writer, c.Request, "", modified, content)
+}
+
+// imageNotModified reports whether a conditional request already has the image:
+// If-None-Match is checked against the ETag, or failing that If-Modified-Since
+// against the time the image was stored
+func imageNotModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
 	}
+	return !modified.Truncate(time.Second).After(since)
 }
 
-// RegenerateNodeCaption regenerates caption for a note node
+// RegenerateNodeCaption regenerates the caption of every page of a note, or of one with ?page=n
 func RegenerateNodeCaption(c *gin.Context) {
 	nodeID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
@@ -614,27 +794,43 @@ func RegenerateNodeCaption(c *gin.Context) {
 		return
 	}
 
-	if node.Metadata.Type != models.NodeTypeNote || node.Metadata.DriveID == "" {
+	if node.Metadata.Type != models.NodeTypeNote || services.FirstPageDriveID(services.NotePages(&node)) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "This node is not a note with an image"})
 		return
 	}
 
-	job := queue.CaptionJob{
-		ID:      primitive.NewObjectID().Hex(),
-		NoteID:  nodeID.Hex(),
-		DriveID: node.Metadata.DriveID,
+	pages := services.NotePages(&node)
+	if p := c.Query("page"); p != "" {
+		pageNumber, err := strconv.Atoi(p)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
+			return
+		}
+		page, err := services.NotePageAt(&node, pageNumber)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+			return
+		}
+		if page.DriveID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This page was read from a PDF's text and has no image"})
+			return
+		}
+		pages = []models.NotePage{*page}
 	}
 
-	if err := services.EnqueueCaptionJob(job); err != nil {
+	jobIDs, err := services.EnqueuePageCaptionJobs(&node, pages)
+	if err != nil {
 		log.Printf("[RegenerateNodeCaption] Failed to enqueue job: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue caption job"})
 		return
 	}
 
-	log.Printf("[RegenerateNodeCaption] Enqueued job %s for node %s", job.ID, nodeID.Hex())
+	log.Printf("[RegenerateNodeCaption] Enqueued %d jobs for node %s", len(jobIDs), nodeID.Hex())
 	c.JSON(http.StatusAccepted, gin.H{
 		"message": "Caption regeneration started",
 		"nodeId":  nodeID.Hex(),
+		"jobId":   jobIDs[0],
+		"jobIds":  jobIDs,
 	})
 }
 
@@ -691,7 +887,7 @@ func ReviewNoteNode(c *gin.Context) {
 	}
 
 	// Enqueue mastery update for ancestors
-	services.EnqueueAncestorMasteryUpdate(nodeID)
+	services.EnqueueAncestorMasteryUpdate(nodeID, userID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Review recorded successfully",
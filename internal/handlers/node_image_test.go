@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestImageNotModified(t *testing.T) {
+	stored := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	etag := `"blob-1"`
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no conditions", nil, false},
+		{"matching etag", map[string]string{"If-None-Match": `"blob-1"`}, true},
+		{"weak etag in a list", map[string]string{"If-None-Match": `"other", W/"blob-1"`}, true},
+		{"any etag", map[string]string{"If-None-Match": "*"}, true},
+		{"stale etag", map[string]string{"If-None-Match": `"blob-0"`}, false},
+		// If-None-Match wins over If-Modified-Since
+		{"stale etag, recent date", map[string]string{
+			"If-None-Match":     `"blob-0"`,
+			"If-Modified-Since": stored.Add(time.Hour).Format(http.TimeFormat),
+		}, false},
+		{"modified since", map[string]string{"If-Modified-Since": stored.Add(-time.Hour).Format(http.TimeFormat)}, false},
+		{"not modified since", map[string]string{"If-Modified-Since": stored.Format(http.TimeFormat)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/nodes/1/image", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := imageNotModified(req, etag, stored); got != tt.want {
+				t.Errorf("imageNotModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeBlobRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/image", func(c *gin.Context) {
+		// Not seekable, like a Drive download
+		body := io.NopCloser(strings.NewReader("0123456789"))
+		serveBlob(c, &services.Blob{Body: body, ContentType: "image/jpeg", Size: 10}, time.Now())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/image", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if got := w.Body.String(); got != "2345" {
+		t.Errorf("body = %q, want %q", got, "2345")
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Errorf("Content-Range = %q, want %q", got, "bytes 2-5/10")
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/jpeg" {
+		t.Errorf("Content-Type = %q, want image/jpeg", got)
+	}
+}
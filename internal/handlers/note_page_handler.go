@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cogniscan/backend/internal/models"
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReorderNotePagesPayload defines the expected JSON for reordering a note's pages
+type ReorderNotePagesPayload struct {
+	PageIDs []string `json:"pageIds" binding:"required"`
+}
+
+// AppendNotePages uploads one or more images (repeated "image" form fields), or a
+// single PDF, as new pages at the end of a note
+func AppendNotePages(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := c.Request.ParseMultipartForm(20 << 20); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error parsing form data"})
+		return
+	}
+	files := c.Request.MultipartForm.File["image"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one image file is required"})
+		return
+	}
+	if len(files) > services.MaxNotePages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrTooManyPages.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	// Fail before uploading anything if the note is not there or the files cannot fit
+	note, err := services.GetOwnedNote(ctx, c.Param("id"), userID)
+	if err != nil {
+		writeNotePageError(c, "AppendNotePages", err)
+		return
+	}
+	if err := services.CheckStorageQuota(ctx, userID, uploadBytes(files)); err != nil {
+		writeStorageError(ctx, c, "AppendNotePages", userID, err)
+		return
+	}
+
+	pages, err := uploadNotePages(ctx, userID, files)
+	if err != nil {
+		writeUploadError(c, "AppendNotePages", err)
+		return
+	}
+	if err := reserveStorage(ctx, userID, note.ParentID, pages); err != nil {
+		writeStorageError(ctx, c, "AppendNotePages", userID, err)
+		return
+	}
+
+	node, err := services.AppendNotePages(ctx, c.Param("id"), userID, pages)
+	if err != nil {
+		discardReservedPages("AppendNotePages", userID, note.ParentID, pages)
+		writeNotePageError(c, "AppendNotePages", err)
+		return
+	}
+
+	// Re-uploaded pages come with the transcription of the earlier upload
+	if _, err := services.EnqueuePageCaptionJobs(node, services.UncaptionedPages(pages)); err != nil {
+		log.Printf("[AppendNotePages] Failed to enqueue caption jobs: %v", err)
+	}
+	// Pages taken from a PDF's text layer are transcribed already
+	if services.NotePagesCaptioned(&models.Node{Pages: pages}) {
+		if err := services.RefreshNoteCaption(ctx, node); err != nil {
+			log.Printf("[AppendNotePages] Failed to refresh caption of note %s: %v", node.ID.Hex(), err)
+		}
+	}
+	syncDocumentIndex(ctx, "AppendNotePages", node)
+
+	c.JSON(http.StatusCreated, node)
+}
+
+// ReorderNotePages puts a note's pages in the order given by pageIds
+func ReorderNotePages(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var payload ReorderNotePagesPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	node, err := services.ReorderNotePages(ctx, c.Param("id"), userID, payload.PageIDs)
+	if err != nil {
+		writeNotePageError(c, "ReorderNotePages", err)
+		return
+	}
+
+	if err := services.RefreshNoteCaption(ctx, node); err != nil {
+		log.Printf("[ReorderNotePages] Failed to refresh caption of note %s: %v", node.ID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, node)
+}
+
+// DeleteNotePage removes a page from a note and deletes its image
+func DeleteNotePage(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	node, removed, err := services.RemoveNotePage(ctx, c.Param("id"), userID, c.Param("pageId"))
+	if err != nil {
+		writeNotePageError(c, "DeleteNotePage", err)
+		return
+	}
+
+	if err := services.RefreshNoteCaption(ctx, node); err != nil {
+		log.Printf("[DeleteNotePage] Failed to refresh caption of note %s: %v", node.ID.Hex(), err)
+	}
+	released := services.ReleaseNotePageBlobs(ctx, userID, []models.NotePage{*removed}, nil)
+	releaseStorage(ctx, "DeleteNotePage", userID, node.ParentID, released)
+	syncDocumentIndex(ctx, "DeleteNotePage", node)
+
+	c.JSON(http.StatusOK, node)
+}
+
+// writeNotePageError maps note page errors onto responses
+func writeNotePageError(c *gin.Context, handler string, err error) {
+	switch err {
+	case services.ErrNodeNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+	case services.ErrPageNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+	case services.ErrNotANote, services.ErrTooManyPages, services.ErrInvalidPageOrder, services.ErrLastPage:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("[%s] %v", handler, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Request failed"})
+	}
+}
+
+// writeUploadError maps errors from reading uploaded pages onto responses
+func writeUploadError(c *gin.Context, handler string, err error) {
+	switch {
+	case errors.Is(err, errPDFNotAlone), errors.Is(err, services.ErrInvalidPDF),
+		errors.Is(err, services.ErrPDFTooLarge), errors.Is(err, services.ErrTooManyPages),
+		errors.Is(err, services.ErrUnsupportedImage), errors.Is(err, services.ErrImageTooLarge):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRasterizerMissing):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		log.Printf("[%s] Failed to upload pages: %v", handler, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+	}
+}
+
+// writeStorageError answers a failed quota check, telling the user how much space
+// they have when the upload does not fit
+func writeStorageError(ctx context.Context, c *gin.Context, handler, userID string, err error) {
+	if !errors.Is(err, services.ErrStorageQuotaExceeded) {
+		log.Printf("[%s] Failed to check storage quota: %v", handler, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check storage quota"})
+		return
+	}
+	response := gin.H{"error": "Not enough storage left for this upload", "code": "STORAGE_QUOTA_EXCEEDED"}
+	if progress, err := services.GetStorageUsage(ctx, userID); err == nil {
+		response["usedBytes"] = progress.StorageUsedBytes
+		response["quotaBytes"] = services.StorageQuotaBytes(progress)
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, response)
+}
+
+// uploadBytes counts the uploaded images, whose originals are stored as received. PDFs
+// are left out: only their rasterised pages are stored, at a size not known yet.
+func uploadBytes(files []*multipart.FileHeader) int64 {
+	var total int64
+	for _, header := range files {
+		if !isPDFUpload(header) {
+			total += header.Size
+		}
+	}
+	return total
+}
+
+// reserveStorage counts newly stored pages against the owner's quota, deleting them
+// again if they do not fit
+func reserveStorage(ctx context.Context, ownerID, folderID string, pages []models.NotePage) error {
+	if err := services.ReserveStorage(ctx, ownerID, folderID, services.NotePagesBytes(pages)); err != nil {
+		discardPages(ownerID, pages)
+		return err
+	}
+	return nil
+}
+
+// discardPagesTimeout bounds deleting the uploads of a request that failed
+const discardPagesTimeout = 30 * time.Second
+
+// discardPages deletes the blobs of pages stored for a request that then failed, and
+// returns the bytes released. It has a context of its own: the request's running out
+// may be why it failed.
+func discardPages(ownerID string, pages []models.NotePage) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), discardPagesTimeout)
+	defer cancel()
+	return services.ReleaseNotePageBlobs(ctx, ownerID, pages, nil)
+}
+
+// discardReservedPages discards pages counted against the owner's quota and takes
+// them off their folder's usage again
+func discardReservedPages(handler, ownerID, folderID string, pages []models.NotePage) {
+	released := discardPages(ownerID, pages)
+	ctx, cancel := context.WithTimeout(context.Background(), discardPagesTimeout)
+	defer cancel()
+	releaseStorage(ctx, handler, ownerID, folderID, released)
+}
+
+// releaseStorage takes the bytes released with deleted pages off the owner's usage
+func releaseStorage(ctx context.Context, handler, ownerID, folderID string, bytes int64) {
+	if bytes == 0 {
+		return
+	}
+	if err := services.ChangeStorageUsage(ctx, ownerID, folderID, -bytes); err != nil {
+		log.Printf("[%s] Failed to release %d bytes of storage: %v", handler, bytes, err)
+	}
+}
+
+// errPDFNotAlone rejects a PDF uploaded together with other files
+var errPDFNotAlone = errors.New("a PDF must be uploaded on its own")
+
+// uploadNotePages preprocesses and stores each uploaded image as a new page of one of
+// the owner's notes, in form order. A single PDF becomes a page per PDF page instead.
+// If one upload fails, the images already stored are deleted again.
+func uploadNotePages(ctx context.Context, ownerID string, files []*multipart.FileHeader) ([]models.NotePage, error) {
+	for _, header := range files {
+		if !isPDFUpload(header) {
+			continue
+		}
+		if len(files) > 1 {
+			return nil, errPDFNotAlone
+		}
+		data, err := readFormPDF(header)
+		if err != nil {
+			return nil, err
+		}
+		return services.IngestPDF(ctx, header.Filename, data)
+	}
+
+	pages := make([]models.NotePage, 0, len(files))
+	for _, header := range files {
+		page, err := uploadFormFile(ctx, ownerID, header, pages)
+		if err != nil {
+			// Pages reusing an earlier upload keep its blobs
+			discardPages(ownerID, pages)
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// isPDFUpload reports whether an uploaded file is a PDF by its type or name
+func isPDFUpload(header *multipart.FileHeader) bool {
+	return header.Header.Get("Content-Type") == "application/pdf" ||
+		strings.EqualFold(filepath.Ext(header.Filename), ".pdf")
+}
+
+// readFormPDF reads an uploaded PDF into memory
+func readFormPDF(header *multipart.FileHeader) ([]byte, error) {
+	if header.Size > services.MaxPDFBytes {
+		return nil, services.ErrPDFTooLarge
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", header.Filename, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, services.MaxPDFBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", header.Filename, err)
+	}
+	if len(data) > services.MaxPDFBytes {
+		return nil, services.ErrPDFTooLarge
+	}
+	return data, nil
+}
+
+// uploadFormFile preprocesses one uploaded image and stores it with its original,
+// unless it repeats one of the owner's uploads or one of batch
+func uploadFormFile(ctx context.Context, ownerID string, header *multipart.FileHeader, batch []models.NotePage) (models.NotePage, error) {
+	if header.Size > services.MaxImageBytes {
+		return models.NotePage{}, services.ErrImageTooLarge
+	}
+	file, err := header.Open()
+	if err != nil {
+		return models.NotePage{}, fmt.Errorf("failed to open %s: %w", header.Filename, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, services.MaxImageBytes+1))
+	if err != nil {
+		return models.NotePage{}, fmt.Errorf("failed to read %s: %w", header.Filename, err)
+	}
+	return services.StoreNoteImage(ctx, ownerID, header.Filename, data, batch)
+}
+
+// syncDocumentIndex refreshes a note's page counts in the document index
+func syncDocumentIndex(ctx context.Context, handler string, node *models.Node) {
+	if err := services.SyncDocumentIndex(ctx, node); err != nil {
+		log.Printf("[%s] Failed to sync document index of note %s: %v", handler, node.ID.Hex(), err)
+	}
+}
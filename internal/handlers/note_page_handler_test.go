@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+func TestNotePageUnauthorizedAccess(t *testing.T) {
+	router := setupTestRouterNoAuth()
+	router.POST("/nodes/:id/pages", AppendNotePages)
+	router.PUT("/nodes/:id/pages", ReorderNotePages)
+	router.DELETE("/nodes/:id/pages/:pageId", DeleteNotePage)
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{"POST", "/nodes/507f1f77bcf86cd799439011/pages"},
+		{"PUT", "/nodes/507f1f77bcf86cd799439011/pages"},
+		{"DELETE", "/nodes/507f1f77bcf86cd799439011/pages/page-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestIsPDFUpload(t *testing.T) {
+	tests := []struct {
+		filename    string
+		contentType string
+		want        bool
+	}{
+		{"slides.pdf", "application/octet-stream", true},
+		{"SLIDES.PDF", "", true},
+		{"upload", "application/pdf", true},
+		{"page.jpg", "image/jpeg", false},
+	}
+	for _, tt := range tests {
+		header := &multipart.FileHeader{Filename: tt.filename, Header: textproto.MIMEHeader{}}
+		header.Header.Set("Content-Type", tt.contentType)
+		if got := isPDFUpload(header); got != tt.want {
+			t.Errorf("isPDFUpload(%q, %q) = %v, want %v", tt.filename, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestUploadBytes(t *testing.T) {
+	files := []*multipart.FileHeader{
+		{Filename: "page-1.jpg", Size: 3000, Header: textproto.MIMEHeader{}},
+		{Filename: "page-2.heic", Size: 2000, Header: textproto.MIMEHeader{}},
+		{Filename: "slides.pdf", Size: 9000, Header: textproto.MIMEHeader{}},
+	}
+	// Only the images are stored as received
+	if got := uploadBytes(files); got != 5000 {
+		t.Errorf("uploadBytes() = %d, want 5000", got)
+	}
+}
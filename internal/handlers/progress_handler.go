@@ -14,6 +14,7 @@ import (
 	"cogniscan/backend/internal/cache"
 	"cogniscan/backend/internal/database"
 	"cogniscan/backend/internal/models"
+	"cogniscan/backend/internal/services"
 )
 
 // UserProgressResponse represents the user progress response
@@ -63,12 +64,7 @@ func GetCurrentUserProgress(c *gin.Context) {
 	err := collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&progress)
 	if err != nil {
 		// Return default progress if not found
-		storageQuotaBytes := int64(10737418240) // 10GB default
-		if quotaStr := os.Getenv("STORAGE_QUOTA_BYTES"); quotaStr != "" {
-			if parsed, parseErr := parseInt64(quotaStr); parseErr == nil {
-				storageQuotaBytes = parsed
-			}
-		}
+		storageQuotaBytes := services.StorageQuotaBytes(nil)
 
 		defaultProgress := &UserProgressResponse{
 			UserID:           userID,
@@ -96,7 +92,7 @@ func GetCurrentUserProgress(c *gin.Context) {
 		DailyGoalPercent:   progress.DailyGoalPercent,
 		DailyGoalDate:      progress.DailyGoalDate,
 		StorageUsedBytes:   progress.StorageUsedBytes,
-		StorageQuotaBytes:  progress.StorageQuotaBytes,
+		StorageQuotaBytes:  services.StorageQuotaBytes(&progress),
 		SessionAccuracy:    progress.SessionAccuracy,
 		SessionAvgSpeed:   progress.SessionAvgSpeed,
 		SessionStreak:     progress.SessionStreak,
@@ -107,12 +103,6 @@ func GetCurrentUserProgress(c *gin.Context) {
 	c.JSON(200, response)
 }
 
-func parseInt64(s string) (int64, error) {
-	var result int64
-	_, err := fmt.Sscanf(s, "%d", &result)
-	return result, err
-}
-
 // UpdateDailyProgress updates daily goal progress
 // @Summary Updates the user's daily goal percentage for cognitive retention tracking
 func UpdateDailyProgress(c *gin.Context) {
@@ -251,7 +241,7 @@ func RecordStudySession(c *gin.Context) {
 }
 
 // GetStorageUsage returns storage usage statistics
-// @Summary Returns the user's current storage usage and quota information
+// @Summary Returns the user's current storage usage and quota information, with the bytes stored in each folder
 func GetStorageUsage(c *gin.Context) {
 	userID := c.GetString("userId")
 	if userID == "" {
@@ -262,49 +252,22 @@ func GetStorageUsage(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	db := database.Client.Database(os.Getenv("DB_NAME"))
-
-	// Get storage quota from user progress or default
-	var progress models.UserProgress
-	storageQuotaBytes := int64(10737418240) // 10GB default
-	err := db.Collection("user_progress").FindOne(ctx, bson.M{"userId": userID}).Decode(&progress)
-	if err == nil && progress.StorageQuotaBytes > 0 {
-		storageQuotaBytes = progress.StorageQuotaBytes
-	}
-
-	// Calculate total storage from notes
-	notesCollection := db.Collection("notes")
-	pipeline := []bson.M{
-		{"$match": bson.M{"ownerId": userID}},
-		{"$count": "totalNotes"},
-	}
-
-	cursor, err := notesCollection.Aggregate(ctx, pipeline)
+	// Usage is kept up to date as blobs are stored and deleted
+	progress, err := services.GetStorageUsage(ctx, userID)
 	if err != nil {
-		log.Printf("Failed to count notes: %v", err)
-	}
-
-	var result struct {
-		TotalNotes int `bson:"totalNotes"`
-	}
-	if cursor.Next(ctx) {
-		cursor.Decode(&result)
+		log.Printf("Failed to fetch storage usage: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch storage usage"})
+		return
 	}
-	cursor.Close(ctx)
 
-	// Estimate storage (assuming average 5MB per note)
-	// In production, you would track actual file sizes
-	const avgNoteSizeMB = 5
-	usedBytes := int64(result.TotalNotes * avgNoteSizeMB * 1024 * 1024)
-
-	// Update user progress with actual storage used
-	update := bson.M{
-		"$set": bson.M{
-			"storageUsedBytes": usedBytes,
-			"updatedAt":        time.Now(),
-		},
+	usedBytes := progress.StorageUsedBytes
+	storageQuotaBytes := services.StorageQuotaBytes(progress)
+	byFolder := make(map[string]int64, len(progress.StorageByFolder))
+	for folderID, bytes := range progress.StorageByFolder {
+		if bytes > 0 {
+			byFolder[folderID] = bytes
+		}
 	}
-	db.Collection("user_progress").UpdateOne(ctx, bson.M{"userId": userID}, update)
 
 	usedGB := float64(usedBytes) / (1024 * 1024 * 1024)
 	quotaGB := float64(storageQuotaBytes) / (1024 * 1024 * 1024)
@@ -318,7 +281,8 @@ func GetStorageUsage(c *gin.Context) {
 		"quotaBytes": storageQuotaBytes,
 		"usedGB":     usedGB,
 		"quotaGB":    quotaGB,
-		"percentage":  percentage,
+		"percentage": percentage,
+		"byFolder":   byFolder,
 	}
 
 	c.JSON(200, storage)
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cogniscan/backend/internal/queue"
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetQueueStats returns ready/processing/delayed/dead counts for the caption and quiz queues
+func GetQueueStats(c *gin.Context) {
+	if !services.IsQueueServiceInitialized() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Queue service not initialized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stats := []*queue.ReliableQueueStats{}
+	for _, q := range []*queue.ReliableQueue{services.GetCaptionQueue(), services.GetQuizQueue()} {
+		s, err := q.Stats(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read queue stats"})
+			return
+		}
+		stats = append(stats, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"queues": stats})
+}
+
+// GetDeadLetterJobs lists dead-lettered jobs of a queue (?offset=&limit=, default 50, max 500)
+func GetDeadLetterJobs(c *gin.Context) {
+	q, ok := jobQueueFromParam(c)
+	if !ok {
+		return
+	}
+
+	offset, _ := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	jobs, err := q.ListDead(ctx, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list dead-lettered jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"queue":  q.Name(),
+		"jobs":   jobs,
+		"offset": offset,
+		"limit":  limit,
+	})
+}
+
+// RequeueDeadLetterJob moves one dead-lettered job back onto its queue
+func RequeueDeadLetterJob(c *gin.Context) {
+	q, ok := jobQueueFromParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := q.RequeueDead(ctx, c.Param("jobId")); err != nil {
+		if err == queue.ErrJobNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found in dead-letter queue"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job requeued", "jobId": c.Param("jobId")})
+}
+
+// RequeueAllDeadLetterJobs moves every dead-lettered job back onto its queue
+func RequeueAllDeadLetterJobs(c *gin.Context) {
+	q, ok := jobQueueFromParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	count, err := q.RequeueAllDead(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue jobs", "requeued": count})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Jobs requeued", "requeued": count})
+}
+
+// PurgeDeadLetterJob deletes one dead-lettered job
+func PurgeDeadLetterJob(c *gin.Context) {
+	q, ok := jobQueueFromParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := q.PurgeDead(ctx, c.Param("jobId")); err != nil {
+		if err == queue.ErrJobNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found in dead-letter queue"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job purged", "jobId": c.Param("jobId")})
+}
+
+// PurgeAllDeadLetterJobs deletes every dead-lettered job of a queue
+func PurgeAllDeadLetterJobs(c *gin.Context) {
+	q, ok := jobQueueFromParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := q.PurgeAllDead(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Jobs purged", "purged": count})
+}
+
+// jobQueueFromParam resolves the :queue route param, writing the error response when it fails
+func jobQueueFromParam(c *gin.Context) (*queue.ReliableQueue, bool) {
+	if !services.IsQueueServiceInitialized() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Queue service not initialized"})
+		return nil, false
+	}
+
+	q := services.GetJobQueue(c.Param("queue"))
+	if q == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown queue (expected caption or quiz)"})
+		return nil, false
+	}
+	return q, true
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueueHandlersWithoutQueueService(t *testing.T) {
+	router := setupTestRouterWithUserID("admin@example.com")
+	router.GET("/admin/queues", GetQueueStats)
+	router.GET("/admin/queues/:queue/dead", GetDeadLetterJobs)
+	router.POST("/admin/queues/:queue/dead/requeue", RequeueAllDeadLetterJobs)
+	router.POST("/admin/queues/:queue/dead/:jobId/requeue", RequeueDeadLetterJob)
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/admin/queues"},
+		{"GET", "/admin/queues/caption/dead"},
+		{"POST", "/admin/queues/quiz/dead/requeue"},
+		{"POST", "/admin/queues/quiz/dead/job-1/requeue"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -78,6 +79,36 @@ func CreateQuiz(c *gin.Context) {
 	})
 }
 
+// CreateTagQuiz generates a quiz from the notes carrying a tag, directly or through a folder
+func CreateTagQuiz(c *gin.Context) {
+	createScopedQuiz(c, services.Scope{Tag: c.Param("tag")})
+}
+
+// CreateCollectionQuiz generates a quiz from the notes matching a smart collection
+func CreateCollectionQuiz(c *gin.Context) {
+	createScopedQuiz(c, services.Scope{CollectionID: c.Param("collectionId")})
+}
+
+// createScopedQuiz generates a tag or collection quiz synchronously
+func createScopedQuiz(c *gin.Context, scope services.Scope) {
+	firebaseUser := middleware.ForContext(c.Request.Context())
+	if firebaseUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	quiz, questions, err := services.CreateQuizForScope(c.Request.Context(), scope, firebaseUser.Claims["email"].(string))
+	if err != nil {
+		writeTagError(c, "CreateScopedQuiz", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateQuizResponse{
+		Quiz:      quiz,
+		Questions: questions,
+	})
+}
+
 // RequestQuizGeneration starts asynchronous quiz generation
 func RequestQuizGeneration(c *gin.Context) {
 	firebaseUser := middleware.ForContext(c.Request.Context())
@@ -90,6 +121,10 @@ func RequestQuizGeneration(c *gin.Context) {
 
 	// Check if a quiz is already being generated
 	status, err := services.GetFolderQuizStatus(c.Request.Context(), folderID, firebaseUser.Claims["email"].(string))
+	if errors.Is(err, services.ErrNodeNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
 	if err == nil && (status.Status == models.QuizGenStatusPending || status.Status == models.QuizGenStatusProcessing) {
 		c.JSON(http.StatusConflict, gin.H{"error": "Quiz generation already in progress"})
 		return
@@ -411,7 +446,8 @@ func GetQuizSummary(c *gin.Context) {
 	})
 }
 
-// RegenerateQuiz deletes the existing quiz and triggers regeneration for a folder
+// RegenerateQuiz deletes the existing quiz and triggers regeneration for a folder, or
+// regenerates a tag or collection quiz directly
 func RegenerateQuiz(c *gin.Context) {
 	firebaseUser := middleware.ForContext(c.Request.Context())
 	if firebaseUser == nil {
@@ -462,6 +498,21 @@ func RegenerateQuiz(c *gin.Context) {
 	quizzesCollection := services.GetQuizCollection()
 	quizzesCollection.DeleteOne(c.Request.Context(), bson.M{"_id": quiz.ID, "ownerId": firebaseUser.Claims["email"].(string)})
 
+	// Tag and collection quizzes have no folder status to drive a job; regenerate them in place
+	if folderID == "" {
+		scope := services.Scope{Tag: quiz.Tag, CollectionID: quiz.CollectionID}
+		newQuiz, questions, err := services.CreateQuizForScope(c.Request.Context(), scope, firebaseUser.Claims["email"].(string))
+		if err != nil {
+			writeTagError(c, "RegenerateQuiz", err)
+			return
+		}
+		c.JSON(http.StatusOK, CreateQuizResponse{
+			Quiz:      newQuiz,
+			Questions: questions,
+		})
+		return
+	}
+
 	// Create job for regeneration
 	jobID := uuid.New().String()
 	job := queue.QuizJob{
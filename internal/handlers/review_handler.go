@@ -26,7 +26,7 @@ type ReviewItem struct {
 	SuccessRate  float64 `json:"successRate"`
 }
 
-// GetReviewQueue returns notes due for review
+// GetReviewQueue returns notes due for review, optionally limited to a ?tag= or ?collectionId=
 func GetReviewQueue(c *gin.Context) {
 	firebaseUser := middleware.ForContext(c.Request.Context())
 	if firebaseUser == nil {
@@ -41,7 +41,11 @@ func GetReviewQueue(c *gin.Context) {
 		}
 	}
 
-	reviews, err := services.GetReviewQueue(c.Request.Context(), firebaseUser.Claims["email"].(string), limit)
+	reviews, err := services.GetReviewQueue(c.Request.Context(), firebaseUser.Claims["email"].(string), scopeFromQuery(c), limit)
+	if isScopeError(err) {
+		writeTagError(c, "GetReviewQueue", err)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get review queue"})
 		return
@@ -60,7 +64,7 @@ func GetReviewQueue(c *gin.Context) {
 	}
 
 	// Create map of note ID to note
-	noteMap := make(map[string]models.Note)
+	noteMap := make(map[string]models.Node)
 	for _, note := range notes {
 		noteMap[note.ID.Hex()] = note
 	}
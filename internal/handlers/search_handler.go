@@ -2,18 +2,18 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"sync"
+	"strconv"
 	"time"
 
-	"cogniscan/backend/internal/database"
 	"cogniscan/backend/internal/middleware"
 	"cogniscan/backend/internal/models"
+	"cogniscan/backend/internal/services"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 // SearchResultItem defines a generic structure for search results.
@@ -25,15 +25,22 @@ type SearchResultItem struct {
 	MegaURL   string    `json:"megaUrl,omitempty"`  // For notes
 	FolderID  string    `json:"folderId,omitempty"` // For notes
 	CreatedAt time.Time `json:"createdAt"`
-
-	// New fields for redesign relevance and mastery
-	Relevance      float64 `json:"relevance"`              // 0-1 relevance score
-	MasteryLevel   string  `json:"masteryLevel"`           // "Mastered", "Learnt", "Review Soon"
-	MasteryPercent float64 `json:"masteryPercent"`         // 0-1 mastery percentage
-	ThumbnailURL   string  `json:"thumbnailUrl,omitempty"` // For notes with images
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Relevance and mastery
+	Relevance      float64            `json:"relevance"`              // 0-1 relevance score
+	MasteryLevel   string             `json:"masteryLevel"`           // "Mastered", "Learnt", "Review Soon"
+	MasteryPercent float64            `json:"masteryPercent"`         // 0-1 mastery percentage
+	Mastery        models.NodeMastery `json:"mastery"`                // Full mastery stored on the node
+	ThumbnailURL   string             `json:"thumbnailUrl,omitempty"` // For notes with images
+
+	// Why the item matched
+	Snippet   string              `json:"snippet,omitempty"` // Caption excerpt, HTML-escaped, query terms wrapped in <mark>
+	Passage   *services.TextRange `json:"passage,omitempty"` // Character offsets of the caption chunk that matched semantically
+	MatchedBy []string            `json:"matchedBy"`         // "name", "caption" and/or "semantic"
 }
 
-// SearchInsight represents AI-generated insight for search results
+// SearchInsight summarizes the matched results
 type SearchInsight struct {
 	Summary       string   `json:"summary"`       // Contextual summary of results
 	RelatedTopics []string `json:"relatedTopics"` // Related topics to explore
@@ -45,138 +52,178 @@ type SearchResponse struct {
 	Items   []SearchResultItem `json:"items"`
 	Insight *SearchInsight     `json:"insight,omitempty"`
 	Total   int                `json:"total"`
+	Offset  int                `json:"offset"`
+	Limit   int                `json:"limit"`
+	HasMore bool               `json:"hasMore"`
 }
 
-// SearchItems searches for folders and notes matching a query with relevance scoring.
-// @Summary Performs semantic search across folders and notes with relevance scoring and AI-generated insights
+// SearchItems runs a hybrid search over the user's folders and notes: names and caption
+// terms are ranked lexically, captions also semantically, and the rankings are fused.
+// Query parameters:
+//   - q: search text (required; an empty query returns no items)
+//   - sort: relevance (default), date or mastery; order=asc reverses the default descending order
+//   - type: folder or note
+//   - folderId: only search below this folder
+//   - mastery: mastered, learnt or review_soon
+//   - tag, collectionId: only search nodes carrying a tag or matching a smart collection
+//   - offset, limit: pagination (limit defaults to 20, max 100)
 func SearchItems(c *gin.Context) {
-	query := c.Query("q")
-	sortType := c.DefaultQuery("sort", "relevance") // "relevance", "date", "mastery"
-	if query == "" {
-		c.JSON(http.StatusOK, []SearchResultItem{})
-		return
-	}
-
 	firebaseUser := middleware.ForContext(c.Request.Context())
 	if firebaseUser == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var results []SearchResultItem
+	sortBy, err := services.ParseSearchSort(c.Query("sort"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	masteryLevel, err := services.ParseMasteryLevel(c.Query("mastery"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var nodeType models.NodeType
+	switch t := models.NodeType(c.Query("type")); t {
+	case "", models.NodeTypeFolder, models.NodeTypeNote:
+		nodeType = t
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type, expected folder or note"})
+		return
+	}
+
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(services.SearchDefaultLimit)))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || limit > services.SearchMaxLimit {
+		limit = services.SearchDefaultLimit
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Search folders
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		foldersCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("folders")
-		filter := bson.M{
-			"ownerId": firebaseUser.Claims["email"],
-			"name":    bson.M{"$regex": query, "$options": "i"}, // Case-insensitive regex search
-		}
-		cursor, err := foldersCollection.Find(ctx, filter)
-		if err != nil {
-			log.Printf("Error searching folders: %v", err)
-			return
-		}
-		defer cursor.Close(ctx)
-
-		var folders []models.Folder
-		if err = cursor.All(ctx, &folders); err == nil {
-			mu.Lock()
-			for _, f := range folders {
-				results = append(results, SearchResultItem{
-					Type:     "folder",
-					ID:       f.ID.Hex(),
-					Name:     f.Name,
-					ParentID: f.ParentID,
-				})
-			}
-			mu.Unlock()
-		}
-	}()
-
-	// Search notes
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		notesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("notes")
-		filter := bson.M{
-			"ownerId": firebaseUser.Claims["email"],
-			"$or": []bson.M{
-				{"name": bson.M{"$regex": query, "$options": "i"}},
-				{"caption": bson.M{"$regex": query, "$options": "i"}},
-			},
-		}
-		cursor, err := notesCollection.Find(ctx, filter)
-		if err != nil {
-			log.Printf("Error searching notes: %v", err)
-			return
+	results, err := services.SearchNodes(ctx, services.SearchOptions{
+		Query:        c.Query("q"),
+		OwnerID:      firebaseUser.Claims["email"].(string),
+		Type:         nodeType,
+		SubtreeID:    c.Query("folderId"),
+		MasteryLevel: masteryLevel,
+		Scope:        scopeFromQuery(c),
+		Sort:         sortBy,
+		Ascending:    c.Query("order") == "asc",
+		Offset:       offset,
+		Limit:        limit,
+	})
+	if err != nil {
+		switch {
+		case isScopeError(err):
+			writeTagError(c, "SearchItems", err)
+		case errors.Is(err, services.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		case errors.Is(err, services.ErrInvalidNodeType):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "folderId must refer to a folder"})
+		default:
+			log.Printf("Error searching nodes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
 		}
-		defer cursor.Close(ctx)
-
-		var notes []models.Note
-		if err = cursor.All(ctx, &notes); err == nil {
-			mu.Lock()
-			for _, n := range notes {
-				results = append(results, SearchResultItem{
-					Type:      "note",
-					ID:        n.ID.Hex(),
-					Name:      n.Name,
-					FolderID:  n.FolderID,
-					CreatedAt: n.CreatedAt,
-				})
-			}
-			mu.Unlock()
-		}
-	}()
+		return
+	}
 
-	wg.Wait()
+	items := make([]SearchResultItem, 0, len(results.Hits))
+	for _, hit := range results.Hits {
+		items = append(items, searchResultItem(hit))
+	}
 
-	// Calculate relevance scores (mock implementation - should use vector embeddings in production)
-	for i := range results {
-		results[i].Relevance = 1.0 - (float64(i) * 0.05) // Mock relevance calculation
+	c.JSON(http.StatusOK, SearchResponse{
+		Items:   items,
+		Insight: buildSearchInsight(c.Query("q"), items, results.Total),
+		Total:   results.Total,
+		Offset:  offset,
+		Limit:   limit,
+		HasMore: offset+len(items) < results.Total,
+	})
+}
 
-		// Set mastery level based on FolderMastery data (mock)
-		if results[i].Type == "folder" {
-			results[i].MasteryLevel = "Mastered"
-			results[i].MasteryPercent = 0.74
-		} else {
-			results[i].MasteryLevel = "Review Soon"
-			results[i].MasteryPercent = 0.45
-		}
+// searchResultItem converts a search hit into its response form
+func searchResultItem(hit services.SearchHit) SearchResultItem {
+	node := hit.Node
+	item := SearchResultItem{
+		Type:           string(node.Metadata.Type),
+		ID:             node.ID.Hex(),
+		Name:           node.Name,
+		CreatedAt:      node.CreatedAt,
+		UpdatedAt:      node.UpdatedAt,
+		Relevance:      hit.Score,
+		MasteryLevel:   services.NodeMasteryLevel(node),
+		MasteryPercent: node.Mastery.MasteryPercent,
+		Mastery:        node.Mastery,
+		Snippet:        hit.Snippet,
+		Passage:        hit.Passage,
+		MatchedBy:      hit.MatchedBy,
 	}
+	if node.Metadata.Type == models.NodeTypeNote {
+		item.FolderID = node.ParentID
+		item.MegaURL = node.PublicURL
+	} else {
+		item.ParentID = node.ParentID
+	}
+	return item
+}
 
-	// Sort results based on sort type
-	results = sortResults(results, sortType)
+// buildSearchInsight summarizes a page of results: how many folders and notes matched,
+// which matched folders to explore, and what to study next
+func buildSearchInsight(query string, items []SearchResultItem, total int) *SearchInsight {
+	if len(items) == 0 {
+		return nil
+	}
 
-	// Generate AI insight if results exist
-	var insight *SearchInsight
-	if len(results) > 0 {
-		insight = &SearchInsight{
-			Summary:       "Based on your search for \"" + query + "\", you might want to revisit your notes on related topics in this folder.",
-			RelatedTopics: []string{"Backpropagation Fundamentals", "Neural Architecture", "Optimization Techniques"},
-			Suggestion:    "Generate a quiz for the top 5 results",
+	var folders, notes, reviewSoon int
+	var topics []string
+	var weakest *SearchResultItem
+	for i := range items {
+		item := &items[i]
+		if item.Type == string(models.NodeTypeFolder) {
+			folders++
+			if len(topics) < 3 {
+				topics = append(topics, item.Name)
+			}
+		} else {
+			notes++
+		}
+		if item.MasteryLevel == "Review Soon" {
+			reviewSoon++
+		}
+		if weakest == nil || item.MasteryPercent < weakest.MasteryPercent {
+			weakest = item
 		}
 	}
+	if topics == nil {
+		topics = []string{}
+	}
 
-	response := SearchResponse{
-		Items:   results,
-		Insight: insight,
-		Total:   len(results),
+	summary := fmt.Sprintf("Found %d results for \"%s\" (%d folders and %d notes shown).", total, query, folders, notes)
+	if reviewSoon > 0 {
+		summary += fmt.Sprintf(" %d of them are due for review.", reviewSoon)
 	}
 
-	c.JSON(http.StatusOK, response)
-}
+	var suggestion string
+	switch {
+	case weakest != nil && weakest.MasteryLevel == "Review Soon" && weakest.Type == string(models.NodeTypeFolder):
+		suggestion = fmt.Sprintf("Generate a quiz for \"%s\"", weakest.Name)
+	case weakest != nil && weakest.MasteryLevel == "Review Soon":
+		suggestion = fmt.Sprintf("Review \"%s\"", weakest.Name)
+	case len(topics) > 0:
+		suggestion = fmt.Sprintf("Generate a quiz for \"%s\" to keep it fresh", topics[0])
+	}
 
-// sortResults sorts the search results based on the specified sort type
-func sortResults(results []SearchResultItem, sortType string) []SearchResultItem {
-	// Mock sorting - should implement proper sorting based on relevance, date, or mastery
-	// This is a placeholder for production implementation
-	return results
+	return &SearchInsight{
+		Summary:       summary,
+		RelatedTopics: topics,
+		Suggestion:    suggestion,
+	}
 }
@@ -3,9 +3,11 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,6 +32,48 @@ func setupSearchTestRouter() *gin.Engine {
 	return router
 }
 
+// seedSearchNodes inserts nodes and their captions for the search tests
+func seedSearchNodes(t *testing.T, ctx context.Context, nodes []models.Node, captions map[primitive.ObjectID]string) {
+	db := database.Client.Database(os.Getenv("DB_NAME"))
+	for _, node := range nodes {
+		if _, err := db.Collection("nodes").InsertOne(ctx, node); err != nil {
+			t.Skip("Skipping test: MongoDB not available")
+		}
+	}
+	for noteID, caption := range captions {
+		embedding := models.CaptionEmbedding{
+			NoteID:  noteID.Hex(),
+			OwnerID: "test-user-id",
+			Caption: caption,
+		}
+		if _, err := db.Collection("caption_embeddings").InsertOne(ctx, embedding); err != nil {
+			t.Skip("Skipping test: MongoDB not available")
+		}
+	}
+}
+
+func searchFolder(name, parentID, ownerID string) models.Node {
+	return models.Node{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		ParentID:  parentID,
+		OwnerID:   ownerID,
+		Metadata:  models.NodeMetadata{Type: models.NodeTypeFolder},
+		CreatedAt: time.Now(),
+	}
+}
+
+func searchNote(name, parentID, ownerID string) models.Node {
+	return models.Node{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		ParentID:  parentID,
+		OwnerID:   ownerID,
+		Metadata:  models.NodeMetadata{Type: models.NodeTypeNote, DriveID: "drive-" + name},
+		CreatedAt: time.Now(),
+	}
+}
+
 func TestSearchItems(t *testing.T) {
 	os.Setenv("MONGO_URI", "mongodb://localhost:27017")
 	os.Setenv("DB_NAME", "cogniscan_test")
@@ -38,158 +82,97 @@ func TestSearchItems(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Create test folders
-	foldersCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("folders")
-	notesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("notes")
+	important := searchFolder("Important Documents", "", "test-user-id")
+	photos := searchFolder("Photos", "", "test-user-id")
+	work := searchFolder("Work Files", "", "other-user-id")
+
+	meeting := searchNote("Important Meeting Notes", important.ID.Hex(), "test-user-id")
+	meeting.Mastery = models.NodeMastery{MasteryLevel: "Mastered", MasteryPercent: 1}
+	vacation := searchNote("Vacation Photo", photos.ID.Hex(), "test-user-id")
+	scan := searchNote("Photo of Document", photos.ID.Hex(), "test-user-id")
+	other := searchNote("Other User Note", work.ID.Hex(), "other-user-id")
+
+	seedSearchNodes(t, ctx,
+		[]models.Node{important, photos, work, meeting, vacation, scan, other},
+		map[primitive.ObjectID]string{
+			meeting.ID:  "Meeting notes about important project",
+			vacation.ID: "Beautiful sunset at the beach",
+			scan.ID:     "Scan of important document",
+		})
 
-	// Insert test folders
-	testFolders := []models.Folder{
-		{
-			ID:       primitive.NewObjectID(),
-			Name:     "Important Documents",
-			ParentID: "",
-			OwnerID:  "test-user-id",
-		},
-		{
-			ID:       primitive.NewObjectID(),
-			Name:     "Photos",
-			ParentID: "",
-			OwnerID:  "test-user-id",
-		},
-		{
-			ID:       primitive.NewObjectID(),
-			Name:     "Work Files",
-			ParentID: "",
-			OwnerID:  "other-user-id",
-		},
-	}
+	defer database.Client.Database(os.Getenv("DB_NAME")).Drop(ctx)
 
-	for _, folder := range testFolders {
-		_, err := foldersCollection.InsertOne(ctx, folder)
-		if err != nil {
-			t.Skip("Skipping test: MongoDB not available")
-		}
-	}
+	router := setupSearchTestRouter()
+	router.GET("/search", SearchItems)
 
-	// Insert test notes
-	testNotes := []models.Note{
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []string
+	}{
 		{
-			ID:       primitive.NewObjectID(),
-			Name:     "Important Meeting Notes",
-			DriveID:  "drive1",
-			FolderID: testFolders[0].ID.Hex(),
-			OwnerID:  "test-user-id",
-			Caption:  "Meeting notes about important project",
+			name:       "Search for 'important' - matches folder name and captions",
+			query:      "q=important",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{important.ID.Hex(), meeting.ID.Hex(), scan.ID.Hex()},
 		},
 		{
-			ID:       primitive.NewObjectID(),
-			Name:     "Vacation Photo",
-			DriveID:  "drive2",
-			FolderID: testFolders[1].ID.Hex(),
-			OwnerID:  "test-user-id",
-			Caption:  "Beautiful sunset at the beach",
+			name:       "Search for 'photo' - matches folder and note names",
+			query:      "q=photo",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{photos.ID.Hex(), vacation.ID.Hex(), scan.ID.Hex()},
 		},
 		{
-			ID:       primitive.NewObjectID(),
-			Name:     "Photo of Document",
-			DriveID:  "drive3",
-			FolderID: testFolders[1].ID.Hex(),
-			OwnerID:  "test-user-id",
-			Caption:  "Scan of important document",
+			name:       "Search for 'beach' - matches only caption",
+			query:      "q=beach",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{vacation.ID.Hex()},
 		},
 		{
-			ID:       primitive.NewObjectID(),
-			Name:     "Other User Note",
-			DriveID:  "drive4",
-			FolderID: "folder123",
-			OwnerID:  "other-user-id",
-			Caption:  "Other user content",
-		},
-	}
-
-	for _, note := range testNotes {
-		_, err := notesCollection.InsertOne(ctx, note)
-		if err != nil {
-			t.Skip("Skipping test: MongoDB not available")
-		}
-	}
-
-	defer database.Client.Database(os.Getenv("DB_NAME")).Drop(ctx)
-
-	router := setupSearchTestRouter()
-	router.GET("/search", SearchItems)
-
-	tests := []struct {
-		name              string
-		query             string
-		wantStatus        int
-		wantMinResults    int
-		wantMaxResults    int
-		expectedFolderIDs []string
-	}{
-		{
-			name:           "Search for 'important' - matches both folder name and captions",
-			query:          "important",
-			wantStatus:     http.StatusOK,
-			wantMinResults: 3,
-			wantMaxResults: 3,
-			expectedFolderIDs: []string{
-				testFolders[0].ID.Hex(), // "Important Documents" folder
-			},
+			name:       "Type filter - notes only",
+			query:      "q=important&type=note",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{meeting.ID.Hex(), scan.ID.Hex()},
 		},
 		{
-			name:           "Search for 'photo' - matches folder and note name",
-			query:          "photo",
-			wantStatus:     http.StatusOK,
-			wantMinResults: 2,
-			wantMaxResults: 2,
-			expectedFolderIDs: []string{
-				testFolders[1].ID.Hex(), // "Photos" folder
-			},
+			name:       "Subtree filter - inside Photos",
+			query:      "q=photo&folderId=" + photos.ID.Hex(),
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{vacation.ID.Hex(), scan.ID.Hex()},
 		},
 		{
-			name:           "Search for 'beach' - matches only caption",
-			query:          "beach",
-			wantStatus:     http.StatusOK,
-			wantMinResults: 1,
-			wantMaxResults: 1,
+			name:       "Mastery filter - mastered only",
+			query:      "q=important&mastery=mastered",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{meeting.ID.Hex()},
 		},
 		{
-			name:           "Search for 'meeting' - matches note name",
-			query:          "meeting",
-			wantStatus:     http.StatusOK,
-			wantMinResults: 1,
-			wantMaxResults: 1,
+			name:       "Other user's folder as subtree",
+			query:      "q=note&folderId=" + work.ID.Hex(),
+			wantStatus: http.StatusNotFound,
 		},
 		{
-			name:           "Empty query - returns empty array",
-			query:          "",
-			wantStatus:     http.StatusOK,
-			wantMinResults: 0,
-			wantMaxResults: 0,
+			name:       "Empty query - returns no items",
+			query:      "q=",
+			wantStatus: http.StatusOK,
 		},
 		{
-			name:           "No matches - returns empty array",
-			query:          "nonexistent",
-			wantStatus:     http.StatusOK,
-			wantMinResults: 0,
-			wantMaxResults: 0,
+			name:       "No matches - returns no items",
+			query:      "q=nonexistent",
+			wantStatus: http.StatusOK,
 		},
 		{
-			name:           "Case insensitive search - 'IMPORTANT' should match",
-			query:          "IMPORTANT",
-			wantStatus:     http.StatusOK,
-			wantMinResults: 3,
-			wantMaxResults: 3,
-			expectedFolderIDs: []string{
-				testFolders[0].ID.Hex(), // "Important Documents" folder
-			},
+			name:       "Case insensitive search - 'IMPORTANT' should match",
+			query:      "q=IMPORTANT",
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{important.ID.Hex(), meeting.ID.Hex(), scan.ID.Hex()},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req, err := http.NewRequest("GET", "/search?q="+tt.query, nil)
+			req, err := http.NewRequest("GET", "/search?"+tt.query, nil)
 			if err != nil {
 				t.Fatalf("Failed to create request: %v", err)
 			}
@@ -198,59 +181,38 @@ func TestSearchItems(t *testing.T) {
 			router.ServeHTTP(w, req)
 
 			if w.Code != tt.wantStatus {
-				t.Errorf("SearchItems() status = %v, want %v", w.Code, tt.wantStatus)
+				t.Fatalf("SearchItems() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
 			}
 
-			var results []SearchResultItem
-			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+			var response SearchResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
 				t.Fatalf("Failed to unmarshal response: %v", err)
 			}
 
-			if len(results) < tt.wantMinResults || len(results) > tt.wantMaxResults {
-				t.Errorf("SearchItems() results count = %v, want between %d and %d", len(results), tt.wantMinResults, tt.wantMaxResults)
+			if response.Total != len(tt.wantIDs) || len(response.Items) != len(tt.wantIDs) {
+				t.Fatalf("SearchItems() returned %d items (total %d), want %d", len(response.Items), response.Total, len(tt.wantIDs))
 			}
 
-			// Verify all results belong to the test user
-			for _, result := range results {
-				if result.Type == "folder" {
-					// Check if folder is in expected list
-					found := false
-					for _, expectedID := range tt.expectedFolderIDs {
-						if result.ID == expectedID {
-							found = true
-							break
-						}
-					}
-					if len(tt.expectedFolderIDs) > 0 && !found {
-						t.Logf("Warning: Unexpected folder in results: %s (name: %s)", result.ID, result.Name)
-					}
+			want := make(map[string]bool)
+			for _, id := range tt.wantIDs {
+				want[id] = true
+			}
+			for i, result := range response.Items {
+				if !want[result.ID] {
+					t.Errorf("Unexpected result: %s (name: %s)", result.ID, result.Name)
+				}
+				if i > 0 && result.Relevance > response.Items[i-1].Relevance {
+					t.Errorf("Results are not sorted by relevance: %v after %v", result.Relevance, response.Items[i-1].Relevance)
 				}
 			}
 		})
 	}
 }
 
-func TestSearchItems_Unauthorized(t *testing.T) {
-	gin.SetMode(gin.TestMode)
-	router := gin.New()
-
-	// No auth middleware - should result in unauthorized
-	router.GET("/search", SearchItems)
-
-	req, err := http.NewRequest("GET", "/search?q=test", nil)
-	if err != nil {
-		t.Fatalf("Failed to create request: %v", err)
-	}
-
-	w := httptest.NewRecorder()
-	router.ServeHTTP(w, req)
-
-	if w.Code != http.StatusUnauthorized {
-		t.Errorf("SearchItems() without auth should return 401, got %v", w.Code)
-	}
-}
-
-func TestSearchItems_ResultTypes(t *testing.T) {
+func TestSearchItems_Pagination(t *testing.T) {
 	os.Setenv("MONGO_URI", "mongodb://localhost:27017")
 	os.Setenv("DB_NAME", "cogniscan_test")
 	database.ConnectDB()
@@ -258,114 +220,53 @@ func TestSearchItems_ResultTypes(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Create a folder and a note with matching names
-	folderID := primitive.NewObjectID()
-	noteID := primitive.NewObjectID()
-
-	foldersCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("folders")
-	notesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("notes")
-
-	testFolder := models.Folder{
-		ID:       folderID,
-		Name:     "Test",
-		ParentID: "",
-		OwnerID:  "test-user-id",
-	}
-
-	testNote := models.Note{
-		ID:       noteID,
-		Name:     "Test",
-		DriveID:  "drive1",
-		FolderID: "folder123",
-		OwnerID:  "test-user-id",
-		Caption:  "Test caption",
-	}
-
-	_, err := foldersCollection.InsertOne(ctx, testFolder)
-	if err != nil {
-		t.Skip("Skipping test: MongoDB not available")
-	}
-	_, err = notesCollection.InsertOne(ctx, testNote)
-	if err != nil {
-		t.Skip("Skipping test: MongoDB not available")
+	var nodes []models.Node
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, searchFolder(fmt.Sprintf("Chapter %d", i), "", "test-user-id"))
 	}
+	seedSearchNodes(t, ctx, nodes, nil)
 
 	defer database.Client.Database(os.Getenv("DB_NAME")).Drop(ctx)
 
 	router := setupSearchTestRouter()
 	router.GET("/search", SearchItems)
 
-	req, err := http.NewRequest("GET", "/search?q=Test", nil)
-	if err != nil {
-		t.Fatalf("Failed to create request: %v", err)
-	}
-
-	w := httptest.NewRecorder()
-	router.ServeHTTP(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Errorf("SearchItems() status = %v, want %v", w.Code, http.StatusOK)
-	}
+	seen := make(map[string]bool)
+	for offset := 0; offset < 5; offset += 2 {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/search?q=chapter&sort=date&limit=2&offset=%d", offset), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
 
-	var results []SearchResultItem
-	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
-		t.Fatalf("Failed to unmarshal response: %v", err)
-	}
-
-	// Should have both folder and note results
-	if len(results) != 2 {
-		t.Errorf("Expected 2 results (1 folder, 1 note), got %d", len(results))
-	}
-
-	// Verify we have one folder and one note
-	folderCount := 0
-	noteCount := 0
-	for _, result := range results {
-		if result.Type == "folder" {
-			folderCount++
-		} else if result.Type == "note" {
-			noteCount++
+		var response SearchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.Total != 5 {
+			t.Fatalf("SearchItems() total = %d, want 5", response.Total)
+		}
+		if response.HasMore != (offset+len(response.Items) < 5) {
+			t.Errorf("SearchItems() hasMore = %v at offset %d", response.HasMore, offset)
+		}
+		for _, item := range response.Items {
+			if seen[item.ID] {
+				t.Errorf("Item %s returned on more than one page", item.ID)
+			}
+			seen[item.ID] = true
 		}
 	}
-
-	if folderCount != 1 {
-		t.Errorf("Expected 1 folder result, got %d", folderCount)
-	}
-	if noteCount != 1 {
-		t.Errorf("Expected 1 note result, got %d", noteCount)
+	if len(seen) != 5 {
+		t.Errorf("Pages covered %d items, want 5", len(seen))
 	}
 }
 
-func TestSearchItems_Structure(t *testing.T) {
-	os.Setenv("MONGO_URI", "mongodb://localhost:27017")
-	os.Setenv("DB_NAME", "cogniscan_test")
-	database.ConnectDB()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Create a test folder
-	folderID := primitive.NewObjectID()
-	foldersCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("folders")
-
-	testFolder := models.Folder{
-		ID:       folderID,
-		Name:     "Test Folder",
-		ParentID: "",
-		OwnerID:  "test-user-id",
-	}
-
-	_, err := foldersCollection.InsertOne(ctx, testFolder)
-	if err != nil {
-		t.Skip("Skipping test: MongoDB not available")
-	}
-
-	defer database.Client.Database(os.Getenv("DB_NAME")).Drop(ctx)
+func TestSearchItems_Unauthorized(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
 
-	router := setupSearchTestRouter()
+	// No auth middleware - should result in unauthorized
 	router.GET("/search", SearchItems)
 
-	req, err := http.NewRequest("GET", "/search?q=Test", nil)
+	req, err := http.NewRequest("GET", "/search?q=test", nil)
 	if err != nil {
 		t.Fatalf("Failed to create request: %v", err)
 	}
@@ -373,38 +274,33 @@ func TestSearchItems_Structure(t *testing.T) {
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	var results []SearchResultItem
-	json.Unmarshal(w.Body.Bytes(), &results)
-
-	if len(results) > 0 {
-		result := results[0]
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("SearchItems() without auth should return 401, got %v", w.Code)
+	}
+}
 
-		// Verify result structure
-		if result.Type == "" {
-			t.Error("Search result is missing Type field")
-		}
-		if result.ID == "" {
-			t.Error("Search result is missing ID field")
-		}
-		if result.Name == "" {
-			t.Error("Search result is missing Name field")
-		}
-		if result.CreatedAt.IsZero() {
-			t.Error("Search result is missing or invalid CreatedAt field")
-		}
+func TestBuildSearchInsight(t *testing.T) {
+	if insight := buildSearchInsight("cells", nil, 0); insight != nil {
+		t.Errorf("buildSearchInsight() with no items = %+v, want nil", insight)
+	}
 
-		// Folder-specific fields
-		if result.Type == "folder" {
-			if result.ParentID == "" {
-				// Empty is valid for root folders
-			}
-		}
+	items := []SearchResultItem{
+		{Type: "folder", Name: "Biology", MasteryLevel: "Learnt", MasteryPercent: 0.6},
+		{Type: "note", Name: "Cell membrane", MasteryLevel: "Review Soon", MasteryPercent: 0},
+		{Type: "note", Name: "Mitochondria", MasteryLevel: "Mastered", MasteryPercent: 1},
+	}
 
-		// Note-specific fields
-		if result.Type == "note" {
-			if result.FolderID == "" {
-				t.Error("Note result is missing FolderID field")
-			}
-		}
+	insight := buildSearchInsight("cells", items, 7)
+	if insight == nil {
+		t.Fatal("buildSearchInsight() = nil, want insight")
+	}
+	if !strings.Contains(insight.Summary, "Found 7 results") || !strings.Contains(insight.Summary, "1 of them are due for review") {
+		t.Errorf("Summary = %q", insight.Summary)
+	}
+	if len(insight.RelatedTopics) != 1 || insight.RelatedTopics[0] != "Biology" {
+		t.Errorf("RelatedTopics = %v, want [Biology]", insight.RelatedTopics)
+	}
+	if insight.Suggestion != `Review "Cell membrane"` {
+		t.Errorf("Suggestion = %q, want review of the weakest note", insight.Suggestion)
 	}
 }
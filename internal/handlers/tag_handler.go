@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TagNodePayload defines the expected JSON for tagging a node
+type TagNodePayload struct {
+	Tags []string `json:"tags" binding:"required"`
+}
+
+// TagNode adds tags to a node
+func TagNode(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var payload TagNodePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	node, err := services.TagNode(ctx, c.Param("id"), userID, payload.Tags)
+	if err != nil {
+		writeTagError(c, "TagNode", err)
+		return
+	}
+	c.JSON(http.StatusOK, node)
+}
+
+// UntagNode removes a tag from a node
+func UntagNode(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	node, err := services.UntagNode(ctx, c.Param("id"), userID, c.Param("tag"))
+	if err != nil {
+		writeTagError(c, "UntagNode", err)
+		return
+	}
+	c.JSON(http.StatusOK, node)
+}
+
+// GetTags lists the user's tags with how many nodes carry each
+func GetTags(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tags, err := services.ListTags(ctx, userID)
+	if err != nil {
+		log.Printf("[GetTags] Failed to list tags: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// GetNodesByTag lists the nodes carrying a tag
+func GetNodesByTag(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nodes, err := services.GetNodesByTag(ctx, userID, c.Param("tag"))
+	if err != nil {
+		writeTagError(c, "GetNodesByTag", err)
+		return
+	}
+	c.JSON(http.StatusOK, nodes)
+}
+
+// writeTagError maps tag, collection and scope errors onto responses
+func writeTagError(c *gin.Context, handler string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidTag), errors.Is(err, services.ErrTooManyTags),
+		errors.Is(err, services.ErrConflictingScope), errors.Is(err, services.ErrInvalidCollection),
+		errors.Is(err, services.ErrEmptyCollectionQuery), errors.Is(err, services.ErrInvalidDateRange),
+		errors.Is(err, services.ErrInvalidMasteryFilter):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNodeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+	case errors.Is(err, services.ErrCollectionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+	case errors.Is(err, services.ErrNoScopedNotes):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		log.Printf("[%s] %v", handler, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Request failed"})
+	}
+}
+
+// isScopeError reports whether err is a client error from resolving a Scope
+func isScopeError(err error) bool {
+	return errors.Is(err, services.ErrInvalidTag) || errors.Is(err, services.ErrConflictingScope) ||
+		errors.Is(err, services.ErrCollectionNotFound)
+}
+
+// scopeFromQuery reads a tag or collection scope from ?tag= and ?collectionId=
+func scopeFromQuery(c *gin.Context) services.Scope {
+	return services.Scope{Tag: c.Query("tag"), CollectionID: c.Query("collectionId")}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTagUnauthorizedAccess(t *testing.T) {
+	router := setupTestRouterNoAuth()
+	router.POST("/nodes/:id/tags", TagNode)
+	router.DELETE("/nodes/:id/tags/:tag", UntagNode)
+	router.GET("/tags", GetTags)
+	router.GET("/tags/:tag/nodes", GetNodesByTag)
+	router.POST("/collections", CreateSmartCollection)
+	router.GET("/collections", GetSmartCollections)
+	router.GET("/collections/:id", GetSmartCollection)
+	router.PUT("/collections/:id", UpdateSmartCollection)
+	router.DELETE("/collections/:id", DeleteSmartCollection)
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{"POST", "/nodes/507f1f77bcf86cd799439011/tags"},
+		{"DELETE", "/nodes/507f1f77bcf86cd799439011/tags/biology"},
+		{"GET", "/tags"},
+		{"GET", "/tags/biology/nodes"},
+		{"POST", "/collections"},
+		{"GET", "/collections"},
+		{"GET", "/collections/507f1f77bcf86cd799439011"},
+		{"PUT", "/collections/507f1f77bcf86cd799439011"},
+		{"DELETE", "/collections/507f1f77bcf86cd799439011"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
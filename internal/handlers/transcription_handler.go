@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetNoteTranscription returns a note's transcription page by page, as blocks of
+// paragraphs, headings, lists, LaTeX formulas, table rows and diagram descriptions
+func GetNoteTranscription(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	node, err := services.GetOwnedNote(ctx, c.Param("id"), userID)
+	if err != nil {
+		writeNotePageError(c, "GetNoteTranscription", err)
+		return
+	}
+
+	pages, err := services.NoteTranscription(ctx, node)
+	if err != nil {
+		log.Printf("[GetNoteTranscription] %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transcription"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"noteId":        node.ID.Hex(),
+		"captionStatus": node.CaptionStatus,
+		"pages":         pages,
+	})
+}
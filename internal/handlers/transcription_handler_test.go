@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNoteTranscriptionUnauthorizedAccess(t *testing.T) {
+	router := setupTestRouterNoAuth()
+	router.GET("/nodes/:id/transcription", GetNoteTranscription)
+
+	req, _ := http.NewRequest("GET", "/nodes/507f1f77bcf86cd799439011/transcription", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
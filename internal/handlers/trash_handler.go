@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetTrash lists the user's deleted nodes with the time each one will be purged
+func GetTrash(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entries, err := services.ListTrash(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": entries, "retentionDays": int(services.TrashRetention().Hours() / 24)})
+}
+
+// RestoreTrashItem restores a deleted node and its subtree to its original folder,
+// or to the top level if that folder no longer exists
+func RestoreTrashItem(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	entry, err := services.RestoreTrashEntry(ctx, c.Param("id"), userID)
+	if err != nil {
+		if err == services.ErrTrashEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trash item not found"})
+			return
+		}
+		log.Printf("[RestoreTrashItem] Failed to restore %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore node"})
+		return
+	}
+
+	if entry.ParentID != "" {
+		services.EnqueueAncestorMasteryUpdate(entry.NodeID, userID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Node restored", "nodeId": entry.NodeID, "parentId": entry.ParentID})
+}
+
+// PurgeTrashItem permanently deletes a node in the trash without waiting for retention
+func PurgeTrashItem(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if err := services.PurgeTrashEntry(ctx, c.Param("id"), userID); err != nil {
+		if err == services.ErrTrashEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trash item not found"})
+			return
+		}
+		log.Printf("[PurgeTrashItem] Failed to purge %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete node permanently"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Node deleted permanently"})
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrashUnauthorizedAccess(t *testing.T) {
+	router := setupTestRouterNoAuth()
+	router.GET("/trash", GetTrash)
+	router.POST("/trash/:id/restore", RestoreTrashItem)
+	router.DELETE("/trash/:id", PurgeTrashItem)
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/trash"},
+		{"POST", "/trash/507f1f77bcf86cd799439011/restore"},
+		{"DELETE", "/trash/507f1f77bcf86cd799439011"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
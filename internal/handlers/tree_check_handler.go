@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cogniscan/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckNodeTree checks the node trees for consistency and returns the report.
+// ?ownerId= limits the check to one user; ?fix=true applies the repairs, otherwise
+// the run is a dry run that only reports what it would fix.
+func CheckNodeTree(c *gin.Context) {
+	fix, err := strconv.ParseBool(c.DefaultQuery("fix", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fix must be true or false"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	report, err := services.CheckTree(ctx, services.TreeCheckOptions{OwnerID: c.Query("ownerId"), Fix: fix})
+	if err != nil {
+		log.Printf("[CheckNodeTree] Tree check failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Tree check failed: " + err.Error(), "report": report})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
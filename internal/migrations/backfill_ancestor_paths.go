@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cogniscan/backend/internal/models"
+	"cogniscan/backend/internal/services"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BackfillAncestorPaths creates the ancestor path index and computes the ancestors
+// field of nodes written before it existed. Owners whose nodes all have a path are
+// skipped, so running it on every startup is cheap.
+func BackfillAncestorPaths(ctx context.Context) error {
+	nodesCollection := services.GetNodesCollection()
+
+	_, err := nodesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "ancestors", Value: 1}},
+		Options: options.Index().SetName("owner_ancestors_index"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ancestors index: %w", err)
+	}
+
+	// A null path (from MigrateToNodes) counts as missing
+	owners, err := nodesCollection.Distinct(ctx, "ownerId", bson.M{"ancestors": nil})
+	if err != nil {
+		return fmt.Errorf("failed to find nodes without ancestors: %w", err)
+	}
+
+	for _, value := range owners {
+		owner, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		// Paths depend on the whole tree, so every node of the owner is read
+		opts := options.Find().SetProjection(bson.M{"_id": 1, "parentId": 1, "ancestors": 1})
+		cursor, err := nodesCollection.Find(ctx, bson.M{"ownerId": owner}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to fetch nodes of %s: %w", owner, err)
+		}
+		var nodes []models.Node
+		if err := cursor.All(ctx, &nodes); err != nil {
+			return fmt.Errorf("failed to decode nodes of %s: %w", owner, err)
+		}
+
+		parents := make(map[string]string, len(nodes))
+		for _, node := range nodes {
+			parents[node.ID.Hex()] = node.ParentID
+		}
+		paths := services.AncestorPaths(parents)
+
+		var writes []mongo.WriteModel
+		for _, node := range nodes {
+			if node.Ancestors != nil {
+				continue
+			}
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": node.ID}).
+				SetUpdate(bson.M{"$set": bson.M{"ancestors": paths[node.ID.Hex()]}}))
+		}
+		if len(writes) == 0 {
+			continue
+		}
+		if _, err := nodesCollection.BulkWrite(ctx, writes); err != nil {
+			return fmt.Errorf("failed to write ancestors of %s: %w", owner, err)
+		}
+		log.Printf("[Migration] Backfilled ancestor paths of %d nodes for %s", len(writes), owner)
+	}
+
+	return nil
+}
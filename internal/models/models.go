@@ -72,9 +72,47 @@ type CaptionEmbedding struct {
 	FolderID    string             `bson:"folderId" json:"folderId"`
 	OwnerID     string             `bson:"ownerId" json:"ownerId"`
 	Caption     string             `bson:"caption" json:"caption"`
-	Vector      []float32          `bson:"vector" json:"vector"` // Embedding vector
-	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+	Vector      []float32          `bson:"vector,omitempty" json:"vector,omitempty"` // Legacy whole-caption vector; embeddings now live in caption_chunks
+	ChunkCount  int                `bson:"chunkCount" json:"chunkCount"`
+	// Rune offset where each page starts in Caption; empty for single-page notes
+	PageStarts []int `bson:"pageStarts,omitempty" json:"pageStarts,omitempty"`
+	// Newest embedding version the caption has been embedded with
+	EmbeddingModel     string    `bson:"embeddingModel,omitempty" json:"embeddingModel,omitempty"`
+	EmbeddingDimension int       `bson:"embeddingDimension,omitempty" json:"embeddingDimension,omitempty"`
+	EmbeddingVersion   int       `bson:"embeddingVersion,omitempty" json:"embeddingVersion,omitempty"`
+	CreatedAt          time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt          time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// CaptionChunk is an embedded slice of a note's caption. Start and End are
+// character (rune) offsets into the caption; neighbouring chunks overlap.
+type CaptionChunk struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	NoteID     string             `bson:"noteId" json:"noteId"`
+	FolderID   string             `bson:"folderId" json:"folderId"`
+	OwnerID    string             `bson:"ownerId" json:"ownerId"`
+	ChunkIndex int                `bson:"chunkIndex" json:"chunkIndex"`
+	Version    int                `bson:"embeddingVersion" json:"embeddingVersion"`
+	Start      int                `bson:"start" json:"start"`
+	End        int                `bson:"end" json:"end"`
+	Page       int                `bson:"page,omitempty" json:"page,omitempty"` // 1-based page the chunk lies on; 0 for single-page notes
+	Vector     []float32          `bson:"vector" json:"-"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// EmbeddingVersion is one embedding space: a model and vector size. Search uses
+// the newest activated version; a newer unactivated version is being built by
+// re-embedding every caption and is activated once it covers all of them.
+type EmbeddingVersion struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Version       int                `bson:"version" json:"version"`
+	Model         string             `bson:"model" json:"model"`
+	Dimension     int                `bson:"dimension" json:"dimension"`
+	TotalNotes    int64              `bson:"totalNotes" json:"totalNotes"`
+	EmbeddedNotes int64              `bson:"embeddedNotes" json:"embeddedNotes"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+	ActivatedAt   *time.Time         `bson:"activatedAt,omitempty" json:"activatedAt,omitempty"`
 }
 
 // QuizStatus tracks quiz generation state
@@ -89,6 +127,8 @@ const (
 type Quiz struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	FolderID       string             `bson:"folderId" json:"folderId"`
+	Tag            string             `bson:"tag,omitempty" json:"tag,omitempty"`                   // Set instead of FolderID for tag quizzes
+	CollectionID   string             `bson:"collectionId,omitempty" json:"collectionId,omitempty"` // Set instead of FolderID for collection quizzes
 	OwnerID        string             `bson:"ownerId" json:"ownerId"`
 	Status         QuizStatus         `bson:"status" json:"status"`
 	TotalQuestions int                `bson:"totalQuestions" json:"totalQuestions"`
@@ -159,6 +199,8 @@ type UserProgress struct {
 	// Storage tracking
 	StorageUsedBytes int64 `bson:"storageUsedBytes" json:"storageUsedBytes"`
 	StorageQuotaBytes int64 `bson:"storageQuotaBytes" json:"storageQuotaBytes"`
+	// Bytes stored by the notes directly in each folder ("root" for the top level)
+	StorageByFolder map[string]int64 `bson:"storageByFolder,omitempty" json:"storageByFolder,omitempty"`
 
 	// Session statistics (rolling window)
 	SessionAccuracy  float64 `bson:"sessionAccuracy" json:"sessionAccuracy"`
@@ -259,11 +301,69 @@ type NodeMetadata struct {
 	DriveID string   `bson:"driveId,omitempty" json:"driveId,omitempty"` // Only for notes
 }
 
+// NotePage is one page of a note: its own stored image and transcription. The
+// note's caption is assembled from its pages' captions in page order. A PDF page
+// with a text layer keeps that text as its caption and has no image.
+type NotePage struct {
+	ID      string `bson:"id" json:"id"`
+	DriveID string `bson:"driveId,omitempty" json:"driveId,omitempty"`
+	// The upload as received, kept beside the normalised image in DriveID
+	OriginalDriveID string `bson:"originalDriveId,omitempty" json:"originalDriveId,omitempty"`
+	// Downscaled copies of the image by size name (small, medium)
+	Thumbnails map[string]string `bson:"thumbnails,omitempty" json:"thumbnails,omitempty"`
+	// Stored size of each blob above, in bytes; unset on pages stored before sizes were
+	// recorded and on pages sharing the blobs of an earlier upload, which counts them
+	ImageBytes     int64            `bson:"imageBytes,omitempty" json:"imageBytes,omitempty"`
+	OriginalBytes  int64            `bson:"originalBytes,omitempty" json:"originalBytes,omitempty"`
+	ThumbnailBytes map[string]int64 `bson:"thumbnailBytes,omitempty" json:"thumbnailBytes,omitempty"`
+	// SHA-256 of the upload as received, and a 64-bit difference hash (hex) of the image
+	// for spotting near-duplicates. Pages re-uploaded unchanged share the blobs above.
+	SHA256  string `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	PHash   string `bson:"phash,omitempty" json:"phash,omitempty"`
+	Caption string `bson:"caption,omitempty" json:"caption,omitempty"`
+	// The transcription as blocks; Caption is its plain-text rendering
+	Transcription *Transcription `bson:"transcription,omitempty" json:"transcription,omitempty"`
+	CaptionStatus CaptionStatus  `bson:"captionStatus,omitempty" json:"captionStatus,omitempty"`
+	CaptionError  string         `bson:"captionError,omitempty" json:"captionError,omitempty"`
+	CreatedAt     time.Time      `bson:"createdAt" json:"createdAt"`
+}
+
+// TranscriptionBlockType is the kind of content a transcription block holds
+type TranscriptionBlockType string
+
+const (
+	BlockParagraph TranscriptionBlockType = "paragraph"
+	BlockHeading   TranscriptionBlockType = "heading"
+	BlockList      TranscriptionBlockType = "list"
+	BlockFormula   TranscriptionBlockType = "formula"
+	BlockTable     TranscriptionBlockType = "table"
+	BlockDiagram   TranscriptionBlockType = "diagram"
+)
+
+// TranscriptionBlock is one piece of a page's transcription. Which fields are set
+// depends on the type: Text for paragraphs, headings and diagram descriptions, LaTeX
+// for formulas, Items for lists and Rows (header first) for tables.
+type TranscriptionBlock struct {
+	Type    TranscriptionBlockType `bson:"type" json:"type"`
+	Text    string                 `bson:"text,omitempty" json:"text,omitempty"`
+	Level   int                    `bson:"level,omitempty" json:"level,omitempty"` // Heading level, 1 is the top
+	LaTeX   string                 `bson:"latex,omitempty" json:"latex,omitempty"`
+	Items   []string               `bson:"items,omitempty" json:"items,omitempty"`
+	Ordered bool                   `bson:"ordered,omitempty" json:"ordered,omitempty"`
+	Rows    [][]string             `bson:"rows,omitempty" json:"rows,omitempty"`
+}
+
+// Transcription is the structured content of a transcribed page
+type Transcription struct {
+	Blocks []TranscriptionBlock `bson:"blocks" json:"blocks"`
+}
+
 // Node represents a unified structure for both folders and notes
 type Node struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Name          string             `bson:"name" json:"name"`
 	ParentID      string             `bson:"parentId" json:"parentId"`
+	Ancestors     []string           `bson:"ancestors" json:"ancestors"` // Top-level folder first, parent last
 	Children      []string           `bson:"children" json:"children"`
 	TotalNoteCount int               `bson:"totalNoteCount" json:"totalNoteCount"`
 	Metadata      NodeMetadata       `bson:"metadata" json:"metadata"`
@@ -281,17 +381,107 @@ type Node struct {
 	QuizUpdatedAt         time.Time           `bson:"quizUpdatedAt,omitempty" json:"quizUpdatedAt,omitempty"`
 
 	// Note-specific fields
-	PublicURL string `bson:"publicUrl,omitempty" json:"publicUrl,omitempty"`
+	PublicURL     string        `bson:"publicUrl,omitempty" json:"publicUrl,omitempty"`
+	CaptionStatus CaptionStatus `bson:"captionStatus,omitempty" json:"captionStatus,omitempty"`
+	CaptionError  string        `bson:"captionError,omitempty" json:"captionError,omitempty"`
 	// Caption data is stored separately in caption_embeddings collection
+
+	// Transcription of a note without pages; notes with pages keep one per page
+	Transcription *Transcription `bson:"transcription,omitempty" json:"transcription,omitempty"`
+
+	// Ordered pages of a note; metadata.driveId mirrors the first page image's blob.
+	// Notes created before pages existed have none and use metadata.driveId alone.
+	Pages []NotePage `bson:"pages,omitempty" json:"pages,omitempty"`
+
+	// Trash - set on every node of a deleted subtree until it is restored or purged
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	TrashID   string     `bson:"trashId,omitempty" json:"trashId,omitempty"`
+
+	// User-defined tags (lowercase); a folder's tags apply to everything below it
+	Tags []string `bson:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// CollectionFilter selects the nodes of a smart collection. Every criterion that is
+// set must match; tags must all apply (directly or through a tagged folder).
+type CollectionFilter struct {
+	Tags          []string   `bson:"tags,omitempty" json:"tags,omitempty"`
+	MasteryLevel  string     `bson:"masteryLevel,omitempty" json:"masteryLevel,omitempty"` // "Mastered", "Learnt" or "Review Soon"
+	CreatedAfter  *time.Time `bson:"createdAfter,omitempty" json:"createdAfter,omitempty"`
+	CreatedBefore *time.Time `bson:"createdBefore,omitempty" json:"createdBefore,omitempty"`
+	CaptionText   string     `bson:"captionText,omitempty" json:"captionText,omitempty"` // Notes whose caption contains this text
+}
+
+// SmartCollection is a saved filter over a user's nodes, evaluated when it is used
+type SmartCollection struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID   string             `bson:"ownerId" json:"ownerId"`
+	Name      string             `bson:"name" json:"name"`
+	Filter    CollectionFilter   `bson:"filter" json:"filter"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// TrashEntry is a deleted subtree waiting in its owner's trash. Its nodes keep their
+// data, marked with deletedAt and the entry's ID, until restored or purged at PurgeAt.
+type TrashEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID   string             `bson:"ownerId" json:"ownerId"`
+	NodeID    string             `bson:"nodeId" json:"nodeId"` // Root of the deleted subtree
+	Name      string             `bson:"name" json:"name"`
+	Type      NodeType           `bson:"type" json:"type"`
+	ParentID  string             `bson:"parentId" json:"parentId"` // Original parent, restored to
+	NodeCount int                `bson:"nodeCount" json:"nodeCount"`
+	NoteCount int                `bson:"noteCount" json:"noteCount"`
+	DeletedAt time.Time          `bson:"deletedAt" json:"deletedAt"`
+	PurgeAt   time.Time          `bson:"purgeAt" json:"purgeAt"`
+	// LeaseUntil is set while a restore or purge is working on the entry
+	LeaseUntil *time.Time `bson:"leaseUntil,omitempty" json:"-"`
 }
 
 // MasteryJob represents a job in the mastery update queue
 type MasteryJob struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	NodeID    string             `bson:"nodeId" json:"nodeId"`
+	OwnerID   string             `bson:"ownerId,omitempty" json:"ownerId,omitempty"`
 	Status    string             `bson:"status" json:"status"` // "pending", "processing", "completed", "failed"
 	Attempt   int                `bson:"attempt" json:"attempt"`
 	Error     string             `bson:"error,omitempty" json:"error,omitempty"`
 	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
 	ProcessedAt time.Time        `bson:"processedAt,omitempty" json:"processedAt,omitempty"`
 }
+
+// JobType identifies the kind of background job
+type JobType string
+
+const (
+	JobTypeCaption JobType = "caption"
+	JobTypeQuiz    JobType = "quiz"
+	JobTypeMastery JobType = "mastery"
+)
+
+// JobState is the lifecycle state of a background job
+type JobState string
+
+const (
+	JobStateQueued     JobState = "queued"
+	JobStateProcessing JobState = "processing"
+	JobStateRetrying   JobState = "retrying" // failed an attempt, waiting to be retried
+	JobStateCompleted  JobState = "completed"
+	JobStateFailed     JobState = "failed"
+)
+
+// Job is the tracked record of a caption, quiz or mastery job.
+// It is exposed through GET /jobs/:id and pushed to the owner's event stream on every transition.
+type Job struct {
+	ID         string    `bson:"_id" json:"id"`
+	Type       JobType   `bson:"type" json:"type"`
+	OwnerID    string    `bson:"ownerId" json:"ownerId"`
+	TargetID   string    `bson:"targetId" json:"targetId"` // Note, folder or node the job works on
+	State      JobState  `bson:"state" json:"state"`
+	Attempts   int       `bson:"attempts" json:"attempts"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time `bson:"updatedAt" json:"updatedAt"`
+	StartedAt  time.Time `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	FinishedAt time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
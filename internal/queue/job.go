@@ -2,9 +2,11 @@ package queue
 
 // CaptionJob represents a caption generation job in the queue
 type CaptionJob struct {
-	ID      string `json:"id"`      // Unique job ID
-	NoteID  string `json:"noteId"`  // MongoDB note ID
-	DriveID string `json:"driveId"` // Google Drive file ID
+	ID      string `json:"id"`               // Unique job ID
+	NoteID  string `json:"noteId"`           // MongoDB note ID
+	DriveID string `json:"driveId"`          // Google Drive file ID
+	OwnerID string `json:"ownerId"`          // User ID who owns the note
+	PageID  string `json:"pageId,omitempty"` // Page to transcribe; empty for notes without pages
 }
 
 // QuizJob represents a quiz generation job in the queue
@@ -10,62 +10,120 @@ import (
 	"time"
 
 	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
 	ErrQueueShutdown = errors.New("queue is shutting down")
 	ErrJobNotFound   = errors.New("job not found")
+	ErrQueueFull     = errors.New("queue is full")
+)
+
+// Mastery job statuses stored on models.MasteryJob
+const (
+	MasteryJobPending    = "pending"
+	MasteryJobProcessing = "processing"
+	MasteryJobCompleted  = "completed"
+	MasteryJobFailed     = "failed"
 )
 
+const (
+	masteryMaxRetryDefault = 3
+	masteryJobTimeout      = 60 * time.Second
+	masteryPersistTimeout  = 10 * time.Second
+	// masteryFailedRetention is how long failed jobs are kept for inspection; completed
+	// jobs are deleted straight away
+	masteryFailedRetention = 7 * 24 * time.Hour
+)
+
+// MasteryProcessor recalculates mastery for the ancestors of a node.
+// It is injected by the caller so the queue does not depend on the services package.
+type MasteryProcessor func(ctx context.Context, nodeID string) error
+
+// MasteryTransitionHook is called whenever a job changes status (errMsg is set for failures)
+type MasteryTransitionHook func(job *MasteryUpdateJob, status string, errMsg string)
+
 // MasteryQueue manages background mastery update jobs
 type MasteryQueue struct {
 	jobs      chan *MasteryUpdateJob
 	workers   int
+	maxRetry  int
 	wg        sync.WaitGroup
+	mu        sync.RWMutex
 	ctx       context.Context
 	cancel    context.CancelFunc
 	started   bool
 	shutdown  bool
-	db        interface{} // Will be replaced with actual database client
+	store     *mongo.Collection // mastery_jobs collection, nil disables persistence
+	processor MasteryProcessor
+	onChange  MasteryTransitionHook
 }
 
 // MasteryUpdateJob represents a mastery update job
 type MasteryUpdateJob struct {
-	ID     string `json:"id"`
-	NodeID string `json:"nodeId"` // The node that triggered the update
+	ID      string `json:"id"`
+	NodeID  string `json:"nodeId"` // The node that triggered the update
+	OwnerID string `json:"ownerId,omitempty"`
+	Attempt int    `json:"attempt"`
 }
 
-// NewMasteryQueue creates a new mastery update queue
-func NewMasteryQueue(workers int, db interface{}) *MasteryQueue {
+// NewMasteryQueue creates a new mastery update queue.
+// store may be nil, in which case jobs only live in memory and are lost on restart.
+func NewMasteryQueue(workers int, maxRetry int, store *mongo.Collection, processor MasteryProcessor) *MasteryQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	if maxRetry < 0 {
+		maxRetry = masteryMaxRetryDefault
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	return &MasteryQueue{
-		jobs:    make(chan *MasteryUpdateJob, 1000),
-		workers: workers,
-		ctx:     ctx,
-		cancel:  cancel,
-		db:      db,
+		jobs:      make(chan *MasteryUpdateJob, 1000),
+		workers:   workers,
+		maxRetry:  maxRetry,
+		ctx:       ctx,
+		cancel:    cancel,
+		store:     store,
+		processor: processor,
 	}
 }
 
-// Start begins processing jobs
+// OnTransition registers a hook called on every job status change. Call before Start.
+func (q *MasteryQueue) OnTransition(hook MasteryTransitionHook) {
+	q.onChange = hook
+}
+
+// Start replays persisted jobs and begins processing
 func (q *MasteryQueue) Start() {
+	q.mu.Lock()
 	if q.started {
+		q.mu.Unlock()
 		return
 	}
 	q.started = true
+	q.mu.Unlock()
 
 	for i := 0; i < q.workers; i++ {
 		q.wg.Add(1)
 		go q.worker(i)
 	}
 
+	q.ensureIndexes()
+	q.replayPendingJobs()
+
 	log.Printf("MasteryQueue started with %d workers", q.workers)
 }
 
 // Stop gracefully shuts down the queue
 func (q *MasteryQueue) Stop() {
+	q.mu.Lock()
 	if !q.started || q.shutdown {
+		q.mu.Unlock()
 		return
 	}
 	q.shutdown = true
@@ -74,6 +132,7 @@ func (q *MasteryQueue) Stop() {
 
 	// Close the jobs channel to signal workers to stop
 	close(q.jobs)
+	q.mu.Unlock()
 
 	// Wait for all workers to finish
 	done := make(chan struct{})
@@ -87,12 +146,32 @@ func (q *MasteryQueue) Stop() {
 	case <-done:
 		log.Println("MasteryQueue stopped gracefully")
 	case <-time.After(30 * time.Second):
+		// Anything still running stays "processing" in the store and is replayed on next start
+		q.cancel()
 		log.Println("MasteryQueue stopped (timeout)")
 	}
 }
 
-// Enqueue adds a job to the queue
+// Enqueue persists a job and adds it to the queue
 func (q *MasteryQueue) Enqueue(job *MasteryUpdateJob) error {
+	q.mu.RLock()
+	shutdown := q.shutdown
+	q.mu.RUnlock()
+	if shutdown {
+		return ErrQueueShutdown
+	}
+
+	if err := q.persist(job, MasteryJobPending, ""); err != nil {
+		log.Printf("MasteryQueue: failed to persist job %s: %v", job.ID, err)
+	}
+
+	return q.push(job)
+}
+
+// push adds a job to the in-memory channel without persisting it
+func (q *MasteryQueue) push(job *MasteryUpdateJob) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
 	if q.shutdown {
 		return ErrQueueShutdown
 	}
@@ -101,7 +180,7 @@ func (q *MasteryQueue) Enqueue(job *MasteryUpdateJob) error {
 	case q.jobs <- job:
 		return nil
 	default:
-		return errors.New("queue is full")
+		return ErrQueueFull
 	}
 }
 
@@ -129,26 +208,207 @@ func (q *MasteryQueue) worker(id int) {
 	}
 }
 
-// processJob handles a single mastery update job
+// processJob runs the processor for a single job, retrying with exponential backoff
 func (q *MasteryQueue) processJob(job *MasteryUpdateJob) {
 	log.Printf("Processing mastery update job for node %s", job.NodeID)
 
-	// TODO: Implement actual mastery propagation logic
-	// Call services.UpdateAncestorMasteryAsync from the mastery service
-	// This requires a processor function injection to avoid circular imports
+	if q.processor == nil {
+		log.Printf("MasteryQueue: no processor configured, dropping job %s", job.ID)
+		q.persist(job, MasteryJobFailed, "no processor configured")
+		return
+	}
+
+	// A replayed job keeps its attempts; one interrupted during its last attempt, or
+	// stored under a higher MASTERY_MAX_RETRY, has none left
+	if job.Attempt > q.maxRetry {
+		errMsg := fmt.Sprintf("no attempts left after %d (max retries %d)", job.Attempt, q.maxRetry)
+		q.persist(job, MasteryJobFailed, errMsg)
+		log.Printf("MasteryQueue: job %s failed: %s", job.ID, errMsg)
+		return
+	}
+
+	var lastErr error
+	for job.Attempt <= q.maxRetry {
+		if job.Attempt > 0 {
+			backoff := time.Duration(1<<uint(job.Attempt)) * time.Second
+			log.Printf("MasteryQueue: retry %d/%d for job %s after %v", job.Attempt, q.maxRetry, job.ID, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-q.ctx.Done():
+				return
+			}
+		}
+
+		job.Attempt++
+		q.persist(job, MasteryJobProcessing, "")
+
+		ctx, cancel := context.WithTimeout(q.ctx, masteryJobTimeout)
+		lastErr = q.processor(ctx, job.NodeID)
+		cancel()
+
+		if lastErr == nil {
+			q.persist(job, MasteryJobCompleted, "")
+			log.Printf("Mastery update job processed for node %s", job.NodeID)
+			return
+		}
+
+		log.Printf("MasteryQueue: job %s attempt %d failed: %v", job.ID, job.Attempt, lastErr)
+		if q.ctx.Err() != nil {
+			// Shutting down - leave the job for replay on next start
+			return
+		}
+	}
 
-	log.Printf("Mastery update job processed for node %s", job.NodeID)
+	q.persist(job, MasteryJobFailed, lastErr.Error())
+	log.Printf("MasteryQueue: job %s failed after %d attempts: %v", job.ID, job.Attempt, lastErr)
 }
 
-// GetPendingJobs returns pending jobs from the database (for persistence)
-func (q *MasteryQueue) GetPendingJobs(ctx context.Context, db interface{}) ([]*models.MasteryJob, error) {
-	// Placeholder - will be implemented with actual database client
-	return nil, nil
+// persist reports the job's new state to the transition hook and records it in the store
+func (q *MasteryQueue) persist(job *MasteryUpdateJob, status, errorMsg string) error {
+	if q.onChange != nil {
+		q.onChange(job, status, errorMsg)
+	}
+
+	if q.store == nil {
+		return nil
+	}
+
+	objID, err := primitive.ObjectIDFromHex(job.ID)
+	if err != nil {
+		return fmt.Errorf("invalid mastery job ID %q: %w", job.ID, err)
+	}
+
+	record := &models.MasteryJob{
+		ID:      objID,
+		NodeID:  job.NodeID,
+		OwnerID: job.OwnerID,
+		Status:  status,
+		Attempt: job.Attempt,
+		Error:   errorMsg,
+	}
+	if status == MasteryJobCompleted || status == MasteryJobFailed {
+		record.ProcessedAt = time.Now()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), masteryPersistTimeout)
+	defer cancel()
+	if status == MasteryJobCompleted {
+		// Nothing left to replay or inspect
+		if _, err := q.store.DeleteOne(ctx, bson.M{"_id": objID}); err != nil {
+			return fmt.Errorf("failed to delete completed mastery job: %w", err)
+		}
+		return nil
+	}
+	return q.PersistJob(ctx, record)
+}
+
+// ensureIndexes expires failed jobs masteryFailedRetention after they were processed.
+// Unfinished jobs have no processedAt and are kept until they finish.
+func (q *MasteryQueue) ensureIndexes() {
+	if q.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), masteryPersistTimeout)
+	defer cancel()
+
+	_, err := q.store.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "processedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(masteryFailedRetention / time.Second)),
+	})
+	if err != nil {
+		log.Printf("MasteryQueue: failed to create mastery_jobs TTL index: %v", err)
+	}
 }
 
-// PersistJob saves a job to the database
-func (q *MasteryQueue) PersistJob(ctx context.Context, db interface{}, job *models.MasteryJob) error {
-	// Placeholder - will be implemented with actual database client
+// replayPendingJobs re-enqueues jobs left pending or processing by a previous run
+func (q *MasteryQueue) replayPendingJobs() {
+	if q.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pending, err := q.GetPendingJobs(ctx)
+	if err != nil {
+		log.Printf("MasteryQueue: failed to load pending jobs: %v", err)
+		return
+	}
+
+	replayed := 0
+	for _, record := range pending {
+		job := &MasteryUpdateJob{
+			ID:      record.ID.Hex(),
+			NodeID:  record.NodeID,
+			OwnerID: record.OwnerID,
+			Attempt: record.Attempt,
+		}
+		if err := q.push(job); err != nil {
+			// Still pending in the store, picked up on the next restart
+			log.Printf("MasteryQueue: could not replay job %s: %v", job.ID, err)
+			continue
+		}
+		replayed++
+	}
+
+	if replayed > 0 {
+		log.Printf("MasteryQueue: replayed %d pending jobs", replayed)
+	}
+}
+
+// GetPendingJobs returns jobs that have not finished, oldest first
+func (q *MasteryQueue) GetPendingJobs(ctx context.Context) ([]*models.MasteryJob, error) {
+	if q.store == nil {
+		return nil, nil
+	}
+
+	filter := bson.M{"status": bson.M{"$in": []string{MasteryJobPending, MasteryJobProcessing}}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := q.store.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending mastery jobs: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var jobs []*models.MasteryJob
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to decode pending mastery jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// PersistJob upserts a job's state into the store
+func (q *MasteryQueue) PersistJob(ctx context.Context, job *models.MasteryJob) error {
+	if q.store == nil {
+		return nil
+	}
+
+	set := bson.M{
+		"nodeId":  job.NodeID,
+		"ownerId": job.OwnerID,
+		"status":  job.Status,
+		"attempt": job.Attempt,
+	}
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"createdAt": time.Now()},
+	}
+	if job.Error != "" {
+		set["error"] = job.Error
+	} else {
+		update["$unset"] = bson.M{"error": ""}
+	}
+	if !job.ProcessedAt.IsZero() {
+		set["processedAt"] = job.ProcessedAt
+	}
+
+	_, err := q.store.UpdateOne(ctx, bson.M{"_id": job.ID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to persist mastery job: %w", err)
+	}
 	return nil
 }
 
@@ -182,6 +442,8 @@ func (q *MasteryQueue) EnqueueAncestorUpdate(nodeID string) error {
 
 // QueueStats returns statistics about the queue
 func (q *MasteryQueue) QueueStats() QueueStats {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
 	return QueueStats{
 		Pending:    len(q.jobs),
 		Workers:    q.workers,
@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMasteryQueueProcessesJobs(t *testing.T) {
+	var mu sync.Mutex
+	processed := map[string]int{}
+	done := make(chan struct{}, 3)
+
+	q := NewMasteryQueue(2, 0, nil, func(ctx context.Context, nodeID string) error {
+		mu.Lock()
+		processed[nodeID]++
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	})
+	q.Start()
+	defer q.Stop()
+
+	for _, nodeID := range []string{"node-a", "node-b", "node-c"} {
+		if err := q.EnqueueAncestorUpdate(nodeID); err != nil {
+			t.Fatalf("EnqueueAncestorUpdate(%s) error = %v", nodeID, err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for jobs to be processed")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, nodeID := range []string{"node-a", "node-b", "node-c"} {
+		if processed[nodeID] != 1 {
+			t.Errorf("node %s processed %d times, want 1", nodeID, processed[nodeID])
+		}
+	}
+}
+
+func TestMasteryQueueRetriesFailedJobs(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	done := make(chan struct{})
+
+	q := NewMasteryQueue(1, 1, nil, func(ctx context.Context, nodeID string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			return errors.New("transient failure")
+		}
+		close(done)
+		return nil
+	})
+	q.Start()
+	defer q.Stop()
+
+	if err := q.EnqueueAncestorUpdate("node-retry"); err != nil {
+		t.Fatalf("EnqueueAncestorUpdate() error = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for retry")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("processor called %d times, want 2", attempts)
+	}
+}
+
+func TestMasteryQueueRejectsAfterStop(t *testing.T) {
+	q := NewMasteryQueue(1, 0, nil, func(ctx context.Context, nodeID string) error { return nil })
+	q.Start()
+	q.Stop()
+
+	if err := q.EnqueueAncestorUpdate("node-late"); !errors.Is(err, ErrQueueShutdown) {
+		t.Errorf("Enqueue after Stop error = %v, want %v", err, ErrQueueShutdown)
+	}
+}
+
+func TestMasteryQueueReportsTransitions(t *testing.T) {
+	var mu sync.Mutex
+	var statuses []string
+	done := make(chan struct{})
+
+	q := NewMasteryQueue(1, 0, nil, func(ctx context.Context, nodeID string) error { return nil })
+	q.OnTransition(func(job *MasteryUpdateJob, status string, errMsg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if job.OwnerID != "owner@example.com" {
+			t.Errorf("transition for owner %q, want owner@example.com", job.OwnerID)
+		}
+		statuses = append(statuses, status)
+		if status == MasteryJobCompleted {
+			close(done)
+		}
+	})
+	q.Start()
+	defer q.Stop()
+
+	if err := q.Enqueue(&MasteryUpdateJob{ID: JobID(), NodeID: "node-a", OwnerID: "owner@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for job to complete")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{MasteryJobPending, MasteryJobProcessing, MasteryJobCompleted}
+	if len(statuses) != len(want) {
+		t.Fatalf("transitions = %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("transitions = %v, want %v", statuses, want)
+			break
+		}
+	}
+}
+
+func TestMasteryQueueFailsReplayedJobWithoutAttemptsLeft(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	failed := make(chan string, 1)
+
+	q := NewMasteryQueue(1, 2, nil, func(ctx context.Context, nodeID string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return nil
+	})
+	q.OnTransition(func(job *MasteryUpdateJob, status string, errMsg string) {
+		if status == MasteryJobFailed {
+			failed <- errMsg
+		}
+	})
+	q.Start()
+	defer q.Stop()
+
+	// As replayed after the server stopped during the final attempt
+	if err := q.push(&MasteryUpdateJob{ID: JobID(), NodeID: "node-replayed", Attempt: 3}); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+
+	select {
+	case errMsg := <-failed:
+		if errMsg == "" {
+			t.Error("failed transition has no error message")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for job to fail")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 0 {
+		t.Errorf("processor called %d times, want 0", calls)
+	}
+}
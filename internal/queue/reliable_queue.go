@@ -0,0 +1,500 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	reliableRetryBackoffMax = 5 * time.Minute
+	reliablePromoteBatch    = 100
+)
+
+// Delivery is a job envelope as stored in Redis. Payload is the job's own JSON.
+type Delivery struct {
+	ID         string          `json:"id"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"lastError,omitempty"`
+	EnqueuedAt time.Time       `json:"enqueuedAt"`
+	FailedAt   *time.Time      `json:"failedAt,omitempty"`
+
+	raw string // exact member stored in Redis, needed to remove it again
+}
+
+// Decode unmarshals the job payload into v
+func (d *Delivery) Decode(v interface{}) error {
+	return json.Unmarshal(d.Payload, v)
+}
+
+// ReliableQueueStats is a snapshot of a reliable queue's lists
+type ReliableQueueStats struct {
+	Name       string `json:"name"`
+	Ready      int64  `json:"ready"`
+	Processing int64  `json:"processing"`
+	Delayed    int64  `json:"delayed"`
+	Dead       int64  `json:"dead"`
+	MaxRetry   int    `json:"maxRetry"`
+}
+
+// ReliableQueue is an at-least-once Redis job queue.
+//
+// Reserved jobs move atomically from the ready list to a processing list and get a
+// lease. Ack removes them; Fail schedules a delayed retry or, once MaxRetry is
+// exhausted, moves them to the dead-letter list. Recover puts jobs whose lease
+// expired (the worker crashed or hung) back on the queue and promotes due retries.
+type ReliableQueue struct {
+	client            *redis.Client
+	name              string
+	readyKey          string
+	processingKey     string
+	leasesKey         string
+	delayedKey        string
+	deadKey           string
+	maxRetry          int
+	visibilityTimeout time.Duration
+
+	// OnDeadLetter is called after a job is moved to the dead-letter list
+	OnDeadLetter func(d *Delivery)
+}
+
+// NewReliableQueue creates a reliable queue whose ready list lives at key.
+// The processing, lease, delayed and dead-letter structures are stored under key + ":<suffix>".
+func NewReliableQueue(client *redis.Client, name, key string, maxRetry int, visibilityTimeout time.Duration) *ReliableQueue {
+	return &ReliableQueue{
+		client:            client,
+		name:              name,
+		readyKey:          key,
+		processingKey:     key + ":processing",
+		leasesKey:         key + ":leases",
+		delayedKey:        key + ":delayed",
+		deadKey:           key + ":dead",
+		maxRetry:          maxRetry,
+		visibilityTimeout: visibilityTimeout,
+	}
+}
+
+// Name returns the queue name used by the operator endpoints
+func (q *ReliableQueue) Name() string {
+	return q.name
+}
+
+// MaxRetry returns how many times a failed job is retried before it is dead-lettered
+func (q *ReliableQueue) MaxRetry() int {
+	return q.maxRetry
+}
+
+// VisibilityTimeout returns how long a reserved job may go without Extend before it is redelivered
+func (q *ReliableQueue) VisibilityTimeout() time.Duration {
+	return q.visibilityTimeout
+}
+
+// Enqueue adds a job to the back of the queue
+func (q *ReliableQueue) Enqueue(ctx context.Context, id string, job interface{}) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	d := &Delivery{ID: id, Payload: payload, EnqueuedAt: time.Now()}
+	raw, err := d.encode()
+	if err != nil {
+		return err
+	}
+	return q.client.RPush(ctx, q.readyKey, raw).Err()
+}
+
+// Reserve blocks up to timeout for the next job and leases it to the caller.
+// It returns nil, nil when no job became available.
+func (q *ReliableQueue) Reserve(ctx context.Context, timeout time.Duration) (*Delivery, error) {
+	raw, err := q.client.BLMove(ctx, q.readyKey, q.processingKey, "LEFT", "RIGHT", timeout).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil // No jobs available (timeout)
+		}
+		return nil, err
+	}
+
+	if err := q.client.HSet(ctx, q.leasesKey, raw, q.leaseDeadline()).Err(); err != nil {
+		// The job stays in the processing list without a lease; Recover leases it and redelivers later
+		log.Printf("[ReliableQueue:%s] Failed to lease job: %v", q.name, err)
+	}
+
+	d, err := decodeDelivery(raw)
+	if err != nil {
+		// Unreadable entries can never succeed, dead-letter them straight away
+		q.moveToDead(ctx, raw, &Delivery{ID: "", Payload: json.RawMessage(strconv.Quote(raw)), raw: raw}, err)
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return d, nil
+}
+
+// Extend pushes a reserved job's lease forward by the visibility timeout.
+// It returns ErrJobNotFound if the job was already redelivered by Recover.
+func (q *ReliableQueue) Extend(ctx context.Context, d *Delivery) error {
+	extended, err := extendScript.Run(ctx, q.client, []string{q.processingKey, q.leasesKey}, d.raw, q.leaseDeadline()).Int()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if extended == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Ack marks a reserved job as done
+func (q *ReliableQueue) Ack(ctx context.Context, d *Delivery) error {
+	pipe := q.client.TxPipeline()
+	pipe.LRem(ctx, q.processingKey, 1, d.raw)
+	pipe.HDel(ctx, q.leasesKey, d.raw)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Fail records a failed attempt. The job is retried after an exponential backoff
+// until it has failed MaxRetry+1 times, then it is dead-lettered.
+// It reports whether the job was dead-lettered.
+func (q *ReliableQueue) Fail(ctx context.Context, d *Delivery, jobErr error) (bool, error) {
+	return q.fail(ctx, d.raw, d, jobErr)
+}
+
+func (q *ReliableQueue) fail(ctx context.Context, raw string, d *Delivery, jobErr error) (bool, error) {
+	if d.Attempts+1 > q.maxRetry {
+		return true, q.moveToDead(ctx, raw, d, jobErr)
+	}
+
+	next := *d
+	next.Attempts++
+	next.LastError = jobErr.Error()
+	newRaw, err := next.encode()
+	if err != nil {
+		return false, err
+	}
+
+	retryAt := time.Now().Add(retryBackoff(next.Attempts))
+	_, err = moveScript.Run(ctx, q.client,
+		[]string{q.processingKey, q.leasesKey, q.delayedKey},
+		raw, newRaw, retryAt.UnixMilli()).Result()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// moveToDead moves a processing job to the dead-letter list
+func (q *ReliableQueue) moveToDead(ctx context.Context, raw string, d *Delivery, jobErr error) error {
+	dead := *d
+	dead.Attempts++
+	dead.LastError = jobErr.Error()
+	now := time.Now()
+	dead.FailedAt = &now
+	newRaw, err := dead.encode()
+	if err != nil {
+		return err
+	}
+
+	moved, err := moveScript.Run(ctx, q.client,
+		[]string{q.processingKey, q.leasesKey, q.deadKey},
+		raw, newRaw, "").Int()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	if moved == 1 {
+		log.Printf("[ReliableQueue:%s] Job %s dead-lettered after %d attempts: %v", q.name, d.ID, dead.Attempts, jobErr)
+		if q.OnDeadLetter != nil {
+			dead.raw = newRaw
+			q.OnDeadLetter(&dead)
+		}
+	}
+	return nil
+}
+
+// Recover promotes delayed retries that are due and redelivers jobs whose lease expired.
+// It is safe to run concurrently from several processes.
+func (q *ReliableQueue) Recover(ctx context.Context) error {
+	promoted, err := promoteScript.Run(ctx, q.client,
+		[]string{q.delayedKey, q.readyKey},
+		time.Now().UnixMilli(), reliablePromoteBatch).Int()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to promote delayed jobs: %w", err)
+	}
+	if promoted > 0 {
+		log.Printf("[ReliableQueue:%s] Promoted %d delayed jobs", q.name, promoted)
+	}
+
+	processing, err := q.client.LRange(ctx, q.processingKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list processing jobs: %w", err)
+	}
+	if len(processing) == 0 {
+		return nil
+	}
+
+	deadlines, err := q.client.HMGet(ctx, q.leasesKey, processing...).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read leases: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+	for i, raw := range processing {
+		deadline, ok := deadlines[i].(string)
+		if !ok {
+			// Reserved but never leased (or the lease write failed): start the clock now
+			q.client.HSetNX(ctx, q.leasesKey, raw, q.leaseDeadline())
+			continue
+		}
+		if ms, err := strconv.ParseInt(deadline, 10, 64); err == nil && ms > now {
+			continue
+		}
+
+		d, err := decodeDelivery(raw)
+		if err != nil {
+			d = &Delivery{Payload: json.RawMessage(strconv.Quote(raw))}
+		}
+		deadLettered, err := q.fail(ctx, raw, d, errors.New("visibility timeout expired"))
+		if err != nil {
+			log.Printf("[ReliableQueue:%s] Failed to redeliver job %s: %v", q.name, d.ID, err)
+			continue
+		}
+		if !deadLettered {
+			log.Printf("[ReliableQueue:%s] Lease expired for job %s, scheduled for redelivery", q.name, d.ID)
+		}
+	}
+	return nil
+}
+
+// RunRecovery calls Recover every interval until ctx is cancelled
+func (q *ReliableQueue) RunRecovery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.Recover(ctx); err != nil {
+				log.Printf("[ReliableQueue:%s] Recovery failed: %v", q.name, err)
+			}
+		}
+	}
+}
+
+// Stats returns the length of each list
+func (q *ReliableQueue) Stats(ctx context.Context) (*ReliableQueueStats, error) {
+	pipe := q.client.Pipeline()
+	ready := pipe.LLen(ctx, q.readyKey)
+	processing := pipe.LLen(ctx, q.processingKey)
+	delayed := pipe.ZCard(ctx, q.delayedKey)
+	dead := pipe.LLen(ctx, q.deadKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return &ReliableQueueStats{
+		Name:       q.name,
+		Ready:      ready.Val(),
+		Processing: processing.Val(),
+		Delayed:    delayed.Val(),
+		Dead:       dead.Val(),
+		MaxRetry:   q.maxRetry,
+	}, nil
+}
+
+// ListDead returns dead-lettered jobs, oldest first
+func (q *ReliableQueue) ListDead(ctx context.Context, offset, limit int64) ([]*Delivery, error) {
+	raws, err := q.client.LRange(ctx, q.deadKey, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Delivery, 0, len(raws))
+	for _, raw := range raws {
+		d, err := decodeDelivery(raw)
+		if err != nil {
+			d = &Delivery{Payload: json.RawMessage(strconv.Quote(raw)), raw: raw}
+		}
+		jobs = append(jobs, d)
+	}
+	return jobs, nil
+}
+
+// RequeueDead moves a dead-lettered job back onto the queue with its attempts reset
+func (q *ReliableQueue) RequeueDead(ctx context.Context, id string) error {
+	d, err := q.findDead(ctx, id)
+	if err != nil {
+		return err
+	}
+	return q.requeueDead(ctx, d)
+}
+
+// RequeueAllDead moves every dead-lettered job back onto the queue and returns how many were moved
+func (q *ReliableQueue) RequeueAllDead(ctx context.Context) (int, error) {
+	raws, err := q.client.LRange(ctx, q.deadKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for _, raw := range raws {
+		d, err := decodeDelivery(raw)
+		if err != nil {
+			continue // Unreadable entries stay dead until purged
+		}
+		if err := q.requeueDead(ctx, d); err != nil {
+			if err == ErrJobNotFound {
+				continue // Requeued or purged concurrently
+			}
+			return requeued, err
+		}
+		requeued++
+	}
+	return requeued, nil
+}
+
+func (q *ReliableQueue) requeueDead(ctx context.Context, d *Delivery) error {
+	fresh := Delivery{ID: d.ID, Payload: d.Payload, EnqueuedAt: time.Now()}
+	newRaw, err := fresh.encode()
+	if err != nil {
+		return err
+	}
+
+	moved, err := relistScript.Run(ctx, q.client, []string{q.deadKey, q.readyKey}, d.raw, newRaw).Int()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if moved == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// PurgeDead deletes a single dead-lettered job
+func (q *ReliableQueue) PurgeDead(ctx context.Context, id string) error {
+	d, err := q.findDead(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	removed, err := q.client.LRem(ctx, q.deadKey, 1, d.raw).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// PurgeAllDead deletes every dead-lettered job and returns how many were removed
+func (q *ReliableQueue) PurgeAllDead(ctx context.Context) (int64, error) {
+	pipe := q.client.TxPipeline()
+	count := pipe.LLen(ctx, q.deadKey)
+	pipe.Del(ctx, q.deadKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+func (q *ReliableQueue) findDead(ctx context.Context, id string) (*Delivery, error) {
+	raws, err := q.client.LRange(ctx, q.deadKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, raw := range raws {
+		d, err := decodeDelivery(raw)
+		if err == nil && d.ID == id {
+			return d, nil
+		}
+	}
+	return nil, ErrJobNotFound
+}
+
+func (q *ReliableQueue) leaseDeadline() int64 {
+	return time.Now().Add(q.visibilityTimeout).UnixMilli()
+}
+
+func (d *Delivery) encode() (string, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode job %s: %w", d.ID, err)
+	}
+	return string(data), nil
+}
+
+// decodeDelivery parses a stored envelope. Bare job JSON written before envelopes
+// existed is wrapped as a first attempt, using its "id" field as the job ID.
+func decodeDelivery(raw string) (*Delivery, error) {
+	var d Delivery
+	if err := json.Unmarshal([]byte(raw), &d); err != nil {
+		return nil, err
+	}
+	if len(d.Payload) == 0 {
+		d = Delivery{ID: d.ID, Payload: json.RawMessage(raw)}
+	}
+	d.raw = raw
+	return &d, nil
+}
+
+// retryBackoff returns the delay before retry number attempt (1-based)
+func retryBackoff(attempt int) time.Duration {
+	backoff := time.Duration(1<<uint(attempt)) * time.Second
+	if backoff <= 0 || backoff > reliableRetryBackoffMax {
+		return reliableRetryBackoffMax
+	}
+	return backoff
+}
+
+// moveScript moves ARGV[1] out of the processing list (KEYS[1]) and its lease (KEYS[2])
+// into KEYS[3] as ARGV[2]: a sorted set scored by ARGV[3], or a list when ARGV[3] is empty.
+// It does nothing if the job is no longer in the processing list.
+var moveScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+if ARGV[3] == '' then
+	redis.call('RPUSH', KEYS[3], ARGV[2])
+else
+	redis.call('ZADD', KEYS[3], ARGV[3], ARGV[2])
+end
+return 1
+`)
+
+// extendScript sets the lease (KEYS[2]) of ARGV[1] to ARGV[2] if it is still in the processing list (KEYS[1])
+var extendScript = redis.NewScript(`
+if not redis.call('LPOS', KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+return 1
+`)
+
+// promoteScript moves up to ARGV[2] members of the delayed set (KEYS[1]) that are due
+// at ARGV[1] onto the ready list (KEYS[2])
+var promoteScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, member in ipairs(due) do
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('RPUSH', KEYS[2], member)
+end
+return #due
+`)
+
+// relistScript replaces ARGV[1] in list KEYS[1] with ARGV[2] at the back of list KEYS[2]
+var relistScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call('RPUSH', KEYS[2], ARGV[2])
+return 1
+`)
@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestDecodeDeliveryLegacyJob(t *testing.T) {
+	raw := `{"id":"job-1","noteId":"note-1","driveId":"drive-1"}`
+
+	d, err := decodeDelivery(raw)
+	if err != nil {
+		t.Fatalf("decodeDelivery() error = %v", err)
+	}
+	if d.ID != "job-1" || d.Attempts != 0 {
+		t.Errorf("decodeDelivery() = {ID: %q, Attempts: %d}, want {job-1, 0}", d.ID, d.Attempts)
+	}
+
+	var job CaptionJob
+	if err := d.Decode(&job); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if job.NoteID != "note-1" || job.DriveID != "drive-1" {
+		t.Errorf("Decode() = %+v, want the original job", job)
+	}
+}
+
+func TestDeliveryEnvelopeRoundTrip(t *testing.T) {
+	d := &Delivery{ID: "job-2", Payload: []byte(`{"id":"job-2","folderId":"f"}`), Attempts: 2, LastError: "boom"}
+	raw, err := d.encode()
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+
+	got, err := decodeDelivery(raw)
+	if err != nil {
+		t.Fatalf("decodeDelivery() error = %v", err)
+	}
+	if got.ID != d.ID || got.Attempts != 2 || got.LastError != "boom" || got.raw != raw {
+		t.Errorf("decodeDelivery() = %+v, want %+v", got, d)
+	}
+}
+
+func TestRetryBackoffIsCapped(t *testing.T) {
+	if got := retryBackoff(1); got != 2*time.Second {
+		t.Errorf("retryBackoff(1) = %v, want 2s", got)
+	}
+	if got := retryBackoff(40); got != reliableRetryBackoffMax {
+		t.Errorf("retryBackoff(40) = %v, want %v", got, reliableRetryBackoffMax)
+	}
+}
+
+// TestReliableQueueLifecycle needs a disposable Redis in REDIS_TEST_URL
+func TestReliableQueueLifecycle(t *testing.T) {
+	url := os.Getenv("REDIS_TEST_URL")
+	if url == "" {
+		t.Skip("REDIS_TEST_URL not set")
+	}
+	opt, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	client := redis.NewClient(opt)
+	ctx := context.Background()
+
+	key := "cogniscan:test:queue:" + JobID()
+	defer client.Del(ctx, key, key+":processing", key+":leases", key+":delayed", key+":dead")
+
+	q := NewReliableQueue(client, "test", key, 0, 50*time.Millisecond)
+	var deadLettered *Delivery
+	q.OnDeadLetter = func(d *Delivery) { deadLettered = d }
+
+	if err := q.Enqueue(ctx, "job-1", CaptionJob{ID: "job-1", NoteID: "n1"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// A reserved job whose lease expires is failed; with maxRetry 0 it goes straight to the DLQ
+	d, err := q.Reserve(ctx, time.Second)
+	if err != nil || d == nil {
+		t.Fatalf("Reserve() = %v, %v", d, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := q.Recover(ctx); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if deadLettered == nil || deadLettered.ID != "job-1" {
+		t.Fatalf("expected job-1 to be dead-lettered, got %+v", deadLettered)
+	}
+	if err := q.Extend(ctx, d); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Extend() after redelivery error = %v, want %v", err, ErrJobNotFound)
+	}
+
+	if err := q.RequeueDead(ctx, "job-1"); err != nil {
+		t.Fatalf("RequeueDead() error = %v", err)
+	}
+	d, err = q.Reserve(ctx, time.Second)
+	if err != nil || d == nil || d.Attempts != 0 {
+		t.Fatalf("Reserve() after requeue = %+v, %v, want a fresh delivery", d, err)
+	}
+	if err := q.Ack(ctx, d); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Ready+stats.Processing+stats.Delayed+stats.Dead != 0 {
+		t.Errorf("Stats() = %+v, want an empty queue", stats)
+	}
+}
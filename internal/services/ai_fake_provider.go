@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"cogniscan/backend/internal/models"
+)
+
+// FakeAIProvider is a deterministic, offline AIProvider for local development and tests.
+// Captions are derived from the image hash, embeddings are hashed bag-of-words vectors
+// (so texts sharing words are similar), and chat answers are shaped by ChatRequest.Task.
+type FakeAIProvider struct{}
+
+// NewFakeAIProvider creates a fake provider
+func NewFakeAIProvider() *FakeAIProvider {
+	return &FakeAIProvider{}
+}
+
+// Name returns the provider name
+func (p *FakeAIProvider) Name() string {
+	return AIProviderFake
+}
+
+// Caption returns a stable transcription for the image content, in the block format
+// the caption prompt asks for
+func (p *FakeAIProvider) Caption(ctx context.Context, image []byte, mimeType string) (string, error) {
+	digest := sha256.Sum256(image)
+	sum := hex.EncodeToString(digest[:6])
+	data, err := json.Marshal(models.Transcription{Blocks: []models.TranscriptionBlock{
+		{Type: models.BlockHeading, Text: "Scanned page " + sum, Level: 1},
+		{Type: models.BlockParagraph, Text: fmt.Sprintf("Transcription of scanned page %s (%d bytes).", sum, len(image))},
+	}})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// EmbeddingModel names the fake embedding space
+func (p *FakeAIProvider) EmbeddingModel() EmbeddingModel {
+	return EmbeddingModel{ID: "fake-bag-of-words", Dimension: vectorDimension}
+}
+
+// EmbedPassage returns a hashed bag-of-words embedding
+func (p *FakeAIProvider) EmbedPassage(ctx context.Context, model EmbeddingModel, text string) ([]float32, error) {
+	return fakeEmbedding(text, model.Dimension), nil
+}
+
+// EmbedQuery returns a hashed bag-of-words embedding; queries and passages share one space
+func (p *FakeAIProvider) EmbedQuery(ctx context.Context, model EmbeddingModel, text string) ([]float32, error) {
+	return fakeEmbedding(text, model.Dimension), nil
+}
+
+// Chat returns a canned answer in the format the task expects
+func (p *FakeAIProvider) Chat(ctx context.Context, req ChatRequest) (string, error) {
+	var answer any
+	switch req.Task {
+	case ChatTaskNameSuggestions:
+		tag := shortHash(req.Prompt)
+		answer = []string{"Study Notes", "Scanned Notes", "Lecture Notes", "Notes " + tag, "Collection " + tag}
+	case ChatTaskQuiz:
+		answer = fakeQuizQuestions(req.Prompt)
+	default:
+		return "Response " + shortHash(req.Prompt), nil
+	}
+
+	data, err := json.Marshal(answer)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Summarize returns the start of the document followed by a fixed takeaway list
+func (p *FakeAIProvider) Summarize(ctx context.Context, title, content string) (string, error) {
+	excerpt := strings.Join(strings.Fields(content), " ")
+	if len(excerpt) > 200 {
+		excerpt = excerpt[:200] + "..."
+	}
+	return fmt.Sprintf("Summary of %s: %s\nKey takeaways:\n- Review %s", title, excerpt, title), nil
+}
+
+// fakeNotePattern matches the note blocks GenerateQuestionsUsingAI puts in its prompt
+var fakeNotePattern = regexp.MustCompile(`Note ID: (\S+)\nCaption: ([^\n]*)`)
+
+// fakeQuizQuestions builds one question per note found in a quiz prompt (at most 15)
+func fakeQuizQuestions(prompt string) []models.Question {
+	matches := fakeNotePattern.FindAllStringSubmatch(prompt, 15)
+
+	questions := make([]models.Question, 0, len(matches))
+	for _, m := range matches {
+		noteID, caption := m[1], strings.TrimSpace(m[2])
+		if len(caption) > 80 {
+			caption = caption[:80] + "..."
+		}
+		questions = append(questions, models.Question{
+			Text:              fmt.Sprintf("Which statement matches the note \"%s\"?", caption),
+			Options:           []string{"It is covered in this note", "It is not covered", "It contradicts the note", "None of the above"},
+			CorrectOption:     0,
+			ReferencedNoteIDs: []string{noteID},
+			Explanation:       "The statement is taken from the note's transcription.",
+		})
+	}
+	return questions
+}
+
+// fakeEmbedding hashes each lowercase word into one of dimension buckets (vectorDimension
+// when unset) and L2-normalizes
+func fakeEmbedding(text string, dimension int) []float32 {
+	if dimension <= 0 {
+		dimension = vectorDimension
+	}
+	vec := make([]float32, dimension)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vec[h.Sum32()%uint32(dimension)]++
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		// Keep empty text from producing a zero vector, which cosine similarity cannot handle
+		vec[0] = 1
+		return vec
+	}
+
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vec {
+		vec[i] *= scale
+	}
+	return vec
+}
+
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:3])
+}
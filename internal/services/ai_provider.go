@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+)
+
+// AI providers selectable through AI_PROVIDER
+const (
+	AIProviderNVIDIA = "nvidia"
+	AIProviderOpenAI = "openai"
+	AIProviderFake   = "fake"
+)
+
+var ErrAINotInitialized = errors.New("AI client not initialized")
+
+// ChatTask tells a provider what a chat completion is for.
+// Real providers only use it for logging; the fake provider uses it to shape its answer.
+type ChatTask string
+
+const (
+	ChatTaskGeneral         ChatTask = "general"
+	ChatTaskNameSuggestions ChatTask = "name-suggestions"
+	ChatTaskQuiz            ChatTask = "quiz"
+)
+
+// ChatRequest is a single-prompt chat completion request
+type ChatRequest struct {
+	Task        ChatTask
+	Prompt      string
+	MaxTokens   int64
+	Temperature float64
+	TopP        float64
+}
+
+// EmbeddingModel identifies an embedding model and the size of the vectors it produces
+type EmbeddingModel struct {
+	ID        string
+	Dimension int
+}
+
+// AIProvider is the set of model operations the backend relies on
+type AIProvider interface {
+	Name() string
+	// Caption transcribes the text and diagrams in an image of the given MIME type
+	Caption(ctx context.Context, image []byte, mimeType string) (string, error)
+	// EmbeddingModel is the model configured for new embeddings
+	EmbeddingModel() EmbeddingModel
+	// EmbedPassage embeds stored content (captions) with the given model
+	EmbedPassage(ctx context.Context, model EmbeddingModel, text string) ([]float32, error)
+	// EmbedQuery embeds a search query with the given model
+	EmbedQuery(ctx context.Context, model EmbeddingModel, text string) ([]float32, error)
+	// Chat runs a chat completion and returns the message content
+	Chat(ctx context.Context, req ChatRequest) (string, error)
+	// Summarize summarizes a document
+	Summarize(ctx context.Context, title, content string) (string, error)
+}
+
+// OpenAICompatibleConfig configures a provider that speaks the OpenAI API
+type OpenAICompatibleConfig struct {
+	Name           string
+	BaseURL        string
+	APIKey         string
+	VisionModel    string
+	ChatModel      string
+	SummaryModel   string
+	EmbeddingModel string
+	// EmbeddingDimensions is sent as "dimensions" when non-zero (OpenAI text-embedding-3 models)
+	EmbeddingDimensions int64
+	// NativeDimension is the vector size of models that do not take "dimensions"
+	NativeDimension int
+	// InputTypeEmbeddings sends input_type=passage|query, as NVIDIA's asymmetric embedding models require
+	InputTypeEmbeddings bool
+	// InlineImages embeds the image as an <img> tag in the prompt text instead of an image_url part
+	InlineImages bool
+}
+
+// OpenAICompatibleProvider implements AIProvider against any OpenAI-compatible endpoint
+type OpenAICompatibleProvider struct {
+	cfg    OpenAICompatibleConfig
+	client openai.Client
+}
+
+// NewOpenAICompatibleProvider creates a provider for the given endpoint and models
+func NewOpenAICompatibleProvider(cfg OpenAICompatibleConfig) *OpenAICompatibleProvider {
+	opts := []option.RequestOption{option.WithAPIKey(cfg.APIKey)}
+	if cfg.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(cfg.BaseURL))
+	}
+	return &OpenAICompatibleProvider{
+		cfg:    cfg,
+		client: openai.NewClient(opts...),
+	}
+}
+
+// NewNVIDIAProvider creates a provider for NVIDIA's hosted models.
+// Model names can be overridden with the AI_*_MODEL variables.
+func NewNVIDIAProvider(apiKey string) *OpenAICompatibleProvider {
+	return NewOpenAICompatibleProvider(OpenAICompatibleConfig{
+		Name:                AIProviderNVIDIA,
+		BaseURL:             "https://integrate.api.nvidia.com/v1/",
+		APIKey:              apiKey,
+		VisionModel:         envOrDefault("AI_VISION_MODEL", "microsoft/phi-3.5-vision-instruct"),
+		ChatModel:           envOrDefault("AI_CHAT_MODEL", "meta/llama-3.3-70b-instruct"),
+		SummaryModel:        envOrDefault("AI_SUMMARY_MODEL", "meta/llama-3.3-70b-instruct"),
+		EmbeddingModel:      envOrDefault("AI_EMBEDDING_MODEL", "nvidia/llama-nemotron-embed-1b-v2"),
+		NativeDimension:     envInt("AI_EMBEDDING_DIMENSION", vectorDimension),
+		InputTypeEmbeddings: true,
+		InlineImages:        true,
+	})
+}
+
+// NewOpenAIProvider creates a provider for OpenAI or any server exposing the same API (OPENAI_BASE_URL).
+// Embeddings are requested at AI_EMBEDDING_DIMENSION (default vectorDimension).
+func NewOpenAIProvider(apiKey string) *OpenAICompatibleProvider {
+	return NewOpenAICompatibleProvider(OpenAICompatibleConfig{
+		Name:                AIProviderOpenAI,
+		BaseURL:             os.Getenv("OPENAI_BASE_URL"),
+		APIKey:              apiKey,
+		VisionModel:         envOrDefault("AI_VISION_MODEL", "gpt-4o-mini"),
+		ChatModel:           envOrDefault("AI_CHAT_MODEL", "gpt-4o-mini"),
+		SummaryModel:        envOrDefault("AI_SUMMARY_MODEL", "gpt-4o-mini"),
+		EmbeddingModel:      envOrDefault("AI_EMBEDDING_MODEL", "text-embedding-3-small"),
+		EmbeddingDimensions: int64(envInt("AI_EMBEDDING_DIMENSION", vectorDimension)),
+	})
+}
+
+// Name returns the provider name
+func (p *OpenAICompatibleProvider) Name() string {
+	return p.cfg.Name
+}
+
+// Caption transcribes an image with the configured vision model
+func (p *OpenAICompatibleProvider) Caption(ctx context.Context, image []byte, mimeType string) (string, error) {
+	dataURL := "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(image)
+
+	var message openai.ChatCompletionMessageParamUnion
+	if p.cfg.InlineImages {
+		message = openai.UserMessage(fmt.Sprintf("<img src=\"%s\">\n\n%s", dataURL, captionPrompt))
+	} else {
+		message = openai.UserMessage([]openai.ChatCompletionContentPartUnionParam{
+			openai.TextContentPart(captionPrompt),
+			openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: dataURL}),
+		})
+	}
+
+	completion, err := p.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Messages:    []openai.ChatCompletionMessageParamUnion{message},
+		Model:       shared.ChatModel(p.cfg.VisionModel),
+		MaxTokens:   openai.Int(2048),   // Increased from 512 for comprehensive content extraction
+		Temperature: openai.Float(0.30), // Balanced for flexibility across different content types
+		TopP:        openai.Float(0.70),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("no response from AI model")
+	}
+	return completion.Choices[0].Message.Content, nil
+}
+
+// EmbeddingModel returns the configured embedding model and its vector size
+func (p *OpenAICompatibleProvider) EmbeddingModel() EmbeddingModel {
+	dimension := p.cfg.NativeDimension
+	if p.cfg.EmbeddingDimensions > 0 {
+		dimension = int(p.cfg.EmbeddingDimensions)
+	}
+	return EmbeddingModel{ID: p.cfg.EmbeddingModel, Dimension: dimension}
+}
+
+// EmbedPassage embeds content for storage
+func (p *OpenAICompatibleProvider) EmbedPassage(ctx context.Context, model EmbeddingModel, text string) ([]float32, error) {
+	return p.embed(ctx, model, text, "passage")
+}
+
+// EmbedQuery embeds a search query
+func (p *OpenAICompatibleProvider) EmbedQuery(ctx context.Context, model EmbeddingModel, text string) ([]float32, error) {
+	return p.embed(ctx, model, text, "query")
+}
+
+func (p *OpenAICompatibleProvider) embed(ctx context.Context, model EmbeddingModel, text, inputType string) ([]float32, error) {
+	params := openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{
+			OfArrayOfStrings: []string{text},
+		},
+		Model: openai.EmbeddingModel(model.ID),
+	}
+	// Models that take "dimensions" are asked for the size the model was versioned with
+	if p.cfg.EmbeddingDimensions > 0 && model.Dimension > 0 {
+		params.Dimensions = openai.Int(int64(model.Dimension))
+	}
+
+	var opts []option.RequestOption
+	if p.cfg.InputTypeEmbeddings {
+		// input_type is required for asymmetric models: "query" for search queries, "passage" for storing
+		opts = append(opts, option.WithJSONSet("input_type", inputType), option.WithJSONSet("truncate", "NONE"))
+	}
+
+	embedding, err := p.client.Embeddings.New(ctx, params, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(embedding.Data) == 0 {
+		return nil, fmt.Errorf("no embedding data returned from AI model")
+	}
+
+	// Convert []float64 to []float32
+	vec := embedding.Data[0].Embedding
+	result := make([]float32, len(vec))
+	for i, v := range vec {
+		result[i] = float32(v)
+	}
+	return result, nil
+}
+
+// Chat runs a chat completion with the configured chat model
+func (p *OpenAICompatibleProvider) Chat(ctx context.Context, req ChatRequest) (string, error) {
+	return p.complete(ctx, p.cfg.ChatModel, req)
+}
+
+// Summarize summarizes a document with the configured summary model
+func (p *OpenAICompatibleProvider) Summarize(ctx context.Context, title, content string) (string, error) {
+	return p.complete(ctx, p.cfg.SummaryModel, ChatRequest{
+		Task:        ChatTaskGeneral,
+		Prompt:      documentSummaryPrompt(title, content),
+		MaxTokens:   500,
+		Temperature: 0.70,
+		TopP:        0.90,
+	})
+}
+
+func (p *OpenAICompatibleProvider) complete(ctx context.Context, model string, req ChatRequest) (string, error) {
+	params := openai.ChatCompletionNewParams{
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.UserMessage(req.Prompt),
+		},
+		Model:       shared.ChatModel(model),
+		Temperature: openai.Float(req.Temperature),
+		TopP:        openai.Float(req.TopP),
+	}
+	if req.MaxTokens > 0 {
+		params.MaxTokens = openai.Int(req.MaxTokens)
+	}
+
+	completion, err := p.client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("no response from AI model")
+	}
+	return completion.Choices[0].Message.Content, nil
+}
+
+// newAIProviderFromEnv builds the provider selected by AI_PROVIDER.
+// It returns a nil provider when the selected provider has no credentials.
+func newAIProviderFromEnv() (AIProvider, error) {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("AI_PROVIDER")))
+
+	switch name {
+	case "", AIProviderNVIDIA:
+		apiKey := os.Getenv("NVIDIA_API_KEY")
+		if apiKey == "" {
+			return nil, nil
+		}
+		return NewNVIDIAProvider(apiKey), nil
+	case AIProviderOpenAI:
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("AI_PROVIDER=openai requires OPENAI_API_KEY")
+		}
+		return NewOpenAIProvider(apiKey), nil
+	case AIProviderFake:
+		return NewFakeAIProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown AI_PROVIDER %q (expected nvidia, openai or fake)", name)
+	}
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
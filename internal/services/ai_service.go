@@ -2,162 +2,147 @@ package services
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"strings"
 
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
-	"github.com/openai/openai-go/shared"
+	"cogniscan/backend/internal/models"
 )
 
-var aiClient openai.Client
+var aiProvider AIProvider
 
-// InitAIService initializes the OpenAI client with NVIDIA endpoint
+// InitAIService initializes the AI provider selected by AI_PROVIDER (nvidia, openai or fake).
+// Defaults to NVIDIA; a missing NVIDIA_API_KEY leaves AI features disabled rather than failing.
 func InitAIService() error {
-	apiKey := os.Getenv("NVIDIA_API_KEY")
-	if apiKey == "" {
+	provider, err := newAIProviderFromEnv()
+	aiProvider = provider
+	if err != nil {
+		return err
+	}
+
+	if provider == nil {
 		log.Println("[AIService] NVIDIA_API_KEY not set, caption generation disabled")
 		return nil // Not fatal - caption generation will just fail gracefully
 	}
 
-	aiClient = openai.NewClient(
-		option.WithAPIKey(apiKey),
-		option.WithBaseURL("https://integrate.api.nvidia.com/v1/"),
-	)
-
-	log.Println("[AIService] Successfully initialized AI client with NVIDIA endpoint")
+	log.Printf("[AIService] Successfully initialized %s AI provider", provider.Name())
 	return nil
 }
 
-// isClientInitialized checks if the AI client has been initialized
+// GetAIProvider returns the configured AI provider (nil when AI is disabled)
+func GetAIProvider() AIProvider {
+	return aiProvider
+}
+
+// SetAIProvider replaces the configured AI provider (used by tools and tests)
+func SetAIProvider(provider AIProvider) {
+	aiProvider = provider
+}
+
+// isClientInitialized checks if an AI provider has been configured
 func isClientInitialized() bool {
-	return os.Getenv("NVIDIA_API_KEY") != ""
+	return aiProvider != nil
 }
 
-// GenerateCaption generates a full-text transcription of an image using NVIDIA's phi-3.5-vision-instruct
-// Extracts handwritten and typed text, formulas, and diagram labels with maximum accuracy
+// GenerateCaption generates a full-text transcription of an image, rendered as plain text
 func GenerateCaption(imageBytes []byte) (string, error) {
-	if !isClientInitialized() {
-		return "", fmt.Errorf("AI client not initialized")
+	transcription, err := GenerateTranscription(imageBytes)
+	if err != nil {
+		return "", err
 	}
+	return TranscriptionText(transcription), nil
+}
 
-	// Encode image to base64
-	base64Image := base64.StdEncoding.EncodeToString(imageBytes)
-
-	// Prepare the content with image tag - using adaptive transcription prompt
-	content := fmt.Sprintf(`<img src="data:image/jpeg;base64,%s">
-
-Transcribe all visible text from this image. Describe any diagrams or tables with their labels. Copy mathematical formulas exactly. Do not use templates or placeholders - provide actual content only.`, base64Image)
+// GenerateTranscription transcribes an image into blocks of paragraphs, headings,
+// lists, LaTeX formulas, tables and diagram descriptions.
+// Extracts handwritten and typed text, formulas, and diagram labels with maximum accuracy.
+// The image is sent with its detected type; HEIC, which vision models do not take, is
+// converted to JPEG first.
+func GenerateTranscription(imageBytes []byte) (*models.Transcription, error) {
+	if !isClientInitialized() {
+		return nil, ErrAINotInitialized
+	}
+	if len(imageBytes) == 0 {
+		return nil, fmt.Errorf("empty image data")
+	}
 
-	// Call the API
-	ctx := context.Background()
-	completion, err := aiClient.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(content),
-		},
-		Model:       shared.ChatModel("microsoft/phi-3.5-vision-instruct"),
-		MaxTokens:   openai.Int(2048),   // Increased from 512 for comprehensive content extraction
-		Temperature: openai.Float(0.30), // Balanced for flexibility across different content types
-		TopP:        openai.Float(0.70),
-	})
+	mimeType := DetectImageType(imageBytes)
+	if mimeType == "" || mimeType == MIMETypeHEIC {
+		prepared, err := PreprocessImage(imageBytes)
+		if err != nil {
+			return nil, err
+		}
+		imageBytes, mimeType = prepared.Data, prepared.MIMEType
+	}
 
+	result, err := aiProvider.Caption(context.Background(), imageBytes, mimeType)
 	if err != nil {
 		log.Printf("[AIService] Failed to generate transcription: %v", err)
-		return "", err
-	}
-
-	if len(completion.Choices) == 0 {
-		return "", fmt.Errorf("no response from AI model")
+		return nil, err
 	}
 
-	result := completion.Choices[0].Message.Content
-	if len(strings.TrimSpace(result)) == 0 {
-		return "", fmt.Errorf("empty transcription returned - image may contain no text")
+	transcription := ParseTranscription(result)
+	if len(transcription.Blocks) == 0 {
+		return nil, fmt.Errorf("empty transcription returned - image may contain no text")
 	}
-	return result, nil
+	return transcription, nil
 }
 
-// GenerateEmbedding generates an embedding vector for the given text using NVIDIA's llama-nemotron-embed-1b-v2
-// Uses "passage" input_type for storing captions in the database
+// GenerateEmbedding generates an embedding vector for the given text
+// Uses the "passage" embedding for storing captions in the database
 func GenerateEmbedding(text string) ([]float32, error) {
 	if !isClientInitialized() {
-		return nil, fmt.Errorf("AI client not initialized")
+		return nil, ErrAINotInitialized
 	}
+	return GenerateEmbeddingWith(aiProvider.EmbeddingModel(), text)
+}
 
-	ctx := context.Background()
-
-	// Call the embeddings API with NVIDIA's embedding model
-	// input_type is required for asymmetric models: "query" for search queries, "passage" for storing
-	embedding, err := aiClient.Embeddings.New(ctx, openai.EmbeddingNewParams{
-		Input: openai.EmbeddingNewParamsInputUnion{
-			OfArrayOfStrings: []string{text},
-		},
-		Model: openai.EmbeddingModel("nvidia/llama-nemotron-embed-1b-v2"),
-	}, option.WithJSONSet("input_type", "passage"), option.WithJSONSet("truncate", "NONE"))
+// GenerateEmbeddingWith generates a passage embedding with a specific model, as
+// embedding versions other than the configured one require
+func GenerateEmbeddingWith(model EmbeddingModel, text string) ([]float32, error) {
+	if !isClientInitialized() {
+		return nil, ErrAINotInitialized
+	}
 
+	vec, err := aiProvider.EmbedPassage(context.Background(), model, text)
 	if err != nil {
 		log.Printf("[AIService] Failed to generate embedding: %v", err)
 		return nil, err
 	}
-
-	if len(embedding.Data) == 0 {
-		return nil, fmt.Errorf("no embedding data returned from AI model")
-	}
-
-	// Convert []float64 to []float32
-	vec := embedding.Data[0].Embedding
-	result := make([]float32, len(vec))
-	for i, v := range vec {
-		result[i] = float32(v)
+	if model.Dimension > 0 && len(vec) != model.Dimension {
+		return nil, fmt.Errorf("embedding model %s returned %d dimensions, expected %d", model.ID, len(vec), model.Dimension)
 	}
-
-	return result, nil
+	return vec, nil
 }
 
-// GenerateQueryEmbedding generates an embedding vector for a search query using NVIDIA's llama-nemotron-embed-1b-v2
-// Uses "query" input_type for searching against stored document embeddings
+// GenerateQueryEmbedding generates an embedding vector for a search query
+// Uses the "query" embedding for searching against stored document embeddings
 func GenerateQueryEmbedding(text string) ([]float32, error) {
 	if !isClientInitialized() {
-		return nil, fmt.Errorf("AI client not initialized")
+		return nil, ErrAINotInitialized
 	}
+	return GenerateQueryEmbeddingWith(aiProvider.EmbeddingModel(), text)
+}
 
-	ctx := context.Background()
-
-	// Call the embeddings API with NVIDIA's embedding model
-	embedding, err := aiClient.Embeddings.New(ctx, openai.EmbeddingNewParams{
-		Input: openai.EmbeddingNewParamsInputUnion{
-			OfArrayOfStrings: []string{text},
-		},
-		Model: openai.EmbeddingModel("nvidia/llama-nemotron-embed-1b-v2"),
-	}, option.WithJSONSet("input_type", "query"), option.WithJSONSet("truncate", "NONE"))
+// GenerateQueryEmbeddingWith generates a query embedding with a specific model, so
+// queries land in the same space as the embedding version being searched
+func GenerateQueryEmbeddingWith(model EmbeddingModel, text string) ([]float32, error) {
+	if !isClientInitialized() {
+		return nil, ErrAINotInitialized
+	}
 
+	vec, err := aiProvider.EmbedQuery(context.Background(), model, text)
 	if err != nil {
 		log.Printf("[AIService] Failed to generate query embedding: %v", err)
 		return nil, err
 	}
-
-	if len(embedding.Data) == 0 {
-		return nil, fmt.Errorf("no embedding data returned from AI model")
-	}
-
-	// Convert []float64 to []float32
-	vec := embedding.Data[0].Embedding
-	result := make([]float32, len(vec))
-	for i, v := range vec {
-		result[i] = float32(v)
-	}
-
-	return result, nil
+	return vec, nil
 }
 
 // GenerateNameSuggestionsForNote generates name suggestions based on note caption
 func GenerateNameSuggestionsForNote(caption string) ([]string, error) {
 	if !isClientInitialized() {
-		return nil, fmt.Errorf("AI client not initialized")
+		return nil, ErrAINotInitialized
 	}
 
 	prompt := fmt.Sprintf(`You are helping a user name their scanned document note.
@@ -177,15 +162,12 @@ OUTPUT FORMAT (valid JSON array only, no markdown):
 
 Return only valid JSON, no surrounding text.`, caption)
 
-	ctx := context.Background()
-	completion, err := aiClient.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		},
-		Model:       shared.ChatModel("meta/llama-3.3-70b-instruct"),
-		MaxTokens:   openai.Int(512),
-		Temperature: openai.Float(0.80),
-		TopP:        openai.Float(0.90),
+	content, err := aiProvider.Chat(context.Background(), ChatRequest{
+		Task:        ChatTaskNameSuggestions,
+		Prompt:      prompt,
+		MaxTokens:   512,
+		Temperature: 0.80,
+		TopP:        0.90,
 	})
 
 	if err != nil {
@@ -193,12 +175,8 @@ Return only valid JSON, no surrounding text.`, caption)
 		return nil, err
 	}
 
-	if len(completion.Choices) == 0 {
-		return nil, fmt.Errorf("no response from AI model")
-	}
-
 	var suggestions []string
-	if err := json.Unmarshal([]byte(completion.Choices[0].Message.Content), &suggestions); err != nil {
+	if err := json.Unmarshal([]byte(content), &suggestions); err != nil {
 		log.Printf("[AIService] Failed to parse name suggestions: %v", err)
 		return nil, err
 	}
@@ -209,7 +187,7 @@ Return only valid JSON, no surrounding text.`, caption)
 // GenerateNameSuggestionsForFolder generates name suggestions based on all note captions in folder
 func GenerateNameSuggestionsForFolder(noteCaptions []string) ([]string, error) {
 	if !isClientInitialized() {
-		return nil, fmt.Errorf("AI client not initialized")
+		return nil, ErrAINotInitialized
 	}
 
 	if len(noteCaptions) == 0 {
@@ -239,15 +217,12 @@ OUTPUT FORMAT (valid JSON array only, no markdown):
 
 Return only valid JSON, no surrounding text.`, captionsText)
 
-	ctx := context.Background()
-	completion, err := aiClient.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		},
-		Model:       shared.ChatModel("meta/llama-3.3-70b-instruct"),
-		MaxTokens:   openai.Int(512),
-		Temperature: openai.Float(0.80),
-		TopP:        openai.Float(0.90),
+	content, err := aiProvider.Chat(context.Background(), ChatRequest{
+		Task:        ChatTaskNameSuggestions,
+		Prompt:      prompt,
+		MaxTokens:   512,
+		Temperature: 0.80,
+		TopP:        0.90,
 	})
 
 	if err != nil {
@@ -255,12 +230,8 @@ Return only valid JSON, no surrounding text.`, captionsText)
 		return nil, err
 	}
 
-	if len(completion.Choices) == 0 {
-		return nil, fmt.Errorf("no response from AI model")
-	}
-
 	var suggestions []string
-	if err := json.Unmarshal([]byte(completion.Choices[0].Message.Content), &suggestions); err != nil {
+	if err := json.Unmarshal([]byte(content), &suggestions); err != nil {
 		log.Printf("[AIService] Failed to parse folder name suggestions: %v", err)
 		return nil, err
 	}
@@ -273,10 +244,19 @@ Return only valid JSON, no surrounding text.`, captionsText)
 // GenerateDocumentSummary generates an AI summary for a document note
 func GenerateDocumentSummary(noteContent string, noteTitle string) (string, error) {
 	if !isClientInitialized() {
-		return "", fmt.Errorf("AI client not initialized")
+		return "", ErrAINotInitialized
 	}
 
-	prompt := fmt.Sprintf(`Generate a comprehensive summary of the following document:
+	summary, err := aiProvider.Summarize(context.Background(), noteTitle, noteContent)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate document summary: %w", err)
+	}
+	return summary, nil
+}
+
+// documentSummaryPrompt builds the prompt used by providers to summarize a document
+func documentSummaryPrompt(noteTitle string, noteContent string) string {
+	return fmt.Sprintf(`Generate a comprehensive summary of the following document:
 TITLE: %s
 
 CONTENT:
@@ -291,25 +271,4 @@ TASK: Create a well-structured summary that:
 6. Ends with 3-5 key takeaways
 
 OUTPUT FORMAT: Return only the summary text, no markdown or markdown formatting.`, noteTitle, noteContent)
-
-	ctx := context.Background()
-	completion, err := aiClient.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		},
-		Model:       shared.ChatModel("gpt-4o-mini"),
-		MaxTokens:   openai.Int(500),
-		Temperature: openai.Float(0.70),
-		TopP:        openai.Float(0.90),
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("failed to generate document summary: %w", err)
-	}
-
-	if len(completion.Choices) == 0 {
-		return "", fmt.Errorf("no response from AI model")
-	}
-
-	return completion.Choices[0].Message.Content, nil
 }
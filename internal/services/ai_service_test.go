@@ -1,8 +1,13 @@
 package services
 
 import (
+	"context"
 	"os"
 	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"cogniscan/backend/internal/models"
 )
 
 func TestInitAIService(t *testing.T) {
@@ -102,6 +107,7 @@ func TestIsClientInitialized(t *testing.T) {
 			name: "Client initialized",
 			setup: func() {
 				os.Setenv("NVIDIA_API_KEY", "test-api-key")
+				InitAIService()
 			},
 			wantInit: true,
 		},
@@ -109,6 +115,7 @@ func TestIsClientInitialized(t *testing.T) {
 			name: "Client not initialized",
 			setup: func() {
 				os.Unsetenv("NVIDIA_API_KEY")
+				InitAIService()
 			},
 			wantInit: false,
 		},
@@ -136,3 +143,102 @@ func TestIsClientInitialized(t *testing.T) {
 		})
 	}
 }
+
+func TestInitAIServiceProviderSelection(t *testing.T) {
+	defer SetAIProvider(nil)
+	defer os.Unsetenv("AI_PROVIDER")
+
+	os.Setenv("AI_PROVIDER", "fake")
+	if err := InitAIService(); err != nil {
+		t.Fatalf("InitAIService() with fake provider error = %v", err)
+	}
+	if p := GetAIProvider(); p == nil || p.Name() != AIProviderFake {
+		t.Errorf("GetAIProvider() = %v, want fake provider", p)
+	}
+
+	os.Setenv("AI_PROVIDER", "carrier-pigeon")
+	if err := InitAIService(); err == nil {
+		t.Error("InitAIService() with unknown provider should return an error")
+	}
+	if GetAIProvider() != nil {
+		t.Error("unknown provider should leave AI disabled")
+	}
+}
+
+func TestFakeAIProviderEmbeddings(t *testing.T) {
+	SetAIProvider(NewFakeAIProvider())
+	defer SetAIProvider(nil)
+
+	passage, err := GenerateEmbedding("Photosynthesis converts light energy into chemical energy")
+	if err != nil {
+		t.Fatalf("GenerateEmbedding() error = %v", err)
+	}
+	if len(passage) != vectorDimension {
+		t.Fatalf("GenerateEmbedding() dimension = %d, want %d", len(passage), vectorDimension)
+	}
+
+	again, _ := GenerateEmbedding("Photosynthesis converts light energy into chemical energy")
+	related, _ := GenerateQueryEmbedding("light energy photosynthesis")
+	unrelated, _ := GenerateQueryEmbedding("medieval trade routes")
+
+	if dot(passage, again) < 0.999 {
+		t.Error("fake embeddings should be deterministic")
+	}
+	if dot(passage, related) <= dot(passage, unrelated) {
+		t.Error("query sharing words with the passage should score higher than an unrelated one")
+	}
+}
+
+func TestFakeAIProviderTranscription(t *testing.T) {
+	SetAIProvider(NewFakeAIProvider())
+	defer SetAIProvider(nil)
+
+	image := []byte{0xFF, 0xD8, 0xFF, 0xE0, 'p', 'a', 'g', 'e'}
+	transcription, err := GenerateTranscription(image)
+	if err != nil {
+		t.Fatalf("GenerateTranscription() error = %v", err)
+	}
+	if len(transcription.Blocks) != 2 || transcription.Blocks[0].Type != models.BlockHeading || transcription.Blocks[1].Type != models.BlockParagraph {
+		t.Fatalf("GenerateTranscription() = %+v, want a heading and a paragraph", transcription.Blocks)
+	}
+
+	caption, err := GenerateCaption(image)
+	if err != nil {
+		t.Fatalf("GenerateCaption() error = %v", err)
+	}
+	if caption != TranscriptionText(transcription) {
+		t.Errorf("GenerateCaption() = %q, want the transcription as text", caption)
+	}
+}
+
+func TestGenerateQuestionsUsingFakeAI(t *testing.T) {
+	SetAIProvider(NewFakeAIProvider())
+	defer SetAIProvider(nil)
+
+	notes := []models.Note{
+		{ID: primitive.NewObjectID(), Caption: "Mitochondria are the powerhouse of the cell"},
+		{ID: primitive.NewObjectID(), Caption: "Ribosomes synthesize proteins"},
+	}
+
+	questions, err := GenerateQuestionsUsingAI(context.Background(), notes)
+	if err != nil {
+		t.Fatalf("GenerateQuestionsUsingAI() error = %v", err)
+	}
+	if len(questions) != len(notes) {
+		t.Fatalf("GenerateQuestionsUsingAI() returned %d questions, want %d", len(questions), len(notes))
+	}
+	for i, q := range questions {
+		if len(q.Options) != 4 || len(q.ReferencedNoteIDs) != 1 || q.ReferencedNoteIDs[0] != notes[i].ID.Hex() {
+			t.Errorf("question %d = %+v, want 4 options referencing note %s", i, q, notes[i].ID.Hex())
+		}
+	}
+}
+
+// dot is the cosine similarity of two L2-normalized vectors
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
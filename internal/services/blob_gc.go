@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultBlobGCGracePeriod is how old an unreferenced blob must be before it is
+// deleted; younger ones may belong to an upload whose note is not saved yet
+const DefaultBlobGCGracePeriod = 24 * time.Hour
+
+// blobGCDeleteBatch is how many orphans are re-checked against the nodes at a time
+const blobGCDeleteBatch = 500
+
+// BlobGCOptions controls a blob garbage collection run
+type BlobGCOptions struct {
+	GracePeriod time.Duration // Defaults to DefaultBlobGCGracePeriod
+	DryRun      bool          // Report only, delete nothing
+}
+
+// BlobRef is a note's use of a blob
+type BlobRef struct {
+	BlobID  string `json:"blobId"`
+	NoteID  string `json:"noteId"`
+	OwnerID string `json:"ownerId"`
+}
+
+// BlobGCReport is the result of a blob garbage collection run
+type BlobGCReport struct {
+	Backend    string     `json:"backend"`
+	DryRun     bool       `json:"dryRun"`
+	Listed     int        `json:"listed"`
+	Referenced int        `json:"referenced"`
+	Orphans    []BlobInfo `json:"orphans"` // Unreferenced and past the grace period
+	Recent     []BlobInfo `json:"recent"`  // Unreferenced but within the grace period, kept
+	Missing    []BlobRef  `json:"missing"` // Referenced by a note but not in the store
+	Deleted    int        `json:"deleted"`
+	Failed     int        `json:"failed"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt time.Time  `json:"finishedAt"`
+}
+
+// CollectOrphanedBlobs compares the objects in the blob store with the blobs notes
+// reference (trashed notes included, as they can still be restored). It reports
+// orphaned objects and references to objects that are gone, and unless opts.DryRun
+// deletes the orphans older than the grace period. Orphans are re-checked right
+// before deletion, so a blob reused by a note saved mid-run is kept.
+func CollectOrphanedBlobs(ctx context.Context, opts BlobGCOptions) (*BlobGCReport, error) {
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = DefaultBlobGCGracePeriod
+	}
+	store := GetBlobStore()
+	if store == nil {
+		return nil, ErrBlobStoreMissing
+	}
+	report := &BlobGCReport{Backend: store.Name(), DryRun: opts.DryRun, StartedAt: time.Now()}
+
+	// Listing before reading the nodes means a blob uploaded in between is either
+	// unlisted or referenced, never mistaken for an orphan
+	listed, err := store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	refs, err := blobReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Listed, report.Referenced = len(listed), len(refs)
+	report.Orphans, report.Recent, report.Missing = reconcileBlobs(listed, refs, report.StartedAt.Add(-opts.GracePeriod))
+
+	if !opts.DryRun {
+		for batch := range slices.Chunk(report.Orphans, blobGCDeleteBatch) {
+			deleteOrphanedBlobs(ctx, store, batch, report)
+		}
+	}
+	report.FinishedAt = time.Now()
+	log.Printf("[BlobGC] %s: %d listed, %d referenced, %d orphaned (%d within grace), %d missing, %d deleted, %d failed",
+		report.Backend, report.Listed, report.Referenced, len(report.Orphans), len(report.Recent),
+		len(report.Missing), report.Deleted, report.Failed)
+	return report, nil
+}
+
+// blobReferences returns every blob a node references, with the first note seen using it
+func blobReferences(ctx context.Context) (map[string]BlobRef, error) {
+	opts := options.Find().SetProjection(bson.M{
+		"ownerId": 1, "metadata.driveId": 1, "pages.driveId": 1, "pages.originalDriveId": 1, "pages.thumbnails": 1,
+	})
+	filter := bson.M{"$or": bson.A{
+		bson.M{"metadata.driveId": bson.M{"$nin": bson.A{nil, ""}}},
+		bson.M{"pages.0": bson.M{"$exists": true}},
+	}}
+	cursor, err := GetNodesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch nodes: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	refs := make(map[string]BlobRef)
+	for cursor.Next(ctx) {
+		var node models.Node
+		if err := cursor.Decode(&node); err != nil {
+			return nil, fmt.Errorf("failed to decode node: %w", err)
+		}
+		ids := []string{node.Metadata.DriveID}
+		for _, page := range node.Pages {
+			ids = append(ids, NotePageBlobIDs(page)...)
+		}
+		for _, id := range ids {
+			if _, seen := refs[id]; id != "" && !seen {
+				refs[id] = BlobRef{BlobID: id, NoteID: node.ID.Hex(), OwnerID: node.OwnerID}
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read nodes: %w", err)
+	}
+	return refs, nil
+}
+
+// reconcileBlobs splits the listed blobs no note references into orphans created
+// before cutoff and recent ones, and returns the references to unlisted blobs. A blob
+// of unknown age counts as recent: it may be an upload whose note is not saved yet.
+func reconcileBlobs(listed []BlobInfo, refs map[string]BlobRef, cutoff time.Time) (orphans, recent []BlobInfo, missing []BlobRef) {
+	stored := make(map[string]bool, len(listed))
+	for _, blob := range listed {
+		stored[blob.ID] = true
+		if _, ok := refs[blob.ID]; ok {
+			continue
+		}
+		if !blob.CreatedAt.IsZero() && blob.CreatedAt.Before(cutoff) {
+			orphans = append(orphans, blob)
+		} else {
+			recent = append(recent, blob)
+		}
+	}
+	for id, ref := range refs {
+		if !stored[id] {
+			missing = append(missing, ref)
+		}
+	}
+	slices.SortFunc(missing, func(a, b BlobRef) int { return strings.Compare(a.BlobID, b.BlobID) })
+	return orphans, recent, missing
+}
+
+// deleteOrphanedBlobs deletes the orphans that are still unreferenced
+func deleteOrphanedBlobs(ctx context.Context, store BlobStore, orphans []BlobInfo, report *BlobGCReport) {
+	ids := make([]string, len(orphans))
+	for i, blob := range orphans {
+		ids[i] = blob.ID
+	}
+	used, err := referencedBlobs(ctx, "", ids, nil)
+	if err != nil {
+		log.Printf("[BlobGC] Not deleting %d orphans: %v", len(ids), err)
+		report.Failed += len(ids)
+		return
+	}
+	for _, id := range ids {
+		if used[id] {
+			log.Printf("[BlobGC] Keeping %s: referenced since the scan", id)
+			continue
+		}
+		if err := store.Delete(ctx, id); err != nil {
+			log.Printf("[BlobGC] Failed to delete %s: %v", id, err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+	}
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconcileBlobs(t *testing.T) {
+	now := time.Now()
+	cutoff := now.Add(-DefaultBlobGCGracePeriod)
+	listed := []BlobInfo{
+		{ID: "page", CreatedAt: now.Add(-72 * time.Hour)},
+		{ID: "thumb", CreatedAt: now.Add(-72 * time.Hour)},
+		{ID: "stale", CreatedAt: now.Add(-48 * time.Hour)},
+		{ID: "uploading", CreatedAt: now.Add(-time.Minute)},
+		{ID: "undated"},
+	}
+	refs := map[string]BlobRef{
+		"page":  {BlobID: "page", NoteID: "n1", OwnerID: "a@example.com"},
+		"thumb": {BlobID: "thumb", NoteID: "n1", OwnerID: "a@example.com"},
+		"gone":  {BlobID: "gone", NoteID: "n2", OwnerID: "b@example.com"},
+	}
+
+	orphans, recent, missing := reconcileBlobs(listed, refs, cutoff)
+
+	if len(orphans) != 1 || orphans[0].ID != "stale" {
+		t.Errorf("orphans = %+v, want stale", orphans)
+	}
+	if len(recent) != 2 || recent[0].ID != "uploading" || recent[1].ID != "undated" {
+		t.Errorf("recent = %+v, want uploading and undated", recent)
+	}
+	if len(missing) != 1 || missing[0] != refs["gone"] {
+		t.Errorf("missing = %+v, want gone", missing)
+	}
+}
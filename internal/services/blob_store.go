@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Storage backends selectable through STORAGE_BACKEND
+const (
+	StorageBackendDrive = "drive"
+	StorageBackendMega  = "mega"
+	StorageBackendLocal = "local"
+)
+
+const defaultLocalStorageDir = "data/blobs"
+
+var (
+	ErrBlobNotFound     = errors.New("blob not found")
+	ErrInvalidBlobID    = errors.New("invalid blob ID")
+	ErrBlobStoreMissing = errors.New("blob store is not initialized")
+)
+
+// Blob is a downloaded storage object. Callers must close Body.
+type Blob struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64 // -1 when unknown
+}
+
+// BlobInfo describes a stored object as listed by BlobStore.List
+type BlobInfo struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BlobStore stores note images and other binary objects.
+// IDs returned by Upload are what nodes keep in metadata.driveId.
+type BlobStore interface {
+	Name() string
+	Upload(ctx context.Context, name string, content io.Reader) (string, error)
+	Download(ctx context.Context, id string) (*Blob, error)
+	Delete(ctx context.Context, id string) error
+	// List returns every object the store holds for this app
+	List(ctx context.Context) ([]BlobInfo, error)
+}
+
+var blobStore BlobStore
+
+// StorageBackend returns the configured storage backend name
+func StorageBackend() string {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_BACKEND")))
+	if backend == "" {
+		return StorageBackendDrive
+	}
+	return backend
+}
+
+// InitBlobStore initializes the blob store selected by STORAGE_BACKEND (drive, mega or local)
+func InitBlobStore() error {
+	backend := StorageBackend()
+
+	var store BlobStore
+	switch backend {
+	case StorageBackendDrive:
+		if err := InitDriveService(); err != nil {
+			return err
+		}
+		store = &DriveBlobStore{}
+	case StorageBackendMega:
+		if err := InitMegaService(); err != nil {
+			return err
+		}
+		megaStore, err := NewMegaBlobStore(GetClient())
+		if err != nil {
+			return err
+		}
+		store = megaStore
+	case StorageBackendLocal:
+		dir := os.Getenv("LOCAL_STORAGE_DIR")
+		if dir == "" {
+			dir = defaultLocalStorageDir
+		}
+		localStore, err := NewLocalBlobStore(dir)
+		if err != nil {
+			return err
+		}
+		store = localStore
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q (expected drive, mega or local)", backend)
+	}
+
+	blobStore = store
+	log.Printf("[BlobStore] Using %s storage backend", store.Name())
+	return nil
+}
+
+// GetBlobStore returns the configured blob store
+func GetBlobStore() BlobStore {
+	return blobStore
+}
+
+// SetBlobStore replaces the configured blob store (used by tools and tests)
+func SetBlobStore(store BlobStore) {
+	blobStore = store
+}
+
+// UploadBlob uploads content to the configured blob store
+func UploadBlob(ctx context.Context, name string, content io.Reader) (string, error) {
+	if blobStore == nil {
+		return "", ErrBlobStoreMissing
+	}
+	return blobStore.Upload(ctx, name, content)
+}
+
+// DownloadBlob downloads an object from the configured blob store
+func DownloadBlob(ctx context.Context, id string) (*Blob, error) {
+	if blobStore == nil {
+		return nil, ErrBlobStoreMissing
+	}
+	return blobStore.Download(ctx, id)
+}
+
+// ListBlobs lists the objects in the configured blob store
+func ListBlobs(ctx context.Context) ([]BlobInfo, error) {
+	if blobStore == nil {
+		return nil, ErrBlobStoreMissing
+	}
+	return blobStore.List(ctx)
+}
+
+// DeleteBlob deletes an object from the configured blob store
+func DeleteBlob(ctx context.Context, id string) error {
+	if blobStore == nil {
+		return ErrBlobStoreMissing
+	}
+	return blobStore.Delete(ctx, id)
+}
+
+// LocalBlobStore keeps blobs as files in a directory on local disk
+type LocalBlobStore struct {
+	dir string
+}
+
+// NewLocalBlobStore creates a local blob store rooted at dir, creating it if needed
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create local storage dir %s: %w", dir, err)
+	}
+	return &LocalBlobStore{dir: dir}, nil
+}
+
+// Name returns the backend name
+func (s *LocalBlobStore) Name() string {
+	return StorageBackendLocal
+}
+
+// Upload writes content to a new file and returns its ID
+func (s *LocalBlobStore) Upload(ctx context.Context, name string, content io.Reader) (string, error) {
+	id := primitive.NewObjectID().Hex() + strings.ToLower(filepath.Ext(name))
+
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("could not write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("could not write file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, id)); err != nil {
+		return "", fmt.Errorf("could not store file: %w", err)
+	}
+
+	log.Printf("[LocalBlobStore] File stored successfully. ID: %s", id)
+	return id, nil
+}
+
+// Download opens a stored file
+func (s *LocalBlobStore) Download(ctx context.Context, id string) (*Blob, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(id))
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(f, head)
+		contentType = http.DetectContentType(head[:n])
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return &Blob{Body: f, ContentType: contentType, Size: info.Size()}, nil
+}
+
+// Delete removes a stored file
+func (s *LocalBlobStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrBlobNotFound
+		}
+		return err
+	}
+	log.Printf("[LocalBlobStore] Successfully deleted file %s.", id)
+	return nil
+}
+
+// List returns the stored files, leaving out uploads still being written
+func (s *LocalBlobStore) List(ctx context.Context) ([]BlobInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list files: %w", err)
+	}
+	blobs := make([]BlobInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // Deleted since the directory was read
+			}
+			return nil, err
+		}
+		blobs = append(blobs, BlobInfo{ID: entry.Name(), Name: entry.Name(), Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+	return blobs, nil
+}
+
+// path resolves a blob ID to a file path, rejecting anything that could escape the storage dir
+func (s *LocalBlobStore) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", ErrInvalidBlobID
+	}
+	return filepath.Join(s.dir, id), nil
+}
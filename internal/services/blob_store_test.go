@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalBlobStoreRoundTrip(t *testing.T) {
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBlobStore() error = %v", err)
+	}
+	ctx := context.Background()
+
+	content := []byte("\x89PNG\r\n\x1a\nfake png body")
+	id, err := store.Upload(ctx, "page.PNG", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if !strings.HasSuffix(id, ".png") {
+		t.Errorf("Upload() id = %q, want .png extension", id)
+	}
+
+	blob, err := store.Download(ctx, id)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	got, _ := io.ReadAll(blob.Body)
+	blob.Body.Close()
+
+	if !bytes.Equal(got, content) {
+		t.Errorf("Download() content = %q, want %q", got, content)
+	}
+	if blob.ContentType != "image/png" {
+		t.Errorf("Download() content type = %q, want image/png", blob.ContentType)
+	}
+	if blob.Size != int64(len(content)) {
+		t.Errorf("Download() size = %d, want %d", blob.Size, len(content))
+	}
+
+	if err := store.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Download(ctx, id); err != ErrBlobNotFound {
+		t.Errorf("Download() after delete error = %v, want %v", err, ErrBlobNotFound)
+	}
+}
+
+func TestLocalBlobStoreRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalBlobStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatalf("NewLocalBlobStore() error = %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0600)
+
+	for _, id := range []string{"../secret.txt", "", ".upload-123", "a/b"} {
+		if _, err := store.Download(context.Background(), id); err != ErrInvalidBlobID {
+			t.Errorf("Download(%q) error = %v, want %v", id, err, ErrInvalidBlobID)
+		}
+	}
+}
+
+func TestInitBlobStoreUnknownBackend(t *testing.T) {
+	os.Setenv("STORAGE_BACKEND", "floppy")
+	defer os.Unsetenv("STORAGE_BACKEND")
+
+	if err := InitBlobStore(); err == nil {
+		t.Error("InitBlobStore() with unknown backend should return an error")
+	}
+}
+
+func TestLocalBlobStoreList(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatalf("NewLocalBlobStore() error = %v", err)
+	}
+	ctx := context.Background()
+
+	id, err := store.Upload(ctx, "page.jpg", bytes.NewReader([]byte("jpeg body")))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	// An upload still being written is not listed
+	if err := os.WriteFile(filepath.Join(dir, ".upload-123"), []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(blobs) != 1 || blobs[0].ID != id || blobs[0].Size != 9 || blobs[0].CreatedAt.IsZero() {
+		t.Errorf("List() = %+v, want just %s of 9 bytes", blobs, id)
+	}
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"cogniscan/backend/internal/models"
+)
+
+const (
+	// captionChunkSize keeps each chunk well inside the embedding model's 512-token window
+	captionChunkSize = 1000
+	// captionChunkOverlap is how much of the previous chunk each chunk repeats, so a
+	// sentence cut at a boundary is still embedded whole in one of the two chunks
+	captionChunkOverlap = 200
+)
+
+// TextRange is a [Start, End) range of character (rune) offsets into a caption
+type TextRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	Page  int `json:"page,omitempty"` // 1-based page of a multi-page note; 0 otherwise
+}
+
+// chunkCaption splits text into ranges of at most size runes that overlap by about
+// overlap runes. Chunks end at a paragraph break or whitespace when one is near the
+// size limit, so words are not cut in half. Blank text yields no chunks.
+func chunkCaption(text string, size, overlap int) []TextRange {
+	runes := []rune(text)
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if overlap >= size/2 {
+		overlap = size / 4
+	}
+
+	var chunks []TextRange
+	start := 0
+	for {
+		// Skip leading whitespace so chunks start on content
+		for start < len(runes) && unicode.IsSpace(runes[start]) {
+			start++
+		}
+		if start >= len(runes) {
+			break
+		}
+
+		end := start + size
+		if end >= len(runes) {
+			chunks = append(chunks, TextRange{Start: start, End: trimRight(runes, start, len(runes))})
+			break
+		}
+		end = chunkBreak(runes, start+size/2, end)
+		chunks = append(chunks, TextRange{Start: start, End: trimRight(runes, start, end)})
+
+		// Start the next chunk overlap runes back, on a word boundary
+		next := end - overlap
+		for next > start && next < end && !unicode.IsSpace(runes[next-1]) {
+			next--
+		}
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+	return chunks
+}
+
+// chunkPagedCaption chunks each page of a multi-page caption on its own, so no chunk
+// spans two pages, and tags the chunks with their page. pageStarts holds the rune
+// offset where each page starts; without at least two valid starts the caption is
+// chunked as a single page.
+func chunkPagedCaption(text string, pageStarts []int, size, overlap int) []TextRange {
+	runes := []rune(text)
+	if !validPageStarts(pageStarts, len(runes)) {
+		return chunkCaption(text, size, overlap)
+	}
+
+	var chunks []TextRange
+	for i, start := range pageStarts {
+		end := len(runes)
+		if i+1 < len(pageStarts) {
+			end = pageStarts[i+1]
+		}
+		for _, r := range chunkCaption(string(runes[start:end]), size, overlap) {
+			chunks = append(chunks, TextRange{Start: start + r.Start, End: start + r.End, Page: i + 1})
+		}
+	}
+	return chunks
+}
+
+// validPageStarts reports whether pageStarts splits a caption of length runes into
+// two or more pages
+func validPageStarts(pageStarts []int, length int) bool {
+	if len(pageStarts) < 2 || pageStarts[0] != 0 {
+		return false
+	}
+	for i := 1; i < len(pageStarts); i++ {
+		if pageStarts[i] < pageStarts[i-1] || pageStarts[i] > length {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkBreak returns the best end offset in (min, max]: just after the last paragraph
+// break, else the last whitespace, else max itself
+func chunkBreak(runes []rune, min, max int) int {
+	for i := max; i > min; i-- {
+		if runes[i-1] == '\n' && i >= 2 && runes[i-2] == '\n' {
+			return i
+		}
+	}
+	for i := max; i > min; i-- {
+		if unicode.IsSpace(runes[i-1]) {
+			return i
+		}
+	}
+	return max
+}
+
+// trimRight moves end back over trailing whitespace, never past start
+func trimRight(runes []rune, start, end int) int {
+	for end > start && unicode.IsSpace(runes[end-1]) {
+		end--
+	}
+	return end
+}
+
+// EmbedCaption splits a caption into overlapping chunks, per page when pageStarts is
+// set, and embeds each one with model. The returned chunks carry their index, offsets,
+// page and vector; note and version fields are filled in by StoreCaptionEmbedding.
+func EmbedCaption(caption string, pageStarts []int, model EmbeddingModel) ([]models.CaptionChunk, error) {
+	ranges := chunkPagedCaption(caption, pageStarts, captionChunkSize, captionChunkOverlap)
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("cannot embed an empty caption")
+	}
+
+	runes := []rune(caption)
+	chunks := make([]models.CaptionChunk, len(ranges))
+	for i, r := range ranges {
+		vector, err := GenerateEmbeddingWith(model, string(runes[r.Start:r.End]))
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		chunks[i] = models.CaptionChunk{
+			ChunkIndex: i,
+			Start:      r.Start,
+			End:        r.End,
+			Page:       r.Page,
+			Vector:     vector,
+		}
+	}
+	return chunks, nil
+}
+
+// chunkText returns the part of caption a chunk covers, tolerating offsets
+// from a caption that has since been replaced
+func chunkText(caption string, start, end int) string {
+	runes := []rune(caption)
+	if end > len(runes) {
+		end = len(runes)
+	}
+	if start < 0 || start >= end {
+		return ""
+	}
+	return string(runes[start:end])
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestChunkCaption(t *testing.T) {
+	if got := chunkCaption("  \n ", 100, 20); got != nil {
+		t.Errorf("chunkCaption(blank) = %v, want no chunks", got)
+	}
+	if got := chunkCaption("Short note", 100, 20); len(got) != 1 || got[0] != (TextRange{Start: 0, End: 10}) {
+		t.Errorf("chunkCaption(short) = %v, want one chunk covering the text", got)
+	}
+
+	text := strings.Repeat("Mitochondria make ATP. ", 40) + "\n\n" + strings.Repeat("Ribosomes build proteins. ", 40)
+	runes := []rune(text)
+	chunks := chunkCaption(text, 200, 50)
+	if len(chunks) < 2 {
+		t.Fatalf("chunkCaption() = %v, want several chunks", chunks)
+	}
+
+	for i, c := range chunks {
+		if c.End-c.Start > 200 {
+			t.Errorf("chunk %d is %d runes, want at most 200", i, c.End-c.Start)
+		}
+		if unicode.IsSpace(runes[c.Start]) || unicode.IsSpace(runes[c.End-1]) {
+			t.Errorf("chunk %d %q is not trimmed", i, string(runes[c.Start:c.End]))
+		}
+		if c.Start > 0 && !unicode.IsSpace(runes[c.Start-1]) {
+			t.Errorf("chunk %d starts mid-word", i)
+		}
+		if i > 0 {
+			prev := chunks[i-1]
+			if c.Start >= prev.End {
+				t.Errorf("chunk %d does not overlap chunk %d", i, i-1)
+			}
+			if c.Start <= prev.Start {
+				t.Errorf("chunk %d does not advance", i)
+			}
+		}
+	}
+	if last := chunks[len(chunks)-1]; last.End != len(strings.TrimRight(text, " ")) {
+		t.Errorf("last chunk ends at %d, want the end of the text", last.End)
+	}
+}
+
+func TestChunkTextToleratesStaleOffsets(t *testing.T) {
+	if got := chunkText("héllo world", 0, 5); got != "héllo" {
+		t.Errorf("chunkText() = %q, want rune offsets", got)
+	}
+	if got := chunkText("short", 2, 50); got != "ort" {
+		t.Errorf("chunkText() past the end = %q", got)
+	}
+	if got := chunkText("short", 9, 12); got != "" {
+		t.Errorf("chunkText() outside the text = %q", got)
+	}
+}
+
+func TestChunkPagedCaption(t *testing.T) {
+	caption, starts := AssembleNoteCaption([]models.NotePage{
+		{Caption: strings.Repeat("Glycolysis splits glucose. ", 12)},
+		{Caption: ""},
+		{Caption: "Krebs cycle"},
+	})
+	runes := []rune(caption)
+
+	chunks := chunkPagedCaption(caption, starts, 200, 50)
+	if len(chunks) < 3 {
+		t.Fatalf("chunkPagedCaption() = %v, want several chunks", chunks)
+	}
+	for i, c := range chunks {
+		if c.Page < 1 || c.Page > 3 || c.Page == 2 {
+			t.Fatalf("chunk %d is on page %d, want page 1 or 3", i, c.Page)
+		}
+		end := len(runes)
+		if c.Page < len(starts) {
+			end = starts[c.Page]
+		}
+		if c.Start < starts[c.Page-1] || c.End > end {
+			t.Errorf("chunk %d [%d, %d) crosses the bounds of page %d", i, c.Start, c.End, c.Page)
+		}
+	}
+	if last := chunks[len(chunks)-1]; last.Page != 3 || string(runes[last.Start:last.End]) != "Krebs cycle" {
+		t.Errorf("last chunk = %+v, want all of page 3", last)
+	}
+
+	// Missing or broken page starts chunk the caption as one page
+	for _, bad := range [][]int{nil, {0}, {5, 10}, {0, 10, 5}, {0, len(runes) + 1}} {
+		for _, c := range chunkPagedCaption(caption, bad, 200, 50) {
+			if c.Page != 0 {
+				t.Errorf("chunkPagedCaption(%v) tagged chunk %+v with a page", bad, c)
+				break
+			}
+		}
+	}
+}
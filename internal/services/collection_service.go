@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxCollectionNameLength = 100
+
+var (
+	ErrCollectionNotFound   = errors.New("collection not found")
+	ErrInvalidCollection    = errors.New("a collection needs a name of at most 100 characters")
+	ErrEmptyCollectionQuery = errors.New("a collection filter needs at least one of tags, masteryLevel, createdAfter, createdBefore or captionText")
+	ErrInvalidDateRange     = errors.New("createdAfter must be before createdBefore")
+)
+
+// GetSmartCollectionsCollection returns the smart_collections collection
+func GetSmartCollectionsCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection("smart_collections")
+}
+
+// NormalizeCollectionFilter validates a collection filter and normalizes its tags and
+// mastery level
+func NormalizeCollectionFilter(filter models.CollectionFilter) (models.CollectionFilter, error) {
+	tags, err := NormalizeTags(filter.Tags)
+	if err != nil {
+		return filter, err
+	}
+	filter.Tags = tags
+
+	if filter.MasteryLevel, err = ParseMasteryLevel(filter.MasteryLevel); err != nil {
+		return filter, err
+	}
+	filter.CaptionText = strings.TrimSpace(filter.CaptionText)
+
+	if filter.CreatedAfter != nil && filter.CreatedBefore != nil && !filter.CreatedAfter.Before(*filter.CreatedBefore) {
+		return filter, ErrInvalidDateRange
+	}
+	if len(filter.Tags) == 0 && filter.MasteryLevel == "" && filter.CreatedAfter == nil &&
+		filter.CreatedBefore == nil && filter.CaptionText == "" {
+		return filter, ErrEmptyCollectionQuery
+	}
+	return filter, nil
+}
+
+// normalizeCollectionName trims a collection name and checks its length
+func normalizeCollectionName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxCollectionNameLength {
+		return "", ErrInvalidCollection
+	}
+	return name, nil
+}
+
+// CreateSmartCollection saves a new smart collection for the owner
+func CreateSmartCollection(ctx context.Context, ownerID, name string, filter models.CollectionFilter) (*models.SmartCollection, error) {
+	name, err := normalizeCollectionName(name)
+	if err != nil {
+		return nil, err
+	}
+	if filter, err = NormalizeCollectionFilter(filter); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	collection := &models.SmartCollection{
+		ID:        primitive.NewObjectID(),
+		OwnerID:   ownerID,
+		Name:      name,
+		Filter:    filter,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := GetSmartCollectionsCollection().InsertOne(ctx, collection); err != nil {
+		return nil, fmt.Errorf("failed to create collection: %w", err)
+	}
+	return collection, nil
+}
+
+// ListSmartCollections returns the owner's smart collections by name
+func ListSmartCollections(ctx context.Context, ownerID string) ([]models.SmartCollection, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := GetSmartCollectionsCollection().Find(ctx, bson.M{"ownerId": ownerID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch collections: %w", err)
+	}
+
+	collections := []models.SmartCollection{}
+	if err := cursor.All(ctx, &collections); err != nil {
+		return nil, fmt.Errorf("failed to decode collections: %w", err)
+	}
+	return collections, nil
+}
+
+// ownedCollectionFilter matches one of the owner's collections by hex ID
+func ownedCollectionFilter(collectionID, ownerID string) (bson.M, error) {
+	objID, err := primitive.ObjectIDFromHex(collectionID)
+	if err != nil {
+		return nil, ErrCollectionNotFound
+	}
+	return bson.M{"_id": objID, "ownerId": ownerID}, nil
+}
+
+// GetSmartCollection returns one of the owner's smart collections
+func GetSmartCollection(ctx context.Context, collectionID, ownerID string) (*models.SmartCollection, error) {
+	filter, err := ownedCollectionFilter(collectionID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var collection models.SmartCollection
+	if err := GetSmartCollectionsCollection().FindOne(ctx, filter).Decode(&collection); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCollectionNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch collection: %w", err)
+	}
+	return &collection, nil
+}
+
+// UpdateSmartCollection replaces the name and filter of one of the owner's collections
+func UpdateSmartCollection(ctx context.Context, collectionID, ownerID, name string, filter models.CollectionFilter) (*models.SmartCollection, error) {
+	selector, err := ownedCollectionFilter(collectionID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if name, err = normalizeCollectionName(name); err != nil {
+		return nil, err
+	}
+	if filter, err = NormalizeCollectionFilter(filter); err != nil {
+		return nil, err
+	}
+
+	update := bson.M{"$set": bson.M{"name": name, "filter": filter, "updatedAt": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var collection models.SmartCollection
+	if err := GetSmartCollectionsCollection().FindOneAndUpdate(ctx, selector, update, opts).Decode(&collection); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCollectionNotFound
+		}
+		return nil, fmt.Errorf("failed to update collection: %w", err)
+	}
+	return &collection, nil
+}
+
+// DeleteSmartCollection deletes one of the owner's collections
+func DeleteSmartCollection(ctx context.Context, collectionID, ownerID string) error {
+	filter, err := ownedCollectionFilter(collectionID, ownerID)
+	if err != nil {
+		return err
+	}
+
+	result, err := GetSmartCollectionsCollection().DeleteOne(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to delete collection: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrCollectionNotFound
+	}
+	return nil
+}
+
+// GetCollectionNodes returns the owner's live nodes matching a collection, newest first
+func GetCollectionNodes(ctx context.Context, collectionID, ownerID string) ([]models.Node, error) {
+	scope, err := ScopeFilter(ctx, ownerID, Scope{CollectionID: collectionID})
+	if err != nil {
+		return nil, err
+	}
+
+	filter := withFilter(ExcludeTrashed(bson.M{"ownerId": ownerID}), scope)
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(searchCandidateLimit)
+	cursor, err := GetNodesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch collection nodes: %w", err)
+	}
+
+	nodes := []models.Node{}
+	if err := cursor.All(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to decode collection nodes: %w", err)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Document index statuses
+const (
+	IndexStatusPending   = "pending"
+	IndexStatusIndexing  = "indexing"
+	IndexStatusCompleted = "completed"
+	IndexStatusFailed    = "failed"
+)
+
+// GetDocumentIndexCollection returns the document_index collection
+func GetDocumentIndexCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection("document_index")
+}
+
+// NoteIndexProgress counts a note's transcribed pages and derives its index status
+func NoteIndexProgress(node *models.Node) (status string, indexed, total int) {
+	pages := NotePages(node)
+	failed, started := false, false
+	for _, page := range pages {
+		switch page.CaptionStatus {
+		case models.CaptionStatusCompleted:
+			indexed++
+		case models.CaptionStatusFailed:
+			failed = true
+		case models.CaptionStatusProcessing:
+			started = true
+		}
+	}
+
+	total = len(pages)
+	switch {
+	case indexed == total:
+		status = IndexStatusCompleted
+	case failed:
+		status = IndexStatusFailed
+	case started || indexed > 0:
+		status = IndexStatusIndexing
+	default:
+		status = IndexStatusPending
+	}
+	return status, indexed, total
+}
+
+// SyncDocumentIndex records a note's page counts and index status in document_index
+func SyncDocumentIndex(ctx context.Context, node *models.Node) error {
+	status, indexed, total := NoteIndexProgress(node)
+	now := time.Now()
+
+	set := bson.M{
+		"folderId":     node.ParentID,
+		"indexStatus":  status,
+		"pagesIndexed": indexed,
+		"totalPages":   total,
+		"updatedAt":    now,
+	}
+	if status == IndexStatusCompleted {
+		set["indexedAt"] = now
+	}
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+
+	filter := bson.M{"noteId": node.ID.Hex(), "userId": node.OwnerID}
+	if _, err := GetDocumentIndexCollection().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("failed to update document index: %w", err)
+	}
+	return nil
+}
+
+// DeleteDocumentIndex removes a note's document index entry
+func DeleteDocumentIndex(ctx context.Context, noteID string) error {
+	if _, err := GetDocumentIndexCollection().DeleteMany(ctx, bson.M{"noteId": noteID}); err != nil {
+		return fmt.Errorf("failed to delete document index: %w", err)
+	}
+	return nil
+}
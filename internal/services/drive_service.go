@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"golang.org/x/oauth2"
 	"google.golang.org/api/drive/v3"
@@ -156,3 +157,61 @@ func DeleteFile(fileID string) error {
 	log.Printf("[DriveService] Successfully deleted file %s.", fileID)
 	return nil
 }
+
+// DriveBlobStore stores blobs in the GOOGLE_DRIVE_FOLDER_ID Drive folder
+type DriveBlobStore struct{}
+
+// Name returns the backend name
+func (s *DriveBlobStore) Name() string {
+	return StorageBackendDrive
+}
+
+// Upload uploads a private file to Drive and returns its file ID
+func (s *DriveBlobStore) Upload(ctx context.Context, name string, content io.Reader) (string, error) {
+	return UploadFile(name, content)
+}
+
+// Download fetches a file's content from Drive
+func (s *DriveBlobStore) Download(ctx context.Context, id string) (*Blob, error) {
+	resp, err := DownloadFileContent(id)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{
+		Body:        resp.Body,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+	}, nil
+}
+
+// Delete deletes a file from Drive
+func (s *DriveBlobStore) Delete(ctx context.Context, id string) error {
+	return DeleteFile(id)
+}
+
+// List lists the files in the GOOGLE_DRIVE_FOLDER_ID folder, page by page
+func (s *DriveBlobStore) List(ctx context.Context) ([]BlobInfo, error) {
+	srv := GetDriveClient()
+	if srv == nil {
+		return nil, fmt.Errorf("Drive service is not initialized")
+	}
+	query := fmt.Sprintf("'%s' in parents and trashed = false", os.Getenv("GOOGLE_DRIVE_FOLDER_ID"))
+
+	var blobs []BlobInfo
+	err := srv.Files.List().Q(query).PageSize(1000).
+		Fields("nextPageToken, files(id, name, size, createdTime)").
+		Pages(ctx, func(list *drive.FileList) error {
+			for _, file := range list.Files {
+				created, err := time.Parse(time.RFC3339, file.CreatedTime)
+				if err != nil {
+					log.Printf("[DriveService] Unknown creation time %q for file %s", file.CreatedTime, file.Id)
+				}
+				blobs = append(blobs, BlobInfo{ID: file.Id, Name: file.Name, Size: file.Size, CreatedAt: created})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("could not list files: %v", err)
+	}
+	return blobs, nil
+}
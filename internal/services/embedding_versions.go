@@ -0,0 +1,421 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	embeddingVersionsCollectionName = "embedding_versions"
+
+	// legacyEmbeddingVersion is the version given to embeddings stored before versioning
+	legacyEmbeddingVersion = 1
+
+	// reembedLease is how long a re-embedding worker owns a caption before another may retry it
+	reembedLease = 5 * time.Minute
+
+	// retiredEmbeddingGraceDefault is how long chunks of a replaced version are kept,
+	// so instances that have not reloaded the versions yet still find them
+	retiredEmbeddingGraceDefault = 10 * time.Minute
+)
+
+// defaultEmbeddingModel is the model embeddings were produced with before versioning
+var defaultEmbeddingModel = EmbeddingModel{ID: "nvidia/llama-nemotron-embed-1b-v2", Dimension: vectorDimension}
+
+var ErrNoEmbeddingVersion = errors.New("no active embedding version")
+
+var (
+	embeddingVersionsMu sync.RWMutex
+	activeEmbedding     *models.EmbeddingVersion
+	pendingEmbedding    *models.EmbeddingVersion
+)
+
+// GetEmbeddingVersionsCollection returns the embedding_versions collection
+func GetEmbeddingVersionsCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection(embeddingVersionsCollectionName)
+}
+
+// versionModel returns the embedding model of a version
+func versionModel(v *models.EmbeddingVersion) EmbeddingModel {
+	return EmbeddingModel{ID: v.Model, Dimension: v.Dimension}
+}
+
+// ActiveEmbeddingVersion returns the version search queries (nil before InitEmbeddingVersions)
+func ActiveEmbeddingVersion() *models.EmbeddingVersion {
+	embeddingVersionsMu.RLock()
+	defer embeddingVersionsMu.RUnlock()
+	return activeEmbedding
+}
+
+// PendingEmbeddingVersion returns the version being built by re-embedding, if any
+func PendingEmbeddingVersion() *models.EmbeddingVersion {
+	embeddingVersionsMu.RLock()
+	defer embeddingVersionsMu.RUnlock()
+	return pendingEmbedding
+}
+
+// WritableEmbeddingVersions returns the versions new captions are embedded with:
+// the active version, so they are searchable now, and the pending one, so they
+// do not need re-embedding later
+func WritableEmbeddingVersions() []*models.EmbeddingVersion {
+	embeddingVersionsMu.RLock()
+	defer embeddingVersionsMu.RUnlock()
+
+	var versions []*models.EmbeddingVersion
+	if activeEmbedding != nil {
+		versions = append(versions, activeEmbedding)
+	}
+	if pendingEmbedding != nil {
+		versions = append(versions, pendingEmbedding)
+	}
+	return versions
+}
+
+// InitEmbeddingVersions loads the embedding versions, registering the legacy version on
+// first run, and starts a new version when the configured embedding model differs from
+// the active one. Captions are then moved over by the re-embedding worker.
+func InitEmbeddingVersions(ctx context.Context) error {
+	collection := GetEmbeddingVersionsCollection()
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetName("version_index").SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("[EmbeddingVersions] Warning: Could not create version index: %v", err)
+	}
+
+	configured := defaultEmbeddingModel
+	if aiProvider != nil {
+		configured = aiProvider.EmbeddingModel()
+	}
+
+	if err := RefreshEmbeddingVersions(ctx); err != nil {
+		return err
+	}
+
+	if ActiveEmbeddingVersion() == nil {
+		// Embeddings stored so far were made with the configured model
+		now := time.Now()
+		legacy := models.EmbeddingVersion{
+			Version:     legacyEmbeddingVersion,
+			Model:       configured.ID,
+			Dimension:   configured.Dimension,
+			CreatedAt:   now,
+			ActivatedAt: &now,
+		}
+		if _, err := collection.InsertOne(ctx, legacy); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to register embedding version: %w", err)
+		}
+		if err := RefreshEmbeddingVersions(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := backfillEmbeddingVersion(ctx, ActiveEmbeddingVersion()); err != nil {
+		return err
+	}
+
+	if aiProvider != nil {
+		if err := ensurePendingEmbeddingVersion(ctx, configured); err != nil {
+			return err
+		}
+	}
+
+	active := ActiveEmbeddingVersion()
+	log.Printf("[EmbeddingVersions] Active embedding version %d (%s, %d dimensions)", active.Version, active.Model, active.Dimension)
+	if pending := PendingEmbeddingVersion(); pending != nil {
+		log.Printf("[EmbeddingVersions] Re-embedding captions for version %d (%s, %d dimensions)", pending.Version, pending.Model, pending.Dimension)
+	}
+	return nil
+}
+
+// RefreshEmbeddingVersions reloads the active and pending versions. The active version
+// is the newest activated one; the pending version is the newest one after it.
+func RefreshEmbeddingVersions(ctx context.Context) error {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	cursor, err := GetEmbeddingVersionsCollection().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to load embedding versions: %w", err)
+	}
+	var versions []models.EmbeddingVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		return fmt.Errorf("failed to load embedding versions: %w", err)
+	}
+
+	active, pending := selectEmbeddingVersions(versions)
+
+	embeddingVersionsMu.Lock()
+	activeEmbedding, pendingEmbedding = active, pending
+	embeddingVersionsMu.Unlock()
+	return nil
+}
+
+// selectEmbeddingVersions picks the active and pending versions from versions sorted newest first
+func selectEmbeddingVersions(versions []models.EmbeddingVersion) (active, pending *models.EmbeddingVersion) {
+	for i := range versions {
+		v := &versions[i]
+		if v.ActivatedAt != nil {
+			return v, pending
+		}
+		if pending == nil {
+			pending = v
+		}
+	}
+	// Nothing activated yet: the legacy version is registered next
+	return nil, nil
+}
+
+// ensurePendingEmbeddingVersion starts a version for model unless the active or
+// pending version already uses it. A pending version for a third model is abandoned.
+func ensurePendingEmbeddingVersion(ctx context.Context, model EmbeddingModel) error {
+	active, pending := ActiveEmbeddingVersion(), PendingEmbeddingVersion()
+	if versionModel(active) == model {
+		// Likely an instance from before a model change during a rolling deploy;
+		// the pending version keeps building
+		if pending != nil {
+			log.Printf("[EmbeddingVersions] Warning: configured model %s is the active one but version %d (%s) is pending", model.ID, pending.Version, pending.Model)
+		}
+		return nil
+	}
+	if pending != nil {
+		if versionModel(pending) == model {
+			return nil
+		}
+		if err := abandonEmbeddingVersion(ctx, pending); err != nil {
+			return err
+		}
+	}
+
+	next := active.Version + 1
+	if pending != nil {
+		next = pending.Version + 1
+	}
+	total, err := getVectorCollection().CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	version := models.EmbeddingVersion{
+		Version:    next,
+		Model:      model.ID,
+		Dimension:  model.Dimension,
+		TotalNotes: total,
+		CreatedAt:  time.Now(),
+	}
+	if _, err := GetEmbeddingVersionsCollection().InsertOne(ctx, version); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to start embedding version: %w", err)
+	}
+	log.Printf("[EmbeddingVersions] Embedding model changed from %s to %s, starting version %d", active.Model, model.ID, next)
+	return RefreshEmbeddingVersions(ctx)
+}
+
+// abandonEmbeddingVersion drops an unactivated version and the chunks built for it
+func abandonEmbeddingVersion(ctx context.Context, v *models.EmbeddingVersion) error {
+	if _, err := GetEmbeddingVersionsCollection().DeleteOne(ctx, bson.M{"version": v.Version, "activatedAt": bson.M{"$exists": false}}); err != nil {
+		return err
+	}
+	if _, err := getChunkCollection().DeleteMany(ctx, bson.M{"embeddingVersion": v.Version}); err != nil {
+		return err
+	}
+	log.Printf("[EmbeddingVersions] Abandoned unfinished embedding version %d (%s)", v.Version, v.Model)
+	return RefreshEmbeddingVersions(ctx)
+}
+
+// backfillEmbeddingVersion stamps captions and chunks stored before versioning
+// with the legacy version
+func backfillEmbeddingVersion(ctx context.Context, active *models.EmbeddingVersion) error {
+	if active == nil || active.Version != legacyEmbeddingVersion {
+		return nil
+	}
+	unversioned := bson.M{"embeddingVersion": bson.M{"$exists": false}}
+	if _, err := getChunkCollection().UpdateMany(ctx, unversioned, bson.M{"$set": bson.M{"embeddingVersion": active.Version}}); err != nil {
+		return fmt.Errorf("failed to backfill chunk versions: %w", err)
+	}
+	_, err := getVectorCollection().UpdateMany(ctx, unversioned, bson.M{"$set": bson.M{
+		"embeddingVersion":   active.Version,
+		"embeddingModel":     active.Model,
+		"embeddingDimension": active.Dimension,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to backfill caption versions: %w", err)
+	}
+	return nil
+}
+
+// reembedTarget returns the version captions should be brought up to: the pending
+// version while one is being built, otherwise the active one (catching captions
+// written by instances that had not yet seen a switch-over)
+func reembedTarget() *models.EmbeddingVersion {
+	if pending := PendingEmbeddingVersion(); pending != nil {
+		return pending
+	}
+	return ActiveEmbeddingVersion()
+}
+
+// outdatedFilter matches captions not yet embedded with version
+func outdatedFilter(version int) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"embeddingVersion": bson.M{"$lt": version}},
+		bson.M{"embeddingVersion": bson.M{"$exists": false}},
+	}}
+}
+
+// EmbeddingCoverage returns how many captions are embedded with version, out of all captions
+func EmbeddingCoverage(ctx context.Context, version int) (embedded, total int64, err error) {
+	total, err = getVectorCollection().CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, 0, err
+	}
+	outdated, err := getVectorCollection().CountDocuments(ctx, outdatedFilter(version))
+	if err != nil {
+		return 0, 0, err
+	}
+	return total - outdated, total, nil
+}
+
+// ReembedNextCaption claims one caption not yet embedded with the target version and
+// embeds it. It returns false when there is nothing left to re-embed.
+func ReembedNextCaption(ctx context.Context) (bool, error) {
+	target := reembedTarget()
+	if target == nil {
+		return false, ErrNoEmbeddingVersion
+	}
+	if !isClientInitialized() {
+		return false, ErrAINotInitialized
+	}
+
+	now := time.Now()
+	filter := bson.M{"$and": bson.A{
+		outdatedFilter(target.Version),
+		bson.M{"$or": bson.A{
+			bson.M{"reembedLeaseUntil": bson.M{"$exists": false}},
+			bson.M{"reembedLeaseUntil": bson.M{"$lt": now}},
+		}},
+	}}
+	update := bson.M{"$set": bson.M{"reembedLeaseUntil": now.Add(reembedLease)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"vector": 0})
+
+	var caption models.CaptionEmbedding
+	err := getVectorCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&caption)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Blank captions have nothing to embed but still count as covered
+	var chunks []models.CaptionChunk
+	if strings.TrimSpace(caption.Caption) != "" {
+		chunks, err = EmbedCaption(caption.Caption, caption.PageStarts, versionModel(target))
+	}
+	if err == nil {
+		err = StoreCaptionEmbedding(caption.NoteID, caption.FolderID, caption.OwnerID, caption.Caption, caption.PageStarts, target, chunks)
+	}
+	if err != nil {
+		// Leave the lease to expire so the caption is retried later, not immediately
+		return true, fmt.Errorf("failed to re-embed note %s: %w", caption.NoteID, err)
+	}
+	return true, nil
+}
+
+// ActivatePendingEmbeddingVersion switches search to the pending version once every
+// caption is embedded with it. The switch is a single-document update, so every
+// instance sees either the old or the new version, never a mix. Chunks of older
+// versions stay until DeleteRetiredEmbeddings removes them.
+func ActivatePendingEmbeddingVersion(ctx context.Context) (bool, error) {
+	pending := PendingEmbeddingVersion()
+	if pending == nil {
+		return false, nil
+	}
+
+	embedded, total, err := EmbeddingCoverage(ctx, pending.Version)
+	if err != nil {
+		return false, err
+	}
+	_, err = GetEmbeddingVersionsCollection().UpdateOne(ctx,
+		bson.M{"version": pending.Version},
+		bson.M{"$set": bson.M{"embeddedNotes": embedded, "totalNotes": total}})
+	if err != nil {
+		return false, err
+	}
+	if embedded < total {
+		log.Printf("[EmbeddingVersions] Version %d covers %d of %d captions", pending.Version, embedded, total)
+		return false, nil
+	}
+
+	result, err := GetEmbeddingVersionsCollection().UpdateOne(ctx,
+		bson.M{"version": pending.Version, "activatedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"activatedAt": time.Now()}})
+	if err != nil {
+		return false, err
+	}
+	if err := RefreshEmbeddingVersions(ctx); err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil // another instance switched first
+	}
+	log.Printf("[EmbeddingVersions] Activated embedding version %d (%s)", pending.Version, pending.Model)
+	return true, nil
+}
+
+// DeleteRetiredEmbeddings deletes the chunks of versions older than the active one
+// once it has been active for the grace period: EMBEDDING_RETIRE_GRACE (default ten
+// minutes), but at least twice refreshInterval and the vector index sync interval, so
+// every instance has switched its searches over before the old chunks go.
+func DeleteRetiredEmbeddings(ctx context.Context, refreshInterval time.Duration) error {
+	active := ActiveEmbeddingVersion()
+	if active == nil || active.Version == legacyEmbeddingVersion {
+		return nil
+	}
+	grace := max(envDuration("EMBEDDING_RETIRE_GRACE", retiredEmbeddingGraceDefault), 2*refreshInterval, 2*vectorIndexSyncInterval())
+	if !retiredEmbeddingsDue(active, time.Now(), grace) {
+		return nil
+	}
+
+	deleted, err := getChunkCollection().DeleteMany(ctx, bson.M{"embeddingVersion": bson.M{"$lt": active.Version}})
+	if err != nil {
+		return fmt.Errorf("failed to delete chunks of retired versions: %w", err)
+	}
+	if deleted.DeletedCount > 0 {
+		log.Printf("[EmbeddingVersions] Deleted %d chunks of versions before %d", deleted.DeletedCount, active.Version)
+	}
+	return nil
+}
+
+// retiredEmbeddingsDue reports whether active has been active for longer than grace
+func retiredEmbeddingsDue(active *models.EmbeddingVersion, now time.Time, grace time.Duration) bool {
+	return active.ActivatedAt != nil && now.Sub(*active.ActivatedAt) >= grace
+}
+
+// EmbedAndStoreCaption embeds a caption with every writable version and stores it.
+// pageStarts splits the caption of a multi-page note into its pages and is nil otherwise.
+func EmbedAndStoreCaption(noteID, folderID, ownerID, caption string, pageStarts []int) error {
+	versions := WritableEmbeddingVersions()
+	if len(versions) == 0 {
+		return ErrNoEmbeddingVersion
+	}
+	for _, version := range versions {
+		chunks, err := EmbedCaption(caption, pageStarts, versionModel(version))
+		if err != nil {
+			return err
+		}
+		if err := StoreCaptionEmbedding(noteID, folderID, ownerID, caption, pageStarts, version, chunks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestSelectEmbeddingVersions(t *testing.T) {
+	activated := time.Now()
+	v1 := models.EmbeddingVersion{Version: 1, Model: "old", ActivatedAt: &activated}
+	v2 := models.EmbeddingVersion{Version: 2, Model: "new"}
+
+	active, pending := selectEmbeddingVersions([]models.EmbeddingVersion{v2, v1})
+	if active == nil || active.Version != 1 || pending == nil || pending.Version != 2 {
+		t.Errorf("selectEmbeddingVersions() = %v, %v, want active 1 and pending 2", active, pending)
+	}
+
+	// Once version 2 is activated, version 1 is retired and nothing is pending
+	v2.ActivatedAt = &activated
+	active, pending = selectEmbeddingVersions([]models.EmbeddingVersion{v2, v1})
+	if active == nil || active.Version != 2 || pending != nil {
+		t.Errorf("after switch-over selectEmbeddingVersions() = %v, %v, want active 2", active, pending)
+	}
+
+	if active, pending := selectEmbeddingVersions(nil); active != nil || pending != nil {
+		t.Errorf("selectEmbeddingVersions(nil) = %v, %v, want nothing", active, pending)
+	}
+}
+
+func TestRetiredEmbeddingsDue(t *testing.T) {
+	now := time.Now()
+	activated := now.Add(-5 * time.Minute)
+	v2 := &models.EmbeddingVersion{Version: 2, ActivatedAt: &activated}
+
+	// Instances still searching the old version keep finding its chunks until the grace ends
+	if retiredEmbeddingsDue(v2, now, 10*time.Minute) {
+		t.Error("retiredEmbeddingsDue() = true within the grace period")
+	}
+	if !retiredEmbeddingsDue(v2, now, 5*time.Minute) {
+		t.Error("retiredEmbeddingsDue() = false after the grace period")
+	}
+	if retiredEmbeddingsDue(&models.EmbeddingVersion{Version: 3}, now, 0) {
+		t.Error("retiredEmbeddingsDue() = true for a version never activated")
+	}
+}
+
+func TestMemoryVectorIndexKeepsOneEmbeddingVersion(t *testing.T) {
+	ctx := context.Background()
+	index := NewMemoryVectorIndex(1)
+
+	index.Upsert(ctx, "a", 1, []VectorEntry{{NoteID: "a", OwnerID: "alice", Version: 1, Vector: []float32{1, 0}}})
+	index.Upsert(ctx, "a", 2, []VectorEntry{{NoteID: "a", OwnerID: "alice", Version: 2, Vector: []float32{0, 1, 0, 0}}})
+
+	matches, _ := index.Search(ctx, []float32{1, 0}, 5, VectorFilter{OwnerID: "alice", Version: 1})
+	if len(matches) != 1 || matches[0].Score < 0.99 {
+		t.Errorf("Search() = %+v, want the version 1 vector", matches)
+	}
+	if matches, _ := index.Search(ctx, []float32{0, 1, 0, 0}, 5, VectorFilter{OwnerID: "alice", Version: 2}); len(matches) != 0 {
+		t.Errorf("Search() for another version = %+v, want nothing", matches)
+	}
+}
+
+func TestFakeEmbeddingHonoursDimension(t *testing.T) {
+	provider := NewFakeAIProvider()
+	vec, _ := provider.EmbedPassage(context.Background(), EmbeddingModel{ID: "small", Dimension: 256}, "cell biology")
+	if len(vec) != 256 {
+		t.Errorf("EmbedPassage() dimension = %d, want 256", len(vec))
+	}
+	if got := provider.EmbeddingModel().Dimension; got != vectorDimension {
+		t.Errorf("EmbeddingModel().Dimension = %d, want %d", got, vectorDimension)
+	}
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	hnswM              = 16  // links per node on upper layers (twice that on layer 0)
+	hnswEfConstruction = 200 // candidate list size while inserting
+	hnswEfSearch       = 64  // minimum candidate list size while searching
+)
+
+// hnswRef identifies the caption chunk a graph node holds
+type hnswRef struct {
+	noteID   string
+	folderID string
+	chunk    int
+}
+
+// hnswNode is one vector in the graph. Deleted nodes stay in the graph as
+// stepping stones until the next rebuild but are never returned.
+type hnswNode struct {
+	id      string
+	ref     hnswRef
+	vector  []float32 // L2-normalized
+	links   [][]int32 // neighbours per layer
+	deleted bool
+}
+
+// hnswGraph is a hierarchical navigable small world graph for approximate
+// cosine nearest-neighbour search (Malkov & Yashunin, 2016). It is not safe
+// for concurrent use; MemoryVectorIndex guards it.
+type hnswGraph struct {
+	m              int
+	mMax0          int
+	efConstruction int
+	levelMult      float64
+	rng            *rand.Rand
+
+	nodes    []*hnswNode
+	byID     map[string]int32
+	entry    int32
+	maxLevel int
+	live     int
+}
+
+// hnswCandidate is a node with its distance to the query
+type hnswCandidate struct {
+	node int32
+	dist float32
+}
+
+func newHNSWGraph(m, efConstruction int, seed int64) *hnswGraph {
+	return &hnswGraph{
+		m:              m,
+		mMax0:          2 * m,
+		efConstruction: efConstruction,
+		levelMult:      1 / math.Log(float64(m)),
+		rng:            rand.New(rand.NewSource(seed)),
+		byID:           make(map[string]int32),
+		entry:          -1,
+	}
+}
+
+// len returns the number of live vectors
+func (g *hnswGraph) len() int {
+	return g.live
+}
+
+// insert adds a vector, replacing any previous vector with the same ID
+func (g *hnswGraph) insert(id string, ref hnswRef, vector []float32) {
+	g.remove(id)
+
+	level := int(math.Floor(-math.Log(1-g.rng.Float64()) * g.levelMult))
+	idx := int32(len(g.nodes))
+	node := &hnswNode{
+		id:     id,
+		ref:    ref,
+		vector: vector,
+		links:  make([][]int32, level+1),
+	}
+	g.nodes = append(g.nodes, node)
+	g.byID[id] = idx
+	g.live++
+
+	if g.entry < 0 {
+		g.entry = idx
+		g.maxLevel = level
+		return
+	}
+
+	entryPoints := []hnswCandidate{{node: g.entry, dist: g.distance(vector, g.entry)}}
+	for l := g.maxLevel; l > level; l-- {
+		entryPoints = g.searchLayer(vector, entryPoints, 1, l)[:1]
+	}
+
+	for l := min(level, g.maxLevel); l >= 0; l-- {
+		candidates := g.searchLayer(vector, entryPoints, g.efConstruction, l)
+		neighbours := candidates
+		if len(neighbours) > g.m {
+			neighbours = neighbours[:g.m]
+		}
+		node.links[l] = make([]int32, 0, len(neighbours))
+		for _, n := range neighbours {
+			node.links[l] = append(node.links[l], n.node)
+			g.link(n.node, idx, l)
+		}
+		entryPoints = candidates
+	}
+
+	if level > g.maxLevel {
+		g.maxLevel = level
+		g.entry = idx
+	}
+}
+
+// link adds a one-way edge from -> to on layer l, keeping only the closest neighbours
+func (g *hnswGraph) link(from, to int32, l int) {
+	node := g.nodes[from]
+	node.links[l] = append(node.links[l], to)
+
+	limit := g.m
+	if l == 0 {
+		limit = g.mMax0
+	}
+	if len(node.links[l]) <= limit {
+		return
+	}
+
+	candidates := make([]hnswCandidate, len(node.links[l]))
+	for i, n := range node.links[l] {
+		candidates[i] = hnswCandidate{node: n, dist: g.distance(node.vector, n)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+
+	node.links[l] = node.links[l][:0]
+	for _, c := range candidates[:limit] {
+		node.links[l] = append(node.links[l], c.node)
+	}
+}
+
+// remove marks the vector with the given ID as deleted
+func (g *hnswGraph) remove(id string) bool {
+	idx, ok := g.byID[id]
+	if !ok {
+		return false
+	}
+	g.nodes[idx].deleted = true
+	delete(g.byID, id)
+	g.live--
+	return true
+}
+
+// needsRebuild reports whether deleted nodes outnumber live ones enough to slow searches down
+func (g *hnswGraph) needsRebuild() bool {
+	deleted := len(g.nodes) - g.live
+	return deleted > 64 && deleted > g.live
+}
+
+// rebuilt returns a fresh graph holding only the live vectors
+func (g *hnswGraph) rebuilt() *hnswGraph {
+	fresh := newHNSWGraph(g.m, g.efConstruction, g.rng.Int63())
+	for _, node := range g.nodes {
+		if !node.deleted {
+			fresh.insert(node.id, node.ref, node.vector)
+		}
+	}
+	return fresh
+}
+
+// search returns up to k live nodes nearest to query that pass accept (nil accepts all),
+// closest first. ef trades speed for recall.
+func (g *hnswGraph) search(query []float32, k, ef int, accept func(*hnswNode) bool) []hnswCandidate {
+	if g.entry < 0 || k <= 0 {
+		return nil
+	}
+	if ef < k {
+		ef = k
+	}
+
+	entryPoints := []hnswCandidate{{node: g.entry, dist: g.distance(query, g.entry)}}
+	for l := g.maxLevel; l > 0; l-- {
+		entryPoints = g.searchLayer(query, entryPoints, 1, l)[:1]
+	}
+
+	results := make([]hnswCandidate, 0, k)
+	for _, c := range g.searchLayer(query, entryPoints, ef, 0) {
+		node := g.nodes[c.node]
+		if node.deleted || (accept != nil && !accept(node)) {
+			continue
+		}
+		results = append(results, c)
+		if len(results) == k {
+			break
+		}
+	}
+	return results
+}
+
+// exact scans every live node; used for small graphs where it is both exact and cheaper
+func (g *hnswGraph) exact(query []float32, k int, accept func(*hnswNode) bool) []hnswCandidate {
+	results := make([]hnswCandidate, 0, g.live)
+	for i, node := range g.nodes {
+		if node.deleted || (accept != nil && !accept(node)) {
+			continue
+		}
+		results = append(results, hnswCandidate{node: int32(i), dist: g.distance(query, int32(i))})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].dist < results[j].dist })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// searchLayer runs a greedy best-first search on layer l and returns up to ef nodes, closest first
+func (g *hnswGraph) searchLayer(query []float32, entryPoints []hnswCandidate, ef, l int) []hnswCandidate {
+	visited := make(map[int32]bool, ef*4)
+	candidates := &candidateHeap{}
+	results := &candidateHeap{farthestFirst: true}
+
+	for _, ep := range entryPoints {
+		visited[ep.node] = true
+		heap.Push(candidates, ep)
+		heap.Push(results, ep)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+	}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && current.dist > results.items[0].dist {
+			break
+		}
+
+		node := g.nodes[current.node]
+		if l >= len(node.links) {
+			continue
+		}
+		for _, n := range node.links[l] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+
+			d := g.distance(query, n)
+			if results.Len() < ef || d < results.items[0].dist {
+				heap.Push(candidates, hnswCandidate{node: n, dist: d})
+				heap.Push(results, hnswCandidate{node: n, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := make([]hnswCandidate, len(results.items))
+	copy(sorted, results.items)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].dist < sorted[j].dist })
+	return sorted
+}
+
+// distance is the cosine distance between query and a node; both are L2-normalized
+func (g *hnswGraph) distance(query []float32, node int32) float32 {
+	return 1 - dotProduct(query, g.nodes[node].vector)
+}
+
+// dotProduct of two vectors; vectors of different dimensions are treated as unrelated
+func dotProduct(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// normalizeVector returns an L2-normalized copy of v (nil for a zero vector)
+func normalizeVector(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return nil
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = x * scale
+	}
+	return out
+}
+
+// candidateHeap is a min-heap on distance, or a max-heap when farthestFirst is set
+type candidateHeap struct {
+	items         []hnswCandidate
+	farthestFirst bool
+}
+
+func (h candidateHeap) Len() int { return len(h.items) }
+func (h candidateHeap) Less(i, j int) bool {
+	if h.farthestFirst {
+		return h.items[i].dist > h.items[j].dist
+	}
+	return h.items[i].dist < h.items[j].dist
+}
+func (h candidateHeap) Swap(i, j int)       { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *candidateHeap) Push(x interface{}) { h.items = append(h.items, x.(hnswCandidate)) }
+func (h *candidateHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// rrfK dampens the weight of top ranks in reciprocal-rank fusion (the usual value from the RRF paper)
+	rrfK = 60
+
+	// searchVectorLimit is how many nearest captions the semantic path contributes
+	searchVectorLimit = 50
+	// searchVectorMinScore drops semantic neighbours that are barely related.
+	// Atlas reports cosine similarity as (1 + cos) / 2, so 0.5 means unrelated.
+	searchVectorMinScore = 0.65
+
+	snippetLength = 160
+
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Match sources reported on search hits
+const (
+	MatchName     = "name"
+	MatchCaption  = "caption"
+	MatchSemantic = "semantic"
+)
+
+// textSpan is a token and its rune offsets within the original text
+type textSpan struct {
+	token      string
+	start, end int
+}
+
+// tokenSpans splits text into lowercase letter/digit runs
+func tokenSpans(text string) []textSpan {
+	var spans []textSpan
+	runes := []rune(text)
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		isWord := i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			spans = append(spans, textSpan{
+				token: strings.ToLower(string(runes[start:i])),
+				start: start,
+				end:   i,
+			})
+			start = -1
+		}
+	}
+	return spans
+}
+
+// tokenize returns the lowercase tokens of text
+func tokenize(text string) []string {
+	spans := tokenSpans(text)
+	tokens := make([]string, len(spans))
+	for i, span := range spans {
+		tokens[i] = span.token
+	}
+	return tokens
+}
+
+// bm25Index scores documents with Okapi BM25
+type bm25Index struct {
+	docCount int
+	avgLen   float64
+	docFreq  map[string]int
+	docs     map[string][]string // document ID -> tokens
+}
+
+// newBM25Index indexes docs (ID -> text). totalDocs is the size of the whole corpus
+// the docs were drawn from; document frequencies come from docs themselves, which is
+// exact as long as every document containing a query term is among them.
+func newBM25Index(docs map[string]string, totalDocs int) *bm25Index {
+	ix := &bm25Index{
+		docFreq: make(map[string]int),
+		docs:    make(map[string][]string, len(docs)),
+	}
+
+	totalLen := 0
+	for id, text := range docs {
+		tokens := tokenize(text)
+		ix.docs[id] = tokens
+		totalLen += len(tokens)
+
+		seen := make(map[string]bool)
+		for _, token := range tokens {
+			if !seen[token] {
+				seen[token] = true
+				ix.docFreq[token]++
+			}
+		}
+	}
+
+	ix.docCount = totalDocs
+	if ix.docCount < len(docs) {
+		ix.docCount = len(docs)
+	}
+	if len(docs) > 0 {
+		ix.avgLen = float64(totalLen) / float64(len(docs))
+	}
+	return ix
+}
+
+// score returns the BM25 score of a document for the query terms
+func (ix *bm25Index) score(id string, terms []string) float64 {
+	tokens, ok := ix.docs[id]
+	if !ok || len(tokens) == 0 || ix.avgLen == 0 {
+		return 0
+	}
+
+	freq := make(map[string]int)
+	for _, token := range tokens {
+		freq[token]++
+	}
+
+	var score float64
+	lengthNorm := 1 - bm25B + bm25B*float64(len(tokens))/ix.avgLen
+	for _, term := range terms {
+		tf := float64(freq[term])
+		if tf == 0 {
+			continue
+		}
+		df := float64(ix.docFreq[term])
+		idf := math.Log(1 + (float64(ix.docCount)-df+0.5)/(df+0.5))
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*lengthNorm)
+	}
+	return score
+}
+
+// rankIDs orders IDs by descending score, dropping non-positive scores.
+// Ties are broken by ID so rankings are deterministic.
+func rankIDs(scores map[string]float64) []string {
+	ids := make([]string, 0, len(scores))
+	for id, score := range scores {
+		if score > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// reciprocalRankFusion combines rankings (source -> IDs in rank order) into one score per ID:
+// the sum over rankings of 1 / (rrfK + rank). It also reports which sources ranked each ID.
+func reciprocalRankFusion(rankings map[string][]string) (map[string]float64, map[string][]string) {
+	fused := make(map[string]float64)
+	sources := make(map[string][]string)
+
+	// Iterate sources in a fixed order so MatchedBy is stable
+	names := make([]string, 0, len(rankings))
+	for name := range rankings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for rank, id := range rankings[name] {
+			fused[id] += 1 / float64(rrfK+rank+1)
+			sources[id] = append(sources[id], name)
+		}
+	}
+	return fused, sources
+}
+
+// maxFusedScore is the fused score of an item ranked first by every one of n sources
+func maxFusedScore(n int) float64 {
+	return float64(n) / float64(rrfK+1)
+}
+
+// searchSnippet highlights the query terms in caption. When the terms do not appear in
+// it (a purely semantic match), the excerpt comes from the passage that matched instead.
+func searchSnippet(caption string, passage *TextRange, terms []string) string {
+	if passage != nil && !containsAnyToken(caption, terms) {
+		if text := chunkText(caption, passage.Start, passage.End); text != "" {
+			return highlightSnippet(text, terms, snippetLength)
+		}
+	}
+	return highlightSnippet(caption, terms, snippetLength)
+}
+
+// containsAnyToken reports whether text contains any of the tokens as a whole word
+func containsAnyToken(text string, tokens []string) bool {
+	wanted := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		wanted[token] = true
+	}
+	for _, token := range tokenize(text) {
+		if wanted[token] {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightSnippet returns an HTML-escaped excerpt of text of at most maxRunes runes,
+// positioned over the densest cluster of query terms, with each term wrapped in <mark>.
+// Text without any query term yields its opening excerpt.
+func highlightSnippet(text string, terms []string, maxRunes int) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) == 0 {
+		return ""
+	}
+
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+	var matches []textSpan
+	for _, span := range tokenSpans(string(runes)) {
+		if wanted[span.token] {
+			matches = append(matches, span)
+		}
+	}
+
+	// Pick the window start covering the most matches, leaving a little lead-in context
+	start := 0
+	if len(runes) > maxRunes && len(matches) > 0 {
+		best := -1
+		for _, m := range matches {
+			candidate := m.start - maxRunes/5
+			if candidate < 0 {
+				candidate = 0
+			}
+			if candidate > len(runes)-maxRunes {
+				candidate = len(runes) - maxRunes
+			}
+			count := 0
+			for _, other := range matches {
+				if other.start >= candidate && other.end <= candidate+maxRunes {
+					count++
+				}
+			}
+			if count > best {
+				best, start = count, candidate
+			}
+		}
+	}
+	end := start + maxRunes
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, m := range matches {
+		if m.start < start || m.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+		b.WriteString("</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
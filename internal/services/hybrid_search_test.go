@@ -0,0 +1,122 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("CS-101: Newton's 2nd law, F=ma!")
+	want := []string{"cs", "101", "newton", "s", "2nd", "law", "f", "ma"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("tokenize() = %v, want %v", got, want)
+	}
+}
+
+func TestBM25PrefersRareTermsAndShortDocs(t *testing.T) {
+	docs := map[string]string{
+		"code":   "CS101 lecture on sorting algorithms",
+		"common": "lecture notes from the lecture hall",
+		"long":   "CS101 " + strings.Repeat("filler words about something else entirely ", 10),
+		"none":   "photosynthesis lecture about plants",
+	}
+	ix := newBM25Index(docs, 10)
+
+	if ix.score("none", []string{"cs101"}) != 0 {
+		t.Error("document without the term should score 0")
+	}
+	if ix.score("code", []string{"cs101"}) <= ix.score("long", []string{"cs101"}) {
+		t.Error("shorter document with the same term frequency should score higher")
+	}
+	rare := ix.score("code", []string{"cs101"})
+	common := ix.score("code", []string{"lecture"})
+	if rare <= common {
+		t.Errorf("rare term score %v should exceed common term score %v", rare, common)
+	}
+}
+
+func TestReciprocalRankFusion(t *testing.T) {
+	fused, sources := reciprocalRankFusion(map[string][]string{
+		MatchCaption:  {"a", "b", "c"},
+		MatchSemantic: {"c", "b"},
+	})
+
+	if !(fused["b"] > fused["a"] && fused["c"] > fused["a"]) {
+		t.Errorf("items ranked by both sources should beat one ranked first by a single source: %v", fused)
+	}
+	if fused["a"] != 1.0/float64(rrfK+1) {
+		t.Errorf("fused[a] = %v, want 1/(k+1)", fused["a"])
+	}
+	if got := strings.Join(sources["c"], ","); got != "caption,semantic" {
+		t.Errorf("sources[c] = %q, want caption,semantic", got)
+	}
+	if maxFusedScore(2) != 2.0/float64(rrfK+1) {
+		t.Errorf("maxFusedScore(2) = %v", maxFusedScore(2))
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	got := highlightSnippet("Ohm's law: V = I <R>", []string{"law", "r"}, 100)
+	want := "Ohm&#39;s <mark>law</mark>: V = I &lt;<mark>R</mark>&gt;"
+	if got != want {
+		t.Errorf("highlightSnippet() = %q, want %q", got, want)
+	}
+
+	long := strings.Repeat("intro text ", 30) + "the Krebs cycle produces ATP " + strings.Repeat("outro text ", 30)
+	snippet := highlightSnippet(long, []string{"krebs", "atp"}, 80)
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") {
+		t.Errorf("snippet from the middle should be elided on both sides: %q", snippet)
+	}
+	if !strings.Contains(snippet, "<mark>Krebs</mark>") || !strings.Contains(snippet, "<mark>ATP</mark>") {
+		t.Errorf("snippet should be centred on the matches: %q", snippet)
+	}
+
+	if got := highlightSnippet("no matching words here", []string{"zebra"}, 10); got != "no matchin…" {
+		t.Errorf("highlightSnippet() without matches = %q", got)
+	}
+}
+
+func TestRankSearchCandidatesFindsExactTerms(t *testing.T) {
+	folder := models.Node{ID: primitive.NewObjectID(), Name: "MATH201", Metadata: models.NodeMetadata{Type: models.NodeTypeFolder}}
+	exact := models.Node{ID: primitive.NewObjectID(), Name: "Week 3", Metadata: models.NodeMetadata{Type: models.NodeTypeNote}}
+	related := models.Node{ID: primitive.NewObjectID(), Name: "Week 4", Metadata: models.NodeMetadata{Type: models.NodeTypeNote}}
+
+	nodes := map[primitive.ObjectID]models.Node{folder.ID: folder, exact.ID: exact, related.ID: related}
+	captions := map[string]string{
+		exact.ID.Hex():   "MATH201 homework: eigenvalues of a 2x2 matrix",
+		related.ID.Hex(): "Linear algebra review of matrices",
+	}
+
+	rankings := rankSearchCandidates(searchTerms("math201"), nodes, captions, 20, []string{related.ID.Hex(), exact.ID.Hex()})
+
+	if got := rankings[MatchName]; len(got) != 1 || got[0] != folder.ID.Hex() {
+		t.Errorf("name ranking = %v, want only the folder", got)
+	}
+	if got := rankings[MatchCaption]; len(got) != 1 || got[0] != exact.ID.Hex() {
+		t.Errorf("caption ranking = %v, want only the note containing the course code", got)
+	}
+	if got := rankings[MatchSemantic]; len(got) != 2 || got[0] != related.ID.Hex() {
+		t.Errorf("semantic ranking = %v, want the vector order preserved", got)
+	}
+
+	fused, _ := reciprocalRankFusion(rankings)
+	if fused[exact.ID.Hex()] <= fused[related.ID.Hex()] {
+		t.Error("the note containing the exact term should outrank a semantic-only neighbour")
+	}
+}
+
+func TestSearchSnippetFallsBackToMatchedPassage(t *testing.T) {
+	caption := strings.Repeat("Introductory remarks. ", 20) + "Enzymes lower activation energy."
+	passage := &TextRange{Start: strings.Index(caption, "Enzymes"), End: len(caption)}
+
+	if got := searchSnippet(caption, passage, []string{"catalysis"}); got != "Enzymes lower activation energy." {
+		t.Errorf("semantic-only snippet = %q, want the matched passage", got)
+	}
+	if got := searchSnippet(caption, passage, []string{"remarks"}); !strings.Contains(got, "<mark>remarks</mark>") {
+		t.Errorf("lexical snippet = %q, want highlighted terms", got)
+	}
+}
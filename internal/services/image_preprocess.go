@@ -0,0 +1,376 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/gen2brain/heic"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Image types recognised in uploads
+const (
+	MIMETypeJPEG = "image/jpeg"
+	MIMETypePNG  = "image/png"
+	MIMETypeGIF  = "image/gif"
+	MIMETypeWebP = "image/webp"
+	MIMETypeHEIC = "image/heic"
+)
+
+// MaxImageBytes is the largest image accepted for upload
+const MaxImageBytes = 30 << 20
+
+// MaxImagePixels is the most pixels an image may declare. Decoding allocates for
+// every pixel, so a small, highly compressed file could otherwise claim gigabytes.
+const MaxImagePixels = 64_000_000
+
+const (
+	defaultImageMaxDimension = 2048
+	preparedJPEGQuality      = 88
+)
+
+var (
+	ErrUnsupportedImage = errors.New("file is not a supported image (JPEG, PNG, WebP, GIF or HEIC)")
+	ErrImageTooLarge    = fmt.Errorf("image is larger than %d MB or %d megapixels", MaxImageBytes>>20, MaxImagePixels/1_000_000)
+)
+
+// PreparedImage is an uploaded image normalised for display and captioning
+type PreparedImage struct {
+	Data       []byte
+	MIMEType   string // Type of Data, always JPEG
+	SourceType string // Detected type of the upload
+	Width      int
+	Height     int
+	Image      image.Image // Data decoded, for deriving thumbnails
+}
+
+// DetectImageType returns the MIME type of an image from its leading bytes, or ""
+// when it is not a recognised format
+func DetectImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return MIMETypeJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return MIMETypePNG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return MIMETypeGIF
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return MIMETypeWebP
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && isHEIFBrand(string(data[8:12])):
+		return MIMETypeHEIC
+	}
+	return ""
+}
+
+// isHEIFBrand reports whether an ISO media major brand is a HEIF still image
+func isHEIFBrand(brand string) bool {
+	switch brand {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+// PreprocessImage turns an uploaded photo into the image that is stored for display
+// and sent for captioning: decoded from any supported format, turned upright per its
+// EXIF orientation, scaled down to IMAGE_MAX_DIMENSION (default 2048, 0 for no limit),
+// contrast-stretched and re-encoded as JPEG
+func PreprocessImage(data []byte) (*PreparedImage, error) {
+	if len(data) > MaxImageBytes {
+		return nil, ErrImageTooLarge
+	}
+	sourceType := DetectImageType(data)
+	if sourceType == "" {
+		return nil, ErrUnsupportedImage
+	}
+
+	src, err := decodeImage(data, sourceType)
+	if errors.Is(err, ErrImageTooLarge) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	img := flattenAndFit(src, envInt("IMAGE_MAX_DIMENSION", defaultImageMaxDimension))
+	img = orientImage(img, imageOrientation(data, sourceType))
+	stretchContrast(img)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: preparedJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return &PreparedImage{
+		Data:       buf.Bytes(),
+		MIMEType:   MIMETypeJPEG,
+		SourceType: sourceType,
+		Width:      img.Rect.Dx(),
+		Height:     img.Rect.Dy(),
+		Image:      img,
+	}, nil
+}
+
+// decodeImage decodes an image of a detected type, after checking from its header
+// that it is within MaxImagePixels
+func decodeImage(data []byte, mimeType string) (image.Image, error) {
+	config, err := decodeImageConfig(data, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", config.Width, config.Height)
+	}
+	if int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrImageTooLarge, config.Width, config.Height)
+	}
+
+	r := bytes.NewReader(data)
+	switch mimeType {
+	case MIMETypeJPEG:
+		return jpeg.Decode(r)
+	case MIMETypePNG:
+		return png.Decode(r)
+	case MIMETypeGIF:
+		return gif.Decode(r)
+	case MIMETypeWebP:
+		return webp.Decode(r)
+	case MIMETypeHEIC:
+		// HEIF stores rotation and mirroring as transforms, which decoding applies
+		return heic.Decode(r)
+	}
+	return nil, ErrUnsupportedImage
+}
+
+// decodeImageConfig reads the dimensions of an image of a detected type without
+// decoding its pixels
+func decodeImageConfig(data []byte, mimeType string) (image.Config, error) {
+	r := bytes.NewReader(data)
+	switch mimeType {
+	case MIMETypeJPEG:
+		return jpeg.DecodeConfig(r)
+	case MIMETypePNG:
+		return png.DecodeConfig(r)
+	case MIMETypeGIF:
+		return gif.DecodeConfig(r)
+	case MIMETypeWebP:
+		return webp.DecodeConfig(r)
+	case MIMETypeHEIC:
+		return heic.DecodeConfig(r)
+	}
+	return image.Config{}, ErrUnsupportedImage
+}
+
+// flattenAndFit draws an image onto white (JPEG has no transparency), scaled down so
+// neither side exceeds maxDimension
+func flattenAndFit(src image.Image, maxDimension int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if maxDimension > 0 && (w > maxDimension || h > maxDimension) {
+		if w >= h {
+			w, h = maxDimension, max(1, h*maxDimension/w)
+		} else {
+			w, h = max(1, w*maxDimension/h), maxDimension
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	if w == bounds.Dx() && h == bounds.Dy() {
+		draw.Draw(dst, dst.Rect, src, bounds.Min, draw.Over)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Rect, src, bounds, draw.Over, nil)
+	}
+	return dst
+}
+
+// orientImage applies an EXIF orientation (1-8) so the image displays upright
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored
+				dx, dy = w-1-x, y
+			case 3: // Upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored upside down
+				dx, dy = x, h-1-y
+			case 5: // Transposed
+				dx, dy = y, x
+			case 6: // Rotated 90° counter-clockwise: turn clockwise
+				dx, dy = h-1-y, x
+			case 7: // Transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 90° clockwise: turn counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			si := y*src.Stride + x*4
+			di := dy*dst.Stride + dx*4
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// stretchContrast spreads the image's tones over the full range, clipping the darkest
+// and brightest 0.5% of pixels, so faint pencil and grey paper read clearly. Images
+// that already span the range, or are nearly uniform, are left alone.
+func stretchContrast(img *image.RGBA) {
+	var histogram [256]int
+	pixels := 0
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		luma := (299*int(img.Pix[i]) + 587*int(img.Pix[i+1]) + 114*int(img.Pix[i+2])) / 1000
+		histogram[luma]++
+		pixels++
+	}
+	if pixels == 0 {
+		return
+	}
+
+	clip := pixels / 200
+	low, high := 0, 255
+	for seen := 0; low < 255; low++ {
+		if seen += histogram[low]; seen > clip {
+			break
+		}
+	}
+	for seen := 0; high > 0; high-- {
+		if seen += histogram[high]; seen > clip {
+			break
+		}
+	}
+	if high-low < 32 || (low <= 2 && high >= 253) {
+		return
+	}
+
+	var levels [256]uint8
+	for v := range levels {
+		levels[v] = uint8(min(255, max(0, (v-low)*255/(high-low))))
+	}
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		img.Pix[i] = levels[img.Pix[i]]
+		img.Pix[i+1] = levels[img.Pix[i+1]]
+		img.Pix[i+2] = levels[img.Pix[i+2]]
+	}
+}
+
+// imageOrientation reads the EXIF orientation of a JPEG, PNG or WebP, or 1 when it
+// has none. HEIF orientation is applied while decoding.
+func imageOrientation(data []byte, mimeType string) int {
+	var exif []byte
+	switch mimeType {
+	case MIMETypeJPEG:
+		exif = jpegExif(data)
+	case MIMETypePNG:
+		exif = chunkData(data[8:], binary.BigEndian, "eXIf", true)
+	case MIMETypeWebP:
+		exif = chunkData(data[12:], binary.LittleEndian, "EXIF", false)
+	}
+	// Some writers keep the JPEG "Exif\0\0" header in other containers too
+	exif = bytes.TrimPrefix(exif, []byte("Exif\x00\x00"))
+	return tiffOrientation(exif)
+}
+
+// jpegExif returns the TIFF data of a JPEG's EXIF segment
+func jpegExif(data []byte) []byte {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // Image data starts: no more metadata
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// chunkData returns the payload of the first chunk of a PNG (length, type, data, CRC)
+// or RIFF (type, length, data, padded to even) stream with the given type
+func chunkData(data []byte, order binary.ByteOrder, chunkType string, lengthFirst bool) []byte {
+	for i := 0; i+8 <= len(data); {
+		var length int
+		var typ string
+		if lengthFirst {
+			length, typ = int(order.Uint32(data[i:])), string(data[i+4:i+8])
+		} else {
+			typ, length = string(data[i:i+4]), int(order.Uint32(data[i+4:]))
+		}
+		start := i + 8
+		if length < 0 || start+length > len(data) {
+			return nil
+		}
+		if typ == chunkType {
+			return data[start : start+length]
+		}
+		if lengthFirst {
+			i = start + length + 4 // CRC
+		} else {
+			i = start + length + length%2
+		}
+	}
+	return nil
+}
+
+// tiffOrientation reads the Orientation tag (0x0112) from IFD0 of EXIF TIFF data
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withExifOrientation inserts an EXIF segment carrying orientation after the SOI marker
+func withExifOrientation(t *testing.T, jpegData []byte, orientation byte) []byte {
+	t.Helper()
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // Big-endian header, IFD0 at 8
+		0x00, 0x01, // One entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, orientation, 0x00, 0x00, // Orientation, SHORT
+		0x00, 0x00, 0x00, 0x00, // No next IFD
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+
+	var b bytes.Buffer
+	b.Write(jpegData[:2])
+	b.Write([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)})
+	b.Write(segment)
+	b.Write(jpegData[2:])
+	return b.Bytes()
+}
+
+func TestDetectImageType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0}, MIMETypeJPEG},
+		{"png", []byte("\x89PNG\r\n\x1a\n...."), MIMETypePNG},
+		{"gif", []byte("GIF89a.."), MIMETypeGIF},
+		{"webp", []byte("RIFF\x10\x00\x00\x00WEBPVP8 "), MIMETypeWebP},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), MIMETypeHEIC},
+		{"heif", []byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00"), MIMETypeHEIC},
+		{"mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x00\x00"), ""},
+		{"pdf", []byte("%PDF-1.7"), ""},
+	}
+	for _, tt := range tests {
+		if got := DetectImageType(tt.data); got != tt.want {
+			t.Errorf("DetectImageType(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreprocessImage(t *testing.T) {
+	t.Setenv("IMAGE_MAX_DIMENSION", "1000")
+
+	// A wide, washed-out scan with a transparent margin
+	src := image.NewNRGBA(image.Rect(0, 0, 3000, 600))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 3000; x++ {
+			switch {
+			case x < 100:
+				src.Set(x, y, color.NRGBA{}) // Transparent
+			case (x/50)%2 == 0:
+				src.Set(x, y, color.NRGBA{R: 110, G: 110, B: 110, A: 255})
+			default:
+				src.Set(x, y, color.NRGBA{R: 170, G: 170, B: 170, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	prepared, err := PreprocessImage(buf.Bytes())
+	if err != nil {
+		t.Fatalf("PreprocessImage() error = %v", err)
+	}
+	if prepared.MIMEType != MIMETypeJPEG || prepared.SourceType != MIMETypePNG {
+		t.Errorf("types = %q from %q, want JPEG from PNG", prepared.MIMEType, prepared.SourceType)
+	}
+	if prepared.Width != 1000 || prepared.Height != 200 {
+		t.Errorf("size = %dx%d, want 1000x200", prepared.Width, prepared.Height)
+	}
+
+	out, err := jpeg.Decode(bytes.NewReader(prepared.Data))
+	if err != nil {
+		t.Fatalf("output is not a JPEG: %v", err)
+	}
+	if r, _, _, _ := out.At(10, 100).RGBA(); r>>8 < 240 {
+		t.Errorf("transparent margin = %d, want flattened onto white", r>>8)
+	}
+	// Contrast stretching pushes the grey stripes apart
+	dark, _, _, _ := out.At(100, 100).RGBA()
+	light, _, _, _ := out.At(118, 100).RGBA()
+	if dark>>8 > 40 || light>>8-dark>>8 < 80 {
+		t.Errorf("stripes = %d and %d, want contrast stretched", dark>>8, light>>8)
+	}
+
+	if _, err := PreprocessImage([]byte("not an image")); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("PreprocessImage(text) error = %v, want ErrUnsupportedImage", err)
+	}
+	if _, err := PreprocessImage([]byte{0xFF, 0xD8, 0xFF, 0x00}); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("PreprocessImage(corrupt JPEG) error = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestPreprocessImageRejectsHugeDimensions(t *testing.T) {
+	// A PNG header declaring 60000x60000 pixels, which would take gigabytes to decode
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], 60000)
+	binary.BigEndian.PutUint32(ihdr[8:], 60000)
+	ihdr[12], ihdr[13] = 8, 2 // 8-bit RGB
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&b, binary.BigEndian, uint32(13))
+	b.Write(ihdr)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+
+	if _, err := PreprocessImage(b.Bytes()); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("PreprocessImage(60000x60000 PNG) error = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestPreprocessImageOrientation(t *testing.T) {
+	// A landscape photo with a black top-left corner, taken with the phone turned
+	src := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 80; x++ {
+			c := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			if x < 20 && y < 20 {
+				c = color.RGBA{A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := withExifOrientation(t, buf.Bytes(), 6)
+
+	if got := imageOrientation(data, MIMETypeJPEG); got != 6 {
+		t.Fatalf("imageOrientation() = %d, want 6", got)
+	}
+
+	prepared, err := PreprocessImage(data)
+	if err != nil {
+		t.Fatalf("PreprocessImage() error = %v", err)
+	}
+	if prepared.Width != 40 || prepared.Height != 80 {
+		t.Fatalf("size = %dx%d, want 40x80 after turning", prepared.Width, prepared.Height)
+	}
+	out, err := jpeg.Decode(bytes.NewReader(prepared.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Turning clockwise moves the top-left corner to the top right
+	if r, _, _, _ := out.At(30, 10).RGBA(); r>>8 > 60 {
+		t.Errorf("top right = %d, want the dark corner", r>>8)
+	}
+	if r, _, _, _ := out.At(10, 10).RGBA(); r>>8 < 200 {
+		t.Errorf("top left = %d, want white", r>>8)
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// 3x2 image whose red channel numbers the pixels row by row
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Pix[i*4] = uint8(i + 1)
+	}
+	tests := []struct {
+		orientation int
+		want        [][]uint8 // Rows of red values
+	}{
+		{1, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{2, [][]uint8{{3, 2, 1}, {6, 5, 4}}},
+		{3, [][]uint8{{6, 5, 4}, {3, 2, 1}}},
+		{4, [][]uint8{{4, 5, 6}, {1, 2, 3}}},
+		{5, [][]uint8{{1, 4}, {2, 5}, {3, 6}}},
+		{6, [][]uint8{{4, 1}, {5, 2}, {6, 3}}},
+		{7, [][]uint8{{6, 3}, {5, 2}, {4, 1}}},
+		{8, [][]uint8{{3, 6}, {2, 5}, {1, 4}}},
+	}
+	for _, tt := range tests {
+		out := orientImage(src, tt.orientation)
+		for y, row := range tt.want {
+			for x, want := range row {
+				if got := out.RGBAAt(x, y).R; got != want {
+					t.Errorf("orientation %d: pixel (%d, %d) = %d, want %d", tt.orientation, x, y, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/models"
+	"cogniscan/backend/internal/queue"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	jobEventsChannel   = "cogniscan:jobs:events"
+	jobEventBufferSize = 32
+	jobRecordTimeout   = 10 * time.Second
+	// jobRetentionDefault is how long finished job records are kept
+	jobRetentionDefault = 7 * 24 * time.Hour
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// GetJobsCollection returns the jobs collection holding caption, quiz and mastery job records
+func GetJobsCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection("jobs")
+}
+
+// InitJobService creates the TTL index that deletes job records JOB_RETENTION (default
+// a week) after they finished. Unfinished jobs have no finishedAt and are kept.
+func InitJobService(ctx context.Context) error {
+	retention := envDuration("JOB_RETENTION", jobRetentionDefault)
+	_, err := GetJobsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "finishedAt", Value: 1}},
+		Options: options.Index().SetName("finished_at_ttl_index").SetExpireAfterSeconds(int32(retention / time.Second)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create job retention index: %w", err)
+	}
+	return nil
+}
+
+// RecordJobTransition upserts a job record with the job's new state and publishes it to the
+// owner's event stream. Type, OwnerID and TargetID are only written when the record is created.
+func RecordJobTransition(ctx context.Context, job models.Job) (*models.Job, error) {
+	now := time.Now()
+
+	set := bson.M{
+		"state":     job.State,
+		"attempts":  job.Attempts,
+		"updatedAt": now,
+	}
+	update := bson.M{
+		"$set": set,
+		"$setOnInsert": bson.M{
+			"type":      job.Type,
+			"ownerId":   job.OwnerID,
+			"targetId":  job.TargetID,
+			"createdAt": now,
+		},
+	}
+	unset := bson.M{}
+	if job.Error != "" {
+		set["error"] = job.Error
+	} else {
+		unset["error"] = ""
+	}
+	switch job.State {
+	case models.JobStateCompleted, models.JobStateFailed:
+		set["finishedAt"] = now
+	default:
+		// A job run again (e.g. requeued from the dead-letter list) must not expire mid-run
+		unset["finishedAt"] = ""
+		if job.State == models.JobStateProcessing {
+			// $min keeps the first start time across retries
+			update["$min"] = bson.M{"startedAt": now}
+		}
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var record models.Job
+	if err := GetJobsCollection().FindOneAndUpdate(ctx, bson.M{"_id": job.ID}, update, opts).Decode(&record); err != nil {
+		return nil, fmt.Errorf("failed to record job %s: %w", job.ID, err)
+	}
+
+	publishJobEvent(record)
+	return &record, nil
+}
+
+// TrackJob records a job transition. Failures are logged rather than returned
+// so that tracking never breaks the job itself.
+func TrackJob(jobType models.JobType, jobID, ownerID, targetID string, state models.JobState, attempts int, errMsg string) {
+	if database.Client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobRecordTimeout)
+	defer cancel()
+
+	_, err := RecordJobTransition(ctx, models.Job{
+		ID:       jobID,
+		Type:     jobType,
+		OwnerID:  ownerID,
+		TargetID: targetID,
+		State:    state,
+		Attempts: attempts,
+		Error:    errMsg,
+	})
+	if err != nil {
+		log.Printf("[JobService] %v", err)
+	}
+}
+
+// trackMasteryJob maps mastery queue transitions onto job records
+func trackMasteryJob(job *queue.MasteryUpdateJob, status string, errMsg string) {
+	state := models.JobStateQueued
+	switch status {
+	case queue.MasteryJobProcessing:
+		state = models.JobStateProcessing
+	case queue.MasteryJobCompleted:
+		state = models.JobStateCompleted
+	case queue.MasteryJobFailed:
+		state = models.JobStateFailed
+	}
+	TrackJob(models.JobTypeMastery, job.ID, job.OwnerID, job.NodeID, state, job.Attempt, errMsg)
+}
+
+// GetJob returns a job record by ID
+func GetJob(ctx context.Context, jobID string) (*models.Job, error) {
+	var job models.Job
+	if err := GetJobsCollection().FindOne(ctx, bson.M{"_id": jobID}).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrJobNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch job: %w", err)
+	}
+	return &job, nil
+}
+
+// jobEventBroker fans job transitions out to the event streams of their owners
+type jobEventBroker struct {
+	mu   sync.RWMutex
+	subs map[string]map[chan models.Job]struct{} // ownerID -> subscriber channels
+}
+
+var jobEvents = &jobEventBroker{subs: make(map[string]map[chan models.Job]struct{})}
+
+// SubscribeJobEvents returns a channel of job transitions for ownerID and a function to unsubscribe
+func SubscribeJobEvents(ownerID string) (<-chan models.Job, func()) {
+	ch := make(chan models.Job, jobEventBufferSize)
+
+	jobEvents.mu.Lock()
+	if jobEvents.subs[ownerID] == nil {
+		jobEvents.subs[ownerID] = make(map[chan models.Job]struct{})
+	}
+	jobEvents.subs[ownerID][ch] = struct{}{}
+	jobEvents.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			jobEvents.mu.Lock()
+			delete(jobEvents.subs[ownerID], ch)
+			if len(jobEvents.subs[ownerID]) == 0 {
+				delete(jobEvents.subs, ownerID)
+			}
+			jobEvents.mu.Unlock()
+		})
+	}
+}
+
+// deliver sends a job to local subscribers, dropping it for any that are too slow to keep up
+func (b *jobEventBroker) deliver(job models.Job) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subs[job.OwnerID] {
+		select {
+		case ch <- job:
+		default:
+			log.Printf("[JobService] Event stream for %s is full, dropping event for job %s", job.OwnerID, job.ID)
+		}
+	}
+}
+
+// publishJobEvent broadcasts a transition through Redis so every server instance can
+// deliver it; without Redis it is delivered to this instance's subscribers directly
+func publishJobEvent(job models.Job) {
+	if job.OwnerID == "" {
+		return
+	}
+
+	if redisClient != nil {
+		payload, err := json.Marshal(job)
+		if err == nil {
+			err = redisClient.Publish(context.Background(), jobEventsChannel, payload).Err()
+		}
+		if err == nil {
+			return
+		}
+		log.Printf("[JobService] Failed to publish job event, delivering locally: %v", err)
+	}
+
+	jobEvents.deliver(job)
+}
+
+// StartJobEventRelay forwards job events published by any instance to local subscribers.
+// It is a no-op when the queue service (Redis) is disabled.
+func StartJobEventRelay(ctx context.Context) {
+	if redisClient == nil {
+		return
+	}
+
+	pubsub := redisClient.Subscribe(ctx, jobEventsChannel)
+	go func() {
+		defer pubsub.Close()
+		for msg := range pubsub.Channel() {
+			var job models.Job
+			if err := json.Unmarshal([]byte(msg.Payload), &job); err != nil {
+				log.Printf("[JobService] Failed to decode job event: %v", err)
+				continue
+			}
+			jobEvents.deliver(job)
+		}
+	}()
+
+	log.Println("[JobService] Job event relay started")
+}
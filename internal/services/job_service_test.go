@@ -0,0 +1,49 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestJobEventsDeliveredToOwnerOnly(t *testing.T) {
+	alice, unsubscribeAlice := SubscribeJobEvents("alice@example.com")
+	defer unsubscribeAlice()
+	bob, unsubscribeBob := SubscribeJobEvents("bob@example.com")
+	defer unsubscribeBob()
+
+	publishJobEvent(models.Job{ID: "job-1", OwnerID: "alice@example.com", State: models.JobStateCompleted})
+
+	select {
+	case job := <-alice:
+		if job.ID != "job-1" || job.State != models.JobStateCompleted {
+			t.Errorf("received %+v, want job-1 completed", job)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("owner did not receive the job event")
+	}
+
+	select {
+	case job := <-bob:
+		t.Errorf("another user received %+v", job)
+	default:
+	}
+}
+
+func TestJobEventsUnsubscribe(t *testing.T) {
+	events, unsubscribe := SubscribeJobEvents("carol@example.com")
+	unsubscribe()
+	unsubscribe() // Safe to call twice
+
+	publishJobEvent(models.Job{ID: "job-2", OwnerID: "carol@example.com"})
+
+	select {
+	case job := <-events:
+		t.Errorf("received %+v after unsubscribing", job)
+	default:
+	}
+	if _, ok := jobEvents.subs["carol@example.com"]; ok {
+		t.Error("unsubscribing the last stream should remove the owner entry")
+	}
+}
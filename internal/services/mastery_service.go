@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"time"
 
 	"cogniscan/backend/internal/database"
@@ -15,29 +16,37 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Global mastery queue instance
 var masteryQueue *queue.MasteryQueue
 
-// SetMasteryQueue sets the global mastery queue instance
+// SetMasteryQueue sets the global mastery queue instance and tracks its jobs as job records
 func SetMasteryQueue(q *queue.MasteryQueue) {
+	q.OnTransition(trackMasteryJob)
 	masteryQueue = q
 }
 
-// EnqueueAncestorMasteryUpdate enqueues a background job to update ancestor mastery
-func EnqueueAncestorMasteryUpdate(nodeID string) {
-	if masteryQueue != nil {
-		job := &queue.MasteryUpdateJob{
-			ID:     queue.JobID(),
-			NodeID: nodeID,
-		}
-		if err := masteryQueue.Enqueue(job); err != nil {
-			log.Printf("Failed to enqueue ancestor mastery update: %v", err)
-		} else {
-			log.Printf("Enqueued ancestor mastery update for node: %s", nodeID)
-		}
+// EnqueueAncestorMasteryUpdate enqueues a background job to update ancestor mastery.
+// It returns the job ID, or "" if the job could not be enqueued.
+func EnqueueAncestorMasteryUpdate(nodeID, ownerID string) string {
+	if masteryQueue == nil {
+		return ""
+	}
+
+	job := &queue.MasteryUpdateJob{
+		ID:      queue.JobID(),
+		NodeID:  nodeID,
+		OwnerID: ownerID,
+	}
+	if err := masteryQueue.Enqueue(job); err != nil {
+		log.Printf("Failed to enqueue ancestor mastery update: %v", err)
+		return ""
 	}
+
+	log.Printf("Enqueued ancestor mastery update for node: %s", nodeID)
+	return job.ID
 }
 
 var (
@@ -51,11 +60,25 @@ func GetNodesCollection() *mongo.Collection {
 	return database.Client.Database(os.Getenv("DB_NAME")).Collection("nodes")
 }
 
+// GetMasteryJobsCollection returns the mastery_jobs collection used to persist the mastery queue
+func GetMasteryJobsCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection("mastery_jobs")
+}
+
 // GetNoteReviewsCollection returns the note_reviews collection
 func GetNoteReviewsCollection() *mongo.Collection {
 	return database.Client.Database(os.Getenv("DB_NAME")).Collection("note_reviews")
 }
 
+// NodeIDFilter builds an _id filter for a hex node ID.
+// Nodes are keyed by ObjectID; non-hex IDs are matched as plain strings.
+func NodeIDFilter(nodeID string) bson.M {
+	if objectID, err := primitive.ObjectIDFromHex(nodeID); err == nil {
+		return bson.M{"_id": objectID}
+	}
+	return bson.M{"_id": nodeID}
+}
+
 // DetermineMasteryLevel returns the mastery level based on percentage
 func DetermineMasteryLevel(percent float64) string {
 	if percent > 0.8 {
@@ -72,7 +95,7 @@ func CalculateNoteMastery(ctx context.Context, nodeID string) (*models.NodeMaste
 
 	// Get the note node
 	var node models.Node
-	err := nodesCollection.FindOne(ctx, bson.M{"_id": nodeID}).Decode(&node)
+	err := nodesCollection.FindOne(ctx, NodeIDFilter(nodeID)).Decode(&node)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrNodeNotFound
@@ -125,7 +148,7 @@ func CalculateFolderMastery(ctx context.Context, nodeID string) (*models.NodeMas
 
 	// Get the folder node
 	var node models.Node
-	err := nodesCollection.FindOne(ctx, bson.M{"_id": nodeID}).Decode(&node)
+	err := nodesCollection.FindOne(ctx, NodeIDFilter(nodeID)).Decode(&node)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrNodeNotFound
@@ -138,7 +161,7 @@ func CalculateFolderMastery(ctx context.Context, nodeID string) (*models.NodeMas
 	}
 
 	// Get all child nodes
-	cursor, err := nodesCollection.Find(ctx, bson.M{"parentId": nodeID, "ownerId": node.OwnerID})
+	cursor, err := nodesCollection.Find(ctx, ExcludeTrashed(bson.M{"parentId": nodeID, "ownerId": node.OwnerID}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch children: %w", err)
 	}
@@ -149,7 +172,11 @@ func CalculateFolderMastery(ctx context.Context, nodeID string) (*models.NodeMas
 		return nil, fmt.Errorf("failed to decode children: %w", err)
 	}
 
-	// Aggregate mastery from all children
+	return folderMasteryFromChildren(children), nil
+}
+
+// folderMasteryFromChildren aggregates a folder's mastery from its children's
+func folderMasteryFromChildren(children []models.Node) *models.NodeMastery {
 	var (
 		totalNotes    int
 		masteredNotes int
@@ -191,7 +218,7 @@ func CalculateFolderMastery(ctx context.Context, nodeID string) (*models.NodeMas
 		mastery.LastStudyDate = now
 	}
 
-	return mastery, nil
+	return mastery
 }
 
 // UpdateNodeMastery updates the mastery for a node
@@ -200,7 +227,7 @@ func UpdateNodeMastery(ctx context.Context, nodeID string) error {
 
 	// Get the node
 	var node models.Node
-	err := nodesCollection.FindOne(ctx, bson.M{"_id": nodeID}).Decode(&node)
+	err := nodesCollection.FindOne(ctx, NodeIDFilter(nodeID)).Decode(&node)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return ErrNodeNotFound
@@ -228,7 +255,7 @@ func UpdateNodeMastery(ctx context.Context, nodeID string) error {
 		},
 	}
 
-	_, err = nodesCollection.UpdateOne(ctx, bson.M{"_id": nodeID}, update)
+	_, err = nodesCollection.UpdateOne(ctx, NodeIDFilter(nodeID), update)
 	if err != nil {
 		return fmt.Errorf("failed to update node mastery: %w", err)
 	}
@@ -307,53 +334,54 @@ func UpdateAncestorMasteryAsync(ctx context.Context, nodeID string) error {
 
 	// Get the node to find its parent
 	var node models.Node
-	err := nodesCollection.FindOne(ctx, bson.M{"_id": nodeID}).Decode(&node)
+	err := nodesCollection.FindOne(ctx, NodeIDFilter(nodeID)).Decode(&node)
 	if err != nil {
 		return fmt.Errorf("failed to fetch node: %w", err)
 	}
 
-	// Traverse up the tree and update each ancestor
-	currentParentID := node.ParentID
-	for currentParentID != "" {
-		// Get parent node
-		var parentNode models.Node
-		err = nodesCollection.FindOne(ctx, bson.M{"_id": currentParentID}).Decode(&parentNode)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				// Parent doesn't exist, stop traversal
-				break
-			}
-			return fmt.Errorf("failed to fetch parent node: %w", err)
-		}
-
-		// Only update mastery for folders
-		if parentNode.Metadata.Type == models.NodeTypeFolder {
-			// Calculate and update mastery for this folder
-			mastery, err := CalculateFolderMastery(ctx, currentParentID)
-			if err != nil {
-				log.Printf("Failed to calculate mastery for folder %s: %v", currentParentID, err)
-				return fmt.Errorf("failed to calculate folder mastery: %w", err)
-			}
+	if len(node.Ancestors) == 0 {
+		return nil
+	}
 
-			// Update the folder's mastery
-			update := bson.M{
-				"$set": bson.M{
-					"mastery":   mastery,
-					"updatedAt": time.Now(),
-				},
-			}
+	// Read the children of every ancestor at once and recompute bottom-up in memory,
+	// so each folder sees the freshly computed mastery of the folder below it
+	cursor, err := nodesCollection.Find(ctx, ExcludeTrashed(bson.M{"parentId": bson.M{"$in": node.Ancestors}, "ownerId": node.OwnerID}))
+	if err != nil {
+		return fmt.Errorf("failed to fetch ancestor children: %w", err)
+	}
+	var siblings []models.Node
+	if err = cursor.All(ctx, &siblings); err != nil {
+		return fmt.Errorf("failed to decode ancestor children: %w", err)
+	}
+	childrenOf := make(map[string][]models.Node, len(node.Ancestors))
+	for _, sibling := range siblings {
+		childrenOf[sibling.ParentID] = append(childrenOf[sibling.ParentID], sibling)
+	}
 
-			_, err = nodesCollection.UpdateOne(ctx, bson.M{"_id": currentParentID}, update)
-			if err != nil {
-				log.Printf("Failed to update mastery for folder %s: %v", currentParentID, err)
-				return fmt.Errorf("failed to update folder mastery: %w", err)
+	now := time.Now()
+	var writes []mongo.WriteModel
+	var below *models.NodeMastery
+	for i := len(node.Ancestors) - 1; i >= 0; i-- {
+		folderID := node.Ancestors[i]
+		children := childrenOf[folderID]
+		if below != nil {
+			for j := range children {
+				if children[j].ID.Hex() == node.Ancestors[i+1] {
+					children[j].Mastery = *below
+				}
 			}
-
-			log.Printf("Updated mastery for folder %s: %d/%d mastered", currentParentID, mastery.MasteredNotes, mastery.TotalNotes)
 		}
 
-		// Move up to the next ancestor
-		currentParentID = parentNode.ParentID
+		mastery := folderMasteryFromChildren(children)
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(NodeIDFilter(folderID)).
+			SetUpdate(bson.M{"$set": bson.M{"mastery": mastery, "updatedAt": now}}))
+		log.Printf("Updated mastery for folder %s: %d/%d mastered", folderID, mastery.MasteredNotes, mastery.TotalNotes)
+		below = mastery
+	}
+
+	if _, err := nodesCollection.BulkWrite(ctx, writes); err != nil {
+		return fmt.Errorf("failed to update folder mastery: %w", err)
 	}
 
 	return nil
@@ -363,10 +391,10 @@ func UpdateAncestorMasteryAsync(ctx context.Context, nodeID string) error {
 func GetNodesByParent(ctx context.Context, parentID, userID string) ([]models.Node, error) {
 	nodesCollection := GetNodesCollection()
 
-	filter := bson.M{
+	filter := ExcludeTrashed(bson.M{
 		"parentId": parentID,
 		"_id":      bson.M{"$ne": parentID}, // Exclude self-referencing nodes
-	}
+	})
 	if userID != "" {
 		filter["ownerId"] = userID
 	}
@@ -416,23 +444,26 @@ func GetNodeTree(ctx context.Context, nodeID string, maxDepth int) (*models.Node
 		return root, []models.Node{}, nil
 	}
 
-	// Get direct children
-	children, err := GetNodesByParent(ctx, nodeID, root.OwnerID)
+	// Descendants more than maxDepth levels down have an ancestor at that position
+	depth := len(root.Ancestors)
+	filter := ExcludeTrashed(bson.M{
+		"ownerId":   root.OwnerID,
+		"ancestors": nodeID,
+		fmt.Sprintf("ancestors.%d", depth+maxDepth): bson.M{"$exists": false},
+	})
+	cursor, err := GetNodesCollection().Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
 	if err != nil {
-		return root, children, nil
+		return nil, nil, fmt.Errorf("failed to fetch descendants: %w", err)
 	}
-
-	// Recursively get descendants
 	var allDescendants []models.Node
-	allDescendants = append(allDescendants, children...)
-
-	for _, child := range children {
-		_, descendants, err := GetNodeTree(ctx, child.ID.Hex(), maxDepth-1)
-		if err != nil {
-			continue
-		}
-		allDescendants = append(allDescendants, descendants...)
+	if err = cursor.All(ctx, &allDescendants); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode descendants: %w", err)
 	}
 
+	// Shallower levels first
+	sort.SliceStable(allDescendants, func(i, j int) bool {
+		return len(allDescendants[i].Ancestors) < len(allDescendants[j].Ancestors)
+	})
+
 	return root, allDescendants, nil
 }
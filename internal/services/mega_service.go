@@ -2,9 +2,15 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/t3rm1n4l/go-mega"
 )
@@ -64,3 +70,123 @@ func FindOrCreateCogniScanNode(m *mega.Mega) (*mega.Node, error) {
 	log.Println("[MegaService] Successfully created 'CogniScan' folder.")
 	return cogniScanNode, nil
 }
+
+// MegaBlobStore stores blobs in the "CogniScan" folder of a MEGA account
+type MegaBlobStore struct {
+	client *mega.Mega
+	root   *mega.Node
+}
+
+// NewMegaBlobStore creates a MEGA blob store using a logged-in client
+func NewMegaBlobStore(m *mega.Mega) (*MegaBlobStore, error) {
+	if m == nil {
+		return nil, fmt.Errorf("MEGA client is not initialized")
+	}
+	root, err := FindOrCreateCogniScanNode(m)
+	if err != nil {
+		return nil, err
+	}
+	return &MegaBlobStore{client: m, root: root}, nil
+}
+
+// Name returns the backend name
+func (s *MegaBlobStore) Name() string {
+	return StorageBackendMega
+}
+
+// Upload uploads content to MEGA and returns the node hash as its ID
+func (s *MegaBlobStore) Upload(ctx context.Context, name string, content io.Reader) (string, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", fmt.Errorf("could not read content: %v", err)
+	}
+
+	upload, err := s.client.NewUpload(s.root, name, int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("could not start MEGA upload: %v", err)
+	}
+
+	for id := 0; id < upload.Chunks(); id++ {
+		pos, size, err := upload.ChunkLocation(id)
+		if err != nil {
+			return "", err
+		}
+		if err := upload.UploadChunk(id, data[pos:pos+int64(size)]); err != nil {
+			return "", fmt.Errorf("could not upload chunk %d: %v", id, err)
+		}
+	}
+
+	node, err := upload.Finish()
+	if err != nil {
+		return "", fmt.Errorf("could not finish MEGA upload: %v", err)
+	}
+
+	log.Printf("[MegaService] File uploaded successfully. ID: %s", node.GetHash())
+	return node.GetHash(), nil
+}
+
+// Download fetches and decrypts a file from MEGA
+func (s *MegaBlobStore) Download(ctx context.Context, id string) (*Blob, error) {
+	node := s.client.FS.HashLookup(id)
+	if node == nil {
+		return nil, ErrBlobNotFound
+	}
+
+	download, err := s.client.NewDownload(node)
+	if err != nil {
+		return nil, fmt.Errorf("could not start MEGA download: %v", err)
+	}
+
+	var buf bytes.Buffer
+	for chunkID := 0; chunkID < download.Chunks(); chunkID++ {
+		chunk, err := download.DownloadChunk(chunkID)
+		if err != nil {
+			return nil, fmt.Errorf("could not download chunk %d: %v", chunkID, err)
+		}
+		buf.Write(chunk)
+	}
+	if err := download.Finish(); err != nil {
+		return nil, fmt.Errorf("MEGA download failed verification: %v", err)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(node.GetName()))
+	if contentType == "" {
+		contentType = http.DetectContentType(buf.Bytes())
+	}
+
+	return &Blob{
+		Body:        io.NopCloser(&buf),
+		ContentType: contentType,
+		Size:        int64(buf.Len()),
+	}, nil
+}
+
+// Delete permanently deletes a file from MEGA
+func (s *MegaBlobStore) Delete(ctx context.Context, id string) error {
+	node := s.client.FS.HashLookup(id)
+	if node == nil {
+		return ErrBlobNotFound
+	}
+	if err := s.client.Delete(node, true); err != nil {
+		log.Printf("[MegaService] Failed to delete file %s: %v", id, err)
+		return err
+	}
+	log.Printf("[MegaService] Successfully deleted file %s.", id)
+	return nil
+}
+
+// List lists the files in the "CogniScan" folder
+func (s *MegaBlobStore) List(ctx context.Context) ([]BlobInfo, error) {
+	nodes, err := s.client.FS.GetChildren(s.root)
+	if err != nil {
+		return nil, fmt.Errorf("could not list MEGA folder: %v", err)
+	}
+	blobs := make([]BlobInfo, 0, len(nodes))
+	for _, node := range nodes {
+		if node.GetType() != mega.FILE {
+			continue
+		}
+		blobs = append(blobs, BlobInfo{ID: node.GetHash(), Name: node.GetName(), Size: node.GetSize(), CreatedAt: node.GetTimeStamp()})
+	}
+	return blobs, nil
+}
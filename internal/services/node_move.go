@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrMoveTargetNotFolder   = errors.New("move target must be a folder")
+	ErrMoveIntoDescendant    = errors.New("cannot move a node into itself or one of its descendants")
+	ErrNodeMovedConcurrently = errors.New("node was moved concurrently")
+)
+
+// ValidateMoveTarget checks that node can be moved under targetID and returns the
+// target folder. The target must be a folder owned by the node's owner that is
+// neither the node itself nor one of its descendants. An empty targetID is the root.
+func ValidateMoveTarget(ctx context.Context, node *models.Node, targetID string) (*models.Node, error) {
+	if targetID == "" {
+		return nil, nil
+	}
+	if targetID == node.ID.Hex() {
+		return nil, ErrMoveIntoDescendant
+	}
+
+	nodesCollection := GetNodesCollection()
+	filter := ExcludeTrashed(NodeIDFilter(targetID))
+	filter["ownerId"] = node.OwnerID
+
+	var target models.Node
+	if err := nodesCollection.FindOne(ctx, filter).Decode(&target); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrParentNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch move target: %w", err)
+	}
+	if target.Metadata.Type != models.NodeTypeFolder {
+		return nil, ErrMoveTargetNotFolder
+	}
+
+	descendant, err := isDescendant(ctx, node, &target)
+	if err != nil {
+		return nil, err
+	}
+	if descendant {
+		return nil, ErrMoveIntoDescendant
+	}
+	return &target, nil
+}
+
+// isDescendant reports whether target is node or lies below it. The target's ancestor
+// path answers in one query, but parentId is the source of truth: a path that does not
+// match the parent pointers (missing, or left stale by a failed rewrite) is not trusted,
+// and the parent chain is walked instead.
+func isDescendant(ctx context.Context, node, target *models.Node) (bool, error) {
+	nodesCollection := GetNodesCollection()
+	parents := map[string]string{target.ID.Hex(): target.ParentID}
+	if len(target.Ancestors) > 0 {
+		filter := bson.M{"_id": bson.M{"$in": ancestorObjectIDs(target.Ancestors)}, "ownerId": node.OwnerID}
+		cursor, err := nodesCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "parentId": 1}))
+		if err != nil {
+			return false, fmt.Errorf("failed to fetch move target ancestors: %w", err)
+		}
+		var ancestors []models.Node
+		if err := cursor.All(ctx, &ancestors); err != nil {
+			return false, fmt.Errorf("failed to decode move target ancestors: %w", err)
+		}
+		for _, ancestor := range ancestors {
+			parents[ancestor.ID.Hex()] = ancestor.ParentID
+		}
+	}
+	if descendant, ok := pathHasAncestor(target, node.ID.Hex(), parents); ok {
+		return descendant, nil
+	}
+
+	log.Printf("[NodeMove] Ancestor path of %s does not match its parents, walking them instead", target.ID.Hex())
+	descendant, err := hasAncestor(target.ID.Hex(), node.ID.Hex(), func(id string) (string, error) {
+		var ancestor models.Node
+		err := nodesCollection.FindOne(ctx, NodeIDFilter(id), options.FindOne().SetProjection(bson.M{"parentId": 1})).Decode(&ancestor)
+		if err == mongo.ErrNoDocuments {
+			return "", nil
+		}
+		return ancestor.ParentID, err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to walk move target ancestors: %w", err)
+	}
+	return descendant, nil
+}
+
+// pathHasAncestor reports from node's ancestor path whether ancestorID is node or one
+// of its ancestors. parents maps node and the IDs on its path to their parent IDs; ok
+// is false when the path does not follow them up to the top level.
+func pathHasAncestor(node *models.Node, ancestorID string, parents map[string]string) (found, ok bool) {
+	child := node.ID.Hex()
+	for i := len(node.Ancestors) - 1; i >= 0; i-- {
+		if parent, known := parents[child]; !known || parent != node.Ancestors[i] {
+			return false, false
+		}
+		child = node.Ancestors[i]
+	}
+	if parent, known := parents[child]; !known || parent != "" {
+		return false, false
+	}
+	return node.ID.Hex() == ancestorID || slices.Contains(node.Ancestors, ancestorID), true
+}
+
+// hasAncestor walks up from startID using parentOf and reports whether ancestorID is
+// startID or one of its ancestors. A cycle already in the tree stops the walk.
+func hasAncestor(startID, ancestorID string, parentOf func(id string) (string, error)) (bool, error) {
+	visited := make(map[string]bool)
+	for current := startID; current != "" && !visited[current]; {
+		if current == ancestorID {
+			return true, nil
+		}
+		visited[current] = true
+
+		parentID, err := parentOf(current)
+		if err != nil {
+			return false, err
+		}
+		current = parentID
+	}
+	return false, nil
+}
+
+// ReparentNode points node at targetID with the given ancestor path and rewrites the
+// paths of its descendants. It fails with ErrNodeMovedConcurrently if the node's
+// parent changed since it was read. Parents' children arrays are left to the caller.
+func ReparentNode(ctx context.Context, node *models.Node, targetID string, ancestors []string) error {
+	nodesCollection := GetNodesCollection()
+	nodeID := node.ID.Hex()
+
+	// The parent filter makes concurrent moves of the same node fail cleanly
+	filter := bson.M{"_id": node.ID, "ownerId": node.OwnerID, "parentId": node.ParentID}
+	result, err := nodesCollection.UpdateOne(ctx, filter,
+		bson.M{"$set": bson.M{"parentId": targetID, "ancestors": ancestors, "updatedAt": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to move node: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNodeMovedConcurrently
+	}
+
+	// Recorded only once the move is ours, and conditioned on the node (and its
+	// descendants) still being where this move put them
+	movedFilter := bson.M{"_id": node.ID, "ownerId": node.OwnerID, "parentId": targetID}
+	undo := []outboxStep{undoUpdate(nodesCollection, movedFilter, bson.M{"$set": bson.M{"parentId": node.ParentID, "ancestors": node.Ancestors}})}
+	if node.Metadata.Type == models.NodeTypeFolder {
+		movedDescendants := descendantsFilter(node.OwnerID, nodeID)
+		movedDescendants[fmt.Sprintf("ancestors.%d", len(ancestors))] = nodeID
+		undo = append(undo, undoUpdate(nodesCollection, movedDescendants, descendantAncestorsUpdate(nodeID, node.Ancestors)))
+	}
+	if err := recordUndo(ctx, undo...); err != nil {
+		return err
+	}
+
+	// The subtree keeps its shape; only the part of its paths above the node changes
+	if node.Metadata.Type == models.NodeTypeFolder {
+		return RewriteDescendantAncestors(ctx, node.OwnerID, nodeID, ancestors)
+	}
+	return nil
+}
+
+// ConfirmMoveTarget fails with ErrNodeMovedConcurrently if target (nil for the root)
+// was moved or trashed since ValidateMoveTarget read it. It writes the target, and
+// runs last in the move, after the moved subtree's paths are rewritten: of two moves
+// that would each put a node under the other, at least one then fails, with or
+// without transactions, instead of both committing a cycle.
+func ConfirmMoveTarget(ctx context.Context, target *models.Node) error {
+	if target == nil {
+		return nil
+	}
+	filter := ExcludeTrashed(bson.M{
+		"_id": target.ID, "ownerId": target.OwnerID, "parentId": target.ParentID, "ancestors": target.Ancestors,
+	})
+	result, err := GetNodesCollection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"updatedAt": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to confirm move target: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNodeMovedConcurrently
+	}
+	return nil
+}
+
+// CountSubtreeNotes counts the note nodes in the subtree rooted at node, including
+// node itself when it is a note
+func CountSubtreeNotes(ctx context.Context, node *models.Node) (int, error) {
+	_, noteIDs, err := collectSubtree(ctx, node)
+	return len(noteIDs), err
+}
+
+// collectSubtree returns the IDs of every live node in the subtree rooted at node
+// (node included) and the hex IDs of its notes, read in one query on the ancestor path
+func collectSubtree(ctx context.Context, node *models.Node) ([]primitive.ObjectID, []string, error) {
+	nodeIDs := []primitive.ObjectID{node.ID}
+	if node.Metadata.Type == models.NodeTypeNote {
+		return nodeIDs, []string{node.ID.Hex()}, nil
+	}
+
+	filter := ExcludeTrashed(bson.M{"ownerId": node.OwnerID, "ancestors": node.ID.Hex()})
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "metadata.type": 1})
+	cursor, err := GetNodesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch subtree nodes: %w", err)
+	}
+	var descendants []models.Node
+	if err := cursor.All(ctx, &descendants); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode subtree nodes: %w", err)
+	}
+
+	var noteIDs []string
+	for _, descendant := range descendants {
+		nodeIDs = append(nodeIDs, descendant.ID)
+		if descendant.Metadata.Type == models.NodeTypeNote {
+			noteIDs = append(noteIDs, descendant.ID.Hex())
+		}
+	}
+	return nodeIDs, noteIDs, nil
+}
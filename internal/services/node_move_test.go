@@ -0,0 +1,75 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestPathHasAncestor(t *testing.T) {
+	// 1 <- 2 <- 3, with 4 at the top level
+	parents := map[string]string{hexID(1): "", hexID(2): hexID(1), hexID(3): hexID(2), hexID(4): ""}
+	withPath := func(n byte, ancestors ...string) *models.Node {
+		node := testNode(n, models.NodeTypeFolder, parents[hexID(n)])
+		node.Ancestors = ancestors
+		return &node
+	}
+
+	tests := []struct {
+		name       string
+		node       *models.Node
+		ancestorID string
+		found, ok  bool
+	}{
+		{"into own descendant", withPath(3, hexID(1), hexID(2)), hexID(1), true, true},
+		{"into own child", withPath(3, hexID(1), hexID(2)), hexID(2), true, true},
+		{"onto itself", withPath(3, hexID(1), hexID(2)), hexID(3), true, true},
+		{"into unrelated folder", withPath(3, hexID(1), hexID(2)), hexID(4), false, true},
+		{"into ancestor", withPath(1), hexID(3), false, true},
+		{"missing path", withPath(3), hexID(1), false, false},
+		{"missing top of path", withPath(3, hexID(2)), hexID(1), false, false},
+		{"stale path", withPath(3, hexID(4), hexID(2)), hexID(1), false, false},
+		{"stale path naming the node", withPath(2, hexID(4)), hexID(4), false, false},
+	}
+	for _, tt := range tests {
+		found, ok := pathHasAncestor(tt.node, tt.ancestorID, parents)
+		if found != tt.found || ok != tt.ok {
+			t.Errorf("%s: pathHasAncestor() = %v, %v, want %v, %v", tt.name, found, ok, tt.found, tt.ok)
+		}
+	}
+
+	// An ancestor missing from parents (deleted, or not the owner's) breaks the path
+	delete(parents, hexID(1))
+	if _, ok := pathHasAncestor(withPath(3, hexID(1), hexID(2)), hexID(1), parents); ok {
+		t.Error("pathHasAncestor() trusted a path through an unknown ancestor")
+	}
+}
+
+func TestHasAncestor(t *testing.T) {
+	// root <- a <- b <- c, plus a broken loop x <-> y
+	parents := map[string]string{"a": "root", "b": "a", "c": "b", "x": "y", "y": "x"}
+	parentOf := func(id string) (string, error) { return parents[id], nil }
+
+	tests := []struct {
+		start, ancestor string
+		want            bool
+	}{
+		{"c", "a", true},
+		{"c", "c", true},
+		{"a", "c", false},
+		{"b", "root", true},
+		{"x", "a", false},
+	}
+	for _, tt := range tests {
+		got, err := hasAncestor(tt.start, tt.ancestor, parentOf)
+		if err != nil || got != tt.want {
+			t.Errorf("hasAncestor(%q, %q) = %v, %v, want %v", tt.start, tt.ancestor, got, err, tt.want)
+		}
+	}
+
+	lookupErr := errors.New("lookup failed")
+	if _, err := hasAncestor("c", "root", func(string) (string, error) { return "", lookupErr }); err != lookupErr {
+		t.Errorf("hasAncestor() error = %v, want %v", err, lookupErr)
+	}
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cogniscan/backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A node mutation (create, move, delete) writes the node, its parents' children
+// arrays, ancestor note counts and trash markers. RunNodeMutation makes those writes
+// all-or-nothing: in a MongoDB transaction when the deployment supports them (replica
+// set or sharded cluster), otherwise through the node outbox. In outbox mode every
+// write records the write that undoes it on an outbox document; a failed
+// mutation runs its undo steps in reverse, and the outbox document of a mutation
+// that never finished (the process died) is undone by RecoverNodeMutations once its
+// lease runs out. Side effects outside MongoDB (queues, blobs, the vector index)
+// belong after the mutation returns.
+
+const (
+	nodeOutboxCollectionName = "node_outbox"
+	// nodeMutationLease is how long an unfinished mutation is left alone before
+	// recovery assumes its process died and undoes it
+	nodeMutationLease = 5 * time.Minute
+	// nodeUndoTimeout bounds undoing a failed mutation, whose own context may be spent
+	nodeUndoTimeout = 30 * time.Second
+	// nodeCloseAttempts is how many times closing a finished mutation's outbox entry is tried
+	nodeCloseAttempts = 3
+)
+
+// nodeTransactions is set by InitNodeMutations when the deployment supports transactions
+var nodeTransactions bool
+
+// nodeMutationKey carries the running outbox mutation in the mutation's context
+type nodeMutationKey struct{}
+
+// nodeMutation is an outbox-mode mutation in progress
+type nodeMutation struct {
+	id    primitive.ObjectID
+	steps []outboxStep
+}
+
+// outboxStep is one undo write of a node mutation. Filters and updates are stored as
+// encoded BSON because their operator keys ($pull, $inc...) cannot be field names.
+type outboxStep struct {
+	Collection string `bson:"collection"`
+	Filter     []byte `bson:"filter"`
+	Update     []byte `bson:"update,omitempty"`
+	Pipeline   []byte `bson:"pipeline,omitempty"`
+	Delete     bool   `bson:"delete,omitempty"`
+	Document   []byte `bson:"document,omitempty"` // Inserted back, for undoing a delete
+}
+
+// nodeOutboxEntry is the outbox document of an unfinished mutation
+type nodeOutboxEntry struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Kind       string             `bson:"kind"`
+	OwnerID    string             `bson:"ownerId"`
+	Steps      []outboxStep       `bson:"steps"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	LeaseUntil time.Time          `bson:"leaseUntil"`
+	// Committed marks an entry whose writes (or whose undo) are final but which could
+	// not be deleted; recovery deletes it rather than undoing it
+	Committed bool `bson:"committed,omitempty"`
+}
+
+// GetNodeOutboxCollection returns the node_outbox collection
+func GetNodeOutboxCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection(nodeOutboxCollectionName)
+}
+
+// InitNodeMutations detects whether node mutations can use transactions and creates
+// the outbox index. NODE_TRANSACTIONS=off forces outbox mode.
+func InitNodeMutations(ctx context.Context) error {
+	nodeTransactions = os.Getenv("NODE_TRANSACTIONS") != "off" && transactionsSupported(ctx)
+
+	_, err := GetNodeOutboxCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "leaseUntil", Value: 1}},
+		Options: options.Index().SetName("lease_until_index"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create node outbox index: %w", err)
+	}
+
+	mode := "outbox"
+	if nodeTransactions {
+		mode = "transactions"
+	}
+	log.Printf("[NodeMutation] Node mutations use %s", mode)
+	return nil
+}
+
+// transactionsSupported reports whether the server is a replica set member or mongos
+func transactionsSupported(ctx context.Context) bool {
+	var hello bson.M
+	if err := database.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Printf("[NodeMutation] Could not detect transaction support: %v", err)
+		return false
+	}
+	if _, ok := hello["setName"]; ok {
+		return true
+	}
+	return hello["msg"] == "isdbgrid"
+}
+
+// RunNodeMutation runs fn as one all-or-nothing node mutation. All of fn's reads and
+// writes must use the context it is given. kind and ownerID label the outbox entry.
+func RunNodeMutation(ctx context.Context, kind, ownerID string, fn func(ctx context.Context) error) error {
+	if nodeTransactions {
+		session, err := database.Client.StartSession()
+		if err != nil {
+			return fmt.Errorf("failed to start session: %w", err)
+		}
+		defer session.EndSession(ctx)
+
+		_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
+	}
+
+	now := time.Now()
+	m := &nodeMutation{id: primitive.NewObjectID()}
+	entry := nodeOutboxEntry{ID: m.id, Kind: kind, OwnerID: ownerID, Steps: []outboxStep{}, CreatedAt: now, LeaseUntil: now.Add(nodeMutationLease)}
+	if _, err := GetNodeOutboxCollection().InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to open node mutation: %w", err)
+	}
+
+	err := fn(context.WithValue(ctx, nodeMutationKey{}, m))
+
+	undoCtx, cancel := context.WithTimeout(context.Background(), nodeUndoTimeout)
+	defer cancel()
+	if err != nil {
+		if undoErr := undoSteps(undoCtx, m.steps); undoErr != nil {
+			// The outbox entry stays and recovery finishes the undo after the lease
+			log.Printf("[NodeMutation] Failed to undo %s %s: %v", kind, m.id.Hex(), undoErr)
+			return err
+		}
+	}
+	if closeErr := closeNodeMutation(undoCtx, m.id); closeErr != nil {
+		log.Printf("[NodeMutation] Failed to close %s %s: %v", kind, m.id.Hex(), closeErr)
+		if err == nil {
+			// The entry stays open, so recovery will undo the writes: report the
+			// mutation as failed to match
+			return fmt.Errorf("failed to commit node mutation: %w", closeErr)
+		}
+	}
+	return err
+}
+
+// closeNodeMutation deletes the outbox entry of a finished mutation, or failing
+// that marks it committed, retrying a few times
+func closeNodeMutation(ctx context.Context, id primitive.ObjectID) error {
+	outbox := GetNodeOutboxCollection()
+	var err error
+	for attempt := 0; attempt < nodeCloseAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * 200 * time.Millisecond):
+			case <-ctx.Done():
+				return err
+			}
+		}
+		if _, err = outbox.DeleteOne(ctx, bson.M{"_id": id}); err == nil {
+			return nil
+		}
+		if _, err = outbox.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"committed": true}}); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// recordUndo records the writes that undo a write of the running outbox mutation.
+// It does nothing in a transaction or outside a mutation. Callers record before the
+// write when the undo is harmless if the write never happened, and after it otherwise.
+func recordUndo(ctx context.Context, undo ...outboxStep) error {
+	m, ok := ctx.Value(nodeMutationKey{}).(*nodeMutation)
+	if !ok {
+		return nil
+	}
+	_, err := GetNodeOutboxCollection().UpdateOne(ctx, bson.M{"_id": m.id}, bson.M{"$push": bson.M{"steps": bson.M{"$each": undo}}})
+	if err != nil {
+		return fmt.Errorf("failed to record undo: %w", err)
+	}
+	m.steps = append(m.steps, undo...)
+	return nil
+}
+
+// undoUpdate builds an undo step that applies update (a document or a pipeline) to
+// the documents of collection matching filter
+func undoUpdate(collection *mongo.Collection, filter bson.M, update interface{}) outboxStep {
+	step := outboxStep{Collection: collection.Name(), Filter: mustMarshal(filter)}
+	if pipeline, ok := update.(mongo.Pipeline); ok {
+		step.Pipeline = mustMarshal(bson.M{"stages": pipeline})
+	} else {
+		step.Update = mustMarshal(update)
+	}
+	return step
+}
+
+// undoDelete builds an undo step that deletes the documents of collection matching filter
+func undoDelete(collection *mongo.Collection, filter bson.M) outboxStep {
+	return outboxStep{Collection: collection.Name(), Filter: mustMarshal(filter), Delete: true}
+}
+
+// undoInsert builds an undo step that inserts doc into collection unless a document
+// with its _id is already there
+func undoInsert(collection *mongo.Collection, doc interface{}) outboxStep {
+	return outboxStep{Collection: collection.Name(), Filter: mustMarshal(bson.M{}), Document: mustMarshal(doc)}
+}
+
+// mustMarshal encodes a filter or update built in this package, which always encodes
+func mustMarshal(v interface{}) []byte {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("node mutation: cannot encode %v: %v", v, err))
+	}
+	return data
+}
+
+// undoSteps applies undo steps in reverse order
+func undoSteps(ctx context.Context, steps []outboxStep) error {
+	db := database.Client.Database(os.Getenv("DB_NAME"))
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		collection := db.Collection(step.Collection)
+		filter := bson.Raw(step.Filter)
+
+		var err error
+		switch {
+		case step.Delete:
+			_, err = collection.DeleteMany(ctx, filter)
+		case step.Document != nil:
+			if _, err = collection.InsertOne(ctx, bson.Raw(step.Document)); mongo.IsDuplicateKeyError(err) {
+				err = nil
+			}
+		case step.Pipeline != nil:
+			var wrapped struct {
+				Stages []bson.D `bson:"stages"`
+			}
+			if err = bson.Unmarshal(step.Pipeline, &wrapped); err == nil {
+				_, err = collection.UpdateMany(ctx, filter, mongo.Pipeline(wrapped.Stages))
+			}
+		default:
+			_, err = collection.UpdateMany(ctx, filter, bson.Raw(step.Update))
+		}
+		if err != nil {
+			return fmt.Errorf("undo step %d on %s: %w", i, step.Collection, err)
+		}
+	}
+	return nil
+}
+
+// RecoverNodeMutations undoes up to limit outbox mutations whose lease ran out, which
+// means the process running them died, and returns how many were undone. Committed
+// entries are only deleted.
+func RecoverNodeMutations(ctx context.Context, limit int) (int, error) {
+	outbox := GetNodeOutboxCollection()
+	recovered := 0
+	for recovered < limit {
+		if ctx.Err() != nil {
+			return recovered, ctx.Err()
+		}
+
+		// Claim the entry so two instances never undo it at the same time
+		now := time.Now()
+		var entry nodeOutboxEntry
+		err := outbox.FindOneAndUpdate(ctx,
+			bson.M{"$or": bson.A{bson.M{"leaseUntil": bson.M{"$lte": now}}, bson.M{"committed": true}}},
+			bson.M{"$set": bson.M{"leaseUntil": now.Add(nodeMutationLease)}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			break
+		}
+		if err != nil {
+			return recovered, fmt.Errorf("failed to claim node mutation: %w", err)
+		}
+
+		if entry.Committed {
+			if _, err := outbox.DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+				return recovered, fmt.Errorf("failed to remove node mutation %s: %w", entry.ID.Hex(), err)
+			}
+			log.Printf("[NodeMutation] Removed committed %s %s of %s", entry.Kind, entry.ID.Hex(), entry.OwnerID)
+			recovered++
+			continue
+		}
+
+		if err := undoSteps(ctx, entry.Steps); err != nil {
+			return recovered, fmt.Errorf("failed to undo %s %s: %w", entry.Kind, entry.ID.Hex(), err)
+		}
+		if _, err := outbox.DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+			return recovered, fmt.Errorf("failed to remove node mutation %s: %w", entry.ID.Hex(), err)
+		}
+		log.Printf("[NodeMutation] Undid unfinished %s %s of %s (%d steps)", entry.Kind, entry.ID.Hex(), entry.OwnerID, len(entry.Steps))
+		recovered++
+	}
+	return recovered, nil
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestUndoStepEncoding(t *testing.T) {
+	collection := (&mongo.Client{}).Database("test").Collection("nodes")
+
+	step := undoUpdate(collection, bson.M{"trashId": "t1"}, bson.M{"$unset": bson.M{"deletedAt": ""}})
+	if step.Collection != "nodes" || step.Pipeline != nil || step.Delete {
+		t.Fatalf("undoUpdate() = %+v, want a plain update on nodes", step)
+	}
+	if got := bson.Raw(step.Update).Lookup("$unset", "deletedAt").StringValue(); got != "" {
+		t.Errorf("encoded update $unset.deletedAt = %q, want empty", got)
+	}
+	if got := bson.Raw(step.Filter).Lookup("trashId").StringValue(); got != "t1" {
+		t.Errorf("encoded filter trashId = %q, want t1", got)
+	}
+
+	// Pipelines survive the round trip through the outbox document
+	step = undoUpdate(collection, descendantsFilter("alice", "n1"), descendantAncestorsUpdate("n1", []string{"a"}))
+	if step.Pipeline == nil || step.Update != nil {
+		t.Fatalf("undoUpdate() = %+v, want a pipeline update", step)
+	}
+	var wrapped struct {
+		Stages []bson.D `bson:"stages"`
+	}
+	if err := bson.Unmarshal(step.Pipeline, &wrapped); err != nil {
+		t.Fatalf("decoding pipeline: %v", err)
+	}
+	if len(wrapped.Stages) != 1 || wrapped.Stages[0][0].Key != "$set" {
+		t.Errorf("decoded pipeline = %v, want one $set stage", wrapped.Stages)
+	}
+
+	step = undoDelete(collection, bson.M{"_id": "x"})
+	if !step.Delete || step.Update != nil {
+		t.Errorf("undoDelete() = %+v, want a delete", step)
+	}
+
+	step = undoInsert(collection, bson.M{"_id": "x", "name": "Trashed"})
+	if step.Document == nil || step.Delete || step.Update != nil {
+		t.Fatalf("undoInsert() = %+v, want an insert", step)
+	}
+	if got := bson.Raw(step.Document).Lookup("name").StringValue(); got != "Trashed" {
+		t.Errorf("encoded document name = %q, want Trashed", got)
+	}
+}
+
+func TestRecordUndoOutsideMutation(t *testing.T) {
+	// Plain writes (and writes inside a transaction) have no outbox entry to record on
+	if err := recordUndo(context.Background(), outboxStep{Collection: "nodes"}); err != nil {
+		t.Errorf("recordUndo() = %v, want nil outside a mutation", err)
+	}
+}
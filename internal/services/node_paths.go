@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Every node stores its materialized ancestor path in ancestors: the IDs of its
+// ancestors from the top-level folder down to its parent ([] at the top level).
+// parentId stays the source of truth; the path lets a subtree or an ancestor chain
+// be read or updated with one indexed query on {ownerId, ancestors}.
+
+// ChildAncestors returns the ancestor path of a node placed under parent
+// (the top level when parent is nil)
+func ChildAncestors(parent *models.Node) []string {
+	if parent == nil {
+		return []string{}
+	}
+	path := make([]string, 0, len(parent.Ancestors)+1)
+	path = append(path, parent.Ancestors...)
+	return append(path, parent.ID.Hex())
+}
+
+// ParentAncestors returns the ancestor path of a node placed under the owner's live
+// folder parentID, or ErrParentNotFound if there is no such folder
+func ParentAncestors(ctx context.Context, parentID, ownerID string) ([]string, error) {
+	if parentID == "" {
+		return []string{}, nil
+	}
+	filter := ExcludeTrashed(NodeIDFilter(parentID))
+	filter["ownerId"] = ownerID
+	filter["metadata.type"] = models.NodeTypeFolder
+
+	var parent models.Node
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1, "ancestors": 1})
+	if err := GetNodesCollection().FindOne(ctx, filter, opts).Decode(&parent); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrParentNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch parent: %w", err)
+	}
+	return ChildAncestors(&parent), nil
+}
+
+// RewriteDescendantAncestors moves the descendants of nodeID along with it after the
+// node's own path changed to ancestors: the part of each descendant's path above
+// nodeID is replaced, in a single update
+func RewriteDescendantAncestors(ctx context.Context, ownerID, nodeID string, ancestors []string) error {
+	_, err := GetNodesCollection().UpdateMany(ctx, descendantsFilter(ownerID, nodeID), descendantAncestorsUpdate(nodeID, ancestors))
+	if err != nil {
+		return fmt.Errorf("failed to rewrite descendant paths: %w", err)
+	}
+	return nil
+}
+
+// descendantsFilter matches every node below nodeID
+func descendantsFilter(ownerID, nodeID string) bson.M {
+	return bson.M{"ownerId": ownerID, "ancestors": nodeID}
+}
+
+// descendantAncestorsUpdate replaces the part of a descendant's path above nodeID
+func descendantAncestorsUpdate(nodeID string, ancestors []string) mongo.Pipeline {
+	if ancestors == nil {
+		ancestors = []string{}
+	}
+	below := bson.M{"$slice": bson.A{
+		"$ancestors",
+		bson.M{"$indexOfArray": bson.A{"$ancestors", nodeID}},
+		bson.M{"$size": "$ancestors"},
+	}}
+	return mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"ancestors": bson.M{"$concatArrays": bson.A{ancestors, below}},
+		"updatedAt": time.Now(),
+	}}}}
+}
+
+// ancestorObjectIDs converts an ancestor path to _id values for an $in filter
+func ancestorObjectIDs(ancestors []string) []interface{} {
+	ids := make([]interface{}, 0, len(ancestors))
+	for _, id := range ancestors {
+		ids = append(ids, NodeIDFilter(id)["_id"])
+	}
+	return ids
+}
+
+// IncrementAncestorNoteCounts adds delta to the totalNoteCount of every node on an
+// ancestor path in one update
+func IncrementAncestorNoteCounts(ctx context.Context, ancestors []string, ownerID string, delta int) error {
+	if len(ancestors) == 0 || delta == 0 {
+		return nil
+	}
+	filter := bson.M{"_id": bson.M{"$in": ancestorObjectIDs(ancestors)}, "ownerId": ownerID}
+	update := bson.M{"$inc": bson.M{"totalNoteCount": delta}, "$set": bson.M{"updatedAt": time.Now()}}
+	nodesCollection := GetNodesCollection()
+	if _, err := nodesCollection.UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+	// Undoing an increment that never happened would skew the counts, so record after
+	return recordUndo(ctx, undoUpdate(nodesCollection, filter, bson.M{"$inc": bson.M{"totalNoteCount": -delta}}))
+}
+
+// AncestorPaths computes every node's ancestor path from parent pointers (node ID ->
+// parent ID, "" at the top level). A parent that is not in the map ends the path, as
+// does a parent already on it, so corrupt trees with loops still terminate.
+func AncestorPaths(parents map[string]string) map[string][]string {
+	paths := make(map[string][]string, len(parents))
+	for id := range parents {
+		path := []string{}
+		seen := map[string]bool{id: true}
+		for p := parents[id]; p != ""; p = parents[p] {
+			if _, known := parents[p]; !known || seen[p] {
+				break
+			}
+			seen[p] = true
+			path = append(path, p)
+		}
+		slices.Reverse(path)
+		paths[id] = path
+	}
+	return paths
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestChildAncestors(t *testing.T) {
+	if got := ChildAncestors(nil); got == nil || len(got) != 0 {
+		t.Errorf("ChildAncestors(nil) = %#v, want empty path", got)
+	}
+
+	parent := testNode(2, models.NodeTypeFolder, hexID(1))
+	parent.Ancestors = []string{hexID(1)}
+	want := []string{hexID(1), hexID(2)}
+	if got := ChildAncestors(&parent); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChildAncestors() = %v, want %v", got, want)
+	}
+	if len(parent.Ancestors) != 1 {
+		t.Errorf("ChildAncestors() modified the parent's path: %v", parent.Ancestors)
+	}
+}
+
+func TestAncestorPaths(t *testing.T) {
+	// root <- a <- b, c under a missing parent, plus a broken loop x <-> y
+	parents := map[string]string{"root": "", "a": "root", "b": "a", "c": "gone", "x": "y", "y": "x"}
+	paths := AncestorPaths(parents)
+
+	tests := map[string][]string{
+		"root": {},
+		"a":    {"root"},
+		"b":    {"root", "a"},
+		"c":    {},
+		"x":    {"y"},
+		"y":    {"x"},
+	}
+	for id, want := range tests {
+		if got := paths[id]; !reflect.DeepEqual(got, want) {
+			t.Errorf("AncestorPaths()[%q] = %v, want %v", id, got, want)
+		}
+	}
+}
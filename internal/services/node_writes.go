@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The writes below are the building blocks of node mutations; inside RunNodeMutation
+// each one records its undo.
+
+// InsertNode inserts a new node
+func InsertNode(ctx context.Context, node *models.Node) error {
+	nodesCollection := GetNodesCollection()
+	if err := recordUndo(ctx, undoDelete(nodesCollection, bson.M{"_id": node.ID})); err != nil {
+		return err
+	}
+	if _, err := nodesCollection.InsertOne(ctx, node); err != nil {
+		return fmt.Errorf("failed to insert node: %w", err)
+	}
+	return nil
+}
+
+// AddNodeChild adds childID to the children of the owner's node parentID
+func AddNodeChild(ctx context.Context, parentID, ownerID, childID string) error {
+	return updateNodeChildren(ctx, parentID, ownerID, childID, "$addToSet", "$pull")
+}
+
+// RemoveNodeChild removes childID from the children of the owner's node parentID
+func RemoveNodeChild(ctx context.Context, parentID, ownerID, childID string) error {
+	return updateNodeChildren(ctx, parentID, ownerID, childID, "$pull", "$addToSet")
+}
+
+// updateNodeChildren applies op to a parent's children and records the inverse op
+func updateNodeChildren(ctx context.Context, parentID, ownerID, childID, op, inverse string) error {
+	if parentID == "" {
+		return nil
+	}
+	nodesCollection := GetNodesCollection()
+	filter := NodeIDFilter(parentID)
+	filter["ownerId"] = ownerID
+
+	if err := recordUndo(ctx, undoUpdate(nodesCollection, filter, bson.M{inverse: bson.M{"children": childID}})); err != nil {
+		return err
+	}
+	update := bson.M{op: bson.M{"children": childID}, "$set": bson.M{"updatedAt": time.Now()}}
+	if _, err := nodesCollection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update children of %s: %w", parentID, err)
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"log"
+	"maps"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/image/draw"
+)
+
+// NearDuplicateDistance is the most bits two perceptual hashes may differ by for their
+// images to count as the same scan (a re-photographed or recompressed page)
+const NearDuplicateDistance = 6
+
+// minPerceptualHashBits is how many bits a perceptual hash needs set to be compared
+const minPerceptualHashBits = 4
+
+// Duplicate group matches
+const (
+	DuplicateMatchExact   = "exact"   // Notes share identical uploads
+	DuplicateMatchSimilar = "similar" // Notes have pages that look the same
+)
+
+// DuplicateNote is a note in a DuplicateGroup
+type DuplicateNote struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  string    `json:"parentId"`
+	Pages     int       `json:"pages"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DuplicateGroup is a set of notes that are likely copies of each other, oldest first
+type DuplicateGroup struct {
+	Match string          `json:"match"`
+	Notes []DuplicateNote `json:"notes"`
+}
+
+// ImageSHA256 returns the hex SHA-256 of an upload
+func ImageSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// PerceptualHash returns the difference hash of an image: 64 bits, one per pair of
+// horizontally adjacent cells of a 9x8 greyscale thumbnail, set where the left cell
+// is brighter. Rescaling, recompression and small tone changes leave it nearly intact.
+func PerceptualHash(img image.Image) string {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Rect, img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parsePerceptualHash reads a hash made by PerceptualHash
+func parsePerceptualHash(s string) (uint64, bool) {
+	if len(s) != 16 {
+		return 0, false
+	}
+	hash, err := strconv.ParseUint(s, 16, 64)
+	return hash, err == nil
+}
+
+// FindPageByHash returns a page of one of the owner's live notes whose upload had the
+// given SHA-256, or nil if there is none
+func FindPageByHash(ctx context.Context, ownerID, sha string) (*models.NotePage, error) {
+	filter := ExcludeTrashed(bson.M{"ownerId": ownerID, "pages.sha256": sha})
+	opts := options.FindOne().SetProjection(bson.M{"pages.$": 1})
+	var node models.Node
+	if err := GetNodesCollection().FindOne(ctx, filter, opts).Decode(&node); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to look up duplicate upload: %w", err)
+	}
+	if len(node.Pages) == 0 {
+		return nil, nil
+	}
+	return &node.Pages[0], nil
+}
+
+// pageWithHash returns the first of pages whose upload had the given SHA-256, or nil
+func pageWithHash(pages []models.NotePage, sha string) *models.NotePage {
+	for i := range pages {
+		if pages[i].SHA256 == sha {
+			return &pages[i]
+		}
+	}
+	return nil
+}
+
+// reuseNotePage returns a new page sharing the blobs of an identical earlier upload,
+// and its transcription when that is done. The blob sizes stay on the earlier page
+// alone, so the shared blobs count against the quota once; ReleaseNotePageBlobs hands
+// them over when that page goes.
+func reuseNotePage(src *models.NotePage) models.NotePage {
+	page := NewNotePage(src.DriveID)
+	page.OriginalDriveID = src.OriginalDriveID
+	page.Thumbnails = src.Thumbnails
+	page.SHA256, page.PHash = src.SHA256, src.PHash
+	if src.CaptionStatus == models.CaptionStatusCompleted {
+		page.Caption, page.Transcription, page.CaptionStatus = src.Caption, src.Transcription, models.CaptionStatusCompleted
+	}
+	return page
+}
+
+// UncaptionedPages returns the pages that still need transcribing
+func UncaptionedPages(pages []models.NotePage) []models.NotePage {
+	var todo []models.NotePage
+	for _, page := range pages {
+		if page.CaptionStatus != models.CaptionStatusCompleted {
+			todo = append(todo, page)
+		}
+	}
+	return todo
+}
+
+// ReleaseNotePageBlobs deletes the blobs of pages that no page of the owner's notes
+// still uses; pages re-uploaded unchanged share them. Notes matching ignore (those
+// being deleted along with the pages) do not count as users. The recorded size of a
+// blob still in use moves to a page using it, so it stays charged once. It returns
+// the bytes the pages no longer account for, to take off their folder's usage.
+func ReleaseNotePageBlobs(ctx context.Context, ownerID string, pages []models.NotePage, ignore bson.M) int64 {
+	var blobs []pageBlob
+	var ids []string
+	for _, page := range pages {
+		for _, blob := range notePageBlobs(page) {
+			blobs = append(blobs, blob)
+			ids = append(ids, blob.id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0
+	}
+
+	shared, err := referencedBlobs(ctx, ownerID, ids, ignore)
+	if err != nil {
+		// Better to leave blobs behind than delete one still shown
+		log.Printf("[NotePages] Not deleting %d blobs: %v", len(ids), err)
+		return 0
+	}
+	var released int64
+	deleted := make(map[string]bool)
+	for _, blob := range blobs {
+		if shared[blob.id] {
+			if blob.size > 0 && handOverBlobSize(ctx, ownerID, blob, ignore) {
+				released += blob.size
+			}
+			continue
+		}
+		released += blob.size
+		if deleted[blob.id] {
+			continue
+		}
+		deleted[blob.id] = true
+		if err := DeleteBlob(ctx, blob.id); err != nil {
+			log.Printf("[NotePages] Failed to delete unused upload %s: %v", blob.id, err)
+		}
+	}
+	return released
+}
+
+// pageBlob is a blob of a page, the page fields holding its ID and recorded size, and
+// that size
+type pageBlob struct {
+	id, field, sizeField string
+	size                 int64
+}
+
+// notePageBlobs lists the blobs of a page the way NotePageBlobIDs does, with sizes
+func notePageBlobs(page models.NotePage) []pageBlob {
+	var blobs []pageBlob
+	if page.DriveID != "" {
+		blobs = append(blobs, pageBlob{page.DriveID, "driveId", "imageBytes", page.ImageBytes})
+	}
+	if page.OriginalDriveID != "" {
+		blobs = append(blobs, pageBlob{page.OriginalDriveID, "originalDriveId", "originalBytes", page.OriginalBytes})
+	}
+	for _, size := range slices.Sorted(maps.Keys(page.Thumbnails)) {
+		blobs = append(blobs, pageBlob{page.Thumbnails[size], "thumbnails." + size, "thumbnailBytes." + size, page.ThumbnailBytes[size]})
+	}
+	return blobs
+}
+
+// handOverBlobSize records a shared blob's size on a page of the owner's notes that
+// uses it without a size of its own, and charges that note's folder. It reports
+// whether there was such a page; if not, the size stays charged where it was.
+func handOverBlobSize(ctx context.Context, ownerID string, blob pageBlob, ignore bson.M) bool {
+	filter := bson.M{"ownerId": ownerID, "pages": bson.M{"$elemMatch": bson.M{
+		blob.field: blob.id, blob.sizeField: bson.M{"$in": bson.A{nil, 0}},
+	}}}
+	if ignore != nil {
+		filter["$nor"] = bson.A{ignore}
+	}
+	update := bson.M{"$set": bson.M{"pages.$." + blob.sizeField: blob.size}}
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"_id": 1, "parentId": 1})
+	var node models.Node
+	if err := GetNodesCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&node); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("[NotePages] Failed to hand over the size of shared upload %s: %v", blob.id, err)
+		}
+		return false
+	}
+	logStorageChange(ctx, ownerID, node.ParentID, blob.size)
+	return true
+}
+
+// referencedBlobs returns which of the given blobs the owner's notes use, or any
+// user's when ownerID is empty
+func referencedBlobs(ctx context.Context, ownerID string, ids []string, ignore bson.M) (map[string]bool, error) {
+	in := bson.M{"$in": ids}
+	refs := bson.A{bson.M{"metadata.driveId": in}, bson.M{"pages.driveId": in}, bson.M{"pages.originalDriveId": in}}
+	for size := range ThumbnailSizes {
+		refs = append(refs, bson.M{"pages.thumbnails." + size: in})
+	}
+	filter := bson.M{"$or": refs}
+	if ownerID != "" {
+		filter["ownerId"] = ownerID
+	}
+	if ignore != nil {
+		filter["$nor"] = bson.A{ignore}
+	}
+
+	opts := options.Find().SetProjection(bson.M{"metadata.driveId": 1, "pages": 1})
+	cursor, err := GetNodesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up blob references: %w", err)
+	}
+	var nodes []models.Node
+	if err := cursor.All(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to decode blob references: %w", err)
+	}
+
+	used := make(map[string]bool)
+	for _, node := range nodes {
+		used[node.Metadata.DriveID] = true
+		for _, page := range node.Pages {
+			for _, id := range NotePageBlobIDs(page) {
+				used[id] = true
+			}
+		}
+	}
+	return used, nil
+}
+
+// FindDuplicateNotes lists groups of the owner's live notes that share identical
+// uploads or have pages that look alike
+func FindDuplicateNotes(ctx context.Context, ownerID string) ([]DuplicateGroup, error) {
+	filter := ExcludeTrashed(bson.M{"ownerId": ownerID, "metadata.type": models.NodeTypeNote})
+	opts := options.Find().SetProjection(bson.M{
+		"_id": 1, "name": 1, "parentId": 1, "createdAt": 1, "pages.id": 1, "pages.sha256": 1, "pages.phash": 1,
+	})
+	cursor, err := GetNodesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notes: %w", err)
+	}
+	var notes []models.Node
+	if err := cursor.All(ctx, &notes); err != nil {
+		return nil, fmt.Errorf("failed to decode notes: %w", err)
+	}
+	return groupDuplicateNotes(notes, NearDuplicateDistance), nil
+}
+
+// groupDuplicateNotes links notes with a page of the same SHA-256, or perceptual hashes
+// at most maxDistance apart, and returns the linked groups. A group is exact when
+// identical uploads alone connect it.
+func groupDuplicateNotes(notes []models.Node, maxDistance int) []DuplicateGroup {
+	type hashedPage struct {
+		note  int
+		phash uint64
+	}
+
+	exact := newUnionFind(len(notes))
+	linked := newUnionFind(len(notes))
+	firstWithSHA := make(map[string]int)
+	var hashed []hashedPage
+	for i, note := range notes {
+		for _, page := range note.Pages {
+			if page.SHA256 != "" {
+				if j, ok := firstWithSHA[page.SHA256]; ok {
+					exact.union(i, j)
+					linked.union(i, j)
+				} else {
+					firstWithSHA[page.SHA256] = i
+				}
+			}
+			// Nearly featureless pages (blank sheets) all hash alike
+			if phash, ok := parsePerceptualHash(page.PHash); ok && bits.OnesCount64(phash) >= minPerceptualHashBits {
+				hashed = append(hashed, hashedPage{note: i, phash: phash})
+			}
+		}
+	}
+	for a := range hashed {
+		for b := a + 1; b < len(hashed); b++ {
+			if hashed[a].note == hashed[b].note {
+				continue
+			}
+			if bits.OnesCount64(hashed[a].phash^hashed[b].phash) <= maxDistance {
+				linked.union(hashed[a].note, hashed[b].note)
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range notes {
+		root := linked.find(i)
+		members[root] = append(members[root], i)
+	}
+	var groups []DuplicateGroup
+	for _, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		match := DuplicateMatchExact
+		duplicates := make([]DuplicateNote, 0, len(group))
+		for _, i := range group {
+			if exact.find(i) != exact.find(group[0]) {
+				match = DuplicateMatchSimilar
+			}
+			note := notes[i]
+			duplicates = append(duplicates, DuplicateNote{
+				ID: note.ID.Hex(), Name: note.Name, ParentID: note.ParentID, Pages: len(note.Pages), CreatedAt: note.CreatedAt,
+			})
+		}
+		slices.SortFunc(duplicates, compareDuplicateNotes)
+		groups = append(groups, DuplicateGroup{Match: match, Notes: duplicates})
+	}
+	slices.SortFunc(groups, func(a, b DuplicateGroup) int { return compareDuplicateNotes(a.Notes[0], b.Notes[0]) })
+	return groups
+}
+
+// compareDuplicateNotes orders notes oldest first
+func compareDuplicateNotes(a, b DuplicateNote) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// unionFind is a disjoint-set forest over 0..n-1
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	u := make(unionFind, n)
+	for i := range u {
+		u[i] = i
+	}
+	return u
+}
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(a, b int) {
+	u[u.find(a)] = u.find(b)
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/bits"
+	"slices"
+	"testing"
+	"time"
+
+	"cogniscan/backend/internal/models"
+)
+
+// testScan draws a page of ruled "handwriting" whose strokes depend on seed
+func testScan(w, h int, seed int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(235)
+			line := y * 12 / h
+			if (x*7/w+line*seed)%5 < 2 && (y*24/h)%2 == 0 {
+				v = 40
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func perceptualDistance(t *testing.T, a, b string) int {
+	t.Helper()
+	x, okA := parsePerceptualHash(a)
+	y, okB := parsePerceptualHash(b)
+	if !okA || !okB {
+		t.Fatalf("invalid hashes %q, %q", a, b)
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := testScan(800, 1000, 3)
+	hash := PerceptualHash(original)
+	if len(hash) != 16 {
+		t.Fatalf("PerceptualHash() = %q, want 16 hex digits", hash)
+	}
+
+	// The same page, smaller and recompressed
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flattenAndFit(original, 300), &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := perceptualDistance(t, hash, PerceptualHash(recompressed)); d > NearDuplicateDistance {
+		t.Errorf("distance to recompressed copy = %d, want at most %d", d, NearDuplicateDistance)
+	}
+
+	if d := perceptualDistance(t, hash, PerceptualHash(testScan(800, 1000, 4))); d <= NearDuplicateDistance {
+		t.Errorf("distance to another page = %d, want more than %d", d, NearDuplicateDistance)
+	}
+}
+
+func TestGroupDuplicateNotes(t *testing.T) {
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	note := func(n byte, age int, pages ...models.NotePage) models.Node {
+		node := testNode(n, models.NodeTypeNote, "")
+		node.CreatedAt = day.Add(time.Duration(age) * time.Hour)
+		node.Pages = pages
+		return node
+	}
+	// 1 and 3 share an upload; 4 and 5 have pages one bit apart; 6 is blank like 7;
+	// 2 stands alone
+	notes := []models.Node{
+		note(3, 1, models.NotePage{SHA256: "aa", PHash: "f0f0f0f0f0f0f0f0"}),
+		note(1, 0, models.NotePage{SHA256: "bb"}, models.NotePage{SHA256: "aa"}),
+		note(2, 2, models.NotePage{SHA256: "cc", PHash: "0123456789abcdef"}),
+		note(5, 4, models.NotePage{SHA256: "dd", PHash: "ffff0000ffff0001"}),
+		note(4, 3, models.NotePage{SHA256: "ee", PHash: "ffff0000ffff0000"}),
+		note(6, 5, models.NotePage{SHA256: "ff", PHash: "0000000000000000"}),
+		note(7, 6, models.NotePage{SHA256: "gg", PHash: "0000000000000001"}),
+	}
+
+	groups := groupDuplicateNotes(notes, NearDuplicateDistance)
+	if len(groups) != 2 {
+		t.Fatalf("groupDuplicateNotes() = %+v, want 2 groups", groups)
+	}
+	want := []struct {
+		match string
+		ids   []string
+	}{
+		{DuplicateMatchExact, []string{hexID(1), hexID(3)}},
+		{DuplicateMatchSimilar, []string{hexID(4), hexID(5)}},
+	}
+	for i, w := range want {
+		g := groups[i]
+		if g.Match != w.match || len(g.Notes) != len(w.ids) {
+			t.Errorf("group %d = %+v, want %s %v", i, g, w.match, w.ids)
+			continue
+		}
+		for j, id := range w.ids {
+			if g.Notes[j].ID != id {
+				t.Errorf("group %d note %d = %s, want %s", i, j, g.Notes[j].ID, id)
+			}
+		}
+	}
+	if groups[0].Notes[0].Pages != 2 {
+		t.Errorf("pages = %d, want 2", groups[0].Notes[0].Pages)
+	}
+}
+
+func TestReuseNotePage(t *testing.T) {
+	src := &models.NotePage{
+		ID: "p1", DriveID: "img", OriginalDriveID: "orig", Thumbnails: map[string]string{"small": "thumb"},
+		ImageBytes: 10, SHA256: "aa", PHash: "f0f0f0f0f0f0f0f0", Caption: "Mitosis", CaptionStatus: models.CaptionStatusCompleted,
+	}
+	page := reuseNotePage(src)
+	if page.ID == "" || page.ID == src.ID {
+		t.Errorf("ID = %q, want a new page ID", page.ID)
+	}
+	if page.DriveID != "img" || page.OriginalDriveID != "orig" || page.Thumbnails["small"] != "thumb" {
+		t.Errorf("page = %+v, want the source's blobs", page)
+	}
+	if NotePageBytes(page) != 0 {
+		t.Errorf("NotePageBytes() = %d, want 0 so the shared blobs count once", NotePageBytes(page))
+	}
+	if page.Caption != "Mitosis" || page.CaptionStatus != models.CaptionStatusCompleted {
+		t.Errorf("caption = %q (%s), want the source's transcription", page.Caption, page.CaptionStatus)
+	}
+	if len(UncaptionedPages([]models.NotePage{page, {ID: "p2"}})) != 1 {
+		t.Error("UncaptionedPages() should leave out the transcribed page")
+	}
+
+	src.CaptionStatus, src.Caption = models.CaptionStatusPending, ""
+	if page := reuseNotePage(src); page.CaptionStatus == models.CaptionStatusCompleted {
+		t.Errorf("caption status = %s, want the page transcribed again", page.CaptionStatus)
+	}
+}
+
+func TestStoreNoteImageReusesBatch(t *testing.T) {
+	data := []byte("the same scan twice in one upload")
+	batch := []models.NotePage{
+		{ID: "p1", DriveID: "other", SHA256: ImageSHA256([]byte("another scan"))},
+		{ID: "p2", DriveID: "img", OriginalDriveID: "orig", ImageBytes: 10, OriginalBytes: 20, SHA256: ImageSHA256(data)},
+	}
+	page, err := StoreNoteImage(context.Background(), "a@example.com", "scan.jpg", data, batch)
+	if err != nil {
+		t.Fatalf("StoreNoteImage() error = %v", err)
+	}
+	if page.ID == "p2" || page.DriveID != "img" || page.OriginalDriveID != "orig" {
+		t.Errorf("page = %+v, want a new page sharing p2's blobs", page)
+	}
+	if NotePageBytes(page) != 0 {
+		t.Errorf("NotePageBytes() = %d, want 0 so the shared blobs count once", NotePageBytes(page))
+	}
+}
+
+func TestNotePageBlobs(t *testing.T) {
+	page := models.NotePage{
+		DriveID: "img", OriginalDriveID: "orig", ImageBytes: 10, OriginalBytes: 20,
+		Thumbnails: map[string]string{"small": "s", "medium": "m"}, ThumbnailBytes: map[string]int64{"small": 3},
+	}
+	want := []pageBlob{
+		{"img", "driveId", "imageBytes", 10},
+		{"orig", "originalDriveId", "originalBytes", 20},
+		{"m", "thumbnails.medium", "thumbnailBytes.medium", 0},
+		{"s", "thumbnails.small", "thumbnailBytes.small", 3},
+	}
+	got := notePageBlobs(page)
+	if !slices.Equal(got, want) {
+		t.Errorf("notePageBlobs() = %+v, want %+v", got, want)
+	}
+	var ids []string
+	for _, blob := range got {
+		ids = append(ids, blob.id)
+	}
+	if !slices.Equal(ids, NotePageBlobIDs(page)) {
+		t.Errorf("notePageBlobs() IDs = %v, want NotePageBlobIDs() %v", ids, NotePageBlobIDs(page))
+	}
+}
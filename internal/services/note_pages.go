@@ -0,0 +1,534 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cogniscan/backend/internal/models"
+	"cogniscan/backend/internal/queue"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxNotePages is the most pages a note can have
+const MaxNotePages = 50
+
+// notePageSeparator goes between the captions of consecutive pages
+const notePageSeparator = "\n\n"
+
+var (
+	ErrNotANote         = errors.New("node is not a note")
+	ErrPageNotFound     = errors.New("page not found")
+	ErrTooManyPages     = fmt.Errorf("a note can have at most %d pages", MaxNotePages)
+	ErrInvalidPageOrder = errors.New("pageIds must list every page of the note exactly once")
+	ErrLastPage         = errors.New("cannot remove the only page of a note; delete the note instead")
+)
+
+// NewNotePage returns the page for a freshly stored image, pending transcription
+// when the caption queue is running
+func NewNotePage(driveID string) models.NotePage {
+	page := models.NotePage{
+		ID:        primitive.NewObjectID().Hex(),
+		DriveID:   driveID,
+		CreatedAt: time.Now(),
+	}
+	if IsQueueServiceInitialized() {
+		page.CaptionStatus = models.CaptionStatusPending
+	}
+	return page
+}
+
+// StoreNoteImage preprocesses an uploaded image and stores both the upload as received
+// and the normalised image, returning the new page. name is the uploaded file name. An
+// image the owner uploaded before, or that is among batch (the pages stored earlier in
+// the same upload), is not stored again: the page shares the earlier upload's blobs
+// and transcription.
+func StoreNoteImage(ctx context.Context, ownerID, name string, data []byte, batch []models.NotePage) (models.NotePage, error) {
+	sha := ImageSHA256(data)
+	existing := pageWithHash(batch, sha)
+	if existing == nil {
+		var err error
+		if existing, err = FindPageByHash(ctx, ownerID, sha); err != nil {
+			return models.NotePage{}, err
+		}
+	}
+	if existing != nil {
+		log.Printf("[NotePages] %s is a re-upload; reusing its stored image", name)
+		return reuseNotePage(existing), nil
+	}
+
+	prepared, err := PreprocessImage(data)
+	if err != nil {
+		return models.NotePage{}, err
+	}
+
+	originalID, err := UploadBlob(ctx, name, bytes.NewReader(data))
+	if err != nil {
+		return models.NotePage{}, fmt.Errorf("failed to upload original: %w", err)
+	}
+	page, err := storePageImage(ctx, strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)), prepared)
+	if err != nil {
+		DeleteNotePageBlobs([]models.NotePage{{OriginalDriveID: originalID}})
+		return models.NotePage{}, err
+	}
+	page.OriginalDriveID = originalID
+	page.OriginalBytes = int64(len(data))
+	page.SHA256 = sha
+	return page, nil
+}
+
+// storePageImage stores a preprocessed image and its thumbnails as a new page
+func storePageImage(ctx context.Context, base string, prepared *PreparedImage) (models.NotePage, error) {
+	driveID, err := UploadBlob(ctx, base+".jpg", bytes.NewReader(prepared.Data))
+	if err != nil {
+		return models.NotePage{}, fmt.Errorf("failed to upload image: %w", err)
+	}
+	thumbnails, thumbnailBytes, err := storeThumbnails(ctx, base, prepared.Image)
+	if err != nil {
+		DeleteNotePageBlobs([]models.NotePage{{DriveID: driveID}})
+		return models.NotePage{}, err
+	}
+
+	page := NewNotePage(driveID)
+	page.ImageBytes = int64(len(prepared.Data))
+	page.Thumbnails = thumbnails
+	page.ThumbnailBytes = thumbnailBytes
+	page.PHash = PerceptualHash(prepared.Image)
+	return page, nil
+}
+
+// NotePageBlobIDs returns the blobs a page keeps: its image, the original upload and
+// the thumbnails
+func NotePageBlobIDs(page models.NotePage) []string {
+	var ids []string
+	for _, id := range []string{page.DriveID, page.OriginalDriveID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	for _, size := range slices.Sorted(maps.Keys(page.Thumbnails)) {
+		ids = append(ids, page.Thumbnails[size])
+	}
+	return ids
+}
+
+// NotePages returns a note's pages in order. A note from before pages existed reads
+// as a single page without an ID.
+func NotePages(node *models.Node) []models.NotePage {
+	if len(node.Pages) > 0 || node.Metadata.DriveID == "" {
+		return node.Pages
+	}
+	return []models.NotePage{{
+		DriveID:       node.Metadata.DriveID,
+		Transcription: node.Transcription,
+		CaptionStatus: node.CaptionStatus,
+		CaptionError:  node.CaptionError,
+		CreatedAt:     node.CreatedAt,
+	}}
+}
+
+// NotePageAt returns the nth (1-based) page of a note
+func NotePageAt(node *models.Node, n int) (*models.NotePage, error) {
+	pages := NotePages(node)
+	if n < 1 || n > len(pages) {
+		return nil, ErrPageNotFound
+	}
+	return &pages[n-1], nil
+}
+
+// NotePagesCaptioned reports whether every page of a note has been transcribed
+func NotePagesCaptioned(node *models.Node) bool {
+	for _, page := range NotePages(node) {
+		if page.CaptionStatus != models.CaptionStatusCompleted {
+			return false
+		}
+	}
+	return true
+}
+
+// FirstPageDriveID returns the blob of a note's first page that has an image, which
+// metadata.driveId mirrors
+func FirstPageDriveID(pages []models.NotePage) string {
+	for _, page := range pages {
+		if page.DriveID != "" {
+			return page.DriveID
+		}
+	}
+	return ""
+}
+
+// AssembleNoteCaption joins the captions of a note's pages in page order and returns
+// the rune offset where each page starts. Pages without a caption take up no text.
+// A single page has no page starts.
+func AssembleNoteCaption(pages []models.NotePage) (string, []int) {
+	if len(pages) == 1 {
+		return strings.TrimSpace(pages[0].Caption), nil
+	}
+
+	var b strings.Builder
+	starts := make([]int, len(pages))
+	length := 0
+	for i, page := range pages {
+		text := strings.TrimSpace(page.Caption)
+		if text != "" && length > 0 {
+			b.WriteString(notePageSeparator)
+			length += utf8.RuneCountInString(notePageSeparator)
+		}
+		starts[i] = length
+		b.WriteString(text)
+		length += utf8.RuneCountInString(text)
+	}
+	if len(starts) == 0 {
+		starts = nil
+	}
+	return b.String(), starts
+}
+
+// GetOwnedNote returns one of the owner's notes that is not in the trash
+func GetOwnedNote(ctx context.Context, noteID, ownerID string) (*models.Node, error) {
+	node, err := getOwnedLiveNode(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if node.Metadata.Type != models.NodeTypeNote {
+		return nil, ErrNotANote
+	}
+	return node, nil
+}
+
+// ensureNotePages gives a note from before pages existed its single page, carrying
+// over the caption already stored for it, so the page can be addressed by ID
+func ensureNotePages(ctx context.Context, node *models.Node) (*models.Node, error) {
+	if len(node.Pages) > 0 || node.Metadata.DriveID == "" {
+		return node, nil
+	}
+
+	page := NotePages(node)[0]
+	page.ID = primitive.NewObjectID().Hex()
+	embedding, err := GetCaptionEmbedding(node.ID.Hex())
+	if err == nil {
+		page.Caption = embedding.Caption
+	} else if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to fetch caption: %w", err)
+	}
+
+	// Another request may convert the note first; either way it ends up with one page
+	filter := bson.M{"_id": node.ID, "pages.0": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"pages": []models.NotePage{page}}}
+	if _, err := GetNodesCollection().UpdateOne(ctx, filter, update); err != nil {
+		return nil, fmt.Errorf("failed to convert note to pages: %w", err)
+	}
+	return GetOwnedNote(ctx, node.ID.Hex(), node.OwnerID)
+}
+
+// AppendNotePages adds pages to the end of one of the owner's notes and returns the
+// updated note
+func AppendNotePages(ctx context.Context, noteID, ownerID string, pages []models.NotePage) (*models.Node, error) {
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages to append")
+	}
+	if len(pages) > MaxNotePages {
+		return nil, ErrTooManyPages
+	}
+
+	node, err := GetOwnedNote(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if node, err = ensureNotePages(ctx, node); err != nil {
+		return nil, err
+	}
+
+	filter := ExcludeTrashed(bson.M{"_id": node.ID, "ownerId": ownerID})
+	// The size check keeps concurrent appends from going over the limit
+	filter[fmt.Sprintf("pages.%d", MaxNotePages-len(pages))] = bson.M{"$exists": false}
+
+	set := bson.M{"updatedAt": time.Now()}
+	if node.Metadata.DriveID == "" && FirstPageDriveID(pages) != "" {
+		set["metadata.driveId"] = FirstPageDriveID(pages)
+	}
+	if IsQueueServiceInitialized() && !NotePagesCaptioned(&models.Node{Pages: pages}) {
+		set["captionStatus"] = models.CaptionStatusPending
+	}
+	update := bson.M{
+		"$push": bson.M{"pages": bson.M{"$each": pages}},
+		"$set":  set,
+	}
+
+	var updated models.Node
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := GetNodesCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			// Tell a full note apart from one deleted meanwhile
+			if _, lookupErr := GetOwnedNote(ctx, noteID, ownerID); lookupErr != nil {
+				return nil, lookupErr
+			}
+			return nil, ErrTooManyPages
+		}
+		return nil, fmt.Errorf("failed to append pages: %w", err)
+	}
+	return &updated, nil
+}
+
+// RemoveNotePage removes a page from one of the owner's notes. It returns the updated
+// note and the removed page, whose blob the caller deletes.
+func RemoveNotePage(ctx context.Context, noteID, ownerID, pageID string) (*models.Node, *models.NotePage, error) {
+	node, err := GetOwnedNote(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if node, err = ensureNotePages(ctx, node); err != nil {
+		return nil, nil, err
+	}
+
+	filter := ExcludeTrashed(bson.M{
+		"_id":      node.ID,
+		"ownerId":  ownerID,
+		"pages.id": pageID,
+		"pages.1":  bson.M{"$exists": true},
+	})
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"pages": bson.M{"$filter": bson.M{
+				"input": "$pages",
+				"cond":  bson.M{"$ne": bson.A{"$$this.id", pageID}},
+			}},
+			"updatedAt": time.Now(),
+		}}},
+		notePagesSummaryStage(),
+	}
+
+	// Read the note as it was before the write to learn the removed page's blob
+	var note models.Node
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	if err := GetNodesCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&note); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, removePageFailure(ctx, noteID, ownerID, pageID)
+		}
+		return nil, nil, fmt.Errorf("failed to remove page: %w", err)
+	}
+
+	var removed models.NotePage
+	pages := make([]models.NotePage, 0, len(note.Pages)-1)
+	for _, page := range note.Pages {
+		if page.ID == pageID {
+			removed = page
+		} else {
+			pages = append(pages, page)
+		}
+	}
+	note.Pages = pages
+	note.Metadata.DriveID = FirstPageDriveID(pages)
+	if NotePagesCaptioned(&note) {
+		note.CaptionStatus = models.CaptionStatusCompleted
+	}
+	note.UpdatedAt = time.Now()
+	return &note, &removed, nil
+}
+
+// removePageFailure explains why a page could not be removed
+func removePageFailure(ctx context.Context, noteID, ownerID, pageID string) error {
+	node, err := GetOwnedNote(ctx, noteID, ownerID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(node.Pages, func(p models.NotePage) bool { return p.ID == pageID }) {
+		return ErrPageNotFound
+	}
+	return ErrLastPage
+}
+
+// ReorderNotePages puts the pages of one of the owner's notes in the given order,
+// which must list every page exactly once, and returns the updated note
+func ReorderNotePages(ctx context.Context, noteID, ownerID string, pageIDs []string) (*models.Node, error) {
+	seen := make(map[string]bool, len(pageIDs))
+	for _, id := range pageIDs {
+		if seen[id] {
+			return nil, ErrInvalidPageOrder
+		}
+		seen[id] = true
+	}
+
+	node, err := GetOwnedNote(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if node, err = ensureNotePages(ctx, node); err != nil {
+		return nil, err
+	}
+
+	// Reorder server-side so transcriptions written meanwhile are kept
+	filter := ExcludeTrashed(bson.M{
+		"_id":     node.ID,
+		"ownerId": ownerID,
+		"$expr": bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$pages", bson.A{}}}}, len(pageIDs)}},
+			bson.M{"$setEquals": bson.A{bson.M{"$ifNull": bson.A{"$pages.id", bson.A{}}}, pageIDs}},
+		}},
+	})
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"pages": bson.M{"$map": bson.M{
+				"input": pageIDs,
+				"as":    "pageId",
+				"in": bson.M{"$arrayElemAt": bson.A{
+					bson.M{"$filter": bson.M{"input": "$pages", "cond": bson.M{"$eq": bson.A{"$$this.id", "$$pageId"}}}},
+					0,
+				}},
+			}},
+			"updatedAt": time.Now(),
+		}}},
+		notePagesSummaryStage(),
+	}
+
+	var updated models.Node
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := GetNodesCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			if _, lookupErr := GetOwnedNote(ctx, noteID, ownerID); lookupErr != nil {
+				return nil, lookupErr
+			}
+			return nil, ErrInvalidPageOrder
+		}
+		return nil, fmt.Errorf("failed to reorder pages: %w", err)
+	}
+	return &updated, nil
+}
+
+// notePagesSummaryStage points metadata.driveId at the first page image's blob and
+// marks the note's caption completed once every remaining page is transcribed
+func notePagesSummaryStage() bson.D {
+	allCaptioned := bson.M{"$allElementsTrue": bson.A{bson.M{"$map": bson.M{
+		"input": "$pages",
+		"in":    bson.M{"$eq": bson.A{"$$this.captionStatus", models.CaptionStatusCompleted}},
+	}}}}
+	return bson.D{{Key: "$set", Value: bson.M{
+		"metadata.driveId": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$pages.driveId", 0}}, "$$REMOVE"}},
+		"captionStatus":    bson.M{"$cond": bson.A{allCaptioned, models.CaptionStatusCompleted, "$captionStatus"}},
+	}}}
+}
+
+// SetNotePageCaption stores a page's transcription and its plain-text caption and
+// returns the note after the write
+func SetNotePageCaption(ctx context.Context, noteID, pageID, caption string, transcription *models.Transcription) (*models.Node, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"pages.$.caption":       caption,
+			"pages.$.transcription": transcription,
+			"pages.$.captionStatus": models.CaptionStatusCompleted,
+		},
+		"$unset": bson.M{"pages.$.captionError": ""},
+	}
+	return updateNotePage(ctx, noteID, pageID, update)
+}
+
+// SetNotePageStatus records the caption status of a page and returns the note after the write
+func SetNotePageStatus(ctx context.Context, noteID, pageID string, status models.CaptionStatus, errorMsg string) (*models.Node, error) {
+	update := bson.M{"$set": bson.M{"pages.$.captionStatus": status}}
+	if errorMsg != "" {
+		update["$set"].(bson.M)["pages.$.captionError"] = errorMsg
+	} else {
+		update["$unset"] = bson.M{"pages.$.captionError": ""}
+	}
+	return updateNotePage(ctx, noteID, pageID, update)
+}
+
+// updateNotePage applies an update to one page of a note
+func updateNotePage(ctx context.Context, noteID, pageID string, update bson.M) (*models.Node, error) {
+	filter := NodeIDFilter(noteID)
+	filter["pages.id"] = pageID
+
+	var node models.Node
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := GetNodesCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&node); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPageNotFound
+		}
+		return nil, fmt.Errorf("failed to update page: %w", err)
+	}
+	return &node, nil
+}
+
+// EmbedNoteCaption assembles a note's caption from its pages and embeds it. Pages
+// transcribed concurrently can finish in either order, so if the pages changed while
+// embedding, the newer caption is restaged for the re-embed worker.
+func EmbedNoteCaption(ctx context.Context, node *models.Node) error {
+	caption, starts := AssembleNoteCaption(node.Pages)
+	if strings.TrimSpace(caption) == "" {
+		return nil
+	}
+	if err := EmbedAndStoreCaption(node.ID.Hex(), node.ParentID, node.OwnerID, caption, starts); err != nil {
+		return err
+	}
+
+	latest, err := GetNodeByID(ctx, node.ID.Hex())
+	if err != nil {
+		if err == ErrNodeNotFound {
+			return nil
+		}
+		return err
+	}
+	latestCaption, latestStarts := AssembleNoteCaption(latest.Pages)
+	if latestCaption == caption && slices.Equal(latestStarts, starts) {
+		return nil
+	}
+	return RestageCaption(ctx, node.ID.Hex(), latest.ParentID, latest.OwnerID, latestCaption, latestStarts)
+}
+
+// RefreshNoteCaption brings a note's stored caption in line with its pages after they
+// were reordered or removed. The re-embed worker embeds the new caption.
+func RefreshNoteCaption(ctx context.Context, node *models.Node) error {
+	caption, starts := AssembleNoteCaption(node.Pages)
+	if strings.TrimSpace(caption) == "" {
+		return DeleteCaptionEmbedding(node.ID.Hex())
+	}
+	return RestageCaption(ctx, node.ID.Hex(), node.ParentID, node.OwnerID, caption, starts)
+}
+
+// EnqueuePageCaptionJobs queues transcription of pages of a note and returns the job
+// IDs. Pages without an image are skipped. The page of a note from before pages
+// existed is queued without a page ID.
+func EnqueuePageCaptionJobs(node *models.Node, pages []models.NotePage) ([]string, error) {
+	jobIDs := make([]string, 0, len(pages))
+	for _, page := range pages {
+		if page.DriveID == "" {
+			continue
+		}
+		job := queue.CaptionJob{
+			ID:      primitive.NewObjectID().Hex(),
+			NoteID:  node.ID.Hex(),
+			DriveID: page.DriveID,
+			OwnerID: node.OwnerID,
+			PageID:  page.ID,
+		}
+		if err := EnqueueCaptionJob(job); err != nil {
+			return jobIDs, err
+		}
+		jobIDs = append(jobIDs, job.ID)
+	}
+	return jobIDs, nil
+}
+
+// DeleteNotePageBlobs deletes the blobs of pages just stored and never saved on a note.
+// Pages that may share blobs with other notes go through ReleaseNotePageBlobs.
+func DeleteNotePageBlobs(pages []models.NotePage) {
+	for _, page := range pages {
+		for _, id := range NotePageBlobIDs(page) {
+			if err := DeleteBlob(context.Background(), id); err != nil {
+				log.Printf("[NotePages] Failed to delete unused upload %s: %v", id, err)
+			}
+		}
+	}
+}
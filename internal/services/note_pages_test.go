@@ -0,0 +1,99 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestAssembleNoteCaption(t *testing.T) {
+	caption, starts := AssembleNoteCaption([]models.NotePage{{Caption: "  Only page \n"}})
+	if caption != "Only page" || starts != nil {
+		t.Errorf("single page = %q, %v, want %q without page starts", caption, starts, "Only page")
+	}
+
+	caption, starts = AssembleNoteCaption([]models.NotePage{
+		{Caption: ""},
+		{Caption: "Ångström"},
+		{Caption: "pH"},
+	})
+	if want := "Ångström\n\npH"; caption != want {
+		t.Errorf("caption = %q, want %q", caption, want)
+	}
+	// Offsets are in runes: "Ångström" is 8 runes followed by a 2-rune separator
+	if want := []int{0, 0, 10}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("starts = %v, want %v", starts, want)
+	}
+
+	if caption, starts := AssembleNoteCaption(nil); caption != "" || starts != nil {
+		t.Errorf("no pages = %q, %v, want empty", caption, starts)
+	}
+}
+
+func TestNotePages(t *testing.T) {
+	legacy := &models.Node{
+		Metadata:      models.NodeMetadata{Type: models.NodeTypeNote, DriveID: "blob-1"},
+		CaptionStatus: models.CaptionStatusCompleted,
+	}
+	pages := NotePages(legacy)
+	if len(pages) != 1 || pages[0].ID != "" || pages[0].DriveID != "blob-1" {
+		t.Fatalf("NotePages(legacy) = %+v, want one page for metadata.driveId", pages)
+	}
+	if !NotePagesCaptioned(legacy) {
+		t.Errorf("NotePagesCaptioned(legacy) = false, want the note's own status")
+	}
+
+	paged := &models.Node{
+		Metadata: models.NodeMetadata{Type: models.NodeTypeNote, DriveID: "blob-a"},
+		Pages: []models.NotePage{
+			{ID: "a", DriveID: "blob-a", CaptionStatus: models.CaptionStatusCompleted},
+			{ID: "b", DriveID: "blob-b", CaptionStatus: models.CaptionStatusProcessing},
+		},
+	}
+	if page, err := NotePageAt(paged, 2); err != nil || page.ID != "b" {
+		t.Errorf("NotePageAt(2) = %+v, %v, want page b", page, err)
+	}
+	for _, n := range []int{0, 3} {
+		if _, err := NotePageAt(paged, n); err != ErrPageNotFound {
+			t.Errorf("NotePageAt(%d) error = %v, want ErrPageNotFound", n, err)
+		}
+	}
+	if NotePagesCaptioned(paged) {
+		t.Errorf("NotePagesCaptioned() = true with a page still processing")
+	}
+
+	// A page read from a PDF's text layer has no image
+	textFirst := []models.NotePage{{ID: "t", Caption: "Slide text"}, {ID: "i", DriveID: "blob-i"}}
+	if got := FirstPageDriveID(textFirst); got != "blob-i" {
+		t.Errorf("FirstPageDriveID() = %q, want blob-i", got)
+	}
+}
+
+func TestNoteIndexProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []models.CaptionStatus
+		want     string
+		indexed  int
+	}{
+		{"all transcribed", []models.CaptionStatus{models.CaptionStatusCompleted, models.CaptionStatusCompleted}, IndexStatusCompleted, 2},
+		{"partly transcribed", []models.CaptionStatus{models.CaptionStatusCompleted, models.CaptionStatusPending}, IndexStatusIndexing, 1},
+		{"in progress", []models.CaptionStatus{models.CaptionStatusProcessing, models.CaptionStatusPending}, IndexStatusIndexing, 0},
+		{"failed page", []models.CaptionStatus{models.CaptionStatusCompleted, models.CaptionStatusFailed}, IndexStatusFailed, 1},
+		{"not started", []models.CaptionStatus{models.CaptionStatusPending, ""}, IndexStatusPending, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &models.Node{Metadata: models.NodeMetadata{Type: models.NodeTypeNote}}
+			for i, status := range tt.statuses {
+				node.Pages = append(node.Pages, models.NotePage{ID: string(rune('a' + i)), CaptionStatus: status})
+			}
+			status, indexed, total := NoteIndexProgress(node)
+			if status != tt.want || indexed != tt.indexed || total != len(tt.statuses) {
+				t.Errorf("NoteIndexProgress() = %q, %d/%d, want %q, %d/%d",
+					status, indexed, total, tt.want, tt.indexed, len(tt.statuses))
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"cogniscan/backend/internal/models"
+
+	"github.com/ledongthuc/pdf"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxPDFBytes is the largest PDF accepted for upload
+const MaxPDFBytes = 50 << 20
+
+// minPDFPageText is how many letters and digits a page's text layer needs to be used
+// as its transcription; below that (a bare slide number, a stray header) the page is
+// rasterised instead
+const minPDFPageText = 20
+
+const defaultPDFRasterizer = "pdftoppm"
+
+var (
+	ErrInvalidPDF        = errors.New("file is not a readable PDF")
+	ErrPDFTooLarge       = fmt.Errorf("PDF is larger than %d MB", MaxPDFBytes>>20)
+	ErrRasterizerMissing = errors.New("this PDF has pages without text, which cannot be read on this server")
+)
+
+// PDFPage is one page of an uploaded PDF
+type PDFPage struct {
+	Number int    // 1-based
+	Text   string // Text layer, empty when the page has too little text to use
+}
+
+// IsPDF reports whether content starts with a PDF header
+func IsPDF(content []byte) bool {
+	return bytes.HasPrefix(content, []byte("%PDF-"))
+}
+
+// ReadPDFPages reads the pages of a PDF and the text layer of each
+func ReadPDFPages(data []byte) (pages []PDFPage, err error) {
+	if !IsPDF(data) {
+		return nil, ErrInvalidPDF
+	}
+	// The PDF reader panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			pages, err = nil, fmt.Errorf("%w: %v", ErrInvalidPDF, r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPDF, err)
+	}
+	count := reader.NumPage()
+	if count == 0 {
+		return nil, fmt.Errorf("%w: no pages", ErrInvalidPDF)
+	}
+	if count > MaxNotePages {
+		return nil, ErrTooManyPages
+	}
+
+	pages = make([]PDFPage, count)
+	for i := range pages {
+		text, err := reader.Page(i + 1).GetPlainText(nil)
+		if err != nil {
+			// An unreadable text layer is no worse than none: the page gets rasterised
+			log.Printf("[PDFIngest] Failed to read text of page %d: %v", i+1, err)
+			text = ""
+		}
+		pages[i] = PDFPage{Number: i + 1, Text: usablePDFText(text)}
+	}
+	return pages, nil
+}
+
+// usablePDFText tidies the whitespace of a page's text layer and returns it, or ""
+// when it is too short or mostly undecodable glyphs (fonts without a Unicode map)
+func usablePDFText(raw string) string {
+	var lines []string
+	for _, line := range strings.Split(raw, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	text := strings.Join(lines, "\n")
+
+	var total, readable, garbled int
+	for _, r := range text {
+		total++
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			readable++
+		case r == unicode.ReplacementChar || !unicode.IsPrint(r) && !unicode.IsSpace(r):
+			garbled++
+		}
+	}
+	if readable < minPDFPageText || garbled*10 > total {
+		return ""
+	}
+	return text
+}
+
+// RasterizePDFPages renders the given (1-based) pages of a PDF as JPEGs with pdftoppm,
+// or the binary named by PDF_RASTERIZER, at pdfRasterSize pixels on the longest side
+func RasterizePDFPages(ctx context.Context, data []byte, numbers []int) ([][]byte, error) {
+	if len(numbers) == 0 {
+		return nil, nil
+	}
+
+	rasterizer := os.Getenv("PDF_RASTERIZER")
+	if rasterizer == "" {
+		rasterizer = defaultPDFRasterizer
+	}
+	bin, err := exec.LookPath(rasterizer)
+	if err != nil {
+		log.Printf("[PDFIngest] PDF rasterizer %q not found: %v", rasterizer, err)
+		return nil, ErrRasterizerMissing
+	}
+	size := strconv.Itoa(pdfRasterSize())
+
+	dir, err := os.MkdirTemp("", "cogniscan-pdf-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source.pdf")
+	if err := os.WriteFile(source, data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write PDF: %w", err)
+	}
+
+	images := make([][]byte, len(numbers))
+	for i, n := range numbers {
+		page := strconv.Itoa(n)
+		root := filepath.Join(dir, "page-"+page)
+		cmd := exec.CommandContext(ctx, bin, "-f", page, "-l", page, "-scale-to", size, "-jpeg", "-singlefile", source, root)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to rasterise page %d: %w: %s", n, err, bytes.TrimSpace(output))
+		}
+		if images[i], err = os.ReadFile(root + ".jpg"); err != nil {
+			return nil, fmt.Errorf("failed to read rasterised page %d: %w", n, err)
+		}
+	}
+	return images, nil
+}
+
+// pdfRasterSize is the longest side PDF pages are rendered at: IMAGE_MAX_DIMENSION,
+// which preprocessing fits them to anyway, or with no such limit the side of a square
+// of MaxImagePixels. A fixed DPI would let a PDF with huge pages render huge images.
+func pdfRasterSize() int {
+	if side := envInt("IMAGE_MAX_DIMENSION", defaultImageMaxDimension); side > 0 {
+		return side
+	}
+	return int(math.Sqrt(MaxImagePixels))
+}
+
+// IngestPDF turns an uploaded PDF into note pages, in PDF order. Pages with a text
+// layer take it as their transcription; the rest are rasterised, stored and left
+// pending for the caption pipeline. name is the uploaded file name.
+func IngestPDF(ctx context.Context, name string, data []byte) ([]models.NotePage, error) {
+	if len(data) > MaxPDFBytes {
+		return nil, ErrPDFTooLarge
+	}
+	pdfPages, err := ReadPDFPages(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var scanned []int
+	for _, p := range pdfPages {
+		if p.Text == "" {
+			scanned = append(scanned, p.Number)
+		}
+	}
+	images, err := RasterizePDFPages(ctx, data, scanned)
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	pages := make([]models.NotePage, 0, len(pdfPages))
+	for _, p := range pdfPages {
+		if p.Text != "" {
+			pages = append(pages, models.NotePage{
+				ID:            primitive.NewObjectID().Hex(),
+				Caption:       p.Text,
+				Transcription: TranscriptionFromText(p.Text),
+				CaptionStatus: models.CaptionStatusCompleted,
+				CreatedAt:     time.Now(),
+			})
+			continue
+		}
+
+		raster := images[0]
+		images = images[1:]
+		prepared, err := PreprocessImage(raster)
+		var page models.NotePage
+		if err == nil {
+			page, err = storePageImage(ctx, fmt.Sprintf("%s-page-%d", base, p.Number), prepared)
+			page.SHA256 = ImageSHA256(raster)
+		}
+		if err != nil {
+			DeleteNotePageBlobs(pages)
+			return nil, fmt.Errorf("failed to store page %d: %w", p.Number, err)
+		}
+		pages = append(pages, page)
+	}
+
+	log.Printf("[PDFIngest] Read %s: %d pages, %d from the text layer, %d rasterised",
+		name, len(pages), len(pages)-len(scanned), len(scanned))
+	return pages, nil
+}
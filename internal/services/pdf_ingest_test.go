@@ -0,0 +1,121 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildTestPDF writes a minimal PDF with one page per content stream
+func buildTestPDF(contents ...string) []byte {
+	var objects []string
+	kids := make([]string, len(contents))
+	for i := range contents {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(contents)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+	for i, content := range contents {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+func TestReadPDFPages(t *testing.T) {
+	data := buildTestPDF(
+		"BT /F1 12 Tf 72 720 Td (Photosynthesis turns light into chemical energy) Tj ET",
+		"BT /F1 12 Tf 72 720 Td (12) Tj ET",
+		"",
+	)
+
+	pages, err := ReadPDFPages(data)
+	if err != nil {
+		t.Fatalf("ReadPDFPages() error = %v", err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("ReadPDFPages() = %d pages, want 3", len(pages))
+	}
+	if want := "Photosynthesis turns light into chemical energy"; pages[0].Text != want {
+		t.Errorf("page 1 text = %q, want %q", pages[0].Text, want)
+	}
+	for _, p := range pages[1:] {
+		if p.Text != "" {
+			t.Errorf("page %d text = %q, want none so it is rasterised", p.Number, p.Text)
+		}
+	}
+	for i, p := range pages {
+		if p.Number != i+1 {
+			t.Errorf("pages[%d].Number = %d, want %d", i, p.Number, i+1)
+		}
+	}
+}
+
+func TestReadPDFPagesInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"not a pdf": []byte("\x89PNG\r\n\x1a\n"),
+		"truncated": buildTestPDF("BT ET")[:40],
+	} {
+		if _, err := ReadPDFPages(data); !errors.Is(err, ErrInvalidPDF) {
+			t.Errorf("%s: ReadPDFPages() error = %v, want ErrInvalidPDF", name, err)
+		}
+	}
+
+	contents := make([]string, MaxNotePages+1)
+	if _, err := ReadPDFPages(buildTestPDF(contents...)); err != ErrTooManyPages {
+		t.Errorf("ReadPDFPages(%d pages) error = %v, want ErrTooManyPages", len(contents), err)
+	}
+}
+
+func TestUsablePDFText(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"tidies whitespace", "\n  Cell   membranes\tare\n\n\n selectively permeable  \n", "Cell membranes are\nselectively permeable"},
+		{"too short", "\nSlide 4\n", ""},
+		{"undecodable glyphs", "\x01\x02\x03\x04\x05 abcdefghijklmnopqrstuvwxyz", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usablePDFText(tt.raw); got != tt.want {
+				t.Errorf("usablePDFText(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFRasterSize(t *testing.T) {
+	t.Setenv("IMAGE_MAX_DIMENSION", "1600")
+	if got := pdfRasterSize(); got != 1600 {
+		t.Errorf("pdfRasterSize() = %d, want IMAGE_MAX_DIMENSION", got)
+	}
+
+	// Without a dimension limit, pages still fit the pixel budget
+	t.Setenv("IMAGE_MAX_DIMENSION", "0")
+	if side := pdfRasterSize(); side <= 0 || side*side > MaxImagePixels {
+		t.Errorf("pdfRasterSize() = %d, want a square within %d pixels", side, MaxImagePixels)
+	}
+}
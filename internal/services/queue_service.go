@@ -2,22 +2,36 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
+	"cogniscan/backend/internal/models"
 	"cogniscan/backend/internal/queue"
 
 	"github.com/redis/go-redis/v9"
 )
 
-var redisClient *redis.Client
+var (
+	redisClient  *redis.Client
+	captionQueue *queue.ReliableQueue
+	quizQueue    *queue.ReliableQueue
+)
+
 const (
-	queueKey       = "cogniscan:caption:queue"
-	quizQueueKey   = "cogniscan:quiz:queue"
-	queueTTL       = 24 * time.Hour
-	workerTTL      = 30 * time.Second
+	queueKey     = "cogniscan:caption:queue"
+	quizQueueKey = "cogniscan:quiz:queue"
+	workerTTL    = 30 * time.Second
+
+	// Queue names used by the operator endpoints
+	CaptionQueueName = "caption"
+	QuizQueueName    = "quiz"
+
+	captionMaxRetryDefault          = 3
+	quizMaxRetryDefault             = 3
+	captionVisibilityTimeoutDefault = 2 * time.Minute
+	quizVisibilityTimeoutDefault    = 5 * time.Minute
 )
 
 // InitQueueService initializes the Redis client with Upstash
@@ -35,70 +49,85 @@ func InitQueueService() error {
 
 	redisClient = redis.NewClient(opt)
 
-	// Set TTL on the queue key to prevent indefinite growth
-	if err := redisClient.Expire(context.Background(), queueKey, queueTTL).Err(); err != nil {
-		log.Printf("[QueueService] Warning: Failed to set queue TTL: %v", err)
+	// Queues used to carry a TTL; a persisted backlog must never expire, so drop any leftover one
+	ctx := context.Background()
+	for _, key := range []string{queueKey, quizQueueKey} {
+		if err := redisClient.Persist(ctx, key).Err(); err != nil {
+			log.Printf("[QueueService] Warning: Failed to clear TTL on %s: %v", key, err)
+		}
 	}
 
+	captionQueue = queue.NewReliableQueue(redisClient, CaptionQueueName, queueKey,
+		envInt("CAPTION_MAX_RETRY", captionMaxRetryDefault),
+		envDuration("CAPTION_VISIBILITY_TIMEOUT", captionVisibilityTimeoutDefault))
+	quizQueue = queue.NewReliableQueue(redisClient, QuizQueueName, quizQueueKey,
+		envInt("QUIZ_MAX_RETRY", quizMaxRetryDefault),
+		envDuration("QUIZ_VISIBILITY_TIMEOUT", quizVisibilityTimeoutDefault))
+
 	log.Println("[QueueService] Successfully initialized Redis queue service")
 	return nil
 }
 
+// GetCaptionQueue returns the caption job queue (nil when the queue service is disabled)
+func GetCaptionQueue() *queue.ReliableQueue {
+	return captionQueue
+}
+
+// GetQuizQueue returns the quiz job queue (nil when the queue service is disabled)
+func GetQuizQueue() *queue.ReliableQueue {
+	return quizQueue
+}
+
+// GetJobQueue returns a job queue by name (nil if unknown or disabled)
+func GetJobQueue(name string) *queue.ReliableQueue {
+	switch name {
+	case CaptionQueueName:
+		return captionQueue
+	case QuizQueueName:
+		return quizQueue
+	}
+	return nil
+}
+
 // EnqueueCaptionJob adds a caption generation job to the Redis queue
 func EnqueueCaptionJob(job queue.CaptionJob) error {
-	if redisClient == nil {
+	if captionQueue == nil {
 		return nil // Queue service disabled
 	}
 
-	jobJSON, err := json.Marshal(job)
-	if err != nil {
-		return err
-	}
-
-	ctx := context.Background()
-	if err := redisClient.RPush(ctx, queueKey, jobJSON).Err(); err != nil {
+	if err := captionQueue.Enqueue(context.Background(), job.ID, job); err != nil {
 		log.Printf("[QueueService] Failed to enqueue job: %v", err)
 		return err
 	}
 
-	// Refresh TTL when new jobs are added
-	if err := redisClient.Expire(ctx, queueKey, queueTTL).Err(); err != nil {
-		log.Printf("[QueueService] Warning: Failed to refresh queue TTL: %v", err)
-	}
-
+	TrackJob(models.JobTypeCaption, job.ID, job.OwnerID, job.NoteID, models.JobStateQueued, 0, "")
 	log.Printf("[QueueService] Enqueued job %s for note %s", job.ID, job.NoteID)
 	return nil
 }
 
-// DequeueCaptionJob gets the next job from the queue (blocking with timeout)
-func DequeueCaptionJob(timeout time.Duration) (*queue.CaptionJob, error) {
-	if redisClient == nil {
-		return nil, nil // Queue service disabled
+// DequeueCaptionJob reserves the next job from the queue (blocking with timeout).
+// The returned delivery must be acked or failed on the caption queue.
+func DequeueCaptionJob(timeout time.Duration) (*queue.CaptionJob, *queue.Delivery, error) {
+	if captionQueue == nil {
+		return nil, nil, nil // Queue service disabled
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+5*time.Second)
 	defer cancel()
 
-	// BLPOP blocks until a job is available or timeout
-	result, err := redisClient.BLPop(ctx, timeout, queueKey).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return nil, nil // No jobs available (timeout)
-		}
-		return nil, err
-	}
-
-	if len(result) == 0 {
-		return nil, nil
+	d, err := captionQueue.Reserve(ctx, timeout)
+	if err != nil || d == nil {
+		return nil, nil, err
 	}
 
 	var job queue.CaptionJob
-	if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
+	if err := d.Decode(&job); err != nil {
 		log.Printf("[QueueService] Failed to unmarshal job: %v", err)
-		return nil, err
+		captionQueue.Fail(context.Background(), d, err)
+		return nil, nil, err
 	}
 
-	return &job, nil
+	return &job, d, nil
 }
 
 // IsQueueServiceInitialized checks if the queue service is initialized
@@ -108,57 +137,61 @@ func IsQueueServiceInitialized() bool {
 
 // EnqueueQuizJob adds a quiz generation job to Redis queue
 func EnqueueQuizJob(job queue.QuizJob) error {
-	if redisClient == nil {
+	if quizQueue == nil {
 		return nil // Queue service disabled
 	}
 
-	jobJSON, err := json.Marshal(job)
-	if err != nil {
-		return err
-	}
-
-	ctx := context.Background()
-	if err := redisClient.RPush(ctx, quizQueueKey, jobJSON).Err(); err != nil {
+	if err := quizQueue.Enqueue(context.Background(), job.ID, job); err != nil {
 		log.Printf("[QueueService] Failed to enqueue quiz job: %v", err)
 		return err
 	}
 
-	// Refresh TTL when new jobs are added
-	if err := redisClient.Expire(ctx, quizQueueKey, queueTTL).Err(); err != nil {
-		log.Printf("[QueueService] Warning: Failed to refresh quiz queue TTL: %v", err)
-	}
-
+	TrackJob(models.JobTypeQuiz, job.ID, job.OwnerID, job.FolderID, models.JobStateQueued, 0, "")
 	log.Printf("[QueueService] Enqueued quiz job %s for folder %s", job.ID, job.FolderID)
 	return nil
 }
 
-// DequeueQuizJob gets the next quiz job from the queue (blocking with timeout)
-func DequeueQuizJob(timeout time.Duration) (*queue.QuizJob, error) {
-	if redisClient == nil {
-		return nil, nil // Queue service disabled
+// DequeueQuizJob reserves the next quiz job from the queue (blocking with timeout).
+// The returned delivery must be acked or failed on the quiz queue.
+func DequeueQuizJob(timeout time.Duration) (*queue.QuizJob, *queue.Delivery, error) {
+	if quizQueue == nil {
+		return nil, nil, nil // Queue service disabled
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+5*time.Second)
 	defer cancel()
 
-	// BLPOP blocks until a job is available or timeout
-	result, err := redisClient.BLPop(ctx, timeout, quizQueueKey).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return nil, nil // No jobs available (timeout)
-		}
-		return nil, err
-	}
-
-	if len(result) == 0 {
-		return nil, nil
+	d, err := quizQueue.Reserve(ctx, timeout)
+	if err != nil || d == nil {
+		return nil, nil, err
 	}
 
 	var job queue.QuizJob
-	if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
+	if err := d.Decode(&job); err != nil {
 		log.Printf("[QueueService] Failed to unmarshal quiz job: %v", err)
-		return nil, err
+		quizQueue.Fail(context.Background(), d, err)
+		return nil, nil, err
+	}
+
+	return &job, d, nil
+}
+
+// envInt reads a non-negative integer from the environment
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
 	}
+	return fallback
+}
 
-	return &job, nil
+// envDuration reads a Go duration (e.g. "90s", "5m") from the environment
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
 }
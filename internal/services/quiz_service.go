@@ -4,105 +4,169 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/shared"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"cogniscan/backend/internal/database"
 	"cogniscan/backend/internal/models"
 )
 
-var quizModel = shared.ChatModel("meta/llama-3.3-70b-instruct")
-
-// GetNotesForFolder retrieves all notes in a folder and its subfolders
+// GetNotesForFolder retrieves all captioned notes in a folder node and its subfolders.
+// Captions live in caption_embeddings; notes that have not been captioned yet are skipped.
 func GetNotesForFolder(ctx context.Context, folderID, ownerID string) ([]models.Note, error) {
-	collection := database.Client.Database("cogniscan").Collection("notes")
+	// Every note below the folder has it on its ancestor path
+	filter := ExcludeTrashed(bson.M{
+		"ancestors":     folderID,
+		"ownerId":       ownerID,
+		"metadata.type": models.NodeTypeNote,
+	})
 
-	// Get all folder IDs including nested folders
-	folderIDs := []string{folderID}
-	folderIDs = append(folderIDs, getNestedFolderIDs(ctx, folderID, ownerID)...)
+	return notesMatching(ctx, filter)
+}
 
-	filter := bson.M{
-		"folderId": bson.M{"$in": folderIDs},
-		"ownerId":  ownerID,
-		"caption":  bson.M{"$ne": ""}, // Only notes with captions
+// GetNotesForScope retrieves all captioned notes in a tag or smart collection scope
+func GetNotesForScope(ctx context.Context, scope Scope, ownerID string) ([]models.Note, error) {
+	condition, err := ScopeFilter(ctx, ownerID, scope)
+	if err != nil {
+		return nil, err
 	}
+	filter := withFilter(ExcludeTrashed(bson.M{
+		"ownerId":       ownerID,
+		"metadata.type": models.NodeTypeNote,
+	}), condition)
+	return notesMatching(ctx, filter)
+}
 
-	cursor, err := collection.Find(ctx, filter)
+// notesMatching returns the captioned notes among the nodes matching filter
+func notesMatching(ctx context.Context, filter bson.M) ([]models.Note, error) {
+	cursor, err := GetNodesCollection().Find(ctx, filter)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to fetch note nodes: %w", err)
 	}
 
-	var notes []models.Note
-	if err := cursor.All(ctx, &notes); err != nil {
+	var nodes []models.Node
+	if err := cursor.All(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to decode note nodes: %w", err)
+	}
+	if len(nodes) == 0 {
+		return []models.Note{}, nil
+	}
+
+	noteIDs := make([]string, len(nodes))
+	for i, node := range nodes {
+		noteIDs[i] = node.ID.Hex()
+	}
+
+	captions, err := getCaptionsForNotes(ctx, noteIDs)
+	if err != nil {
 		return nil, err
 	}
 
-	return notes, nil
+	return notesFromNodes(nodes, captions), nil
 }
 
-func getNestedFolderIDs(ctx context.Context, parentID, ownerID string) []string {
-	collection := database.Client.Database("cogniscan").Collection("folders")
-	var folders []models.Folder
+// getCaptionsForNotes returns the stored caption of each note, keyed by note ID
+func getCaptionsForNotes(ctx context.Context, noteIDs []string) (map[string]string, error) {
+	filter := bson.M{
+		"noteId":  bson.M{"$in": noteIDs},
+		"caption": bson.M{"$ne": ""},
+	}
+	opts := options.Find().SetProjection(bson.M{"noteId": 1, "caption": 1})
 
-	filter := bson.M{"parentId": parentID, "ownerId": ownerID}
-	cursor, err := collection.Find(ctx, filter)
+	cursor, err := getVectorCollection().Find(ctx, filter, opts)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("failed to fetch captions: %w", err)
 	}
 
-	if err := cursor.All(ctx, &folders); err != nil {
-		return nil
+	var embeddings []models.CaptionEmbedding
+	if err := cursor.All(ctx, &embeddings); err != nil {
+		return nil, fmt.Errorf("failed to decode captions: %w", err)
 	}
 
-	ids := make([]string, 0, len(folders))
-	for _, folder := range folders {
-		ids = append(ids, folder.ID.Hex())
-		// Recursively get nested folders
-		nested := getNestedFolderIDs(ctx, folder.ID.Hex(), ownerID)
-		ids = append(ids, nested...)
+	captions := make(map[string]string, len(embeddings))
+	for _, embedding := range embeddings {
+		captions[embedding.NoteID] = embedding.Caption
 	}
-
-	return ids
+	return captions, nil
 }
 
-// UpdateFolderQuizStatus updates the quiz generation status for a folder
-func UpdateFolderQuizStatus(ctx context.Context, folderID, ownerID string, status models.QuizGenerationStatus, quizID string, errorMsg string) error {
-	collection := database.Client.Database("cogniscan").Collection("folders")
+// notesFromNodes pairs note nodes with their captions, dropping notes without one
+func notesFromNodes(nodes []models.Node, captions map[string]string) []models.Note {
+	notes := make([]models.Note, 0, len(nodes))
+	for _, node := range nodes {
+		caption := strings.TrimSpace(captions[node.ID.Hex()])
+		if caption == "" {
+			continue
+		}
+		notes = append(notes, models.Note{
+			ID:            node.ID,
+			Name:          node.Name,
+			PublicURL:     node.PublicURL,
+			DriveID:       node.Metadata.DriveID,
+			Caption:       caption,
+			CaptionStatus: node.CaptionStatus,
+			CreatedAt:     node.CreatedAt,
+			UpdatedAt:     node.UpdatedAt,
+			FolderID:      node.ParentID,
+			OwnerID:       node.OwnerID,
+		})
+	}
+	return notes
+}
 
+// folderNodeFilter matches a folder node owned by ownerID
+func folderNodeFilter(folderID, ownerID string) (bson.M, error) {
 	objID, err := primitive.ObjectIDFromHex(folderID)
+	if err != nil {
+		return nil, ErrNodeNotFound
+	}
+	return ExcludeTrashed(bson.M{"_id": objID, "ownerId": ownerID, "metadata.type": models.NodeTypeFolder}), nil
+}
+
+// UpdateFolderQuizStatus updates the quiz generation status of a folder node
+func UpdateFolderQuizStatus(ctx context.Context, folderID, ownerID string, status models.QuizGenerationStatus, quizID string, errorMsg string) error {
+	filter, err := folderNodeFilter(folderID, ownerID)
 	if err != nil {
 		return err
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"quizGenerationStatus": status,
-			"quizUpdatedAt":        time.Now(),
-		},
+	set := bson.M{
+		"quizGenerationStatus": status,
+		"quizUpdatedAt":        time.Now(),
 	}
+	unset := bson.M{}
 
 	if quizID != "" {
-		update["$set"].(bson.M)["quizId"] = quizID
+		set["quizId"] = quizID
 	} else {
-		update["$unset"] = bson.M{"quizId": ""}
+		unset["quizId"] = ""
 	}
 
 	if errorMsg != "" {
-		update["$set"].(bson.M)["quizError"] = errorMsg
-	} else if update["$unset"] == nil {
-		update["$unset"] = bson.M{"quizError": ""}
+		set["quizError"] = errorMsg
 	} else {
-		update["$unset"].(bson.M)["quizError"] = ""
+		unset["quizError"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
 	}
 
-	filter := bson.M{"_id": objID, "ownerId": ownerID}
-	_, err = collection.UpdateOne(ctx, filter, update)
-	return err
+	result, err := GetNodesCollection().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to update folder quiz status: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNodeNotFound
+	}
+	return nil
 }
 
 // FolderQuizStatus represents the quiz generation status response
@@ -112,24 +176,19 @@ type FolderQuizStatus struct {
 	ErrorMsg string                      `json:"errorMsg"`
 }
 
-// GetFolderQuizStatus retrieves the quiz generation status for a folder
+// GetFolderQuizStatus retrieves the quiz generation status of a folder node
 func GetFolderQuizStatus(ctx context.Context, folderID, ownerID string) (*FolderQuizStatus, error) {
-	collection := database.Client.Database("cogniscan").Collection("folders")
-
-	objID, err := primitive.ObjectIDFromHex(folderID)
+	filter, err := folderNodeFilter(folderID, ownerID)
 	if err != nil {
 		return nil, err
 	}
 
-	var folder struct {
-		QuizGenerationStatus models.QuizGenerationStatus `bson:"quizGenerationStatus"`
-		QuizID               string                      `bson:"quizId"`
-		QuizError            string                      `bson:"quizError"`
-	}
-
-	err = collection.FindOne(ctx, bson.M{"_id": objID, "ownerId": ownerID}).Decode(&folder)
-	if err != nil {
-		return nil, err
+	var folder models.Node
+	if err := GetNodesCollection().FindOne(ctx, filter).Decode(&folder); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch folder: %w", err)
 	}
 
 	// Default to "none" if status is empty
@@ -145,11 +204,14 @@ func GetFolderQuizStatus(ctx context.Context, folderID, ownerID string) (*Folder
 	}, nil
 }
 
-// GenerateQuestionsUsingAI generates questions using NVIDIA's LLaMA model
+// GenerateQuestionsUsingAI generates questions using the configured AI provider
 func GenerateQuestionsUsingAI(ctx context.Context, notes []models.Note) ([]models.Question, error) {
 	if len(notes) == 0 {
 		return nil, fmt.Errorf("no notes provided for question generation")
 	}
+	if !isClientInitialized() {
+		return nil, ErrAINotInitialized
+	}
 
 	// Build note context
 	noteContext := ""
@@ -197,28 +259,20 @@ IMPORTANT:
 - Questions should test specific facts and understanding from the transcriptions
 - Return only valid JSON, no surrounding text`, noteContext)
 
-	// Call NVIDIA API
-	completion, err := aiClient.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		},
-		Model:       quizModel,
-		MaxTokens:   openai.Int(6144), // Increased for potentially more questions
-		Temperature: openai.Float(0.70),
-		TopP:        openai.Float(0.90),
+	content, err := aiProvider.Chat(ctx, ChatRequest{
+		Task:        ChatTaskQuiz,
+		Prompt:      prompt,
+		MaxTokens:   6144, // Increased for potentially more questions
+		Temperature: 0.70,
+		TopP:        0.90,
 	})
-
 	if err != nil {
 		return nil, fmt.Errorf("AI API error: %w", err)
 	}
 
-	if len(completion.Choices) == 0 {
-		return nil, fmt.Errorf("no response from AI model")
-	}
-
 	// Parse JSON response
 	var questions []models.Question
-	if err := json.Unmarshal([]byte(completion.Choices[0].Message.Content), &questions); err != nil {
+	if err := json.Unmarshal([]byte(content), &questions); err != nil {
 		return nil, fmt.Errorf("failed to parse AI response: %w", err)
 	}
 
@@ -282,8 +336,7 @@ func CreateQuizForFolder(ctx context.Context, folderID, ownerID string, updateSt
 		UpdatedAt:      nowTime,
 	}
 
-	quizzesCollection := database.Client.Database("cogniscan").Collection("quizzes")
-	result, err := quizzesCollection.InsertOne(ctx, quiz)
+	result, err := GetQuizCollection().InsertOne(ctx, quiz)
 	if err != nil {
 		if updateStatus {
 			UpdateFolderQuizStatus(ctx, folderID, ownerID, models.QuizGenStatusFailed, "", fmt.Sprintf("failed to create quiz: %v", err))
@@ -299,8 +352,7 @@ func CreateQuizForFolder(ctx context.Context, folderID, ownerID string, updateSt
 		questions[i].CreatedAt = time.Now()
 	}
 
-	questionsCollection := database.Client.Database("cogniscan").Collection("questions")
-	if _, err := questionsCollection.InsertMany(ctx, convertQuestionsToInterface(questions)); err != nil {
+	if _, err := GetQuestionCollection().InsertMany(ctx, convertQuestionsToInterface(questions)); err != nil {
 		if updateStatus {
 			UpdateFolderQuizStatus(ctx, folderID, ownerID, models.QuizGenStatusFailed, "", fmt.Sprintf("failed to save questions: %v", err))
 		}
@@ -322,6 +374,65 @@ func CreateQuizForFolder(ctx context.Context, folderID, ownerID string, updateSt
 	return quiz, questions, nil
 }
 
+// CreateQuizForScope creates a quiz over the notes of a tag or smart collection.
+// Scoped quizzes are generated synchronously and have no folder status to track.
+func CreateQuizForScope(ctx context.Context, scope Scope, ownerID string) (*models.Quiz, []models.Question, error) {
+	if scope.IsZero() {
+		return nil, nil, fmt.Errorf("a quiz scope needs a tag or a collection")
+	}
+	if scope.Tag != "" {
+		tag, err := NormalizeTag(scope.Tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		scope.Tag = tag
+	}
+
+	notes, err := GetNotesForScope(ctx, scope, ownerID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get notes: %w", err)
+	}
+	if len(notes) == 0 {
+		return nil, nil, ErrNoScopedNotes
+	}
+
+	questions, err := GenerateQuestionsUsingAI(ctx, notes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate questions: %w", err)
+	}
+
+	nowTime := time.Now()
+	quiz := &models.Quiz{
+		Tag:            scope.Tag,
+		CollectionID:   scope.CollectionID,
+		OwnerID:        ownerID,
+		Status:         models.QuizStatusCompleted,
+		TotalQuestions: len(questions),
+		CreatedAt:      nowTime,
+		UpdatedAt:      nowTime,
+	}
+
+	result, err := GetQuizCollection().InsertOne(ctx, quiz)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create quiz: %w", err)
+	}
+	quiz.ID = result.InsertedID.(primitive.ObjectID)
+
+	for i := range questions {
+		questions[i].QuizID = quiz.ID.Hex()
+		questions[i].CreatedAt = time.Now()
+	}
+	if _, err := GetQuestionCollection().InsertMany(ctx, convertQuestionsToInterface(questions)); err != nil {
+		return nil, nil, fmt.Errorf("failed to save questions: %w", err)
+	}
+
+	for _, note := range notes {
+		InitializeNoteReview(ctx, note.ID.Hex(), ownerID)
+	}
+
+	return quiz, questions, nil
+}
+
 func convertQuestionsToInterface(questions []models.Question) []interface{} {
 	result := make([]interface{}, len(questions))
 	for i, q := range questions {
@@ -332,7 +443,7 @@ func convertQuestionsToInterface(questions []models.Question) []interface{} {
 
 // GetQuiz retrieves a quiz
 func GetQuiz(ctx context.Context, quizID, ownerID string) (*models.Quiz, error) {
-	collection := database.Client.Database("cogniscan").Collection("quizzes")
+	collection := GetQuizCollection()
 	objID, err := primitive.ObjectIDFromHex(quizID)
 	if err != nil {
 		return nil, err
@@ -345,7 +456,7 @@ func GetQuiz(ctx context.Context, quizID, ownerID string) (*models.Quiz, error)
 
 // GetQuizQuestions retrieves all questions for a quiz
 func GetQuizQuestions(ctx context.Context, quizID string) ([]models.Question, error) {
-	collection := database.Client.Database("cogniscan").Collection("questions")
+	collection := GetQuestionCollection()
 
 	cursor, err := collection.Find(ctx, bson.M{"quizId": quizID})
 	if err != nil {
@@ -362,7 +473,7 @@ func GetQuizQuestions(ctx context.Context, quizID string) ([]models.Question, er
 
 // GetQuestion retrieves a single question
 func GetQuestion(ctx context.Context, questionID string) (*models.Question, error) {
-	collection := database.Client.Database("cogniscan").Collection("questions")
+	collection := GetQuestionCollection()
 	objID, err := primitive.ObjectIDFromHex(questionID)
 	if err != nil {
 		return nil, err
@@ -375,28 +486,22 @@ func GetQuestion(ctx context.Context, questionID string) (*models.Question, erro
 
 // GetQuizCollection returns the quizzes collection
 func GetQuizCollection() *mongo.Collection {
-	return database.Client.Database("cogniscan").Collection("quizzes")
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection("quizzes")
 }
 
 // GetQuestionCollection returns the questions collection
 func GetQuestionCollection() *mongo.Collection {
-	return database.Client.Database("cogniscan").Collection("questions")
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection("questions")
 }
 
 // GetAnswerCollection returns the question_answers collection
 func GetAnswerCollection() *mongo.Collection {
-	return database.Client.Database("cogniscan").Collection("question_answers")
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection("question_answers")
 }
 
-// GetNotesByIDs retrieves notes by their IDs
-func GetNotesByIDs(ctx context.Context, noteIDs []string) ([]models.Note, error) {
-	if len(noteIDs) == 0 {
-		return []models.Note{}, nil
-	}
-
-	collection := database.Client.Database("cogniscan").Collection("notes")
+// GetNotesByIDs retrieves note nodes by their IDs
+func GetNotesByIDs(ctx context.Context, noteIDs []string) ([]models.Node, error) {
 	objectIDs := make([]primitive.ObjectID, 0, len(noteIDs))
-
 	for _, id := range noteIDs {
 		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
@@ -406,16 +511,19 @@ func GetNotesByIDs(ctx context.Context, noteIDs []string) ([]models.Note, error)
 	}
 
 	if len(objectIDs) == 0 {
-		return []models.Note{}, nil
+		return []models.Node{}, nil
 	}
 
-	filter := bson.M{"_id": bson.M{"$in": objectIDs}}
-	cursor, err := collection.Find(ctx, filter)
+	filter := bson.M{
+		"_id":           bson.M{"$in": objectIDs},
+		"metadata.type": models.NodeTypeNote,
+	}
+	cursor, err := GetNodesCollection().Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 
-	var notes []models.Note
+	var notes []models.Node
 	if err := cursor.All(ctx, &notes); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestNotesFromNodes(t *testing.T) {
+	captioned := models.Node{
+		ID:       primitive.NewObjectID(),
+		Name:     "Cell biology",
+		ParentID: "folder-1",
+		OwnerID:  "user@example.com",
+		Metadata: models.NodeMetadata{Type: models.NodeTypeNote, DriveID: "blob-1"},
+	}
+	pending := models.Node{
+		ID:       primitive.NewObjectID(),
+		Name:     "Still captioning",
+		ParentID: "folder-2",
+		OwnerID:  "user@example.com",
+		Metadata: models.NodeMetadata{Type: models.NodeTypeNote},
+	}
+	blank := models.Node{
+		ID:       primitive.NewObjectID(),
+		Metadata: models.NodeMetadata{Type: models.NodeTypeNote},
+	}
+
+	captions := map[string]string{
+		captioned.ID.Hex(): "Mitochondria are the powerhouse of the cell",
+		blank.ID.Hex():     "   ",
+	}
+
+	notes := notesFromNodes([]models.Node{captioned, pending, blank}, captions)
+	if len(notes) != 1 {
+		t.Fatalf("notesFromNodes() returned %d notes, want 1", len(notes))
+	}
+
+	note := notes[0]
+	if note.ID != captioned.ID || note.Caption != captions[captioned.ID.Hex()] {
+		t.Errorf("notesFromNodes() = %+v, want note %s with its caption", note, captioned.ID.Hex())
+	}
+	if note.FolderID != "folder-1" || note.DriveID != "blob-1" || note.OwnerID != captioned.OwnerID {
+		t.Errorf("notesFromNodes() did not carry node fields over: %+v", note)
+	}
+}
+
+func TestFolderNodeFilterRejectsInvalidID(t *testing.T) {
+	if _, err := folderNodeFilter("not-an-object-id", "user@example.com"); err != ErrNodeNotFound {
+		t.Errorf("folderNodeFilter() error = %v, want ErrNodeNotFound", err)
+	}
+
+	id := primitive.NewObjectID()
+	filter, err := folderNodeFilter(id.Hex(), "user@example.com")
+	if err != nil {
+		t.Fatalf("folderNodeFilter() error = %v", err)
+	}
+	if filter["_id"] != id || filter["metadata.type"] != models.NodeTypeFolder {
+		t.Errorf("folderNodeFilter() = %v, want folder filter on %s", filter, id.Hex())
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrConflictingScope = errors.New("use either a tag or a collection, not both")
+	ErrNoScopedNotes    = errors.New("no captioned notes in this tag or collection")
+)
+
+// Scope narrows quizzes, the review queue and search to the nodes carrying a tag or
+// matching a smart collection. The zero Scope matches everything.
+type Scope struct {
+	Tag          string
+	CollectionID string
+}
+
+// IsZero reports whether the scope matches everything
+func (s Scope) IsZero() bool {
+	return s.Tag == "" && s.CollectionID == ""
+}
+
+// ScopeFilter returns the nodes filter conditions of a scope, to be combined with an
+// owner (and usually a trash) filter. A tag matches nodes carrying it and everything
+// below a folder carrying it. Returns nil for the zero scope.
+func ScopeFilter(ctx context.Context, ownerID string, scope Scope) (bson.M, error) {
+	if scope.Tag != "" && scope.CollectionID != "" {
+		return nil, ErrConflictingScope
+	}
+
+	var criteria models.CollectionFilter
+	switch {
+	case scope.Tag != "":
+		tag, err := NormalizeTag(scope.Tag)
+		if err != nil {
+			return nil, err
+		}
+		criteria.Tags = []string{tag}
+	case scope.CollectionID != "":
+		collection, err := GetSmartCollection(ctx, scope.CollectionID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		criteria = collection.Filter
+	default:
+		return nil, nil
+	}
+
+	var conditions []bson.M
+	for _, tag := range criteria.Tags {
+		condition, err := tagCondition(ctx, ownerID, tag)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if criteria.MasteryLevel != "" {
+		conditions = append(conditions, masteryLevelCondition(criteria.MasteryLevel))
+	}
+	if criteria.CreatedAfter != nil || criteria.CreatedBefore != nil {
+		created := bson.M{}
+		if criteria.CreatedAfter != nil {
+			created["$gte"] = *criteria.CreatedAfter
+		}
+		if criteria.CreatedBefore != nil {
+			created["$lt"] = *criteria.CreatedBefore
+		}
+		conditions = append(conditions, bson.M{"createdAt": created})
+	}
+	if criteria.CaptionText != "" {
+		matches, err := findCaptionMatches(ctx, ownerID, regexp.QuoteMeta(criteria.CaptionText))
+		if err != nil {
+			return nil, err
+		}
+		noteIDs := make([]primitive.ObjectID, 0, len(matches))
+		for noteID := range matches {
+			if objID, err := primitive.ObjectIDFromHex(noteID); err == nil {
+				noteIDs = append(noteIDs, objID)
+			}
+		}
+		conditions = append(conditions, bson.M{"_id": bson.M{"$in": noteIDs}})
+	}
+
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+// tagCondition matches nodes carrying tag or lying below a folder that carries it
+func tagCondition(ctx context.Context, ownerID, tag string) (bson.M, error) {
+	filter := ExcludeTrashed(bson.M{"ownerId": ownerID, "tags": tag, "metadata.type": models.NodeTypeFolder})
+	cursor, err := GetNodesCollection().Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tagged folders: %w", err)
+	}
+	var folders []models.Node
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, fmt.Errorf("failed to decode tagged folders: %w", err)
+	}
+	if len(folders) == 0 {
+		return bson.M{"tags": tag}, nil
+	}
+
+	folderIDs := make([]string, len(folders))
+	for i, folder := range folders {
+		folderIDs[i] = folder.ID.Hex()
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"tags": tag},
+		bson.M{"ancestors": bson.M{"$in": folderIDs}},
+	}}, nil
+}
+
+// masteryLevelCondition matches nodes at a mastery level the way NodeMasteryLevel
+// reads it: the stored level, or the one derived from the percentage when unset
+func masteryLevelCondition(level string) bson.M {
+	percent := bson.M{"$lt": 0.5}
+	switch level {
+	case "Mastered":
+		percent = bson.M{"$gt": 0.8}
+	case "Learnt":
+		percent = bson.M{"$gte": 0.5, "$lte": 0.8}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"mastery.masteryLevel": level},
+		bson.M{"mastery.masteryLevel": bson.M{"$in": bson.A{nil, ""}}, "mastery.masteryPercent": percent},
+	}}
+}
+
+// ScopeNoteIDs returns the IDs of the owner's live notes in a non-zero scope
+func ScopeNoteIDs(ctx context.Context, ownerID string, scope Scope) ([]string, error) {
+	condition, err := ScopeFilter(ctx, ownerID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := withFilter(ExcludeTrashed(bson.M{"ownerId": ownerID, "metadata.type": models.NodeTypeNote}), condition)
+	cursor, err := GetNodesCollection().Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch scoped notes: %w", err)
+	}
+	var notes []models.Node
+	if err := cursor.All(ctx, &notes); err != nil {
+		return nil, fmt.Errorf("failed to decode scoped notes: %w", err)
+	}
+
+	noteIDs := make([]string, len(notes))
+	for i, note := range notes {
+		noteIDs[i] = note.ID.Hex()
+	}
+	return noteIDs, nil
+}
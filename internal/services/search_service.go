@@ -0,0 +1,506 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"cogniscan/backend/internal/models"
+)
+
+// SearchSort selects the ordering of search results
+type SearchSort string
+
+const (
+	SearchSortRelevance SearchSort = "relevance"
+	SearchSortDate      SearchSort = "date"
+	SearchSortMastery   SearchSort = "mastery"
+)
+
+const (
+	// searchCandidateLimit caps how many nodes each match source contributes before ranking
+	searchCandidateLimit = 500
+
+	SearchDefaultLimit = 20
+	SearchMaxLimit     = 100
+)
+
+var (
+	ErrInvalidSearchSort    = errors.New("invalid sort, expected relevance, date or mastery")
+	ErrInvalidMasteryFilter = errors.New("invalid mastery level, expected mastered, learnt or review_soon")
+)
+
+// SearchOptions describes a node search
+type SearchOptions struct {
+	Query        string
+	OwnerID      string
+	Type         models.NodeType // empty matches folders and notes
+	SubtreeID    string          // restrict to descendants of this folder
+	Scope        Scope           // restrict to a tag or smart collection
+	MasteryLevel string          // "Mastered", "Learnt" or "Review Soon"; empty matches all
+	Sort         SearchSort
+	Ascending    bool
+	Offset       int
+	Limit        int
+}
+
+// SearchHit is a matching node with its caption (notes only) and relevance score
+type SearchHit struct {
+	Node      models.Node
+	Caption   string
+	Snippet   string     // HTML-escaped caption excerpt with query terms in <mark>
+	Passage   *TextRange // caption chunk that matched the semantic search best
+	Score     float64    // 0-1, higher is more relevant
+	MatchedBy []string   // rankings that found the node: name, caption, semantic
+}
+
+// SearchResults is one page of ranked hits
+type SearchResults struct {
+	Hits  []SearchHit
+	Total int // matches across all pages
+}
+
+// ParseSearchSort validates a sort query parameter, defaulting to relevance
+func ParseSearchSort(value string) (SearchSort, error) {
+	switch SearchSort(strings.ToLower(strings.TrimSpace(value))) {
+	case "", SearchSortRelevance:
+		return SearchSortRelevance, nil
+	case SearchSortDate:
+		return SearchSortDate, nil
+	case SearchSortMastery:
+		return SearchSortMastery, nil
+	}
+	return "", ErrInvalidSearchSort
+}
+
+// ParseMasteryLevel maps a mastery filter (e.g. "review_soon", "Review Soon") onto a mastery level
+func ParseMasteryLevel(value string) (string, error) {
+	normalized := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(value))
+	switch normalized {
+	case "":
+		return "", nil
+	case "mastered":
+		return "Mastered", nil
+	case "learnt", "learned":
+		return "Learnt", nil
+	case "reviewsoon":
+		return "Review Soon", nil
+	}
+	return "", ErrInvalidMasteryFilter
+}
+
+// NodeMasteryLevel returns a node's mastery level, deriving it from the percentage
+// for nodes whose mastery has not been calculated yet
+func NodeMasteryLevel(node models.Node) string {
+	if node.Mastery.MasteryLevel != "" {
+		return node.Mastery.MasteryLevel
+	}
+	return DetermineMasteryLevel(node.Mastery.MasteryPercent)
+}
+
+// SearchNodes finds folders and notes matching the query by name, by caption terms (BM25)
+// and by caption meaning (vector search), fuses the three rankings with reciprocal-rank
+// fusion, applies the type, subtree and mastery filters, then sorts and paginates
+func SearchNodes(ctx context.Context, opts SearchOptions) (*SearchResults, error) {
+	terms := searchTerms(opts.Query)
+	if len(terms) == 0 {
+		return &SearchResults{Hits: []SearchHit{}}, nil
+	}
+
+	base := ExcludeTrashed(bson.M{"ownerId": opts.OwnerID})
+	if opts.Type != "" {
+		base["metadata.type"] = opts.Type
+	}
+	if opts.SubtreeID != "" {
+		if err := checkSubtreeRoot(ctx, opts.SubtreeID, opts.OwnerID); err != nil {
+			return nil, err
+		}
+		base["ancestors"] = opts.SubtreeID
+	}
+	if !opts.Scope.IsZero() {
+		condition, err := ScopeFilter(ctx, opts.OwnerID, opts.Scope)
+		if err != nil {
+			return nil, err
+		}
+		base = withFilter(base, condition)
+	}
+
+	pattern := termsPattern(terms)
+	nodes := make(map[primitive.ObjectID]models.Node)
+	captions := make(map[string]string)
+	passages := make(map[string]TextRange)
+
+	// Name matches
+	nameFilter := withFilter(base, bson.M{"name": bson.M{"$regex": pattern, "$options": "i"}})
+	if err := collectNodes(ctx, nameFilter, nodes); err != nil {
+		return nil, err
+	}
+
+	var captionCandidates []string
+	var vectorRanking []string
+	if opts.Type != models.NodeTypeFolder {
+		// Lexical caption matches, looked up through caption_embeddings
+		matched, err := findCaptionMatches(ctx, opts.OwnerID, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for noteID, caption := range matched {
+			captions[noteID] = caption
+			captionCandidates = append(captionCandidates, noteID)
+		}
+
+		// Semantic caption matches; search degrades to lexical-only when unavailable
+		vectorRanking = semanticCaptionMatches(opts.Query, opts.OwnerID, captions, passages)
+
+		noteIDs := make([]primitive.ObjectID, 0, len(captionCandidates)+len(vectorRanking))
+		for _, noteID := range append(captionCandidates, vectorRanking...) {
+			if objID, err := primitive.ObjectIDFromHex(noteID); err == nil {
+				noteIDs = append(noteIDs, objID)
+			}
+		}
+		if len(noteIDs) > 0 {
+			captionFilter := withFilter(base, bson.M{
+				"_id":           bson.M{"$in": noteIDs},
+				"metadata.type": models.NodeTypeNote,
+			})
+			if err := collectNodes(ctx, captionFilter, nodes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Name-matched notes are scored on their captions too
+	var missing []string
+	for id, node := range nodes {
+		if node.Metadata.Type == models.NodeTypeNote {
+			if _, ok := captions[id.Hex()]; !ok {
+				missing = append(missing, id.Hex())
+			}
+		}
+	}
+	if len(missing) > 0 {
+		extra, err := getCaptionsForNotes(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for noteID, caption := range extra {
+			captions[noteID] = caption
+		}
+	}
+
+	corpusSize, err := getVectorCollection().CountDocuments(ctx, bson.M{"ownerId": opts.OwnerID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count captions: %w", err)
+	}
+
+	rankings := rankSearchCandidates(terms, nodes, captions, int(corpusSize), vectorRanking)
+	fused, sources := reciprocalRankFusion(rankings)
+
+	tokens := tokenize(opts.Query)
+	hits := make([]SearchHit, 0, len(fused))
+	for id, node := range nodes {
+		key := id.Hex()
+		if fused[key] == 0 {
+			continue
+		}
+		if opts.MasteryLevel != "" && NodeMasteryLevel(node) != opts.MasteryLevel {
+			continue
+		}
+
+		// Normalize against the best score this node type can reach, so an exact
+		// folder name and a note ranked first everywhere both score 1.0
+		possible := 1
+		if node.Metadata.Type == models.NodeTypeNote {
+			possible = 2
+			if vectorRanking != nil {
+				possible = 3
+			}
+		}
+
+		caption := captions[key]
+		hit := SearchHit{
+			Node:      node,
+			Caption:   caption,
+			Score:     math.Min(fused[key]/maxFusedScore(possible), 1),
+			MatchedBy: sources[key],
+		}
+		if passage, ok := passages[key]; ok {
+			hit.Passage = &passage
+		}
+		if caption != "" {
+			hit.Snippet = searchSnippet(caption, hit.Passage, tokens)
+		}
+		hits = append(hits, hit)
+	}
+
+	sortSearchHits(hits, opts.Sort, opts.Ascending)
+	return &SearchResults{Hits: paginateHits(hits, opts.Offset, opts.Limit), Total: len(hits)}, nil
+}
+
+// rankSearchCandidates builds the rankings fused into the final relevance:
+// node names by lexical score, captions by BM25 and, when available, the semantic ranking
+func rankSearchCandidates(terms []string, nodes map[primitive.ObjectID]models.Node, captions map[string]string, corpusSize int, vectorRanking []string) map[string][]string {
+	nameScores := make(map[string]float64)
+	captionDocs := make(map[string]string)
+	for id, node := range nodes {
+		key := id.Hex()
+		nameScores[key] = lexicalScore(terms, node.Name)
+		if node.Metadata.Type == models.NodeTypeNote && captions[key] != "" {
+			captionDocs[key] = captions[key]
+		}
+	}
+
+	// BM25 over the candidate captions. Candidates that only matched a term as a
+	// substring (e.g. "cell" in "cells") keep a small score so they rank last rather than vanish.
+	queryTokens := tokenize(strings.Join(terms, " "))
+	index := newBM25Index(captionDocs, corpusSize)
+	captionScores := make(map[string]float64, len(captionDocs))
+	for id, caption := range captionDocs {
+		score := index.score(id, queryTokens)
+		if score == 0 {
+			score = 0.01 * termCoverage(terms, strings.ToLower(caption))
+		}
+		captionScores[id] = score
+	}
+
+	rankings := map[string][]string{
+		MatchName:    rankIDs(nameScores),
+		MatchCaption: rankIDs(captionScores),
+	}
+
+	if vectorRanking != nil {
+		semantic := make([]string, 0, len(vectorRanking))
+		for _, id := range vectorRanking {
+			if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+				if _, ok := nodes[objID]; ok {
+					semantic = append(semantic, id)
+				}
+			}
+		}
+		rankings[MatchSemantic] = semantic
+	}
+	return rankings
+}
+
+// semanticCaptionMatches returns note IDs of the owner's captions nearest to the query,
+// best first, adding their captions to captions and their best chunk to passages. It
+// returns nil when vector search is unavailable (no AI provider or no vector index) so
+// callers can fall back to lexical search.
+func semanticCaptionMatches(query, ownerID string, captions map[string]string, passages map[string]TextRange) []string {
+	if !isClientInitialized() {
+		return nil
+	}
+
+	results, err := SearchSimilarCaptions(query, searchVectorLimit, ownerID)
+	if err != nil {
+		log.Printf("[SearchService] Vector search unavailable, using lexical search only: %v", err)
+		return nil
+	}
+
+	ranking := make([]string, 0, len(results))
+	for _, result := range results {
+		if result.Score < searchVectorMinScore {
+			continue
+		}
+		if _, ok := captions[result.NoteID]; !ok {
+			captions[result.NoteID] = result.Caption
+		}
+		if result.ChunkEnd > result.ChunkStart {
+			passages[result.NoteID] = TextRange{Start: result.ChunkStart, End: result.ChunkEnd, Page: result.ChunkPage}
+		}
+		ranking = append(ranking, result.NoteID)
+	}
+	return ranking
+}
+
+// checkSubtreeRoot checks that folderID is one of the owner's live folders
+func checkSubtreeRoot(ctx context.Context, folderID, ownerID string) error {
+	if !primitive.IsValidObjectID(folderID) {
+		return ErrNodeNotFound
+	}
+	root, err := GetNodeByID(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	if root.OwnerID != ownerID || root.DeletedAt != nil {
+		return ErrNodeNotFound
+	}
+	if root.Metadata.Type != models.NodeTypeFolder {
+		return ErrInvalidNodeType
+	}
+	return nil
+}
+
+// collectNodes adds the nodes matching filter to nodes
+func collectNodes(ctx context.Context, filter bson.M, nodes map[primitive.ObjectID]models.Node) error {
+	opts := options.Find().SetLimit(searchCandidateLimit)
+	cursor, err := GetNodesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("failed to search nodes: %w", err)
+	}
+
+	var found []models.Node
+	if err := cursor.All(ctx, &found); err != nil {
+		return fmt.Errorf("failed to decode nodes: %w", err)
+	}
+	for _, node := range found {
+		nodes[node.ID] = node
+	}
+	return nil
+}
+
+// findCaptionMatches returns the captions of the owner's notes matching pattern, keyed by note ID
+func findCaptionMatches(ctx context.Context, ownerID, pattern string) (map[string]string, error) {
+	filter := bson.M{
+		"ownerId": ownerID,
+		"caption": bson.M{"$regex": pattern, "$options": "i"},
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"noteId": 1, "caption": 1}).
+		SetLimit(searchCandidateLimit)
+
+	cursor, err := getVectorCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search captions: %w", err)
+	}
+
+	var embeddings []models.CaptionEmbedding
+	if err := cursor.All(ctx, &embeddings); err != nil {
+		return nil, fmt.Errorf("failed to decode captions: %w", err)
+	}
+
+	captions := make(map[string]string, len(embeddings))
+	for _, embedding := range embeddings {
+		captions[embedding.NoteID] = embedding.Caption
+	}
+	return captions, nil
+}
+
+// withFilter returns a copy of base with extra conditions added
+func withFilter(base, extra bson.M) bson.M {
+	filter := make(bson.M, len(base)+len(extra))
+	for k, v := range base {
+		filter[k] = v
+	}
+	for k, v := range extra {
+		filter[k] = v
+	}
+	return filter
+}
+
+// searchTerms splits a query into distinct lowercase terms
+func searchTerms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// termsPattern builds a regex matching any of the terms literally
+func termsPattern(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	return strings.Join(quoted, "|")
+}
+
+// lexicalScore rates how well a node name matches the query terms (0-1): an exact
+// name is 1.0, a name starting with the query 0.9, a name containing it 0.8, otherwise
+// 0.6 scaled by the share of terms found. Captions are ranked by BM25 instead.
+func lexicalScore(terms []string, name string) float64 {
+	phrase := strings.Join(terms, " ")
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+
+	switch {
+	case name == "":
+		return 0
+	case name == phrase:
+		return 1.0
+	case strings.HasPrefix(name, phrase):
+		return 0.9
+	case strings.Contains(name, phrase):
+		return 0.8
+	default:
+		return 0.6 * termCoverage(terms, name)
+	}
+}
+
+// termCoverage is the fraction of terms that occur in text
+func termCoverage(terms []string, text string) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+	found := 0
+	for _, term := range terms {
+		if strings.Contains(text, term) {
+			found++
+		}
+	}
+	return float64(found) / float64(len(terms))
+}
+
+// sortSearchHits orders hits by the requested key (descending unless ascending is set),
+// breaking ties by relevance, then recency, then ID so pages are stable
+func sortSearchHits(hits []SearchHit, by SearchSort, ascending bool) {
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+
+		var c int
+		switch by {
+		case SearchSortDate:
+			c = a.Node.CreatedAt.Compare(b.Node.CreatedAt)
+		case SearchSortMastery:
+			c = cmp.Compare(a.Node.Mastery.MasteryPercent, b.Node.Mastery.MasteryPercent)
+		default:
+			c = cmp.Compare(a.Score, b.Score)
+		}
+		if c != 0 {
+			if ascending {
+				return c < 0
+			}
+			return c > 0
+		}
+
+		if c = cmp.Compare(a.Score, b.Score); c != 0 {
+			return c > 0
+		}
+		if c = a.Node.CreatedAt.Compare(b.Node.CreatedAt); c != 0 {
+			return c > 0
+		}
+		return a.Node.ID.Hex() < b.Node.ID.Hex()
+	})
+}
+
+// paginateHits returns the hits in [offset, offset+limit)
+func paginateHits(hits []SearchHit, offset, limit int) []SearchHit {
+	if limit <= 0 {
+		limit = SearchDefaultLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(hits) {
+		return []SearchHit{}
+	}
+	end := offset + limit
+	if end > len(hits) {
+		end = len(hits)
+	}
+	return hits[offset:end]
+}
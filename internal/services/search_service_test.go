@@ -0,0 +1,127 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestLexicalScore(t *testing.T) {
+	terms := searchTerms("Cell Biology")
+
+	exact := lexicalScore(terms, "cell biology")
+	prefix := lexicalScore(terms, "Cell Biology 101")
+	contains := lexicalScore(terms, "Intro to cell biology")
+	partial := lexicalScore(terms, "Cell diagrams")
+	none := lexicalScore(terms, "Lecture 3")
+
+	if exact != 1 {
+		t.Errorf("exact name score = %v, want 1", exact)
+	}
+	if !(exact > prefix && prefix > contains && contains > partial && partial > 0) {
+		t.Errorf("name scores not ordered: exact %v, prefix %v, contains %v, partial %v", exact, prefix, contains, partial)
+	}
+	if none != 0 {
+		t.Errorf("unrelated score = %v, want 0", none)
+	}
+	if empty := lexicalScore(terms, ""); empty != 0 {
+		t.Errorf("empty name score = %v, want 0", empty)
+	}
+}
+
+func TestTermsPatternEscapesRegex(t *testing.T) {
+	if got := termsPattern(searchTerms("c++ (intro)  c++")); got != `c\+\+|\(intro\)` {
+		t.Errorf("termsPattern() = %q", got)
+	}
+}
+
+func TestParseSearchFilters(t *testing.T) {
+	if s, err := ParseSearchSort(""); err != nil || s != SearchSortRelevance {
+		t.Errorf("ParseSearchSort(\"\") = %v, %v, want relevance", s, err)
+	}
+	if s, err := ParseSearchSort("Mastery"); err != nil || s != SearchSortMastery {
+		t.Errorf("ParseSearchSort(Mastery) = %v, %v", s, err)
+	}
+	if _, err := ParseSearchSort("popularity"); err != ErrInvalidSearchSort {
+		t.Errorf("ParseSearchSort(popularity) error = %v, want ErrInvalidSearchSort", err)
+	}
+
+	levels := map[string]string{
+		"":            "",
+		"mastered":    "Mastered",
+		"Learnt":      "Learnt",
+		"review_soon": "Review Soon",
+		"Review Soon": "Review Soon",
+	}
+	for in, want := range levels {
+		if got, err := ParseMasteryLevel(in); err != nil || got != want {
+			t.Errorf("ParseMasteryLevel(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseMasteryLevel("expert"); err != ErrInvalidMasteryFilter {
+		t.Errorf("ParseMasteryLevel(expert) error = %v, want ErrInvalidMasteryFilter", err)
+	}
+}
+
+func TestSortAndPaginateSearchHits(t *testing.T) {
+	now := time.Now()
+	hit := func(score, mastery float64, age time.Duration) SearchHit {
+		return SearchHit{
+			Node: models.Node{
+				ID:        primitive.NewObjectID(),
+				CreatedAt: now.Add(-age),
+				Mastery:   models.NodeMastery{MasteryPercent: mastery},
+			},
+			Score: score,
+		}
+	}
+	a := hit(0.9, 0.2, 3*time.Hour)
+	b := hit(0.5, 1.0, 1*time.Hour)
+	c := hit(0.7, 0.5, 2*time.Hour)
+
+	order := func(hits []SearchHit) []primitive.ObjectID {
+		ids := make([]primitive.ObjectID, len(hits))
+		for i, h := range hits {
+			ids[i] = h.Node.ID
+		}
+		return ids
+	}
+	check := func(name string, got []SearchHit, want ...SearchHit) {
+		t.Helper()
+		g, w := order(got), order(want)
+		for i := range w {
+			if i >= len(g) || g[i] != w[i] {
+				t.Errorf("%s order = %v, want %v", name, g, w)
+				return
+			}
+		}
+	}
+
+	hits := []SearchHit{a, b, c}
+	sortSearchHits(hits, SearchSortRelevance, false)
+	check("relevance", hits, a, c, b)
+
+	sortSearchHits(hits, SearchSortDate, false)
+	check("date", hits, b, c, a)
+
+	sortSearchHits(hits, SearchSortMastery, true)
+	check("mastery asc", hits, a, c, b)
+
+	check("page 2", paginateHits(hits, 2, 2), b)
+	if got := paginateHits(hits, 5, 2); len(got) != 0 {
+		t.Errorf("paginateHits() past the end returned %d hits", len(got))
+	}
+}
+
+func TestNodeMasteryLevelFallsBackToPercent(t *testing.T) {
+	if got := NodeMasteryLevel(models.Node{}); got != "Review Soon" {
+		t.Errorf("NodeMasteryLevel() for a new node = %q, want Review Soon", got)
+	}
+	node := models.Node{Mastery: models.NodeMastery{MasteryLevel: "Learnt", MasteryPercent: 0.5}}
+	if got := NodeMasteryLevel(node); got != "Learnt" {
+		t.Errorf("NodeMasteryLevel() = %q, want Learnt", got)
+	}
+}
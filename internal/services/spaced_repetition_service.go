@@ -9,7 +9,6 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"cogniscan/backend/internal/database"
 	"cogniscan/backend/internal/models"
 )
 
@@ -22,9 +21,9 @@ const (
 	QualityEasy  AnswerQuality = 5 // Perfect, quick response
 )
 
-// GetReviewCollection returns the note_reviews collection
+// GetReviewCollection returns the note_reviews collection shared with mastery tracking
 func GetReviewCollection() *mongo.Collection {
-	return database.Client.Database("cogniscan").Collection("note_reviews")
+	return GetNoteReviewsCollection()
 }
 
 // SM-2 Algorithm: CalculateNextReview
@@ -160,17 +159,25 @@ func ProcessQuestionAnswer(ctx context.Context, question *models.Question, userI
 	return nil
 }
 
-// GetReviewQueue returns notes due for review
-func GetReviewQueue(ctx context.Context, userID string, limit int) ([]models.NoteReview, error) {
+// GetReviewQueue returns notes due for review, limited to a tag or collection scope
+// unless the scope is zero
+func GetReviewQueue(ctx context.Context, userID string, scope Scope, limit int) ([]models.NoteReview, error) {
 	collection := GetReviewCollection()
 	now := time.Now()
 
-	filter := bson.M{
+	filter := ExcludeTrashed(bson.M{
 		"userId": userID,
 		"$or": []bson.M{
 			{"toReview": true},
 			{"nextReview": bson.M{"$lte": now}},
 		},
+	})
+	if !scope.IsZero() {
+		noteIDs, err := ScopeNoteIDs(ctx, userID, scope)
+		if err != nil {
+			return nil, err
+		}
+		filter["noteId"] = bson.M{"$in": noteIDs}
 	}
 
 	opts := options.Find().
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultStorageQuotaBytes applies when neither the user nor STORAGE_QUOTA_BYTES sets a quota
+const DefaultStorageQuotaBytes int64 = 10 << 30
+
+// StorageRootFolder is the per-folder usage key of notes at the top level
+const StorageRootFolder = "root"
+
+var ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+
+// GetUserProgressCollection returns the user_progress collection
+func GetUserProgressCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection("user_progress")
+}
+
+// NotePageBytes returns the stored size of a page's blobs
+func NotePageBytes(page models.NotePage) int64 {
+	total := page.ImageBytes + page.OriginalBytes
+	for _, size := range page.ThumbnailBytes {
+		total += size
+	}
+	return total
+}
+
+// NotePagesBytes returns the stored size of every blob of the given pages
+func NotePagesBytes(pages []models.NotePage) int64 {
+	var total int64
+	for _, page := range pages {
+		total += NotePageBytes(page)
+	}
+	return total
+}
+
+// StorageQuotaBytes returns a user's quota: their own if set, else STORAGE_QUOTA_BYTES,
+// else DefaultStorageQuotaBytes
+func StorageQuotaBytes(progress *models.UserProgress) int64 {
+	if progress != nil && progress.StorageQuotaBytes > 0 {
+		return progress.StorageQuotaBytes
+	}
+	if quota, err := strconv.ParseInt(os.Getenv("STORAGE_QUOTA_BYTES"), 10, 64); err == nil && quota > 0 {
+		return quota
+	}
+	return DefaultStorageQuotaBytes
+}
+
+// GetStorageUsage returns a user's recorded usage, with a zero usage when they have
+// no progress document yet
+func GetStorageUsage(ctx context.Context, ownerID string) (*models.UserProgress, error) {
+	var progress models.UserProgress
+	err := GetUserProgressCollection().FindOne(ctx, bson.M{"userId": ownerID}).Decode(&progress)
+	if err == mongo.ErrNoDocuments {
+		return &models.UserProgress{UserID: ownerID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch storage usage: %w", err)
+	}
+	return &progress, nil
+}
+
+// CheckStorageQuota fails with ErrStorageQuotaExceeded if storing incoming more bytes
+// would take the user over their quota
+func CheckStorageQuota(ctx context.Context, ownerID string, incoming int64) error {
+	progress, err := GetStorageUsage(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	if progress.StorageUsedBytes+incoming > StorageQuotaBytes(progress) {
+		return ErrStorageQuotaExceeded
+	}
+	return nil
+}
+
+// ReserveStorage adds bytes stored in a folder to the user's usage, failing with
+// ErrStorageQuotaExceeded instead if that would take them over their quota. The
+// check and the increment are one write, so concurrent uploads cannot both slip under.
+func ReserveStorage(ctx context.Context, ownerID, folderID string, bytes int64) error {
+	if bytes <= 0 {
+		return nil
+	}
+	progress, err := GetStorageUsage(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	limit := StorageQuotaBytes(progress) - bytes
+	if limit < 0 {
+		return ErrStorageQuotaExceeded
+	}
+
+	filter := bson.M{"userId": ownerID, "$or": bson.A{
+		bson.M{"storageUsedBytes": bson.M{"$lte": limit}},
+		bson.M{"storageUsedBytes": bson.M{"$exists": false}},
+	}}
+	result, err := GetUserProgressCollection().UpdateOne(ctx, filter, storageUsageUpdate(folderID, bytes))
+	if err != nil {
+		return fmt.Errorf("failed to update storage usage: %w", err)
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	if progress.ID.IsZero() {
+		// First upload: there is no progress document to hold the usage yet
+		return ChangeStorageUsage(ctx, ownerID, folderID, bytes)
+	}
+	return ErrStorageQuotaExceeded
+}
+
+// ChangeStorageUsage adds delta bytes (negative when blobs are deleted) to the user's
+// usage and to that of the folder they are stored in
+func ChangeStorageUsage(ctx context.Context, ownerID, folderID string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	opts := options.Update().SetUpsert(true)
+	if _, err := GetUserProgressCollection().UpdateOne(ctx, bson.M{"userId": ownerID}, storageUsageUpdate(folderID, delta), opts); err != nil {
+		return fmt.Errorf("failed to update storage usage: %w", err)
+	}
+	return nil
+}
+
+// MoveStorageUsage moves a note's bytes from one folder's usage to another's
+func MoveStorageUsage(ctx context.Context, ownerID, fromFolderID, toFolderID string, bytes int64) error {
+	from, to := storageFolderKey(fromFolderID), storageFolderKey(toFolderID)
+	if bytes == 0 || from == to {
+		return nil
+	}
+	update := bson.M{
+		"$inc": bson.M{"storageByFolder." + from: -bytes, "storageByFolder." + to: bytes},
+		"$set": bson.M{"updatedAt": time.Now()},
+	}
+	if _, err := GetUserProgressCollection().UpdateOne(ctx, bson.M{"userId": ownerID}, update); err != nil {
+		return fmt.Errorf("failed to move storage usage: %w", err)
+	}
+	return nil
+}
+
+// CorrectStorageUsage adds corrections to a user's total and per-folder usage (keyed
+// as storageFolderKey does). Being increments, they keep any change recorded after
+// the usage they correct was read.
+func CorrectStorageUsage(ctx context.Context, ownerID string, total int64, byFolder map[string]int64) error {
+	now := time.Now()
+	inc := bson.M{"storageUsedBytes": total}
+	for folderKey, delta := range byFolder {
+		inc["storageByFolder."+folderKey] = delta
+	}
+	update := bson.M{
+		"$inc":         inc,
+		"$set":         bson.M{"updatedAt": now},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+	if _, err := GetUserProgressCollection().UpdateOne(ctx, bson.M{"userId": ownerID}, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("failed to correct storage usage: %w", err)
+	}
+	return nil
+}
+
+// logStorageChange applies ChangeStorageUsage, logging instead of failing: the blobs
+// are already stored or gone, and the tree checker corrects any drift
+func logStorageChange(ctx context.Context, ownerID, folderID string, delta int64) {
+	if err := ChangeStorageUsage(ctx, ownerID, folderID, delta); err != nil {
+		log.Printf("[StorageUsage] Failed to record %d bytes for %s: %v", delta, ownerID, err)
+	}
+}
+
+// storageUsageUpdate increments the total and per-folder usage by delta
+func storageUsageUpdate(folderID string, delta int64) bson.M {
+	now := time.Now()
+	return bson.M{
+		"$inc":         bson.M{"storageUsedBytes": delta, "storageByFolder." + storageFolderKey(folderID): delta},
+		"$set":         bson.M{"updatedAt": now},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+}
+
+// storageFolderKey returns the per-folder usage key of a parent ID
+func storageFolderKey(folderID string) string {
+	if folderID == "" {
+		return StorageRootFolder
+	}
+	return folderID
+}
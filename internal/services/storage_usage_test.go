@@ -0,0 +1,41 @@
+package services
+
+import (
+	"testing"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestNotePagesBytes(t *testing.T) {
+	pages := []models.NotePage{
+		{ImageBytes: 1000, OriginalBytes: 4000, ThumbnailBytes: map[string]int64{"small": 30, "medium": 200}},
+		{Caption: "Read from a PDF's text layer"},
+		{ImageBytes: 500},
+	}
+	if got := NotePageBytes(pages[0]); got != 5230 {
+		t.Errorf("NotePageBytes() = %d, want 5230", got)
+	}
+	if got := NotePagesBytes(pages); got != 5730 {
+		t.Errorf("NotePagesBytes() = %d, want 5730", got)
+	}
+}
+
+func TestStorageQuotaBytes(t *testing.T) {
+	t.Setenv("STORAGE_QUOTA_BYTES", "")
+	if got := StorageQuotaBytes(nil); got != DefaultStorageQuotaBytes {
+		t.Errorf("StorageQuotaBytes(nil) = %d, want the default", got)
+	}
+
+	t.Setenv("STORAGE_QUOTA_BYTES", "1048576")
+	if got := StorageQuotaBytes(&models.UserProgress{}); got != 1<<20 {
+		t.Errorf("StorageQuotaBytes() = %d, want STORAGE_QUOTA_BYTES", got)
+	}
+	if got := StorageQuotaBytes(&models.UserProgress{StorageQuotaBytes: 5 << 20}); got != 5<<20 {
+		t.Errorf("StorageQuotaBytes() = %d, want the user's own quota", got)
+	}
+
+	t.Setenv("STORAGE_QUOTA_BYTES", "lots")
+	if got := StorageQuotaBytes(nil); got != DefaultStorageQuotaBytes {
+		t.Errorf("StorageQuotaBytes(nil) = %d, want the default for an invalid setting", got)
+	}
+}
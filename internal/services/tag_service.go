@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxTagLength   = 50
+	maxTagsPerNode = 20
+)
+
+var (
+	ErrInvalidTag  = errors.New("tags are 1-50 letters, digits, '-' or '_'")
+	ErrTooManyTags = fmt.Errorf("a node can have at most %d tags", maxTagsPerNode)
+)
+
+// TagCount is a tag and how many of the owner's live nodes carry it
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// NormalizeTag lowercases a tag, drops a leading '#' and turns spaces into '-'
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if tag == "" || len(tag) > maxTagLength {
+		return "", ErrInvalidTag
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return "", ErrInvalidTag
+		}
+	}
+	return tag, nil
+}
+
+// NormalizeTags normalizes a list of tags and drops duplicates
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		t, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+	return normalized, nil
+}
+
+// InitTagService creates the indexes used to find nodes by tag and list collections
+func InitTagService(ctx context.Context) error {
+	_, err := GetNodesCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "tags", Value: 1}},
+		Options: options.Index().SetName("owner_tags_index"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create node tag index: %w", err)
+	}
+
+	_, err = GetSmartCollectionsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetName("owner_name_index"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create smart collection index: %w", err)
+	}
+	return nil
+}
+
+// TagNode adds tags to one of the owner's live nodes and returns the updated node
+func TagNode(ctx context.Context, nodeID, ownerID string, tags []string) (*models.Node, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, ErrInvalidTag
+	}
+	if len(tags) > maxTagsPerNode {
+		return nil, ErrTooManyTags
+	}
+
+	filter := ExcludeTrashed(NodeIDFilter(nodeID))
+	filter["ownerId"] = ownerID
+	// The size check keeps concurrent tagging from going over the limit
+	filter[fmt.Sprintf("tags.%d", maxTagsPerNode-len(tags))] = bson.M{"$exists": false}
+
+	update := bson.M{
+		"$addToSet": bson.M{"tags": bson.M{"$each": tags}},
+		"$set":      bson.M{"updatedAt": time.Now()},
+	}
+	node, err := updateTaggedNode(ctx, filter, update)
+	if err == ErrNodeNotFound {
+		// Tell a full tag list apart from a missing node
+		if _, lookupErr := getOwnedLiveNode(ctx, nodeID, ownerID); lookupErr == nil {
+			return nil, ErrTooManyTags
+		}
+	}
+	return node, err
+}
+
+// UntagNode removes a tag from one of the owner's live nodes and returns the updated node
+func UntagNode(ctx context.Context, nodeID, ownerID, tag string) (*models.Node, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := ExcludeTrashed(NodeIDFilter(nodeID))
+	filter["ownerId"] = ownerID
+	update := bson.M{
+		"$pull": bson.M{"tags": tag},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}
+	return updateTaggedNode(ctx, filter, update)
+}
+
+// updateTaggedNode applies a tag update and returns the node after it
+func updateTaggedNode(ctx context.Context, filter, update bson.M) (*models.Node, error) {
+	var node models.Node
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := GetNodesCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&node)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to update tags: %w", err)
+	}
+	return &node, nil
+}
+
+// getOwnedLiveNode returns one of the owner's nodes that is not in the trash
+func getOwnedLiveNode(ctx context.Context, nodeID, ownerID string) (*models.Node, error) {
+	filter := ExcludeTrashed(NodeIDFilter(nodeID))
+	filter["ownerId"] = ownerID
+
+	var node models.Node
+	if err := GetNodesCollection().FindOne(ctx, filter).Decode(&node); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch node: %w", err)
+	}
+	return &node, nil
+}
+
+// ListTags returns the owner's tags with the number of live nodes carrying each,
+// most used first
+func ListTags(ctx context.Context, ownerID string) ([]TagCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: ExcludeTrashed(bson.M{"ownerId": ownerID, "tags.0": bson.M{"$exists": true}})}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := GetNodesCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	tags := []TagCount{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, fmt.Errorf("failed to decode tags: %w", err)
+	}
+	return tags, nil
+}
+
+// GetNodesByTag returns the owner's live nodes carrying tag directly, newest first
+func GetNodesByTag(ctx context.Context, ownerID, tag string) ([]models.Node, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := GetNodesCollection().Find(ctx, ExcludeTrashed(bson.M{"ownerId": ownerID, "tags": tag}), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tagged nodes: %w", err)
+	}
+
+	nodes := []models.Node{}
+	if err := cursor.All(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to decode tagged nodes: %w", err)
+	}
+	return nodes, nil
+}
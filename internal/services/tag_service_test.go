@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	valid := map[string]string{
+		"Biology":          "biology",
+		"#exam-prep":       "exam-prep",
+		"  cell  biology ": "cell-biology",
+		"año_2":            "año_2",
+	}
+	for in, want := range valid {
+		if got, err := NormalizeTag(in); err != nil || got != want {
+			t.Errorf("NormalizeTag(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "#", "a/b", "c++", strings.Repeat("x", maxTagLength+1)} {
+		if _, err := NormalizeTag(in); err != ErrInvalidTag {
+			t.Errorf("NormalizeTag(%q) error = %v, want ErrInvalidTag", in, err)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := NormalizeTags([]string{"Math", "#math", "physics"})
+	if err != nil {
+		t.Fatalf("NormalizeTags() error = %v", err)
+	}
+	if want := []string{"math", "physics"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeTags() = %v, want %v", got, want)
+	}
+	if _, err := NormalizeTags([]string{"ok", "not ok!"}); err != ErrInvalidTag {
+		t.Errorf("NormalizeTags() error = %v, want ErrInvalidTag", err)
+	}
+}
+
+func TestNormalizeCollectionFilter(t *testing.T) {
+	got, err := NormalizeCollectionFilter(models.CollectionFilter{Tags: []string{"#Exam"}, MasteryLevel: "review_soon"})
+	if err != nil {
+		t.Fatalf("NormalizeCollectionFilter() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.Tags, []string{"exam"}) || got.MasteryLevel != "Review Soon" {
+		t.Errorf("NormalizeCollectionFilter() = %+v", got)
+	}
+
+	if _, err := NormalizeCollectionFilter(models.CollectionFilter{CaptionText: "   "}); err != ErrEmptyCollectionQuery {
+		t.Errorf("empty filter error = %v, want ErrEmptyCollectionQuery", err)
+	}
+
+	after := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := after.Add(-24 * time.Hour)
+	_, err = NormalizeCollectionFilter(models.CollectionFilter{CreatedAfter: &after, CreatedBefore: &before})
+	if err != ErrInvalidDateRange {
+		t.Errorf("inverted range error = %v, want ErrInvalidDateRange", err)
+	}
+}
+
+func TestScopeFilterConflictingScope(t *testing.T) {
+	if _, err := ScopeFilter(context.Background(), "owner", Scope{Tag: "a", CollectionID: "b"}); err != ErrConflictingScope {
+		t.Errorf("ScopeFilter() error = %v, want ErrConflictingScope", err)
+	}
+	if filter, err := ScopeFilter(context.Background(), "owner", Scope{}); filter != nil || err != nil {
+		t.Errorf("ScopeFilter(zero) = %v, %v, want nil, nil", filter, err)
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ThumbnailSizes are the thumbnail sizes stored for each page image, by the length of
+// their longest side
+var ThumbnailSizes = map[string]int{
+	"small":  256,
+	"medium": 768,
+}
+
+const thumbnailJPEGQuality = 80
+
+var ErrInvalidThumbnailSize = errors.New("size must be small, medium or full")
+
+// makeThumbnail scales an image down to fit maxSide and encodes it as JPEG
+func makeThumbnail(img image.Image, maxSide int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flattenAndFit(img, maxSide), &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// storeThumbnails stores every thumbnail size of a page image and returns their blob
+// IDs and sizes in bytes, by size name
+func storeThumbnails(ctx context.Context, base string, img image.Image) (map[string]string, map[string]int64, error) {
+	thumbnails := make(map[string]string, len(ThumbnailSizes))
+	sizes := make(map[string]int64, len(ThumbnailSizes))
+	for size, maxSide := range ThumbnailSizes {
+		id, n, err := storeThumbnail(ctx, fmt.Sprintf("%s-%s.jpg", base, size), img, maxSide)
+		if err != nil {
+			DeleteNotePageBlobs([]models.NotePage{{Thumbnails: thumbnails}})
+			return nil, nil, err
+		}
+		thumbnails[size], sizes[size] = id, n
+	}
+	return thumbnails, sizes, nil
+}
+
+// storeThumbnail makes and uploads one thumbnail, returning its blob ID and size
+func storeThumbnail(ctx context.Context, name string, img image.Image, maxSide int) (string, int64, error) {
+	data, err := makeThumbnail(img, maxSide)
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := UploadBlob(ctx, name, bytes.NewReader(data))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to upload thumbnail: %w", err)
+	}
+	return id, int64(len(data)), nil
+}
+
+// NotePageThumbnail returns the blob of a page's thumbnail. Pages stored before
+// thumbnails existed get it made from their image and recorded on first request.
+func NotePageThumbnail(ctx context.Context, node *models.Node, page *models.NotePage, size string) (string, error) {
+	maxSide, ok := ThumbnailSizes[size]
+	if !ok {
+		return "", ErrInvalidThumbnailSize
+	}
+	if id := page.Thumbnails[size]; id != "" {
+		return id, nil
+	}
+
+	// A note from before pages existed needs a page ID to record the thumbnail on
+	if page.ID == "" {
+		converted, err := ensureNotePages(ctx, node)
+		if err != nil {
+			return "", err
+		}
+		page = &converted.Pages[0]
+	}
+
+	blob, err := DownloadBlob(ctx, page.DriveID)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(blob.Body, MaxImageBytes+1))
+	blob.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+	// Older pages hold the upload as received, so they are normalised first
+	prepared, err := PreprocessImage(data)
+	if err != nil {
+		return "", err
+	}
+	id, n, err := storeThumbnail(ctx, fmt.Sprintf("%s-%s.jpg", page.ID, size), prepared.Image, maxSide)
+	if err != nil {
+		return "", err
+	}
+
+	// A concurrent request may have recorded one first; keep that and drop ours
+	field := "thumbnails." + size
+	filter := NodeIDFilter(node.ID.Hex())
+	filter["pages"] = bson.M{"$elemMatch": bson.M{"id": page.ID, field: bson.M{"$exists": false}}}
+	update := bson.M{"$set": bson.M{"pages.$." + field: id, "pages.$.thumbnailBytes." + size: n}}
+	result, err := GetNodesCollection().UpdateOne(ctx, filter, update)
+	if err == nil && result.MatchedCount > 0 {
+		log.Printf("[Thumbnails] Made %s thumbnail for page %s of note %s", size, page.ID, node.ID.Hex())
+		// Derived copies count towards usage but are not refused over quota
+		logStorageChange(ctx, node.OwnerID, node.ParentID, n)
+		return id, nil
+	}
+	DeleteNotePageBlobs([]models.NotePage{{Thumbnails: map[string]string{size: id}}})
+	if err != nil {
+		return "", fmt.Errorf("failed to record thumbnail: %w", err)
+	}
+
+	latest, err := GetNodeByID(ctx, node.ID.Hex())
+	if err != nil {
+		return "", err
+	}
+	for _, p := range latest.Pages {
+		if p.ID == page.ID && p.Thumbnails[size] != "" {
+			return p.Thumbnails[size], nil
+		}
+	}
+	return "", ErrPageNotFound
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"slices"
+	"testing"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestMakeThumbnail(t *testing.T) {
+	for _, size := range [][2]int{{2000, 1000}, {600, 1800}, {100, 50}} {
+		src := image.NewRGBA(image.Rect(0, 0, size[0], size[1]))
+		data, err := makeThumbnail(src, ThumbnailSizes["small"])
+		if err != nil {
+			t.Fatalf("makeThumbnail(%v) error = %v", size, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("thumbnail is not a JPEG: %v", err)
+		}
+		longest := max(cfg.Width, cfg.Height)
+		if want := min(max(size[0], size[1]), ThumbnailSizes["small"]); longest != want {
+			t.Errorf("thumbnail of %v is %dx%d, want longest side %d", size, cfg.Width, cfg.Height, want)
+		}
+	}
+}
+
+func TestNotePageBlobIDs(t *testing.T) {
+	page := models.NotePage{
+		DriveID:         "image",
+		OriginalDriveID: "original",
+		Thumbnails:      map[string]string{"small": "thumb-s", "medium": "thumb-m"},
+	}
+	want := []string{"image", "original", "thumb-m", "thumb-s"}
+	if got := NotePageBlobIDs(page); !slices.Equal(got, want) {
+		t.Errorf("NotePageBlobIDs() = %v, want %v", got, want)
+	}
+	if got := NotePageBlobIDs(models.NotePage{Caption: "PDF text"}); len(got) != 0 {
+		t.Errorf("NotePageBlobIDs(text page) = %v, want none", got)
+	}
+}
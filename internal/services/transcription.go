@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// captionPrompt is the transcription instruction sent alongside note images
+const captionPrompt = `Transcribe this image of notes. Return only a JSON object of the form {"blocks": [...]} listing its content in reading order, each block being one of:
+{"type": "heading", "text": "...", "level": 1}
+{"type": "paragraph", "text": "..."}
+{"type": "list", "items": ["...", "..."], "ordered": false}
+{"type": "formula", "latex": "..."} - mathematics as LaTeX, without $ delimiters
+{"type": "table", "rows": [["header", "..."], ["cell", "..."]]}
+{"type": "diagram", "text": "..."} - what the diagram shows, with its labels
+Copy all visible text exactly, handwritten and typed. Do not use templates or placeholders - provide actual content only.`
+
+var (
+	headingLine  = regexp.MustCompile(`^(#{1,6})\s+(.+)$`)
+	listItemLine = regexp.MustCompile(`^\s*(?:([-*•])|(\d+)[.)])\s+(.+)$`)
+	tableRule    = regexp.MustCompile(`^\|?[\s:|-]+\|?$`)
+)
+
+// PageTranscription is one page of a note's transcription
+type PageTranscription struct {
+	PageID        string               `json:"pageId,omitempty"`
+	Number        int                  `json:"number"`
+	CaptionStatus models.CaptionStatus `json:"captionStatus,omitempty"`
+	// Structured is false when the blocks were recovered from a plain-text caption
+	// stored before transcriptions were
+	Structured bool                        `json:"structured"`
+	Blocks     []models.TranscriptionBlock `json:"blocks"`
+}
+
+// rawTranscriptionBlock is a block as a model returns it, before its values are checked
+type rawTranscriptionBlock struct {
+	Type    string  `json:"type"`
+	Text    string  `json:"text"`
+	Level   int     `json:"level"`
+	LaTeX   string  `json:"latex"`
+	Items   []any   `json:"items"`
+	Ordered bool    `json:"ordered"`
+	Rows    [][]any `json:"rows"`
+}
+
+// ParseTranscription reads a transcription returned by the vision model. Models that
+// ignore the requested JSON get their free text split into blocks instead.
+func ParseTranscription(raw string) *models.Transcription {
+	text := strings.TrimSpace(raw)
+	if start, end := strings.IndexAny(text, "{["), strings.LastIndexAny(text, "}]"); start >= 0 && end > start {
+		// Models like to wrap JSON in a code fence or a sentence
+		body := text[start : end+1]
+		var doc struct {
+			Blocks []rawTranscriptionBlock `json:"blocks"`
+		}
+		if err := json.Unmarshal([]byte(body), &doc); err != nil {
+			doc.Blocks = nil
+			_ = json.Unmarshal([]byte(body), &doc.Blocks)
+		}
+		if t := normalizeTranscription(doc.Blocks); len(t.Blocks) > 0 {
+			return t
+		}
+	}
+	return TranscriptionFromText(text)
+}
+
+// normalizeTranscription turns model blocks into stored ones, trimming their text,
+// treating unknown types as paragraphs and dropping blocks left empty
+func normalizeTranscription(raw []rawTranscriptionBlock) *models.Transcription {
+	t := &models.Transcription{Blocks: []models.TranscriptionBlock{}}
+	for _, r := range raw {
+		block := models.TranscriptionBlock{Type: models.TranscriptionBlockType(strings.ToLower(strings.TrimSpace(r.Type)))}
+		text := strings.TrimSpace(r.Text)
+		switch block.Type {
+		case models.BlockHeading:
+			block.Text, block.Level = text, min(max(r.Level, 1), 6)
+		case models.BlockList:
+			block.Ordered = r.Ordered
+			for _, item := range r.Items {
+				if s := strings.TrimSpace(blockValue(item)); s != "" {
+					block.Items = append(block.Items, s)
+				}
+			}
+			if len(block.Items) == 0 && text != "" {
+				block.Items = strings.Split(text, "\n")
+			}
+		case models.BlockFormula:
+			block.LaTeX = strings.TrimSpace(r.LaTeX)
+			if block.LaTeX == "" {
+				block.LaTeX = strings.TrimSpace(strings.Trim(text, "$"))
+			}
+		case models.BlockTable:
+			for _, row := range r.Rows {
+				cells := make([]string, len(row))
+				for i, cell := range row {
+					cells[i] = strings.TrimSpace(blockValue(cell))
+				}
+				block.Rows = append(block.Rows, cells)
+			}
+		case models.BlockDiagram:
+			block.Text = text
+		default:
+			block.Type, block.Text = models.BlockParagraph, text
+		}
+		if block.Text != "" || block.LaTeX != "" || len(block.Items) > 0 || len(block.Rows) > 0 {
+			t.Blocks = append(t.Blocks, block)
+		}
+	}
+	return t
+}
+
+// blockValue reads a list item or table cell, which models sometimes give as numbers
+func blockValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// TranscriptionFromText splits plain text into blocks, reading the markup that
+// TranscriptionText writes: "#" headings, "-" or "1." list items, "$$" formulas,
+// "|" table rows and "[Diagram: ...]" descriptions. Other lines are paragraphs,
+// separated by blank lines.
+func TranscriptionFromText(text string) *models.Transcription {
+	t := &models.Transcription{Blocks: []models.TranscriptionBlock{}}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			t.Blocks = append(t.Blocks, models.TranscriptionBlock{Type: models.BlockParagraph, Text: strings.Join(paragraph, "\n")})
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case line == "":
+			flush()
+		case headingLine.MatchString(line):
+			flush()
+			m := headingLine.FindStringSubmatch(line)
+			t.Blocks = append(t.Blocks, models.TranscriptionBlock{Type: models.BlockHeading, Text: strings.TrimSpace(m[2]), Level: len(m[1])})
+		case strings.HasPrefix(line, "$$"):
+			flush()
+			body, next := delimitedBlock(lines, i, "$$", "$$")
+			t.Blocks = append(t.Blocks, models.TranscriptionBlock{Type: models.BlockFormula, LaTeX: body})
+			i = next
+		case strings.HasPrefix(line, "[Diagram:"):
+			flush()
+			body, next := delimitedBlock(lines, i, "[Diagram:", "]")
+			t.Blocks = append(t.Blocks, models.TranscriptionBlock{Type: models.BlockDiagram, Text: body})
+			i = next
+		case strings.HasPrefix(line, "|"):
+			flush()
+			block := models.TranscriptionBlock{Type: models.BlockTable}
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				row := strings.TrimSpace(lines[i])
+				if tableRule.MatchString(row) {
+					continue // The |---|---| line under a header
+				}
+				cells := strings.Split(strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|"), "|")
+				for j := range cells {
+					cells[j] = strings.TrimSpace(cells[j])
+				}
+				block.Rows = append(block.Rows, cells)
+			}
+			i--
+			t.Blocks = append(t.Blocks, block)
+		case listItemLine.MatchString(line):
+			flush()
+			block := models.TranscriptionBlock{Type: models.BlockList, Ordered: listItemLine.FindStringSubmatch(line)[2] != ""}
+			for ; i < len(lines); i++ {
+				m := listItemLine.FindStringSubmatch(strings.TrimSpace(lines[i]))
+				if m == nil || (m[2] != "") != block.Ordered {
+					break
+				}
+				block.Items = append(block.Items, strings.TrimSpace(m[3]))
+			}
+			i--
+			t.Blocks = append(t.Blocks, block)
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+	return t
+}
+
+// delimitedBlock returns the text between open on line i and the next close, and the
+// line the block ends on. An unclosed block runs to the end of the text.
+func delimitedBlock(lines []string, i int, open, close string) (string, int) {
+	var body []string
+	j := i
+	for ; j < len(lines); j++ {
+		line := strings.TrimSpace(lines[j])
+		if j == i {
+			line = strings.TrimPrefix(line, open)
+		}
+		if strings.HasSuffix(line, close) {
+			body = append(body, strings.TrimSuffix(line, close))
+			break
+		}
+		body = append(body, line)
+	}
+	return strings.TrimSpace(strings.Join(body, "\n")), min(j, len(lines)-1)
+}
+
+// TranscriptionText renders a transcription as the plain-text caption that is
+// embedded, searched and quizzed on. Blocks are separated by blank lines.
+func TranscriptionText(t *models.Transcription) string {
+	if t == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(t.Blocks))
+	for _, block := range t.Blocks {
+		switch block.Type {
+		case models.BlockHeading:
+			parts = append(parts, strings.Repeat("#", min(max(block.Level, 1), 6))+" "+block.Text)
+		case models.BlockList:
+			items := make([]string, len(block.Items))
+			for i, item := range block.Items {
+				if block.Ordered {
+					items[i] = fmt.Sprintf("%d. %s", i+1, item)
+				} else {
+					items[i] = "- " + item
+				}
+			}
+			parts = append(parts, strings.Join(items, "\n"))
+		case models.BlockFormula:
+			parts = append(parts, "$$"+block.LaTeX+"$$")
+		case models.BlockTable:
+			rows := make([]string, len(block.Rows))
+			for i, row := range block.Rows {
+				rows[i] = "| " + strings.Join(row, " | ") + " |"
+			}
+			parts = append(parts, strings.Join(rows, "\n"))
+		case models.BlockDiagram:
+			parts = append(parts, "[Diagram: "+block.Text+"]")
+		default:
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// SetNoteTranscription stores the transcription of a note without pages, whose
+// caption lives in caption_embeddings
+func SetNoteTranscription(ctx context.Context, noteID string, t *models.Transcription) error {
+	if _, err := GetNodesCollection().UpdateOne(ctx, NodeIDFilter(noteID), bson.M{"$set": bson.M{"transcription": t}}); err != nil {
+		return fmt.Errorf("failed to store transcription: %w", err)
+	}
+	return nil
+}
+
+// NoteTranscription returns the transcription of each page of a note. Pages
+// transcribed before transcriptions were stored have theirs read from their caption.
+func NoteTranscription(ctx context.Context, node *models.Node) ([]PageTranscription, error) {
+	pages := NotePages(node)
+	result := make([]PageTranscription, len(pages))
+	for i, page := range pages {
+		result[i] = PageTranscription{PageID: page.ID, Number: i + 1, CaptionStatus: page.CaptionStatus, Blocks: []models.TranscriptionBlock{}}
+		if page.Transcription != nil {
+			result[i].Structured, result[i].Blocks = true, page.Transcription.Blocks
+			continue
+		}
+
+		caption := page.Caption
+		if page.ID == "" && page.CaptionStatus == models.CaptionStatusCompleted {
+			// A note without pages keeps its caption in caption_embeddings
+			embedding, err := GetCaptionEmbedding(node.ID.Hex())
+			if err != nil && err != mongo.ErrNoDocuments {
+				return nil, fmt.Errorf("failed to fetch caption: %w", err)
+			}
+			if err == nil {
+				caption = embedding.Caption
+			}
+		}
+		result[i].Blocks = TranscriptionFromText(caption).Blocks
+	}
+	return result, nil
+}
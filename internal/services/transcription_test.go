@@ -0,0 +1,89 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"cogniscan/backend/internal/models"
+)
+
+func TestParseTranscription(t *testing.T) {
+	raw := "Here is the transcription:\n```json\n" + `{"blocks": [
+		{"type": "Heading", "text": " Kinematics ", "level": 0},
+		{"type": "formula", "text": "$v = u + at$"},
+		{"type": "list", "items": ["speed", 3, ""], "ordered": true},
+		{"type": "table", "rows": [["t", "v"], [0, 1.5]]},
+		{"type": "diagram", "text": "Velocity-time graph"},
+		{"type": "caption", "text": "Unknown types read as paragraphs"},
+		{"type": "paragraph", "text": "  "}
+	]}` + "\n```"
+
+	got := ParseTranscription(raw)
+	want := []models.TranscriptionBlock{
+		{Type: models.BlockHeading, Text: "Kinematics", Level: 1},
+		{Type: models.BlockFormula, LaTeX: "v = u + at"},
+		{Type: models.BlockList, Items: []string{"speed", "3"}, Ordered: true},
+		{Type: models.BlockTable, Rows: [][]string{{"t", "v"}, {"0", "1.5"}}},
+		{Type: models.BlockDiagram, Text: "Velocity-time graph"},
+		{Type: models.BlockParagraph, Text: "Unknown types read as paragraphs"},
+	}
+	if !reflect.DeepEqual(got.Blocks, want) {
+		t.Errorf("ParseTranscription() = %+v, want %+v", got.Blocks, want)
+	}
+
+	// A bare array of blocks is accepted too
+	if got := ParseTranscription(`[{"type": "paragraph", "text": "Notes"}]`); len(got.Blocks) != 1 || got.Blocks[0].Text != "Notes" {
+		t.Errorf("ParseTranscription(array) = %+v, want one paragraph", got.Blocks)
+	}
+
+	// Free text from a model that ignored the format is split into blocks
+	got = ParseTranscription("# Notes\nNewton's laws {of motion}\n\n- inertia\n- F = ma")
+	want = []models.TranscriptionBlock{
+		{Type: models.BlockHeading, Text: "Notes", Level: 1},
+		{Type: models.BlockParagraph, Text: "Newton's laws {of motion}"},
+		{Type: models.BlockList, Items: []string{"inertia", "F = ma"}},
+	}
+	if !reflect.DeepEqual(got.Blocks, want) {
+		t.Errorf("ParseTranscription(text) = %+v, want %+v", got.Blocks, want)
+	}
+}
+
+func TestTranscriptionTextRoundTrip(t *testing.T) {
+	transcription := &models.Transcription{Blocks: []models.TranscriptionBlock{
+		{Type: models.BlockHeading, Text: "Thermodynamics", Level: 2},
+		{Type: models.BlockParagraph, Text: "Energy is conserved.\nHeat flows from hot to cold."},
+		{Type: models.BlockList, Items: []string{"First law", "Second law"}, Ordered: true},
+		{Type: models.BlockList, Items: []string{"closed", "open"}},
+		{Type: models.BlockFormula, LaTeX: `\Delta U = Q - W`},
+		{Type: models.BlockTable, Rows: [][]string{{"State", "T (K)"}, {"A", "300"}}},
+		{Type: models.BlockDiagram, Text: "Piston in a cylinder, arrows labelled Q and W"},
+	}}
+
+	text := TranscriptionText(transcription)
+	want := "## Thermodynamics\n\nEnergy is conserved.\nHeat flows from hot to cold.\n\n1. First law\n2. Second law\n\n" +
+		"- closed\n- open\n\n$$\\Delta U = Q - W$$\n\n| State | T (K) |\n| A | 300 |\n\n" +
+		"[Diagram: Piston in a cylinder, arrows labelled Q and W]"
+	if text != want {
+		t.Errorf("TranscriptionText() = %q, want %q", text, want)
+	}
+
+	if got := TranscriptionFromText(text); !reflect.DeepEqual(got, transcription) {
+		t.Errorf("TranscriptionFromText() = %+v, want %+v", got.Blocks, transcription.Blocks)
+	}
+}
+
+func TestTranscriptionFromText(t *testing.T) {
+	text := "| a | b |\n|---|---|\n| 1 | 2 |\n$$\nx^2\n$$\n[Diagram: a circle\nwith radius r]\nplain line"
+	want := []models.TranscriptionBlock{
+		{Type: models.BlockTable, Rows: [][]string{{"a", "b"}, {"1", "2"}}},
+		{Type: models.BlockFormula, LaTeX: "x^2"},
+		{Type: models.BlockDiagram, Text: "a circle\nwith radius r"},
+		{Type: models.BlockParagraph, Text: "plain line"},
+	}
+	if got := TranscriptionFromText(text); !reflect.DeepEqual(got.Blocks, want) {
+		t.Errorf("TranscriptionFromText() = %+v, want %+v", got.Blocks, want)
+	}
+	if got := TranscriptionFromText("  \n"); len(got.Blocks) != 0 {
+		t.Errorf("TranscriptionFromText(blank) = %+v, want no blocks", got.Blocks)
+	}
+}
@@ -0,0 +1,393 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	trashCollectionName   = "trash"
+	trashRetentionDefault = 30 * 24 * time.Hour
+	// trashLease bounds how long a crashed restore or purge keeps others off an entry
+	trashLease = 10 * time.Minute
+)
+
+var ErrTrashEntryNotFound = errors.New("trash entry not found")
+
+// GetTrashCollection returns the trash collection
+func GetTrashCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection(trashCollectionName)
+}
+
+// ExcludeTrashed adds a condition to a nodes (or note_reviews) filter that skips
+// documents in the trash, and returns the filter
+func ExcludeTrashed(filter bson.M) bson.M {
+	filter["deletedAt"] = bson.M{"$exists": false}
+	return filter
+}
+
+// TrashRetention is how long deleted nodes stay restorable before they are purged
+func TrashRetention() time.Duration {
+	return envDuration("TRASH_RETENTION", trashRetentionDefault)
+}
+
+// InitTrashService creates the indexes used to list, restore and purge the trash
+func InitTrashService(ctx context.Context) error {
+	_, err := GetTrashCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "deletedAt", Value: -1}}, Options: options.Index().SetName("owner_deleted_index")},
+		{Keys: bson.D{{Key: "purgeAt", Value: 1}}, Options: options.Index().SetName("purge_at_index")},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create trash indexes: %w", err)
+	}
+
+	trashIDIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "trashId", Value: 1}},
+		Options: options.Index().SetName("trash_id_index").SetSparse(true),
+	}
+	if _, err := GetNodesCollection().Indexes().CreateOne(ctx, trashIDIndex); err != nil {
+		return fmt.Errorf("failed to create node trash index: %w", err)
+	}
+	if _, err := GetNoteReviewsCollection().Indexes().CreateOne(ctx, trashIDIndex); err != nil {
+		return fmt.Errorf("failed to create review trash index: %w", err)
+	}
+	return nil
+}
+
+// TrashNode moves node and its subtree to the owner's trash: every node (and every
+// note's review record) is marked with deletedAt and the new entry's ID, which hides
+// it from listings, search and quizzes. The caller detaches the node from its parent.
+func TrashNode(ctx context.Context, node *models.Node) (*models.TrashEntry, error) {
+	nodesCollection := GetNodesCollection()
+	now := time.Now()
+	entry := &models.TrashEntry{
+		ID:        primitive.NewObjectID(),
+		OwnerID:   node.OwnerID,
+		NodeID:    node.ID.Hex(),
+		Name:      node.Name,
+		Type:      node.Metadata.Type,
+		ParentID:  node.ParentID,
+		DeletedAt: now,
+		PurgeAt:   now.Add(TrashRetention()),
+	}
+	mark := bson.M{"$set": bson.M{"deletedAt": now, "trashId": entry.ID.Hex()}}
+
+	// Inside a node mutation everything marked here is unmarked again if it fails
+	unmark := bson.M{"$unset": bson.M{"deletedAt": "", "trashId": ""}}
+	if err := recordUndo(ctx,
+		undoUpdate(nodesCollection, bson.M{"trashId": entry.ID.Hex()}, unmark),
+		undoUpdate(GetNoteReviewsCollection(), bson.M{"trashId": entry.ID.Hex()}, unmark),
+		undoDelete(GetTrashCollection(), bson.M{"_id": entry.ID}),
+	); err != nil {
+		return nil, err
+	}
+
+	// Claim the root first so concurrent deletes of the same node trash it once
+	result, err := nodesCollection.UpdateOne(ctx, ExcludeTrashed(bson.M{"_id": node.ID, "ownerId": node.OwnerID}), mark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to trash node: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrNodeNotFound
+	}
+
+	nodeIDs, noteIDs, err := collectSubtree(ctx, node)
+	if err == nil {
+		entry.NodeCount, entry.NoteCount = len(nodeIDs), len(noteIDs)
+		_, err = GetTrashCollection().InsertOne(ctx, entry)
+	}
+	if err == nil && len(nodeIDs) > 1 {
+		_, err = nodesCollection.UpdateMany(ctx, ExcludeTrashed(bson.M{"_id": bson.M{"$in": nodeIDs[1:]}, "ownerId": node.OwnerID}), mark)
+	}
+	if err == nil && len(noteIDs) > 0 {
+		_, err = GetNoteReviewsCollection().UpdateMany(ctx, ExcludeTrashed(bson.M{"noteId": bson.M{"$in": noteIDs}}), mark)
+	}
+	if err != nil {
+		// Put back what was marked so the subtree is not left half-trashed
+		unmarkTrashed(ctx, entry.ID.Hex())
+		GetTrashCollection().DeleteOne(ctx, bson.M{"_id": entry.ID})
+		return nil, fmt.Errorf("failed to trash node: %w", err)
+	}
+
+	log.Printf("[TrashService] Trashed node %s with %d nodes (%d notes)", entry.NodeID, entry.NodeCount, entry.NoteCount)
+	return entry, nil
+}
+
+// unmarkTrashed clears the trash markers left by a trash entry
+func unmarkTrashed(ctx context.Context, trashID string) error {
+	unmark := bson.M{"$unset": bson.M{"deletedAt": "", "trashId": ""}}
+	if _, err := GetNodesCollection().UpdateMany(ctx, bson.M{"trashId": trashID}, unmark); err != nil {
+		return err
+	}
+	_, err := GetNoteReviewsCollection().UpdateMany(ctx, bson.M{"trashId": trashID}, unmark)
+	return err
+}
+
+// ListTrash returns the owner's trash, most recently deleted first
+func ListTrash(ctx context.Context, ownerID string) ([]models.TrashEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}})
+	cursor, err := GetTrashCollection().Find(ctx, bson.M{"ownerId": ownerID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trash: %w", err)
+	}
+
+	entries := []models.TrashEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode trash: %w", err)
+	}
+	return entries, nil
+}
+
+// claimTrashEntry leases the entry matching filter so a restore and a purge never
+// work on it at the same time. It returns ErrTrashEntryNotFound when none is free.
+func claimTrashEntry(ctx context.Context, filter bson.M) (*models.TrashEntry, error) {
+	now := time.Now()
+	filter["$or"] = bson.A{
+		bson.M{"leaseUntil": bson.M{"$exists": false}},
+		bson.M{"leaseUntil": bson.M{"$lt": now}},
+	}
+	update := bson.M{"$set": bson.M{"leaseUntil": now.Add(trashLease)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var entry models.TrashEntry
+	err := GetTrashCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTrashEntryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim trash entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// ownedTrashEntryFilter matches one of the owner's trash entries by hex ID
+func ownedTrashEntryFilter(entryID, ownerID string) (bson.M, error) {
+	objID, err := primitive.ObjectIDFromHex(entryID)
+	if err != nil {
+		return nil, ErrTrashEntryNotFound
+	}
+	return bson.M{"_id": objID, "ownerId": ownerID}, nil
+}
+
+// RestoreTrashEntry brings a trashed subtree back under its original parent, or to
+// the top level if that parent is gone or itself in the trash, and returns the entry
+// with ParentID set to where the subtree went. Unmarking the subtree, rewriting its
+// paths, removing the entry and re-attaching the subtree to its parent are one node
+// mutation, so a failed restore leaves the subtree in the trash.
+func RestoreTrashEntry(ctx context.Context, entryID, ownerID string) (*models.TrashEntry, error) {
+	filter, err := ownedTrashEntryFilter(entryID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := claimTrashEntry(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	originalParentID := entry.ParentID
+	err = RunNodeMutation(ctx, "restore", ownerID, func(ctx context.Context) error {
+		entry.ParentID = originalParentID // A retried transaction starts over
+		return restoreTrashedSubtree(ctx, entry)
+	})
+	if err != nil {
+		releaseTrashEntry(entry.ID)
+		return nil, err
+	}
+
+	// A note restored to the top level takes its storage with it; notes in a restored
+	// folder keep their parent
+	if entry.Type == models.NodeTypeNote && entry.ParentID != originalParentID {
+		if note, err := GetNodeByID(ctx, entry.NodeID); err != nil {
+			log.Printf("[TrashService] Failed to fetch restored note %s: %v", entry.NodeID, err)
+		} else if err := MoveStorageUsage(ctx, ownerID, originalParentID, entry.ParentID, NotePagesBytes(note.Pages)); err != nil {
+			log.Printf("[TrashService] Failed to move storage usage of note %s: %v", entry.NodeID, err)
+		}
+	}
+
+	log.Printf("[TrashService] Restored node %s with %d nodes", entry.NodeID, entry.NodeCount)
+	return entry, nil
+}
+
+// restoreTrashedSubtree does the writes of a restore, recording their undo first:
+// every write is harmless to undo if it never happened
+func restoreTrashedSubtree(ctx context.Context, entry *models.TrashEntry) error {
+	ownerID := entry.OwnerID
+	ancestors, err := ParentAncestors(ctx, entry.ParentID, ownerID)
+	if err == ErrParentNotFound {
+		entry.ParentID = ""
+		ancestors = []string{}
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch original parent: %w", err)
+	}
+
+	nodesCollection := GetNodesCollection()
+	reviewsCollection := GetNoteReviewsCollection()
+	trashID := entry.ID.Hex()
+	rootFilter := NodeIDFilter(entry.NodeID)
+	rootFilter["ownerId"] = ownerID
+
+	var root models.Node
+	trashedRoot := bson.M{"_id": rootFilter["_id"], "ownerId": ownerID, "trashId": trashID}
+	opts := options.FindOne().SetProjection(bson.M{"parentId": 1, "ancestors": 1})
+	if err := nodesCollection.FindOne(ctx, trashedRoot, opts).Decode(&root); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNodeNotFound
+		}
+		return fmt.Errorf("failed to fetch trashed node: %w", err)
+	}
+	nodeIDs, err := nodesCollection.Distinct(ctx, "_id", bson.M{"trashId": trashID})
+	if err != nil {
+		return fmt.Errorf("failed to fetch trashed nodes: %w", err)
+	}
+	reviewIDs, err := reviewsCollection.Distinct(ctx, "_id", bson.M{"trashId": trashID})
+	if err != nil {
+		return fmt.Errorf("failed to fetch trashed reviews: %w", err)
+	}
+
+	if reviewIDs == nil {
+		reviewIDs = []interface{}{} // A subtree without notes has no reviews
+	}
+
+	mark := bson.M{"$set": bson.M{"deletedAt": entry.DeletedAt, "trashId": trashID}}
+	if err := recordUndo(ctx,
+		undoInsert(GetTrashCollection(), entry),
+		undoUpdate(nodesCollection, bson.M{"_id": bson.M{"$in": nodeIDs}}, mark),
+		undoUpdate(reviewsCollection, bson.M{"_id": bson.M{"$in": reviewIDs}}, mark),
+		undoUpdate(nodesCollection, rootFilter, bson.M{"$set": bson.M{"parentId": root.ParentID, "ancestors": root.Ancestors}}),
+		undoUpdate(nodesCollection, descendantsFilter(ownerID, entry.NodeID), descendantAncestorsUpdate(entry.NodeID, root.Ancestors)),
+	); err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"parentId": entry.ParentID, "ancestors": ancestors, "updatedAt": time.Now()}}
+	if _, err := nodesCollection.UpdateOne(ctx, rootFilter, update); err != nil {
+		return fmt.Errorf("failed to restore node: %w", err)
+	}
+	// The original parent may be gone, so the subtree's paths can change too
+	if err := RewriteDescendantAncestors(ctx, ownerID, entry.NodeID, ancestors); err != nil {
+		return err
+	}
+	if err := unmarkTrashed(ctx, trashID); err != nil {
+		return fmt.Errorf("failed to restore nodes: %w", err)
+	}
+	if _, err := GetTrashCollection().DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+		return fmt.Errorf("failed to remove trash entry: %w", err)
+	}
+
+	if entry.ParentID == "" {
+		return nil
+	}
+	if err := AddNodeChild(ctx, entry.ParentID, ownerID, entry.NodeID); err != nil {
+		return err
+	}
+	return IncrementAncestorNoteCounts(ctx, ancestors, ownerID, entry.NoteCount)
+}
+
+// releaseTrashEntry drops the lease of an entry whose restore failed, so it can be
+// retried straight away
+func releaseTrashEntry(id primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := GetTrashCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"leaseUntil": ""}}); err != nil {
+		log.Printf("[TrashService] Failed to release trash entry %s: %v", id.Hex(), err)
+	}
+}
+
+// PurgeTrashEntry permanently deletes one of the owner's trash entries right away
+func PurgeTrashEntry(ctx context.Context, entryID, ownerID string) error {
+	filter, err := ownedTrashEntryFilter(entryID, ownerID)
+	if err != nil {
+		return err
+	}
+	entry, err := claimTrashEntry(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return purgeTrashEntry(ctx, entry)
+}
+
+// PurgeExpiredTrash permanently deletes up to limit trash entries whose retention
+// has run out and returns how many were purged
+func PurgeExpiredTrash(ctx context.Context, limit int) (int, error) {
+	purged := 0
+	for purged < limit {
+		if ctx.Err() != nil {
+			return purged, ctx.Err()
+		}
+		entry, err := claimTrashEntry(ctx, bson.M{"purgeAt": bson.M{"$lte": time.Now()}})
+		if err == ErrTrashEntryNotFound {
+			break
+		}
+		if err != nil {
+			return purged, err
+		}
+		if err := purgeTrashEntry(ctx, entry); err != nil {
+			// The lease expires and a later round retries the entry
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeTrashEntry deletes a claimed entry's notes from blob storage, the vector index
+// and the document index, then its review records, nodes and the entry itself
+func purgeTrashEntry(ctx context.Context, entry *models.TrashEntry) error {
+	trashID := entry.ID.Hex()
+	nodesCollection := GetNodesCollection()
+
+	noteFilter := bson.M{"trashId": trashID, "metadata.type": models.NodeTypeNote}
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "parentId": 1, "metadata": 1, "pages": 1})
+	cursor, err := nodesCollection.Find(ctx, noteFilter, opts)
+	if err != nil {
+		return fmt.Errorf("failed to fetch trashed notes: %w", err)
+	}
+	var notes []models.Node
+	if err := cursor.All(ctx, &notes); err != nil {
+		return fmt.Errorf("failed to decode trashed notes: %w", err)
+	}
+
+	freed := make(map[string]int64)
+	for _, note := range notes {
+		// Blobs shared with notes outside this entry (re-uploads) stay, charged to those
+		freed[note.ParentID] += ReleaseNotePageBlobs(ctx, entry.OwnerID, NotePages(&note), bson.M{"trashId": trashID})
+		if err := DeleteCaptionEmbedding(note.ID.Hex()); err != nil {
+			return fmt.Errorf("failed to delete embedding for note %s: %w", note.ID.Hex(), err)
+		}
+		if err := DeleteDocumentIndex(ctx, note.ID.Hex()); err != nil {
+			return err
+		}
+	}
+
+	if _, err := GetNoteReviewsCollection().DeleteMany(ctx, bson.M{"trashId": trashID}); err != nil {
+		return fmt.Errorf("failed to delete trashed reviews: %w", err)
+	}
+	if _, err := nodesCollection.DeleteMany(ctx, bson.M{"trashId": trashID}); err != nil {
+		return fmt.Errorf("failed to delete trashed nodes: %w", err)
+	}
+	// Only once the notes are gone, so a purge retried after a failure above does not
+	// take their bytes off twice
+	for folderID, bytes := range freed {
+		if bytes != 0 {
+			logStorageChange(ctx, entry.OwnerID, folderID, -bytes)
+		}
+	}
+	if _, err := GetTrashCollection().DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+		return fmt.Errorf("failed to delete trash entry: %w", err)
+	}
+
+	log.Printf("[TrashService] Purged node %s with %d nodes (%d notes)", entry.NodeID, entry.NodeCount, len(notes))
+	return nil
+}
@@ -0,0 +1,510 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"time"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TreeIssueKind names a class of node tree inconsistency
+type TreeIssueKind string
+
+const (
+	// TreeIssueOrphan is a node whose parent is missing, trashed, owned by someone else or a note
+	TreeIssueOrphan TreeIssueKind = "orphan"
+	// TreeIssueCycle is a node on a parent-pointer loop, detached to break it
+	TreeIssueCycle TreeIssueKind = "cycle"
+	// TreeIssuePathDrift is a node whose stored ancestor path does not match its parent chain
+	TreeIssuePathDrift TreeIssueKind = "path_drift"
+	// TreeIssueDanglingChild is a children entry that is not a live child of the node
+	TreeIssueDanglingChild TreeIssueKind = "dangling_child"
+	// TreeIssueMissingChild is a live child missing from its parent's children array
+	TreeIssueMissingChild TreeIssueKind = "missing_child"
+	// TreeIssueCountDrift is a folder whose totalNoteCount differs from the notes below it
+	TreeIssueCountDrift TreeIssueKind = "count_drift"
+	// TreeIssueMasteryDrift is a node whose mastery covers a different number of notes
+	TreeIssueMasteryDrift TreeIssueKind = "mastery_drift"
+	// TreeIssueMissingEmbedding is a captioned note with no caption_embeddings document
+	TreeIssueMissingEmbedding TreeIssueKind = "missing_embedding"
+	// TreeIssueOrphanEmbedding is a caption_embeddings document whose note no longer exists
+	TreeIssueOrphanEmbedding TreeIssueKind = "orphan_embedding"
+	// TreeIssueStorageDrift is an owner whose recorded storage usage differs from what their notes store
+	TreeIssueStorageDrift TreeIssueKind = "storage_drift"
+)
+
+// ErrTreeChangedSinceScan fails a repair whose node changed after CheckTree read it
+var ErrTreeChangedSinceScan = errors.New("changed since scan")
+
+// TreeIssue is one inconsistency found by CheckTree and, in fix mode, the outcome of repairing it
+type TreeIssue struct {
+	Kind    TreeIssueKind `json:"kind"`
+	OwnerID string        `json:"ownerId"`
+	NodeID  string        `json:"nodeId"`
+	Detail  string        `json:"detail"`
+	Fix     string        `json:"fix"`
+	Fixed   bool          `json:"fixed"`
+	Error   string        `json:"error,omitempty"`
+
+	repair func(ctx context.Context) error
+}
+
+// TreeCheckOptions selects what CheckTree looks at and whether it repairs
+type TreeCheckOptions struct {
+	OwnerID string // Only check this user's tree; empty checks every owner
+	Fix     bool   // Apply the repairs; otherwise only report them (dry run)
+}
+
+// TreeCheckReport summarizes a CheckTree run
+type TreeCheckReport struct {
+	DryRun       bool                  `json:"dryRun"`
+	Owners       int                   `json:"owners"`
+	NodesChecked int                   `json:"nodesChecked"`
+	Counts       map[TreeIssueKind]int `json:"counts"`
+	Fixed        int                   `json:"fixed"`
+	Failed       int                   `json:"failed"`
+	Issues       []TreeIssue           `json:"issues"`
+	StartedAt    time.Time             `json:"startedAt"`
+	FinishedAt   time.Time             `json:"finishedAt"`
+}
+
+// CheckTree checks the node trees for orphans, cycles, children arrays out of step
+// with parent pointers, drifted note counts and mastery, and captions missing from
+// (or left behind in) caption_embeddings. With opts.Fix it repairs what it finds:
+// orphans and cycle members move to the top level, ancestor paths, children arrays
+// and counts are rewritten, mastery is recalculated, missing captions are re-queued, orphaned
+// embeddings deleted and storage usage recounted from the blob sizes recorded on notes. Trashed
+// nodes are only used to tell orphaned embeddings apart and, as their blobs are kept until
+// purged, to count storage.
+func CheckTree(ctx context.Context, opts TreeCheckOptions) (*TreeCheckReport, error) {
+	report := &TreeCheckReport{
+		DryRun:    !opts.Fix,
+		Counts:    make(map[TreeIssueKind]int),
+		Issues:    []TreeIssue{},
+		StartedAt: time.Now(),
+	}
+
+	owners := []string{opts.OwnerID}
+	if opts.OwnerID == "" {
+		values, err := GetNodesCollection().Distinct(ctx, "ownerId", bson.M{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list node owners: %w", err)
+		}
+		owners = owners[:0]
+		for _, v := range values {
+			if owner, ok := v.(string); ok {
+				owners = append(owners, owner)
+			}
+		}
+		sort.Strings(owners)
+	}
+
+	for _, owner := range owners {
+		if err := checkOwnerTree(ctx, owner, opts.Fix, report); err != nil {
+			return report, fmt.Errorf("owner %s: %w", owner, err)
+		}
+		report.Owners++
+	}
+
+	report.FinishedAt = time.Now()
+	log.Printf("[TreeCheck] Checked %d nodes of %d owners: %d issues, %d fixed, %d failed (dry run: %v)",
+		report.NodesChecked, report.Owners, len(report.Issues), report.Fixed, report.Failed, report.DryRun)
+	return report, nil
+}
+
+// checkOwnerTree loads one owner's nodes and captions, analyzes them and applies the repairs
+func checkOwnerTree(ctx context.Context, ownerID string, fix bool, report *TreeCheckReport) error {
+	opts := options.Find().SetProjection(bson.M{
+		"_id": 1, "name": 1, "parentId": 1, "ancestors": 1, "children": 1, "totalNoteCount": 1, "metadata": 1,
+		"ownerId": 1, "mastery.totalNotes": 1, "captionStatus": 1, "pages.id": 1, "pages.driveId": 1, "deletedAt": 1,
+		"pages.imageBytes": 1, "pages.originalBytes": 1, "pages.thumbnailBytes": 1,
+	})
+	cursor, err := GetNodesCollection().Find(ctx, bson.M{"ownerId": ownerID}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to fetch nodes: %w", err)
+	}
+	var nodes []models.Node
+	if err := cursor.All(ctx, &nodes); err != nil {
+		return fmt.Errorf("failed to decode nodes: %w", err)
+	}
+
+	captioned := make(map[string]bool)
+	values, err := getVectorCollection().Distinct(ctx, "noteId", bson.M{"ownerId": ownerID})
+	if err != nil {
+		return fmt.Errorf("failed to list captions: %w", err)
+	}
+	for _, v := range values {
+		if noteID, ok := v.(string); ok {
+			captioned[noteID] = true
+		}
+	}
+
+	usage, err := GetStorageUsage(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+
+	issues := append(analyzeTree(ownerID, nodes, captioned), analyzeStorage(ownerID, nodes, usage)...)
+	report.NodesChecked += len(nodes)
+	for i := range issues {
+		issue := &issues[i]
+		report.Counts[issue.Kind]++
+		if fix && issue.repair != nil {
+			if err := issue.repair(ctx); err != nil {
+				issue.Error = err.Error()
+				report.Failed++
+			} else {
+				issue.Fixed = true
+				report.Fixed++
+			}
+		}
+	}
+	report.Issues = append(report.Issues, issues...)
+	return nil
+}
+
+// analyzeTree finds the inconsistencies in one owner's nodes (live and trashed) given
+// the note IDs that have a caption_embeddings document. Issues come in repair order:
+// re-parenting first, then ancestor paths, children arrays, counts, mastery (deepest first) and captions.
+// The later checks run against the tree as it will be once orphans and cycles are fixed.
+func analyzeTree(ownerID string, nodes []models.Node, captioned map[string]bool) []TreeIssue {
+	var issues []TreeIssue
+	add := func(kind TreeIssueKind, nodeID, detail, fix string, repair func(ctx context.Context) error) {
+		issues = append(issues, TreeIssue{Kind: kind, OwnerID: ownerID, NodeID: nodeID, Detail: detail, Fix: fix, repair: repair})
+	}
+
+	live := make(map[string]*models.Node)
+	known := make(map[string]bool)
+	var order []string
+	for i := range nodes {
+		id := nodes[i].ID.Hex()
+		known[id] = true
+		if nodes[i].DeletedAt == nil {
+			live[id] = &nodes[i]
+			order = append(order, id)
+		}
+	}
+	sort.Strings(order)
+
+	// Parent pointers as they will be after repair
+	parent := make(map[string]string, len(live))
+	for _, id := range order {
+		node := live[id]
+		parent[id] = node.ParentID
+		if node.ParentID == "" || node.ParentID == id {
+			continue
+		}
+		p, ok := live[node.ParentID]
+		if ok && p.Metadata.Type == models.NodeTypeFolder {
+			continue
+		}
+		detail := fmt.Sprintf("parent %s does not exist", node.ParentID)
+		if ok {
+			detail = fmt.Sprintf("parent %s is a note", node.ParentID)
+		} else if known[node.ParentID] {
+			detail = fmt.Sprintf("parent %s is in the trash", node.ParentID)
+		}
+		parent[id] = ""
+		add(TreeIssueOrphan, id, detail, "move to the top level", detachNode(id, node.ParentID))
+	}
+
+	// Cycles: walk up from every node, marking nodes whose chain is known to reach the top
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int, len(live))
+	for _, start := range order {
+		var path []string
+		id := start
+		for id != "" && state[id] == unvisited {
+			state[id] = onPath
+			path = append(path, id)
+			id = parent[id]
+		}
+		if id != "" && state[id] == onPath {
+			// The loop is the part of the path from id onwards; detach its smallest ID
+			loop := path[slices.Index(path, id):]
+			victim := slices.Min(loop)
+			parent[victim] = ""
+			add(TreeIssueCycle, victim, fmt.Sprintf("parent chain loops through %d nodes", len(loop)), "move to the top level", detachNode(victim, live[victim].ParentID))
+		}
+		for _, p := range path {
+			state[p] = done
+		}
+	}
+
+	// Ancestor paths follow the repaired parent pointers
+	paths := AncestorPaths(parent)
+	for _, id := range order {
+		if want := paths[id]; !slices.Equal(live[id].Ancestors, want) {
+			add(TreeIssuePathDrift, id, fmt.Sprintf("ancestors is %v, parent chain is %v", live[id].Ancestors, want),
+				"rewrite ancestors", setNodeField(id, "ancestors", live[id].Ancestors, want))
+		}
+	}
+
+	// Children arrays: keep valid entries in order, drop the rest, append missing children
+	children := make(map[string][]string)
+	for _, id := range order {
+		if p := parent[id]; p != "" {
+			children[p] = append(children[p], id)
+		}
+	}
+	for _, id := range order {
+		node := live[id]
+		want := children[id]
+		kept := make([]string, 0, len(want))
+		seen := make(map[string]bool)
+		first := len(issues)
+		for _, child := range node.Children {
+			if seen[child] || parent[child] != id || live[child] == nil {
+				detail := fmt.Sprintf("children lists %s, which is not a child", child)
+				if seen[child] {
+					detail = fmt.Sprintf("children lists %s more than once", child)
+				}
+				add(TreeIssueDanglingChild, id, detail, "rewrite children", nil)
+				continue
+			}
+			seen[child] = true
+			kept = append(kept, child)
+		}
+		for _, child := range want {
+			if !seen[child] {
+				add(TreeIssueMissingChild, id, fmt.Sprintf("children is missing %s", child), "rewrite children", nil)
+				kept = append(kept, child)
+			}
+		}
+		if len(issues) > first {
+			// One write fixes every children issue of the node
+			repair := repairOnce(setNodeField(id, "children", node.Children, kept))
+			for i := first; i < len(issues); i++ {
+				issues[i].repair = repair
+			}
+		}
+	}
+
+	// Note counts on the repaired (acyclic) tree
+	noteCount := make(map[string]int, len(live))
+	var count func(id string) int
+	count = func(id string) int {
+		if n, ok := noteCount[id]; ok {
+			return n
+		}
+		n := 0
+		if live[id].Metadata.Type == models.NodeTypeNote {
+			n = 1
+		}
+		for _, child := range children[id] {
+			n += count(child)
+		}
+		noteCount[id] = n
+		return n
+	}
+
+	for _, id := range order {
+		node := live[id]
+		if node.Metadata.Type == models.NodeTypeFolder && node.TotalNoteCount != count(id) {
+			add(TreeIssueCountDrift, id, fmt.Sprintf("totalNoteCount is %d, subtree has %d notes", node.TotalNoteCount, count(id)),
+				"set totalNoteCount", setNodeField(id, "totalNoteCount", node.TotalNoteCount, count(id)))
+		}
+	}
+
+	var drifted []string
+	for _, id := range order {
+		if live[id].Mastery.TotalNotes != count(id) {
+			drifted = append(drifted, id)
+		}
+	}
+	sort.SliceStable(drifted, func(i, j int) bool { return len(paths[drifted[i]]) > len(paths[drifted[j]]) })
+	for _, id := range drifted {
+		var chain []string
+		for p := id; p != ""; p = parent[p] {
+			chain = append(chain, p)
+		}
+		add(TreeIssueMasteryDrift, id, fmt.Sprintf("mastery covers %d notes, subtree has %d", live[id].Mastery.TotalNotes, count(id)),
+			"recalculate mastery of the node and its ancestors", recalculateMastery(chain))
+	}
+
+	// Captions
+	for _, id := range order {
+		node := live[id]
+		if node.Metadata.Type != models.NodeTypeNote || captioned[id] {
+			continue
+		}
+		// Pending and in-flight captions are not missing; failed ones are retried by users
+		if node.CaptionStatus == models.CaptionStatusCompleted || node.CaptionStatus == "" {
+			add(TreeIssueMissingEmbedding, id, "note has no caption embedding", "queue caption generation", requeueCaption(node))
+		}
+	}
+	var stale []string
+	for noteID := range captioned {
+		if !known[noteID] {
+			stale = append(stale, noteID)
+		}
+	}
+	sort.Strings(stale)
+	for _, noteID := range stale {
+		add(TreeIssueOrphanEmbedding, noteID, "caption embedding has no note", "delete the embedding", func(ctx context.Context) error {
+			return DeleteCaptionEmbedding(noteID)
+		})
+	}
+
+	return issues
+}
+
+// analyzeStorage compares an owner's recorded storage usage with the blob sizes recorded
+// on their notes, live and trashed, counting each under its parent folder as it will be
+// once orphans are moved to the top level
+func analyzeStorage(ownerID string, nodes []models.Node, usage *models.UserProgress) []TreeIssue {
+	folders := make(map[string]bool)
+	for _, node := range nodes {
+		if node.Metadata.Type == models.NodeTypeFolder && node.DeletedAt == nil {
+			folders[node.ID.Hex()] = true
+		}
+	}
+
+	var used int64
+	byFolder := make(map[string]int64)
+	for i := range nodes {
+		node := &nodes[i]
+		bytes := NotePagesBytes(node.Pages)
+		if node.Metadata.Type != models.NodeTypeNote || bytes == 0 {
+			continue
+		}
+		folderID := node.ParentID
+		if node.DeletedAt == nil && !folders[folderID] {
+			folderID = ""
+		}
+		used += bytes
+		byFolder[storageFolderKey(folderID)] += bytes
+	}
+
+	recorded := make(map[string]int64)
+	for folderID, bytes := range usage.StorageByFolder {
+		if bytes != 0 {
+			recorded[folderID] = bytes
+		}
+	}
+	if used == usage.StorageUsedBytes && maps.Equal(byFolder, recorded) {
+		return nil
+	}
+
+	// Corrected by the difference, so uploads and deletions since the scan are kept
+	corrections := make(map[string]int64)
+	for folderKey := range byFolder {
+		corrections[folderKey] = byFolder[folderKey] - recorded[folderKey]
+	}
+	for folderKey, bytes := range recorded {
+		if _, ok := byFolder[folderKey]; !ok {
+			corrections[folderKey] = -bytes
+		}
+	}
+	maps.DeleteFunc(corrections, func(_ string, delta int64) bool { return delta == 0 })
+	return []TreeIssue{{
+		Kind:    TreeIssueStorageDrift,
+		OwnerID: ownerID,
+		Detail:  fmt.Sprintf("storageUsedBytes is %d over %d folders, notes store %d over %d folders", usage.StorageUsedBytes, len(recorded), used, len(byFolder)),
+		Fix:     "recount storage usage",
+		repair: func(ctx context.Context) error {
+			return CorrectStorageUsage(ctx, ownerID, used-usage.StorageUsedBytes, corrections)
+		},
+	}}
+}
+
+// setNodeField returns a repair that sets one field of a node from the value the scan
+// read (observed) to value. The check runs on a live tree, so if the field changed in
+// the meantime the repair fails with ErrTreeChangedSinceScan rather than overwrite it.
+func setNodeField(nodeID, field string, observed, value interface{}) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		filter := NodeIDFilter(nodeID)
+		filter[field] = observedValue(observed)
+		result, err := GetNodesCollection().UpdateOne(ctx, filter,
+			bson.M{"$set": bson.M{field: value, "updatedAt": time.Now()}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrTreeChangedSinceScan
+		}
+		return nil
+	}
+}
+
+// observedValue matches a field still holding a value read from it. A zero value also
+// matches the field being absent, which reads the same.
+func observedValue(observed interface{}) interface{} {
+	if v := reflect.ValueOf(observed); !v.IsValid() || v.IsZero() {
+		return bson.M{"$in": bson.A{observed, nil}}
+	}
+	return observed
+}
+
+// repairOnce wraps a repair shared by several issues so it runs once and every
+// issue reports its outcome
+func repairOnce(repair func(ctx context.Context) error) func(ctx context.Context) error {
+	var ran bool
+	var err error
+	return func(ctx context.Context) error {
+		if !ran {
+			ran, err = true, repair(ctx)
+		}
+		return err
+	}
+}
+
+// detachNode returns a repair that moves a node from parentID to the top level
+func detachNode(nodeID, parentID string) func(ctx context.Context) error {
+	return setNodeField(nodeID, "parentId", parentID, "")
+}
+
+// recalculateMastery returns a repair that recalculates the mastery of a node and
+// then of each of its ancestors, bottom-up
+func recalculateMastery(chain []string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for _, id := range chain {
+			if err := UpdateNodeMastery(ctx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// requeueCaption returns a repair that queues caption generation for a note, one job
+// per page
+func requeueCaption(node *models.Node) func(ctx context.Context) error {
+	note := *node
+	return func(ctx context.Context) error {
+		if !IsQueueServiceInitialized() {
+			return fmt.Errorf("queue service not initialized")
+		}
+		pages := NotePages(&note)
+		if len(pages) == 0 {
+			return fmt.Errorf("note has no stored image")
+		}
+		if FirstPageDriveID(pages) == "" {
+			// Every page came from a PDF's text layer; restage it for the re-embed worker
+			full, err := GetNodeByID(ctx, note.ID.Hex())
+			if err != nil {
+				return err
+			}
+			return RefreshNoteCaption(ctx, full)
+		}
+		if err := setNodeField(note.ID.Hex(), "captionStatus", note.CaptionStatus, models.CaptionStatusPending)(ctx); err != nil {
+			return err
+		}
+		_, err := EnqueuePageCaptionJobs(&note, pages)
+		return err
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testNode builds a node with a readable, deterministic ObjectID (n is the last byte)
+func testNode(n byte, typ models.NodeType, parentID string, children ...string) models.Node {
+	var id primitive.ObjectID
+	id[11] = n
+	return models.Node{ID: id, ParentID: parentID, Children: children, Metadata: models.NodeMetadata{Type: typ}, OwnerID: "alice"}
+}
+
+func hexID(n byte) string {
+	var id primitive.ObjectID
+	id[11] = n
+	return id.Hex()
+}
+
+func TestAnalyzeTreeHealthy(t *testing.T) {
+	root := testNode(1, models.NodeTypeFolder, "", hexID(2))
+	root.TotalNoteCount, root.Mastery.TotalNotes = 1, 1
+	note := testNode(2, models.NodeTypeNote, hexID(1))
+	note.Ancestors = []string{hexID(1)}
+	note.Mastery.TotalNotes = 1
+
+	issues := analyzeTree("alice", []models.Node{root, note}, map[string]bool{hexID(2): true})
+	if len(issues) != 0 {
+		t.Errorf("analyzeTree() = %+v, want no issues", issues)
+	}
+}
+
+func TestAnalyzeTreeFindsInconsistencies(t *testing.T) {
+	now := time.Now()
+	// 1: folder listing a missing child (9) and missing its real child 2
+	// 2: note under 1, counted nowhere, no embedding, no ancestor path
+	// 3: folder whose parent 8 does not exist
+	// 4 <-> 5: folders pointing at each other; 4 (the smaller ID) is detached
+	// 6: trashed folder, 7: live note under it
+	nodes := []models.Node{
+		testNode(1, models.NodeTypeFolder, "", hexID(9)),
+		testNode(2, models.NodeTypeNote, hexID(1)),
+		testNode(3, models.NodeTypeFolder, hexID(8)),
+		testNode(4, models.NodeTypeFolder, hexID(5), hexID(5)),
+		testNode(5, models.NodeTypeFolder, hexID(4), hexID(4)),
+		testNode(6, models.NodeTypeFolder, ""),
+		testNode(7, models.NodeTypeNote, hexID(6)),
+	}
+	nodes[1].Mastery.TotalNotes = 1
+	nodes[1].CaptionStatus = models.CaptionStatusCompleted
+	nodes[5].DeletedAt = &now
+	nodes[6].Mastery.TotalNotes = 1
+	nodes[6].CaptionStatus = models.CaptionStatusPending
+
+	issues := analyzeTree("alice", nodes, map[string]bool{"gone": true})
+
+	found := make(map[string]bool)
+	for _, issue := range issues {
+		found[fmt.Sprintf("%s %s", issue.Kind, issue.NodeID)] = true
+		if issue.repair == nil {
+			t.Errorf("issue %+v has no repair", issue)
+		}
+	}
+	want := []string{
+		"orphan " + hexID(3),
+		"orphan " + hexID(7),
+		"cycle " + hexID(4),
+		"path_drift " + hexID(2),
+		"path_drift " + hexID(5),
+		"dangling_child " + hexID(1),
+		"missing_child " + hexID(1),
+		"dangling_child " + hexID(5), // 4 was detached to break the cycle
+		"count_drift " + hexID(1),
+		"mastery_drift " + hexID(1),
+		"missing_embedding " + hexID(2),
+		"orphan_embedding gone",
+	}
+	for _, w := range want {
+		if !found[w] {
+			t.Errorf("missing issue %q in %v", w, found)
+		}
+	}
+	if len(issues) != len(want) {
+		t.Errorf("got %d issues, want %d: %v", len(issues), len(want), found)
+	}
+
+	// Re-parenting comes first so the later writes apply to the repaired tree
+	if issues[0].Kind != TreeIssueOrphan || issues[len(issues)-1].Kind != TreeIssueOrphanEmbedding {
+		t.Errorf("issues out of repair order: first %s, last %s", issues[0].Kind, issues[len(issues)-1].Kind)
+	}
+}
+
+func TestObservedValue(t *testing.T) {
+	// A field read as its zero value may be absent from the document
+	absent := bson.M{"$in": bson.A{0, nil}}
+	if got := observedValue(0); !reflect.DeepEqual(got, absent) {
+		t.Errorf("observedValue(0) = %v, want %v", got, absent)
+	}
+	if got, ok := observedValue([]string(nil)).(bson.M); !ok || got["$in"] == nil {
+		t.Errorf("observedValue(nil slice) = %v, want a match on absence", got)
+	}
+	if got := observedValue(3); got != 3 {
+		t.Errorf("observedValue(3) = %v, want 3", got)
+	}
+	path := []string{hexID(1)}
+	if got := observedValue(path); !reflect.DeepEqual(got, path) {
+		t.Errorf("observedValue(path) = %v, want %v", got, path)
+	}
+}
+
+func TestAnalyzeStorage(t *testing.T) {
+	now := time.Now()
+	// 1: folder with note 2; 3: note at the top level; 4: trashed note under 1;
+	// 5: note whose parent 9 does not exist, counted at the top level
+	nodes := []models.Node{
+		testNode(1, models.NodeTypeFolder, ""),
+		testNode(2, models.NodeTypeNote, hexID(1)),
+		testNode(3, models.NodeTypeNote, ""),
+		testNode(4, models.NodeTypeNote, hexID(1)),
+		testNode(5, models.NodeTypeNote, hexID(9)),
+	}
+	nodes[1].Pages = []models.NotePage{{ImageBytes: 100, OriginalBytes: 400}}
+	nodes[2].Pages = []models.NotePage{{ImageBytes: 50}}
+	nodes[3].Pages = []models.NotePage{{ImageBytes: 25}}
+	nodes[3].DeletedAt = &now
+	nodes[4].Pages = []models.NotePage{{ImageBytes: 10}}
+
+	usage := &models.UserProgress{
+		StorageUsedBytes: 585,
+		StorageByFolder:  map[string]int64{hexID(1): 525, StorageRootFolder: 60, hexID(7): 0},
+	}
+	if issues := analyzeStorage("alice", nodes, usage); len(issues) != 0 {
+		t.Errorf("analyzeStorage() = %+v, want no issues", issues)
+	}
+
+	// An estimate from before usage was tracked
+	usage = &models.UserProgress{StorageUsedBytes: 15 << 20}
+	issues := analyzeStorage("alice", nodes, usage)
+	if len(issues) != 1 || issues[0].Kind != TreeIssueStorageDrift || issues[0].repair == nil {
+		t.Errorf("analyzeStorage() = %+v, want one repairable storage_drift", issues)
+	}
+}
@@ -0,0 +1,566 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cogniscan/backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Vector index backends, selected with VECTOR_INDEX
+const (
+	VectorIndexAuto   = "auto"   // Atlas when the vector_index search index exists, memory otherwise
+	VectorIndexAtlas  = "atlas"  // MongoDB Atlas Vector Search
+	VectorIndexMemory = "memory" // in-process HNSW loaded from caption_chunks
+)
+
+const (
+	// memoryExactThreshold is the per-owner size below which the memory index scans exactly
+	memoryExactThreshold = 1000
+
+	// chunkOversample is how many chunks are fetched per wanted note before
+	// keeping only each note's best chunk
+	chunkOversample = 4
+
+	vectorIndexSyncIntervalDefault = time.Minute
+	// vectorIndexSyncLookback covers clock skew between instances and notes whose
+	// chunks were still being written during the previous sync
+	vectorIndexSyncLookback = time.Minute
+)
+
+var ErrVectorIndexNotInitialized = errors.New("vector index not initialized")
+
+// VectorEntry is one embedded caption chunk as seen by a vector index
+type VectorEntry struct {
+	NoteID     string
+	FolderID   string
+	OwnerID    string
+	Version    int // embedding version
+	ChunkIndex int
+	Vector     []float32
+}
+
+// VectorFilter restricts a vector search; empty fields match everything
+type VectorFilter struct {
+	OwnerID  string
+	FolderID string
+	Version  int // embedding version; vectors of different versions are not comparable
+}
+
+// VectorMatch is a search result: a note and its best-matching chunk.
+// Score is (1 + cosine) / 2, the scale Atlas reports.
+type VectorMatch struct {
+	NoteID     string
+	ChunkIndex int
+	Score      float32
+}
+
+// VectorIndex finds the notes whose caption chunks are nearest to a query vector,
+// returning each note once with its best chunk. caption_chunks stays the source of
+// truth; indexes that keep their own copy are updated through Upsert/Delete as
+// embeddings are stored and removed.
+type VectorIndex interface {
+	Name() string
+	// Upsert replaces every chunk of a note in one embedding version with chunks
+	Upsert(ctx context.Context, noteID string, version int, chunks []VectorEntry) error
+	Delete(ctx context.Context, noteID string) error
+	DeleteFolder(ctx context.Context, folderID string) error
+	Search(ctx context.Context, query []float32, limit int, filter VectorFilter) ([]VectorMatch, error)
+}
+
+var vectorIndex VectorIndex
+
+// GetVectorIndex returns the active vector index (nil until InitVectorService runs)
+func GetVectorIndex() VectorIndex {
+	return vectorIndex
+}
+
+// SetVectorIndex replaces the active vector index (used by tests and tools)
+func SetVectorIndex(index VectorIndex) {
+	vectorIndex = index
+}
+
+// newVectorIndexFromEnv builds the index selected by VECTOR_INDEX
+func newVectorIndexFromEnv(ctx context.Context, hasAtlasIndex bool) (VectorIndex, error) {
+	mode := strings.ToLower(envOrDefault("VECTOR_INDEX", VectorIndexAuto))
+	switch mode {
+	case VectorIndexAtlas:
+		return &AtlasVectorIndex{}, nil
+	case VectorIndexAuto:
+		if hasAtlasIndex {
+			return &AtlasVectorIndex{}, nil
+		}
+		fallthrough
+	case VectorIndexMemory:
+		version := legacyEmbeddingVersion
+		if active := ActiveEmbeddingVersion(); active != nil {
+			version = active.Version
+		}
+		index := NewMemoryVectorIndex(version)
+		loadStart := time.Now()
+		if _, err := index.Load(ctx, time.Time{}); err != nil {
+			return nil, err
+		}
+		startVectorIndexSync(index, loadStart)
+		return index, nil
+	}
+	return nil, fmt.Errorf("unknown VECTOR_INDEX %q", mode)
+}
+
+// AtlasVectorIndex searches with MongoDB Atlas $vectorSearch. Atlas indexes
+// caption_chunks itself, so Upsert and Delete are no-ops.
+type AtlasVectorIndex struct{}
+
+// Name returns the backend name
+func (a *AtlasVectorIndex) Name() string {
+	return VectorIndexAtlas
+}
+
+// Upsert is a no-op; Atlas picks up writes to caption_chunks
+func (a *AtlasVectorIndex) Upsert(ctx context.Context, noteID string, version int, chunks []VectorEntry) error {
+	return nil
+}
+
+// Delete is a no-op; Atlas picks up deletes from caption_chunks
+func (a *AtlasVectorIndex) Delete(ctx context.Context, noteID string) error {
+	return nil
+}
+
+// DeleteFolder is a no-op; Atlas picks up deletes from caption_chunks
+func (a *AtlasVectorIndex) DeleteFolder(ctx context.Context, folderID string) error {
+	return nil
+}
+
+// Search runs $vectorSearch against the vector_index search index
+func (a *AtlasVectorIndex) Search(ctx context.Context, query []float32, limit int, filter VectorFilter) ([]VectorMatch, error) {
+	searchFilter := bson.M{}
+	if filter.OwnerID != "" {
+		searchFilter["ownerId"] = filter.OwnerID
+	}
+	if filter.FolderID != "" {
+		searchFilter["folderId"] = filter.FolderID
+	}
+	if filter.Version != 0 {
+		searchFilter["embeddingVersion"] = filter.Version
+	}
+
+	stage := bson.M{
+		"index":         vectorIndexName,
+		"path":          "vector",
+		"queryVector":   query,
+		"numCandidates": limit * chunkOversample * 10, // Get more candidates for better recall
+		"limit":         limit * chunkOversample,
+	}
+	if len(searchFilter) > 0 {
+		stage["filter"] = searchFilter
+	}
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$vectorSearch", Value: stage}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"noteId":     1,
+			"chunkIndex": 1,
+			"score":      bson.M{"$meta": "vectorSearchScore"},
+		}}},
+		// Keep each note's best chunk; $first follows the score order of $vectorSearch
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":        "$noteId",
+			"chunkIndex": bson.M{"$first": "$chunkIndex"},
+			"score":      bson.M{"$first": "$score"},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := getChunkCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var matches []VectorMatch
+	for cursor.Next(ctx) {
+		var result struct {
+			NoteID     string  `bson:"_id"`
+			ChunkIndex int     `bson:"chunkIndex"`
+			Score      float32 `bson:"score"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			continue
+		}
+		matches = append(matches, VectorMatch{NoteID: result.NoteID, ChunkIndex: result.ChunkIndex, Score: result.Score})
+	}
+	return matches, cursor.Err()
+}
+
+// MemoryVectorIndex keeps the caption chunk embeddings of one embedding version in
+// per-owner HNSW graphs. Every search is scoped to one owner, so a graph per owner
+// keeps searches small and filter-free.
+type MemoryVectorIndex struct {
+	mu             sync.RWMutex
+	version        int                   // embedding version held; others are ignored
+	graphs         map[string]*hnswGraph // ownerID -> graph
+	notes          map[string]memoryNote // noteID -> where its chunks live
+	exactThreshold int
+	seed           int64
+}
+
+// memoryNote records the owner and graph keys of a note's chunks
+type memoryNote struct {
+	ownerID string
+	keys    []string
+}
+
+// NewMemoryVectorIndex returns an empty in-memory index for an embedding version
+func NewMemoryVectorIndex(version int) *MemoryVectorIndex {
+	return &MemoryVectorIndex{
+		version:        version,
+		graphs:         make(map[string]*hnswGraph),
+		notes:          make(map[string]memoryNote),
+		exactThreshold: memoryExactThreshold,
+		seed:           time.Now().UnixNano(),
+	}
+}
+
+// Name returns the backend name
+func (m *MemoryVectorIndex) Name() string {
+	return VectorIndexMemory
+}
+
+// Len returns the number of indexed notes
+func (m *MemoryVectorIndex) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.notes)
+}
+
+// chunkKey is the graph ID of a note's chunk
+func chunkKey(noteID string, chunk int) string {
+	return fmt.Sprintf("%s#%d", noteID, chunk)
+}
+
+// Upsert replaces every chunk of a note. Re-indexing identical chunks is a no-op,
+// so periodic syncs that overlap earlier ones do not churn the graph. Chunks of
+// other embedding versions are ignored.
+func (m *MemoryVectorIndex) Upsert(ctx context.Context, noteID string, version int, chunks []VectorEntry) error {
+	vectors := make([][]float32, len(chunks))
+	for i, chunk := range chunks {
+		vectors[i] = normalizeVector(chunk.Vector)
+		if vectors[i] == nil {
+			return fmt.Errorf("cannot index empty vector for note %s chunk %d", noteID, chunk.ChunkIndex)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if version != m.version {
+		return nil
+	}
+	if m.unchangedLocked(noteID, chunks, vectors) {
+		return nil
+	}
+	m.removeLocked(noteID)
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	ownerID := chunks[0].OwnerID
+	graph := m.graphs[ownerID]
+	if graph == nil {
+		m.seed++
+		graph = newHNSWGraph(hnswM, hnswEfConstruction, m.seed)
+		m.graphs[ownerID] = graph
+	}
+
+	note := memoryNote{ownerID: ownerID, keys: make([]string, len(chunks))}
+	for i, chunk := range chunks {
+		note.keys[i] = chunkKey(noteID, chunk.ChunkIndex)
+		graph.insert(note.keys[i], hnswRef{noteID: noteID, folderID: chunk.FolderID, chunk: chunk.ChunkIndex}, vectors[i])
+	}
+	m.notes[noteID] = note
+	return nil
+}
+
+// unchangedLocked reports whether the note is already indexed with exactly these chunks
+func (m *MemoryVectorIndex) unchangedLocked(noteID string, chunks []VectorEntry, vectors [][]float32) bool {
+	note, ok := m.notes[noteID]
+	if !ok || len(note.keys) != len(chunks) || len(chunks) == 0 || note.ownerID != chunks[0].OwnerID {
+		return false
+	}
+	graph := m.graphs[note.ownerID]
+	for i, chunk := range chunks {
+		idx, ok := graph.byID[chunkKey(noteID, chunk.ChunkIndex)]
+		if !ok {
+			return false
+		}
+		node := graph.nodes[idx]
+		if node.ref.folderID != chunk.FolderID || !slices.Equal(node.vector, vectors[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete removes a note's chunks
+func (m *MemoryVectorIndex) Delete(ctx context.Context, noteID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeLocked(noteID)
+	return nil
+}
+
+// DeleteFolder removes the chunks of every note directly in a folder
+func (m *MemoryVectorIndex) DeleteFolder(ctx context.Context, folderID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var noteIDs []string
+	for _, graph := range m.graphs {
+		for _, node := range graph.nodes {
+			if !node.deleted && node.ref.folderID == folderID {
+				noteIDs = append(noteIDs, node.ref.noteID)
+			}
+		}
+	}
+	for _, noteID := range noteIDs {
+		m.removeLocked(noteID)
+	}
+	return nil
+}
+
+// removeLocked drops a note's chunks from its owner's graph, compacting the graph
+// once deleted nodes dominate it. Callers must hold the write lock.
+func (m *MemoryVectorIndex) removeLocked(noteID string) {
+	note, ok := m.notes[noteID]
+	if !ok {
+		return
+	}
+	delete(m.notes, noteID)
+
+	graph := m.graphs[note.ownerID]
+	if graph == nil {
+		return
+	}
+	for _, key := range note.keys {
+		graph.remove(key)
+	}
+	switch {
+	case graph.len() == 0:
+		delete(m.graphs, note.ownerID)
+	case graph.needsRebuild():
+		m.graphs[note.ownerID] = graph.rebuilt()
+	}
+}
+
+// Search returns the notes whose chunks are nearest to query, best first, each
+// with its best chunk
+func (m *MemoryVectorIndex) Search(ctx context.Context, query []float32, limit int, filter VectorFilter) ([]VectorMatch, error) {
+	normalized := normalizeVector(query)
+	if normalized == nil || limit <= 0 {
+		return []VectorMatch{}, nil
+	}
+
+	var accept func(*hnswNode) bool
+	if filter.FolderID != "" {
+		accept = func(n *hnswNode) bool { return n.ref.folderID == filter.FolderID }
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if filter.Version != 0 && filter.Version != m.version {
+		return []VectorMatch{}, nil
+	}
+
+	var graphs []*hnswGraph
+	if filter.OwnerID != "" {
+		if graph := m.graphs[filter.OwnerID]; graph != nil {
+			graphs = append(graphs, graph)
+		}
+	} else {
+		for _, graph := range m.graphs {
+			graphs = append(graphs, graph)
+		}
+	}
+
+	best := make(map[string]VectorMatch)
+	for _, graph := range graphs {
+		var found []hnswCandidate
+		// Small graphs and folder-scoped searches (few matching nodes, which a
+		// post-filtered graph walk could miss) are scanned exactly
+		if graph.len() <= m.exactThreshold || accept != nil {
+			found = graph.exact(normalized, graph.len(), accept)
+		} else {
+			k := limit * chunkOversample
+			found = graph.search(normalized, k, max(hnswEfSearch, 2*k), nil)
+		}
+		for _, c := range found {
+			ref := graph.nodes[c.node].ref
+			score := (2 - c.dist) / 2 // (1 + cosine) / 2
+			if current, ok := best[ref.noteID]; !ok || score > current.Score {
+				best[ref.noteID] = VectorMatch{NoteID: ref.noteID, ChunkIndex: ref.chunk, Score: score}
+			}
+		}
+	}
+
+	matches := make([]VectorMatch, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].NoteID < matches[j].NoteID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// Load indexes the chunks of notes embedded after since (all notes for the zero time)
+// and returns the newest updatedAt seen, for the next incremental load
+func (m *MemoryVectorIndex) Load(ctx context.Context, since time.Time) (time.Time, error) {
+	m.mu.RLock()
+	version := m.version
+	m.mu.RUnlock()
+
+	filter := bson.M{"embeddingVersion": version}
+	if !since.IsZero() {
+		filter["updatedAt"] = bson.M{"$gt": since}
+	}
+	// Chunks arrive grouped by note so each note is replaced in one Upsert
+	opts := options.Find().
+		SetProjection(bson.M{"noteId": 1, "folderId": 1, "ownerId": 1, "chunkIndex": 1, "vector": 1, "updatedAt": 1}).
+		SetSort(bson.D{{Key: "noteId", Value: 1}, {Key: "chunkIndex", Value: 1}}).
+		SetBatchSize(500)
+
+	cursor, err := getChunkCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return since, fmt.Errorf("failed to load caption chunks: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	newest := since
+	loaded := 0
+	var noteID string
+	var pending []VectorEntry
+	flush := func() {
+		if len(pending) > 0 && m.Upsert(ctx, noteID, version, pending) == nil {
+			loaded++
+		}
+		pending = nil
+	}
+	for cursor.Next(ctx) {
+		var doc models.CaptionChunk
+		if err := cursor.Decode(&doc); err != nil {
+			continue
+		}
+		if doc.NoteID != noteID {
+			flush()
+			noteID = doc.NoteID
+		}
+		pending = append(pending, VectorEntry{
+			NoteID:     doc.NoteID,
+			FolderID:   doc.FolderID,
+			OwnerID:    doc.OwnerID,
+			ChunkIndex: doc.ChunkIndex,
+			Vector:     doc.Vector,
+		})
+		if doc.UpdatedAt.After(newest) {
+			newest = doc.UpdatedAt
+		}
+	}
+	flush()
+	if err := cursor.Err(); err != nil {
+		return newest, fmt.Errorf("failed to load caption chunks: %w", err)
+	}
+
+	if loaded > 0 {
+		log.Printf("[VectorIndex] Loaded embeddings for %d notes into memory index", loaded)
+	}
+	return newest, nil
+}
+
+// Reload rebuilds the index for another embedding version, swapping it in once loaded
+// so searches keep using the previous version until then
+func (m *MemoryVectorIndex) Reload(ctx context.Context, version int) (time.Time, error) {
+	fresh := NewMemoryVectorIndex(version)
+	fresh.exactThreshold = m.exactThreshold
+	newest, err := fresh.Load(ctx, time.Time{})
+	if err != nil {
+		return newest, err
+	}
+
+	m.mu.Lock()
+	m.version, m.graphs, m.notes = fresh.version, fresh.graphs, fresh.notes
+	m.mu.Unlock()
+
+	log.Printf("[VectorIndex] Switched memory index to embedding version %d", version)
+	return newest, nil
+}
+
+// RunSync periodically loads embeddings written by other server instances and follows
+// switch-overs to a new embedding version. Each round looks back a little before the
+// newest embedding it has seen, so chunks written just after a round started are not
+// skipped. Deletions elsewhere need no sync: search results are joined with
+// caption_embeddings, which drops notes that no longer exist.
+func (m *MemoryVectorIndex) RunSync(ctx context.Context, interval time.Duration, since time.Time) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := RefreshEmbeddingVersions(ctx); err != nil {
+				log.Printf("[VectorIndex] Failed to refresh embedding versions: %v", err)
+			}
+			m.mu.RLock()
+			current := m.version
+			m.mu.RUnlock()
+			if active := ActiveEmbeddingVersion(); active != nil && active.Version != current {
+				newest, err := m.Reload(ctx, active.Version)
+				if err != nil {
+					log.Printf("[VectorIndex] Failed to load embedding version %d: %v", active.Version, err)
+					continue
+				}
+				since = newest.Add(-vectorIndexSyncLookback)
+				continue
+			}
+
+			newest, err := m.Load(ctx, since)
+			if err != nil {
+				log.Printf("[VectorIndex] Sync failed: %v", err)
+				continue
+			}
+			since = newest.Add(-vectorIndexSyncLookback)
+		}
+	}
+}
+
+// vectorIndexSyncInterval reads VECTOR_INDEX_SYNC_INTERVAL (e.g. "30s")
+func vectorIndexSyncInterval() time.Duration {
+	return envDuration("VECTOR_INDEX_SYNC_INTERVAL", vectorIndexSyncIntervalDefault)
+}
+
+// startVectorIndexSync syncs the memory index in the background from loadStart,
+// less a margin for clock skew between instances
+func startVectorIndexSync(index *MemoryVectorIndex, loadStart time.Time) {
+	go index.RunSync(context.Background(), vectorIndexSyncInterval(), loadStart.Add(-vectorIndexSyncLookback))
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func randomVector(rng *rand.Rand, dims int) []float32 {
+	v := make([]float32, dims)
+	for i := range v {
+		v[i] = float32(rng.NormFloat64())
+	}
+	return v
+}
+
+func TestHNSWRecallMatchesExactSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	graph := newHNSWGraph(hnswM, hnswEfConstruction, 1)
+	for i := 0; i < 2000; i++ {
+		graph.insert(fmt.Sprintf("n%d", i), hnswRef{}, normalizeVector(randomVector(rng, 32)))
+	}
+
+	const k = 10
+	found, total := 0, 0
+	for q := 0; q < 50; q++ {
+		query := normalizeVector(randomVector(rng, 32))
+		want := make(map[int32]bool)
+		for _, c := range graph.exact(query, k, nil) {
+			want[c.node] = true
+		}
+		for _, c := range graph.search(query, k, hnswEfSearch, nil) {
+			if want[c.node] {
+				found++
+			}
+		}
+		total += k
+	}
+
+	if recall := float64(found) / float64(total); recall < 0.9 {
+		t.Errorf("HNSW recall@%d = %.2f, want >= 0.9", k, recall)
+	}
+}
+
+func TestHNSWRemoveAndRebuild(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	graph := newHNSWGraph(hnswM, hnswEfConstruction, 2)
+	for i := 0; i < 200; i++ {
+		graph.insert(fmt.Sprintf("n%d", i), hnswRef{}, normalizeVector(randomVector(rng, 8)))
+	}
+	for i := 0; i < 150; i++ {
+		graph.remove(fmt.Sprintf("n%d", i))
+	}
+
+	if !graph.needsRebuild() {
+		t.Fatal("graph with mostly deleted nodes should need a rebuild")
+	}
+	for _, c := range graph.search(normalizeVector(randomVector(rng, 8)), 100, 200, nil) {
+		if graph.nodes[c.node].deleted {
+			t.Fatal("search returned a deleted node")
+		}
+	}
+
+	fresh := graph.rebuilt()
+	if fresh.len() != 50 || len(fresh.nodes) != 50 {
+		t.Errorf("rebuilt graph has %d live of %d nodes, want 50 of 50", fresh.len(), len(fresh.nodes))
+	}
+}
+
+func TestMemoryVectorIndexSearch(t *testing.T) {
+	ctx := context.Background()
+	index := NewMemoryVectorIndex(1)
+
+	entries := []VectorEntry{
+		{NoteID: "a", FolderID: "f1", OwnerID: "alice", Vector: []float32{1, 0, 0}},
+		{NoteID: "b", FolderID: "f2", OwnerID: "alice", Vector: []float32{0.8, 0.6, 0}},
+		{NoteID: "c", FolderID: "f1", OwnerID: "alice", Vector: []float32{0, 0, 1}},
+		{NoteID: "d", FolderID: "f1", OwnerID: "bob", Vector: []float32{1, 0, 0}},
+	}
+	for _, entry := range entries {
+		if err := index.Upsert(ctx, entry.NoteID, 1, []VectorEntry{entry}); err != nil {
+			t.Fatalf("Upsert(%s) error = %v", entry.NoteID, err)
+		}
+	}
+
+	matches, _ := index.Search(ctx, []float32{2, 0, 0}, 10, VectorFilter{OwnerID: "alice"})
+	if len(matches) != 3 || matches[0].NoteID != "a" || matches[1].NoteID != "b" {
+		t.Fatalf("Search() = %+v, want a, b, c for alice only", matches)
+	}
+	if math.Abs(float64(matches[0].Score)-1) > 1e-6 || math.Abs(float64(matches[2].Score)-0.5) > 1e-6 {
+		t.Errorf("scores = %v, %v, want 1 for identical and 0.5 for orthogonal", matches[0].Score, matches[2].Score)
+	}
+
+	matches, _ = index.Search(ctx, []float32{1, 0, 0}, 10, VectorFilter{OwnerID: "alice", FolderID: "f2"})
+	if len(matches) != 1 || matches[0].NoteID != "b" {
+		t.Errorf("folder-scoped Search() = %+v, want only b", matches)
+	}
+
+	// Re-embedding a note replaces its vector
+	index.Upsert(ctx, "c", 1, []VectorEntry{{NoteID: "c", FolderID: "f1", OwnerID: "alice", Vector: []float32{1, 0.01, 0}}})
+	matches, _ = index.Search(ctx, []float32{1, 0, 0}, 1, VectorFilter{OwnerID: "alice", FolderID: "f1"})
+	if index.Len() != 4 || len(matches) != 1 {
+		t.Fatalf("after replace: Len() = %d, matches = %+v", index.Len(), matches)
+	}
+
+	index.Delete(ctx, "a")
+	index.DeleteFolder(ctx, "f2")
+	matches, _ = index.Search(ctx, []float32{1, 0, 0}, 10, VectorFilter{OwnerID: "alice"})
+	if len(matches) != 1 || matches[0].NoteID != "c" {
+		t.Errorf("after deletes Search() = %+v, want only c", matches)
+	}
+	if index.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", index.Len())
+	}
+}
+
+func TestMemoryVectorIndexUsesHNSWForLargeOwners(t *testing.T) {
+	ctx := context.Background()
+	index := NewMemoryVectorIndex(1)
+	index.exactThreshold = 0
+
+	rng := rand.New(rand.NewSource(3))
+	target := randomVector(rng, 16)
+	index.Upsert(ctx, "target", 1, []VectorEntry{{NoteID: "target", OwnerID: "alice", Vector: target}})
+	for i := 0; i < 300; i++ {
+		id := fmt.Sprintf("n%d", i)
+		index.Upsert(ctx, id, 1, []VectorEntry{{NoteID: id, OwnerID: "alice", Vector: randomVector(rng, 16)}})
+	}
+
+	matches, err := index.Search(ctx, target, 5, VectorFilter{OwnerID: "alice"})
+	if err != nil || len(matches) != 5 || matches[0].NoteID != "target" {
+		t.Errorf("Search() = %+v, %v, want target first", matches, err)
+	}
+	if _, err := index.Search(ctx, []float32{0, 0}, 5, VectorFilter{}); err != nil {
+		t.Errorf("Search() with a zero vector error = %v", err)
+	}
+}
+
+func TestMemoryVectorIndexReturnsBestChunkPerNote(t *testing.T) {
+	ctx := context.Background()
+	index := NewMemoryVectorIndex(1)
+
+	index.Upsert(ctx, "long", 1, []VectorEntry{
+		{NoteID: "long", OwnerID: "alice", ChunkIndex: 0, Vector: []float32{0, 1, 0}},
+		{NoteID: "long", OwnerID: "alice", ChunkIndex: 1, Vector: []float32{1, 0, 0}},
+		{NoteID: "long", OwnerID: "alice", ChunkIndex: 2, Vector: []float32{0.9, 0.1, 0}},
+	})
+	index.Upsert(ctx, "short", 1, []VectorEntry{{NoteID: "short", OwnerID: "alice", Vector: []float32{0.7, 0.7, 0}}})
+
+	matches, _ := index.Search(ctx, []float32{1, 0, 0}, 10, VectorFilter{OwnerID: "alice"})
+	if len(matches) != 2 {
+		t.Fatalf("Search() = %+v, want one match per note", matches)
+	}
+	if matches[0].NoteID != "long" || matches[0].ChunkIndex != 1 {
+		t.Errorf("best match = %+v, want note long chunk 1", matches[0])
+	}
+
+	// Re-embedding with fewer chunks drops the old ones
+	index.Upsert(ctx, "long", 1, []VectorEntry{{NoteID: "long", OwnerID: "alice", Vector: []float32{0, 0, 1}}})
+	matches, _ = index.Search(ctx, []float32{1, 0, 0}, 1, VectorFilter{OwnerID: "alice"})
+	if matches[0].NoteID != "short" {
+		t.Errorf("after re-embedding best match = %+v, want short", matches[0])
+	}
+	if graph := index.graphs["alice"]; graph.len() != 2 {
+		t.Errorf("graph holds %d live chunks, want 2", graph.len())
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -16,16 +17,23 @@ import (
 
 const (
 	vectorCollectionName = "caption_embeddings"
+	chunkCollectionName  = "caption_chunks"
 	vectorIndexName      = "vector_index"
 	vectorDimension      = 1024 // llama-nemotron-embed-1b-v2 produces 1024 dimensions
 )
 
-// getVectorCollection returns the collection for caption embeddings
+// getVectorCollection returns the collection for captions, one document per note
 func getVectorCollection() *mongo.Collection {
 	return database.Client.Database(os.Getenv("DB_NAME")).Collection(vectorCollectionName)
 }
 
-// EnsureVectorIndex ensures the vector index exists for semantic search
+// getChunkCollection returns the collection for embedded caption chunks
+func getChunkCollection() *mongo.Collection {
+	return database.Client.Database(os.Getenv("DB_NAME")).Collection(chunkCollectionName)
+}
+
+// EnsureVectorIndex creates the lookup and filter indexes for captions and their chunks
+// and reports whether the Atlas Vector Search index exists on caption_chunks.
 // Note: For MongoDB Atlas Vector Search, you need to create the index via the Atlas UI or API
 // The index definition should be:
 // {
@@ -43,95 +51,156 @@ func getVectorCollection() *mongo.Collection {
 //     {
 //       "path": "folderId",
 //       "type": "filter"
+//     },
+//     {
+//       "path": "embeddingVersion",
+//       "type": "filter"
 //     }
 //   ]
 // }
-func EnsureVectorIndex() error {
-	collection := getVectorCollection()
+// Without it (self-hosted MongoDB, tests) semantic search uses the in-memory index.
+func EnsureVectorIndex() (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Check if index already exists
-	cursor, err := collection.Indexes().List(ctx)
-	if err != nil {
-		return err
+	// Regular compound index used for filtering
+	indexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "ownerId", Value: 1},
+			{Key: "folderId", Value: 1},
+		},
+		Options: options.Index().SetName("owner_folder_index"),
 	}
 
-	hasVectorIndex := false
-	for cursor.Next(ctx) {
-		var index bson.M
-		if err := cursor.Decode(&index); err == nil {
-			if name, ok := index["name"].(string); ok && name == vectorIndexName {
-				hasVectorIndex = true
-				break
-			}
-		}
+	if _, err := getVectorCollection().Indexes().CreateOne(ctx, indexModel); err != nil {
+		log.Printf("[VectorService] Warning: Could not create backup index: %v", err)
 	}
 
-	if hasVectorIndex {
-		log.Printf("[VectorService] Vector index '%s' already exists", vectorIndexName)
-		return nil
+	// Lets the re-embedding worker find captions behind the target embedding version
+	versionIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "embeddingVersion", Value: 1}},
+		Options: options.Index().SetName("embedding_version_index"),
+	}
+	if _, err := getVectorCollection().Indexes().CreateOne(ctx, versionIndex); err != nil {
+		log.Printf("[VectorService] Warning: Could not create embedding version index: %v", err)
 	}
 
-	// For MongoDB Atlas Vector Search, the index needs to be created via Atlas
-	// We'll create a regular compound index as a fallback for filtering
-	indexModel := mongo.IndexModel{
-		Keys: bson.D{
-			{Key: "ownerId", Value: 1},
-			{Key: "folderId", Value: 1},
+	chunkIndexes := []mongo.IndexModel{
+		indexModel,
+		{
+			Keys:    bson.D{{Key: "noteId", Value: 1}, {Key: "embeddingVersion", Value: 1}, {Key: "chunkIndex", Value: 1}},
+			Options: options.Index().SetName("note_version_chunk_index").SetUnique(true),
 		},
-		Options: options.Index().SetName("owner_folder_index"),
+		{
+			Keys:    bson.D{{Key: "updatedAt", Value: 1}},
+			Options: options.Index().SetName("updated_at_index"),
+		},
+	}
+	// Superseded by note_version_chunk_index, which lets versions coexist during re-embedding
+	getChunkCollection().Indexes().DropOne(ctx, "note_chunk_index")
+	if _, err := getChunkCollection().Indexes().CreateMany(ctx, chunkIndexes); err != nil {
+		log.Printf("[VectorService] Warning: Could not create chunk indexes: %v", err)
 	}
 
-	_, err = collection.Indexes().CreateOne(ctx, indexModel)
+	// $listSearchIndexes only exists on Atlas; any error means no vector search index
+	cursor, err := getChunkCollection().SearchIndexes().List(ctx, options.SearchIndexes().SetName(vectorIndexName))
 	if err != nil {
-		log.Printf("[VectorService] Warning: Could not create backup index: %v", err)
+		return false, nil
+	}
+	defer cursor.Close(ctx)
+
+	if cursor.Next(ctx) {
+		log.Printf("[VectorService] Vector index '%s' already exists", vectorIndexName)
+		return true, nil
 	}
 
-	log.Println("[VectorService] Vector search index not found. Please create it via MongoDB Atlas UI:")
-	log.Println("  Navigate to: Collections > caption_embeddings > Create Search Index")
-	log.Println("  Use the following JSON definition:")
-	log.Println(`  {
-    "fields": [
-      {
-        "numDimensions": 1024,
-        "path": "vector",
-        "similarity": "cosine",
-        "type": "vector"
-      },
-      {
-        "path": "ownerId",
-        "type": "filter"
-      },
-      {
-        "path": "folderId",
-        "type": "filter"
-      }
-    ]
-  }`)
+	log.Println("[VectorService] Vector search index not found. To use Atlas Vector Search, create it via MongoDB Atlas UI:")
+	log.Println("  Navigate to: Collections > caption_chunks > Create Search Index")
+	log.Println("  Use the JSON definition documented on EnsureVectorIndex")
+
+	return false, nil
+}
+
+// migrateLegacyEmbeddings moves whole-caption vectors stored on caption_embeddings
+// before chunking into caption_chunks as a single legacy-version chunk spanning the caption
+func migrateLegacyEmbeddings(ctx context.Context) error {
+	cursor, err := getVectorCollection().Find(ctx, bson.M{"vector": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var embedding models.CaptionEmbedding
+		if err := cursor.Decode(&embedding); err != nil {
+			continue
+		}
+
+		if len(embedding.Vector) > 0 {
+			chunk := models.CaptionChunk{
+				ChunkIndex: 0,
+				Start:      0,
+				End:        len([]rune(embedding.Caption)),
+				Vector:     embedding.Vector,
+			}
+			if err := storeCaptionChunks(ctx, embedding.NoteID, embedding.FolderID, embedding.OwnerID, legacyEmbeddingVersion, []models.CaptionChunk{chunk}, embedding.UpdatedAt); err != nil {
+				return err
+			}
+		}
 
+		update := bson.M{"$set": bson.M{"chunkCount": 1}, "$unset": bson.M{"vector": ""}}
+		if len(embedding.Vector) == 0 {
+			update["$set"] = bson.M{"chunkCount": 0}
+		}
+		if _, err := getVectorCollection().UpdateByID(ctx, embedding.ID, update); err != nil {
+			return err
+		}
+		migrated++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		log.Printf("[VectorService] Migrated %d whole-caption embeddings to caption_chunks", migrated)
+	}
 	return nil
 }
 
-// StoreCaptionEmbedding stores a caption with its embedding vector
-func StoreCaptionEmbedding(noteID, folderID, ownerID, caption string, vector []float32) error {
+// StoreCaptionEmbedding stores a caption, its page offsets and its chunks embedded with
+// version (from EmbedCaption), replacing whatever was stored for the note in that
+// version before. Most callers want EmbedAndStoreCaption, which covers every writable version.
+func StoreCaptionEmbedding(noteID, folderID, ownerID, caption string, pageStarts []int, version *models.EmbeddingVersion, chunks []models.CaptionChunk) error {
 	collection := getVectorCollection()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Use upsert to update existing embedding or insert new one
+	now := time.Now()
+	if err := storeCaptionChunks(ctx, noteID, folderID, ownerID, version.Version, chunks, now); err != nil {
+		log.Printf("[VectorService] Failed to store chunks for note %s: %v", noteID, err)
+		return err
+	}
+
+	// Use upsert to update existing caption or insert new one
 	filter := bson.M{"noteId": noteID}
 	update := bson.M{
 		"$set": bson.M{
-			"noteId":    noteID,
-			"folderId":  folderID,
-			"ownerId":   ownerID,
-			"caption":   caption,
-			"vector":    vector,
-			"updatedAt": time.Now(),
+			"noteId":     noteID,
+			"folderId":   folderID,
+			"ownerId":    ownerID,
+			"caption":    caption,
+			"pageStarts": pageStarts,
+			"chunkCount": len(chunks),
+			// Record the version embedded most recently
+			"embeddingModel":     version.Model,
+			"embeddingDimension": version.Dimension,
+			"embeddingVersion":   version.Version,
+			"updatedAt":          now,
 		},
+		"$unset": bson.M{"vector": "", "reembedLeaseUntil": ""},
 		"$setOnInsert": bson.M{
-			"createdAt": time.Now(),
+			"createdAt": now,
 		},
 	}
 
@@ -142,77 +211,57 @@ func StoreCaptionEmbedding(noteID, folderID, ownerID, caption string, vector []f
 		return err
 	}
 
-	log.Printf("[VectorService] Stored embedding for note %s", noteID)
-	return nil
-}
-
-// SearchSimilarCaptions performs vector similarity search to find similar captions
-// Returns captions with their similarity scores (0-1, higher is more similar)
-func SearchSimilarCaptions(query string, limit int, ownerID string) ([]models.CaptionEmbedding, []float32, error) {
-	// Generate query embedding for the search text (uses "query" input_type)
-	queryVector, err := GenerateQueryEmbedding(query)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	collection := getVectorCollection()
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Build aggregation pipeline for vector search
-	pipeline := mongo.Pipeline{
-		// Vector search stage
-		bson.D{
-			{Key: "$vectorSearch", Value: bson.M{
-				"index":            vectorIndexName,
-				"path":             "vector",
-				"queryVector":      queryVector,
-				"numCandidates":    limit * 10, // Get more candidates for better recall
-				"limit":            limit,
-				"filter":           bson.M{"ownerId": ownerID}, // Only search within user's data
-			}},
-		},
-		// Project stage to include the score
-		bson.D{
-			{Key: "$project", Value: bson.M{
-				"_id":       1,
-				"noteId":    1,
-				"folderId":  1,
-				"ownerId":   1,
-				"caption":   1,
-				"createdAt": 1,
-				"updatedAt": 1,
-				"score": bson.M{
-					"$meta": "vectorSearchScore",
-				},
-			}},
-		},
-	}
-
-	cursor, err := collection.Aggregate(ctx, pipeline)
-	if err != nil {
-		return nil, nil, err
+	if vectorIndex != nil {
+		entries := make([]VectorEntry, len(chunks))
+		for i, chunk := range chunks {
+			entries[i] = VectorEntry{NoteID: noteID, FolderID: folderID, OwnerID: ownerID, Version: version.Version, ChunkIndex: chunk.ChunkIndex, Vector: chunk.Vector}
+		}
+		if err := vectorIndex.Upsert(ctx, noteID, version.Version, entries); err != nil {
+			log.Printf("[VectorService] Failed to index embedding for note %s: %v", noteID, err)
+		}
 	}
-	defer cursor.Close(ctx)
 
-	var results []models.CaptionEmbedding
-	var scores []float32
+	log.Printf("[VectorService] Stored %d embedded chunks for note %s (embedding version %d)", len(chunks), noteID, version.Version)
+	return nil
+}
 
-	for cursor.Next(ctx) {
-		var result struct {
-			models.CaptionEmbedding
-			Score float32 `bson:"score"`
+// storeCaptionChunks upserts a note's chunks for one embedding version and removes
+// chunks of that version left over from a longer previous caption
+func storeCaptionChunks(ctx context.Context, noteID, folderID, ownerID string, version int, chunks []models.CaptionChunk, now time.Time) error {
+	collection := getChunkCollection()
+
+	if len(chunks) > 0 {
+		writes := make([]mongo.WriteModel, len(chunks))
+		for i, chunk := range chunks {
+			writes[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"noteId": noteID, "embeddingVersion": version, "chunkIndex": chunk.ChunkIndex}).
+				SetUpdate(bson.M{
+					"$set": bson.M{
+						"folderId":  folderID,
+						"ownerId":   ownerID,
+						"start":     chunk.Start,
+						"end":       chunk.End,
+						"page":      chunk.Page,
+						"vector":    chunk.Vector,
+						"updatedAt": now,
+					},
+					"$setOnInsert": bson.M{"createdAt": now},
+				}).
+				SetUpsert(true)
 		}
-
-		if err := cursor.Decode(&result); err != nil {
-			continue
+		if _, err := collection.BulkWrite(ctx, writes); err != nil {
+			return err
 		}
-
-		results = append(results, result.CaptionEmbedding)
-		scores = append(scores, result.Score)
 	}
 
-	return results, scores, nil
+	_, err := collection.DeleteMany(ctx, bson.M{"noteId": noteID, "embeddingVersion": version, "chunkIndex": bson.M{"$gte": len(chunks)}})
+	return err
+}
+
+// SearchSimilarCaptions performs vector similarity search to find similar captions
+// Returns each caption once with its best-matching chunk and similarity score (0-1, higher is more similar)
+func SearchSimilarCaptions(query string, limit int, ownerID string) ([]CaptionMatch, error) {
+	return searchCaptions(query, limit, VectorFilter{OwnerID: ownerID})
 }
 
 // DeleteCaptionEmbedding removes the embedding for a specific note
@@ -222,12 +271,20 @@ func DeleteCaptionEmbedding(noteID string) error {
 	defer cancel()
 
 	filter := bson.M{"noteId": noteID}
+	if _, err := getChunkCollection().DeleteMany(ctx, filter); err != nil {
+		log.Printf("[VectorService] Failed to delete chunks for note %s: %v", noteID, err)
+		return err
+	}
 	result, err := collection.DeleteOne(ctx, filter)
 	if err != nil {
 		log.Printf("[VectorService] Failed to delete embedding for note %s: %v", noteID, err)
 		return err
 	}
 
+	if vectorIndex != nil {
+		vectorIndex.Delete(ctx, noteID)
+	}
+
 	if result.DeletedCount > 0 {
 		log.Printf("[VectorService] Deleted embedding for note %s", noteID)
 	}
@@ -242,12 +299,20 @@ func DeleteFolderEmbeddings(folderID string) error {
 	defer cancel()
 
 	filter := bson.M{"folderId": folderID}
+	if _, err := getChunkCollection().DeleteMany(ctx, filter); err != nil {
+		log.Printf("[VectorService] Failed to delete chunks for folder %s: %v", folderID, err)
+		return err
+	}
 	result, err := collection.DeleteMany(ctx, filter)
 	if err != nil {
 		log.Printf("[VectorService] Failed to delete embeddings for folder %s: %v", folderID, err)
 		return err
 	}
 
+	if vectorIndex != nil {
+		vectorIndex.DeleteFolder(ctx, folderID)
+	}
+
 	if result.DeletedCount > 0 {
 		log.Printf("[VectorService] Deleted %d embeddings for folder %s", result.DeletedCount, folderID)
 	}
@@ -255,6 +320,72 @@ func DeleteFolderEmbeddings(folderID string) error {
 	return nil
 }
 
+// MoveCaptionEmbedding points a note's caption and chunks at a new folder after the
+// note was moved, so folder-scoped search finds it in its new place
+func MoveCaptionEmbedding(noteID, folderID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"noteId": noteID}
+	update := bson.M{"$set": bson.M{"folderId": folderID, "updatedAt": time.Now()}}
+	if _, err := getChunkCollection().UpdateMany(ctx, filter, update); err != nil {
+		log.Printf("[VectorService] Failed to move chunks for note %s: %v", noteID, err)
+		return err
+	}
+	if _, err := getVectorCollection().UpdateOne(ctx, filter, update); err != nil {
+		log.Printf("[VectorService] Failed to move embedding for note %s: %v", noteID, err)
+		return err
+	}
+
+	// Re-index the note's active chunks so this instance sees the new folder right away
+	active := ActiveEmbeddingVersion()
+	if vectorIndex == nil || active == nil {
+		return nil
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "chunkIndex", Value: 1}})
+	cursor, err := getChunkCollection().Find(ctx, bson.M{"noteId": noteID, "embeddingVersion": active.Version}, opts)
+	if err != nil {
+		log.Printf("[VectorService] Failed to reload chunks for note %s: %v", noteID, err)
+		return nil
+	}
+	var chunks []models.CaptionChunk
+	if err := cursor.All(ctx, &chunks); err != nil || len(chunks) == 0 {
+		return nil
+	}
+	entries := make([]VectorEntry, len(chunks))
+	for i, chunk := range chunks {
+		entries[i] = VectorEntry{NoteID: noteID, FolderID: folderID, OwnerID: chunk.OwnerID, Version: active.Version, ChunkIndex: chunk.ChunkIndex, Vector: chunk.Vector}
+	}
+	if err := vectorIndex.Upsert(ctx, noteID, active.Version, entries); err != nil {
+		log.Printf("[VectorService] Failed to re-index moved note %s: %v", noteID, err)
+	}
+	return nil
+}
+
+// RestageCaption replaces a note's stored caption and page offsets without embedding
+// them. Clearing the embedding version hands the note to the re-embed worker, which
+// embeds the new caption; until then search keeps using the old chunks.
+func RestageCaption(ctx context.Context, noteID, folderID, ownerID, caption string, pageStarts []int) error {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"folderId":   folderID,
+			"ownerId":    ownerID,
+			"caption":    caption,
+			"pageStarts": pageStarts,
+			"updatedAt":  now,
+		},
+		"$unset":       bson.M{"embeddingModel": "", "embeddingDimension": "", "embeddingVersion": "", "reembedLeaseUntil": ""},
+		"$setOnInsert": bson.M{"createdAt": now, "chunkCount": 0},
+	}
+	opts := options.Update().SetUpsert(true)
+	if _, err := getVectorCollection().UpdateOne(ctx, bson.M{"noteId": noteID}, update, opts); err != nil {
+		log.Printf("[VectorService] Failed to restage caption for note %s: %v", noteID, err)
+		return err
+	}
+	return nil
+}
+
 // GetCaptionEmbedding retrieves the embedding for a specific note
 func GetCaptionEmbedding(noteID string) (*models.CaptionEmbedding, error) {
 	collection := getVectorCollection()
@@ -272,74 +403,132 @@ func GetCaptionEmbedding(noteID string) (*models.CaptionEmbedding, error) {
 }
 
 // SearchCaptionsInFolder performs vector search within a specific folder
-func SearchCaptionsInFolder(query string, limit int, folderID, ownerID string) ([]models.CaptionEmbedding, []float32, error) {
-	// Generate query embedding for the search text (uses "query" input_type)
-	queryVector, err := GenerateQueryEmbedding(query)
+func SearchCaptionsInFolder(query string, limit int, folderID, ownerID string) ([]CaptionMatch, error) {
+	return searchCaptions(query, limit, VectorFilter{OwnerID: ownerID, FolderID: folderID})
+}
+
+// CaptionMatch is a caption found by vector search, with the chunk that matched best.
+// ChunkStart and ChunkEnd are character offsets into Caption.
+type CaptionMatch struct {
+	models.CaptionEmbedding
+	ChunkIndex int
+	ChunkStart int
+	ChunkEnd   int
+	ChunkPage  int
+	Score      float32
+}
+
+// ChunkText returns the matched part of the caption
+func (m CaptionMatch) ChunkText() string {
+	return chunkText(m.Caption, m.ChunkStart, m.ChunkEnd)
+}
+
+// searchCaptions embeds the query, finds the nearest notes in the vector index and
+// loads their captions and matched chunk offsets, keeping the index's order
+func searchCaptions(query string, limit int, filter VectorFilter) ([]CaptionMatch, error) {
+	if vectorIndex == nil {
+		return nil, ErrVectorIndexNotInitialized
+	}
+	active := ActiveEmbeddingVersion()
+	if active == nil {
+		return nil, ErrNoEmbeddingVersion
+	}
+	filter.Version = active.Version
+
+	// Embed the query with the active version's model (uses "query" input_type)
+	queryVector, err := GenerateQueryEmbeddingWith(versionModel(active), query)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	collection := getVectorCollection()
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	pipeline := mongo.Pipeline{
-		bson.D{
-			{Key: "$vectorSearch", Value: bson.M{
-				"index":       vectorIndexName,
-				"path":        "vector",
-				"queryVector": queryVector,
-				"numCandidates": limit * 10,
-				"limit":       limit,
-				"filter": bson.M{
-					"folderId": folderID,
-					"ownerId":  ownerID,
-				},
-			}},
-		},
-		bson.D{
-			{Key: "$project", Value: bson.M{
-				"_id":       1,
-				"noteId":    1,
-				"folderId":  1,
-				"ownerId":   1,
-				"caption":   1,
-				"createdAt": 1,
-				"updatedAt": 1,
-				"score": bson.M{
-					"$meta": "vectorSearchScore",
-				},
-			}},
-		},
+	matches, err := vectorIndex.Search(ctx, queryVector, limit, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return []CaptionMatch{}, nil
 	}
 
-	cursor, err := collection.Aggregate(ctx, pipeline)
-	if err != nil {
-		return nil, nil, err
+	noteIDs := make([]string, len(matches))
+	for i, match := range matches {
+		noteIDs[i] = match.NoteID
 	}
-	defer cursor.Close(ctx)
 
-	var results []models.CaptionEmbedding
-	var scores []float32
+	// Vectors are not needed by callers
+	opts := options.Find().SetProjection(bson.M{"vector": 0})
+	cursor, err := getVectorCollection().Find(ctx, bson.M{"noteId": bson.M{"$in": noteIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var embeddings []models.CaptionEmbedding
+	if err := cursor.All(ctx, &embeddings); err != nil {
+		return nil, err
+	}
+	byNote := make(map[string]models.CaptionEmbedding, len(embeddings))
+	for _, embedding := range embeddings {
+		byNote[embedding.NoteID] = embedding
+	}
 
-	for cursor.Next(ctx) {
-		var result struct {
-			models.CaptionEmbedding
-			Score float32 `bson:"score"`
-		}
+	cursor, err = getChunkCollection().Find(ctx, bson.M{"noteId": bson.M{"$in": noteIDs}, "embeddingVersion": active.Version}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []models.CaptionChunk
+	if err := cursor.All(ctx, &chunks); err != nil {
+		return nil, err
+	}
+	offsets := make(map[string]models.CaptionChunk, len(chunks))
+	for _, chunk := range chunks {
+		offsets[chunkKey(chunk.NoteID, chunk.ChunkIndex)] = chunk
+	}
 
-		if err := cursor.Decode(&result); err != nil {
+	// Notes deleted since they were indexed are dropped here
+	results := make([]CaptionMatch, 0, len(matches))
+	for _, match := range matches {
+		embedding, ok := byNote[match.NoteID]
+		if !ok {
 			continue
 		}
-
-		results = append(results, result.CaptionEmbedding)
-		scores = append(scores, result.Score)
+		result := CaptionMatch{CaptionEmbedding: embedding, ChunkIndex: match.ChunkIndex, Score: match.Score}
+		if chunk, ok := offsets[chunkKey(match.NoteID, match.ChunkIndex)]; ok {
+			result.ChunkStart, result.ChunkEnd, result.ChunkPage = chunk.Start, chunk.End, chunk.Page
+		}
+		results = append(results, result)
 	}
 
-	return results, scores, nil
+	return results, nil
 }
 
-// InitVectorService initializes the vector service by ensuring the vector index exists
+// InitVectorService ensures the indexes exist, moves legacy whole-caption vectors into
+// caption_chunks, loads the embedding versions and selects the vector index: Atlas
+// Vector Search when its index exists, otherwise an in-memory HNSW index loaded from
+// caption_chunks (override with VECTOR_INDEX=atlas|memory)
 func InitVectorService() error {
-	return EnsureVectorIndex()
+	hasAtlasIndex, err := EnsureVectorIndex()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := migrateLegacyEmbeddings(ctx); err != nil {
+		return fmt.Errorf("failed to migrate embeddings to chunks: %w", err)
+	}
+
+	if err := InitEmbeddingVersions(ctx); err != nil {
+		return err
+	}
+
+	index, err := newVectorIndexFromEnv(ctx, hasAtlasIndex)
+	if err != nil {
+		return err
+	}
+	vectorIndex = index
+
+	log.Printf("[VectorService] Using %s vector index", index.Name())
+	return nil
 }
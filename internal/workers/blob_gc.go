@@ -0,0 +1,36 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cogniscan/backend/internal/services"
+)
+
+// StartBlobGC starts the background worker that deletes blobs no node references
+// once they are older than grace. With dryRun it only logs what it finds.
+func StartBlobGC(ctx context.Context, interval, grace time.Duration, dryRun bool) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("[BlobGC] Exiting")
+				return
+			case <-ticker.C:
+			}
+			if _, err := services.CollectOrphanedBlobs(ctx, services.BlobGCOptions{GracePeriod: grace, DryRun: dryRun}); err != nil {
+				log.Printf("[BlobGC] %v", err)
+			}
+		}
+	}()
+
+	log.Printf("[BlobGC] Started (interval %s, grace %s, dry run %t)", interval, grace, dryRun)
+}
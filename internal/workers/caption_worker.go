@@ -2,13 +2,12 @@ package workers
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
-	"os"
-	"strconv"
 	"time"
 
-	"cogniscan/backend/internal/database"
+	"cogniscan/backend/internal/models"
 	"cogniscan/backend/internal/queue"
 	"cogniscan/backend/internal/services"
 
@@ -17,8 +16,7 @@ import (
 )
 
 const (
-	maxRetryDefault = 3
-	workerTimeout  = 30 * time.Second
+	workerTimeout = 30 * time.Second
 )
 
 // StartCaptionWorker starts the caption generation worker pool
@@ -32,26 +30,30 @@ func StartCaptionWorker(ctx context.Context, workerCount int) {
 		workerCount = 3 // Default worker count
 	}
 
-	// Read max retry from env or use default
-	maxRetry := maxRetryDefault
-	if maxRetryStr := os.Getenv("CAPTION_MAX_RETRY"); maxRetryStr != "" {
-		if n, err := strconv.Atoi(maxRetryStr); err == nil && n > 0 {
-			maxRetry = n
+	q := services.GetCaptionQueue()
+	q.OnDeadLetter = func(d *queue.Delivery) {
+		var job queue.CaptionJob
+		if err := d.Decode(&job); err == nil {
+			updateJobStatus(&job, models.CaptionStatusFailed, d.LastError)
+			services.TrackJob(models.JobTypeCaption, job.ID, job.OwnerID, job.NoteID, models.JobStateFailed, d.Attempts, d.LastError)
 		}
 	}
 
-	log.Printf("[CaptionWorker] Starting %d workers with max retries: %d", workerCount, maxRetry)
+	log.Printf("[CaptionWorker] Starting %d workers with max retries: %d", workerCount, q.MaxRetry())
+
+	// Redeliver jobs abandoned by crashed workers and promote due retries
+	go q.RunRecovery(ctx, recoveryInterval)
 
 	// Start worker goroutines (run in background)
 	for i := 0; i < workerCount; i++ {
-		go worker(ctx, i, maxRetry)
+		go worker(ctx, i, q)
 	}
 
 	log.Println("[CaptionWorker] Workers started in background")
 }
 
 // worker is an individual worker goroutine that processes caption jobs
-func worker(ctx context.Context, id int, maxRetry int) {
+func worker(ctx context.Context, id int, q *queue.ReliableQueue) {
 	log.Printf("[CaptionWorker-%d] Started", id)
 
 	for {
@@ -60,8 +62,8 @@ func worker(ctx context.Context, id int, maxRetry int) {
 			log.Printf("[CaptionWorker-%d] Exiting", id)
 			return
 		default:
-			// Dequeue next job with timeout
-			job, err := services.DequeueCaptionJob(workerTimeout)
+			// Reserve next job with timeout
+			job, d, err := services.DequeueCaptionJob(workerTimeout)
 			if err != nil {
 				log.Printf("[CaptionWorker-%d] Error dequeuing: %v", id, err)
 				time.Sleep(5 * time.Second)
@@ -74,11 +76,10 @@ func worker(ctx context.Context, id int, maxRetry int) {
 				continue
 			}
 
-			log.Printf("[CaptionWorker-%d] Processing job %s for note %s", id, job.ID, job.NoteID)
+			log.Printf("[CaptionWorker-%d] Processing job %s for note %s (attempt %d)", id, job.ID, job.NoteID, d.Attempts+1)
 
-			// Process the job with retry logic
-			if err := processJobWithRetry(ctx, job, maxRetry); err != nil {
-				log.Printf("[CaptionWorker-%d] Job %s failed after %d retries: %v", id, job.ID, maxRetry, err)
+			if err := processDelivery(ctx, q, job, d); err != nil {
+				log.Printf("[CaptionWorker-%d] Job %s attempt %d failed: %v", id, job.ID, d.Attempts+1, err)
 			} else {
 				log.Printf("[CaptionWorker-%d] Job %s completed successfully", id, job.ID)
 			}
@@ -86,110 +87,157 @@ func worker(ctx context.Context, id int, maxRetry int) {
 	}
 }
 
-// processJobWithRetry processes a single job with retry logic
-func processJobWithRetry(ctx context.Context, job *queue.CaptionJob, maxRetry int) error {
-	var lastErr error
-
-	for attempt := 0; attempt <= maxRetry; attempt++ {
-		if attempt > 0 {
-			// Exponential backoff before retry
-			backoff := time.Duration(1<<uint(attempt)) * time.Second
-			log.Printf("[CaptionWorker] Retry %d/%d for job %s after %v", attempt, maxRetry, job.ID, backoff)
-			time.Sleep(backoff)
-		}
-
-		// Update status to "processing" (only on first attempt)
-		if attempt == 0 {
-			if err := updateNoteStatus(job.NoteID, "processing", ""); err != nil {
-				return err
-			}
+// processDelivery runs a reserved job and acks it, or hands the failure back to the
+// queue, which schedules a retry or dead-letters the job once retries are exhausted
+func processDelivery(ctx context.Context, q *queue.ReliableQueue, job *queue.CaptionJob, d *queue.Delivery) error {
+	attempt := d.Attempts + 1
+	services.TrackJob(models.JobTypeCaption, job.ID, job.OwnerID, job.NoteID, models.JobStateProcessing, attempt, "")
+
+	// Update status to "processing" (only on first attempt)
+	if d.Attempts == 0 {
+		if err := updateJobStatus(job, models.CaptionStatusProcessing, ""); err != nil {
+			failDelivery(ctx, q, job, d, err)
+			return err
 		}
+	}
 
-		// Process the job
-		if err := processCaptionJob(ctx, job); err != nil {
-			lastErr = err
-			log.Printf("[CaptionWorker] Job %s attempt %d failed: %v", job.ID, attempt+1, err)
-			continue
-		}
+	stop := keepLeaseAlive(ctx, q, d)
+	err := processCaptionJob(ctx, job)
+	stop()
 
-		// Success - update status to "completed"
-		return updateNoteStatus(job.NoteID, "completed", "")
+	if err != nil {
+		failDelivery(ctx, q, job, d, err)
+		return err
 	}
 
-	// All retries failed - mark as "failed"
-	return updateNoteStatus(job.NoteID, "failed", lastErr.Error())
+	// Success - update status to "completed"
+	if err := updateJobStatus(job, models.CaptionStatusCompleted, ""); err != nil {
+		log.Printf("[CaptionWorker] Failed to mark note %s completed: %v", job.NoteID, err)
+	}
+	if err := q.Ack(ctx, d); err != nil {
+		return err
+	}
+	services.TrackJob(models.JobTypeCaption, job.ID, job.OwnerID, job.NoteID, models.JobStateCompleted, attempt, "")
+	return nil
 }
 
-// processCaptionJob processes a single caption job
-func processCaptionJob(ctx context.Context, job *queue.CaptionJob) error {
-	// Download image from Drive
-	resp, err := services.DownloadFileContent(job.DriveID)
+// failDelivery hands a failed attempt back to the queue. Dead-lettered jobs are
+// reported by the queue's OnDeadLetter hook; everything else is waiting for a retry.
+func failDelivery(ctx context.Context, q *queue.ReliableQueue, job *queue.CaptionJob, d *queue.Delivery, jobErr error) {
+	deadLettered, err := q.Fail(ctx, d, jobErr)
 	if err != nil {
-		return err
+		log.Printf("[CaptionWorker] Failed to record failure for job %s: %v", job.ID, err)
+		return
+	}
+	if !deadLettered {
+		services.TrackJob(models.JobTypeCaption, job.ID, job.OwnerID, job.NoteID, models.JobStateRetrying, d.Attempts+1, jobErr.Error())
 	}
-	defer resp.Body.Close()
+}
 
-	// Read image bytes
-	imageBytes, err := io.ReadAll(resp.Body)
+// processCaptionJob transcribes a note node's image, or one page of it, and stores the
+// caption with its embedding
+func processCaptionJob(ctx context.Context, job *queue.CaptionJob) error {
+	node, err := services.GetNodeByID(ctx, job.NoteID)
 	if err != nil {
+		if err == services.ErrNodeNotFound {
+			log.Printf("[CaptionWorker] Node %s no longer exists, skipping job %s", job.NoteID, job.ID)
+			return nil // Node might have been deleted, not an error
+		}
 		return err
 	}
 
-	// Generate caption using AI service
-	caption, err := services.GenerateCaption(imageBytes)
+	transcription, err := transcribeBlob(ctx, job.DriveID)
 	if err != nil {
 		return err
 	}
+	caption := services.TranscriptionText(transcription)
 
-	// Update MongoDB with caption
-	notesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("notes")
-	noteCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if job.PageID != "" {
+		node, err = services.SetNotePageCaption(ctx, job.NoteID, job.PageID, caption, transcription)
+		if err == services.ErrPageNotFound {
+			log.Printf("[CaptionWorker] Page %s of note %s no longer exists, skipping job %s", job.PageID, job.NoteID, job.ID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// The note's caption is assembled from all of its transcribed pages
+		if err := services.EmbedNoteCaption(ctx, node); err != nil {
+			return fmt.Errorf("failed to embed caption: %w", err)
+		}
+		log.Printf("[CaptionWorker] Generated and saved transcription for page %s of note %s", job.PageID, job.NoteID)
+		return nil
+	}
 
-	objID, err := primitive.ObjectIDFromHex(job.NoteID)
-	if err != nil {
+	// Captions live in caption_embeddings, so without an embedding there is nowhere to keep them.
+	// Long transcriptions are embedded as overlapping chunks, once per writable embedding version.
+	if err := services.EmbedAndStoreCaption(job.NoteID, node.ParentID, node.OwnerID, caption, nil); err != nil {
+		return fmt.Errorf("failed to embed caption: %w", err)
+	}
+	if err := services.SetNoteTranscription(ctx, job.NoteID, transcription); err != nil {
 		return err
 	}
 
-	filter := bson.M{"_id": objID}
-	update := bson.M{"$set": bson.M{"caption": caption, "updatedAt": time.Now()}}
+	log.Printf("[CaptionWorker] Generated and saved transcription for note %s", job.NoteID)
+	return nil
+}
 
-	result, err := notesCollection.UpdateOne(noteCtx, filter, update)
+// transcribeBlob downloads an image from blob storage and transcribes it
+func transcribeBlob(ctx context.Context, driveID string) (*models.Transcription, error) {
+	blob, err := services.DownloadBlob(ctx, driveID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer blob.Body.Close()
 
-	if result.MatchedCount == 0 {
-		return nil // Note might have been deleted, not an error
+	imageBytes, err := io.ReadAll(blob.Body)
+	if err != nil {
+		return nil, err
 	}
+	return services.GenerateTranscription(imageBytes)
+}
 
-	// Fetch the note to get folderId and ownerId for vector storage
-	var note bson.M
-	if err := notesCollection.FindOne(noteCtx, filter).Decode(&note); err == nil {
-		// Generate embedding for the caption
-		vector, err := services.GenerateEmbedding(caption)
+// updateJobStatus updates the caption status of the job's page, if it has one, and of
+// its note, then the note's document index. A note with pages is only completed once
+// every page is transcribed.
+func updateJobStatus(job *queue.CaptionJob, status models.CaptionStatus, errorMsg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if job.PageID != "" {
+		node, err := services.SetNotePageStatus(ctx, job.NoteID, job.PageID, status, errorMsg)
+		if err == services.ErrPageNotFound {
+			return nil // Page might have been removed, not an error
+		}
 		if err != nil {
-			log.Printf("[CaptionWorker] Failed to generate embedding for note %s: %v", job.NoteID, err)
-		} else {
-			// Store the embedding in the vector collection
-			folderID, _ := note["folderId"].(string)
-			ownerID, _ := note["ownerId"].(string)
-
-			if err := services.StoreCaptionEmbedding(job.NoteID, folderID, ownerID, caption, vector); err != nil {
-				log.Printf("[CaptionWorker] Failed to store embedding for note %s: %v", job.NoteID, err)
-			} else {
-				log.Printf("[CaptionWorker] Stored embedding for note %s", job.NoteID)
-			}
+			return err
+		}
+		syncDocumentIndex(ctx, node)
+		if status == models.CaptionStatusCompleted && !services.NotePagesCaptioned(node) {
+			return nil
 		}
+		return updateNoteStatus(job.NoteID, status, errorMsg)
 	}
 
-	log.Printf("[CaptionWorker] Generated and saved transcription for note %s", job.NoteID)
+	if err := updateNoteStatus(job.NoteID, status, errorMsg); err != nil {
+		return err
+	}
+	if node, err := services.GetNodeByID(ctx, job.NoteID); err == nil {
+		syncDocumentIndex(ctx, node)
+	}
 	return nil
 }
 
-// updateNoteStatus updates the caption status of a note in MongoDB
-func updateNoteStatus(noteID string, status string, errorMsg string) error {
-	notesCollection := database.Client.Database(os.Getenv("DB_NAME")).Collection("notes")
+// syncDocumentIndex refreshes a note's document index; failures only cost accuracy
+// of the index status, so they are logged
+func syncDocumentIndex(ctx context.Context, node *models.Node) {
+	if err := services.SyncDocumentIndex(ctx, node); err != nil {
+		log.Printf("[CaptionWorker] Failed to sync document index of note %s: %v", node.ID.Hex(), err)
+	}
+}
+
+// updateNoteStatus updates the caption status of a note node in MongoDB
+func updateNoteStatus(noteID string, status models.CaptionStatus, errorMsg string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -207,7 +255,7 @@ func updateNoteStatus(noteID string, status string, errorMsg string) error {
 	}
 
 	filter := bson.M{"_id": objID}
-	result, err := notesCollection.UpdateOne(ctx, filter, update)
+	result, err := services.GetNodesCollection().UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
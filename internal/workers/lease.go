@@ -0,0 +1,41 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cogniscan/backend/internal/queue"
+)
+
+// recoveryInterval is how often workers look for expired leases and due retries
+const recoveryInterval = 15 * time.Second
+
+// keepLeaseAlive extends a reserved job's lease until the returned stop function is called,
+// so long-running jobs are not redelivered while a worker is still on them
+func keepLeaseAlive(ctx context.Context, q *queue.ReliableQueue, d *queue.Delivery) func() {
+	interval := q.VisibilityTimeout() / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := q.Extend(ctx, d); err != nil {
+					log.Printf("[Worker] Failed to extend lease for job %s: %v", d.ID, err)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
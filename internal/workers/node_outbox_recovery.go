@@ -0,0 +1,52 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cogniscan/backend/internal/services"
+)
+
+const (
+	nodeOutboxRecoveryIntervalDefault = time.Minute
+	// nodeOutboxRecoveryBatchSize caps the mutations undone per round
+	nodeOutboxRecoveryBatchSize = 100
+)
+
+// StartNodeOutboxRecovery starts the background worker that undoes node mutations
+// whose process died before they finished (outbox mode only; transactions need no
+// recovery). Runs on every instance; outbox entries are claimed with leases.
+func StartNodeOutboxRecovery(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = nodeOutboxRecoveryIntervalDefault
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			runNodeOutboxRecoveryRound(ctx)
+			select {
+			case <-ctx.Done():
+				log.Println("[NodeOutboxRecovery] Exiting")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	log.Printf("[NodeOutboxRecovery] Started (interval %s)", interval)
+}
+
+// runNodeOutboxRecoveryRound undoes abandoned mutations until none are left or a batch is done
+func runNodeOutboxRecoveryRound(ctx context.Context) {
+	recovered, err := services.RecoverNodeMutations(ctx, nodeOutboxRecoveryBatchSize)
+	if err != nil {
+		log.Printf("[NodeOutboxRecovery] %v", err)
+	}
+	if recovered > 0 {
+		log.Printf("[NodeOutboxRecovery] Undid %d unfinished node mutations", recovered)
+	}
+}
@@ -3,17 +3,15 @@ package workers
 import (
 	"context"
 	"log"
-	"os"
-	"strconv"
 	"time"
 
+	"cogniscan/backend/internal/models"
 	"cogniscan/backend/internal/queue"
 	"cogniscan/backend/internal/services"
 )
 
 const (
-	quizMaxRetryDefault = 3
-	quizWorkerTimeout   = 30 * time.Second
+	quizWorkerTimeout = 30 * time.Second
 )
 
 // StartQuizWorker starts the quiz generation worker pool
@@ -27,26 +25,30 @@ func StartQuizWorker(ctx context.Context, workerCount int) {
 		workerCount = 2 // Default worker count (quiz jobs may be longer)
 	}
 
-	// Read max retry from env or use default
-	maxRetry := quizMaxRetryDefault
-	if maxRetryStr := os.Getenv("QUIZ_MAX_RETRY"); maxRetryStr != "" {
-		if n, err := strconv.Atoi(maxRetryStr); err == nil && n > 0 {
-			maxRetry = n
+	q := services.GetQuizQueue()
+	q.OnDeadLetter = func(d *queue.Delivery) {
+		var job queue.QuizJob
+		if err := d.Decode(&job); err == nil {
+			services.UpdateFolderQuizStatus(context.Background(), job.FolderID, job.OwnerID, models.QuizGenStatusFailed, "", d.LastError)
+			services.TrackJob(models.JobTypeQuiz, job.ID, job.OwnerID, job.FolderID, models.JobStateFailed, d.Attempts, d.LastError)
 		}
 	}
 
-	log.Printf("[QuizWorker] Starting %d workers with max retries: %d", workerCount, maxRetry)
+	log.Printf("[QuizWorker] Starting %d workers with max retries: %d", workerCount, q.MaxRetry())
+
+	// Redeliver jobs abandoned by crashed workers and promote due retries
+	go q.RunRecovery(ctx, recoveryInterval)
 
 	// Start worker goroutines (run in background)
 	for i := 0; i < workerCount; i++ {
-		go quizWorker(ctx, i, maxRetry)
+		go quizWorker(ctx, i, q)
 	}
 
 	log.Println("[QuizWorker] Workers started in background")
 }
 
 // quizWorker is an individual worker goroutine that processes quiz jobs
-func quizWorker(ctx context.Context, id int, maxRetry int) {
+func quizWorker(ctx context.Context, id int, q *queue.ReliableQueue) {
 	log.Printf("[QuizWorker-%d] Started", id)
 
 	for {
@@ -55,8 +57,8 @@ func quizWorker(ctx context.Context, id int, maxRetry int) {
 			log.Printf("[QuizWorker-%d] Exiting", id)
 			return
 		default:
-			// Dequeue next job with timeout
-			job, err := services.DequeueQuizJob(quizWorkerTimeout)
+			// Reserve next job with timeout
+			job, d, err := services.DequeueQuizJob(quizWorkerTimeout)
 			if err != nil {
 				log.Printf("[QuizWorker-%d] Error dequeuing: %v", id, err)
 				time.Sleep(5 * time.Second)
@@ -69,11 +71,10 @@ func quizWorker(ctx context.Context, id int, maxRetry int) {
 				continue
 			}
 
-			log.Printf("[QuizWorker-%d] Processing job %s for folder %s", id, job.ID, job.FolderID)
+			log.Printf("[QuizWorker-%d] Processing job %s for folder %s (attempt %d)", id, job.ID, job.FolderID, d.Attempts+1)
 
-			// Process the job with retry logic
-			if err := processQuizJobWithRetry(ctx, job, maxRetry); err != nil {
-				log.Printf("[QuizWorker-%d] Job %s failed after %d retries: %v", id, job.ID, maxRetry, err)
+			if err := processQuizDelivery(ctx, q, job, d); err != nil {
+				log.Printf("[QuizWorker-%d] Job %s attempt %d failed: %v", id, job.ID, d.Attempts+1, err)
 			} else {
 				log.Printf("[QuizWorker-%d] Job %s completed successfully", id, job.ID)
 			}
@@ -81,30 +82,29 @@ func quizWorker(ctx context.Context, id int, maxRetry int) {
 	}
 }
 
-// processQuizJobWithRetry processes a single quiz job with retry logic
-func processQuizJobWithRetry(ctx context.Context, job *queue.QuizJob, maxRetry int) error {
-	var lastErr error
-
-	for attempt := 0; attempt <= maxRetry; attempt++ {
-		if attempt > 0 {
-			// Exponential backoff before retry
-			backoff := time.Duration(1<<uint(attempt)) * time.Second
-			log.Printf("[QuizWorker] Retry %d/%d for job %s after %v", attempt, maxRetry, job.ID, backoff)
-			time.Sleep(backoff)
-		}
-
-		// Process the job
-		_, _, err := services.CreateQuizForFolder(ctx, job.FolderID, job.OwnerID, true)
-		if err != nil {
-			lastErr = err
-			log.Printf("[QuizWorker] Job %s attempt %d failed: %v", job.ID, attempt+1, err)
-			continue
+// processQuizDelivery runs a reserved quiz job and acks it, or hands the failure back to the queue
+func processQuizDelivery(ctx context.Context, q *queue.ReliableQueue, job *queue.QuizJob, d *queue.Delivery) error {
+	attempt := d.Attempts + 1
+	services.TrackJob(models.JobTypeQuiz, job.ID, job.OwnerID, job.FolderID, models.JobStateProcessing, attempt, "")
+
+	stop := keepLeaseAlive(ctx, q, d)
+	// Status is updated by CreateQuizForFolder
+	_, _, err := services.CreateQuizForFolder(ctx, job.FolderID, job.OwnerID, true)
+	stop()
+
+	if err != nil {
+		deadLettered, failErr := q.Fail(ctx, d, err)
+		if failErr != nil {
+			log.Printf("[QuizWorker] Failed to record failure for job %s: %v", job.ID, failErr)
+		} else if !deadLettered {
+			services.TrackJob(models.JobTypeQuiz, job.ID, job.OwnerID, job.FolderID, models.JobStateRetrying, attempt, err.Error())
 		}
-
-		// Success - status already updated by CreateQuizForFolder
-		return nil
+		return err
 	}
 
-	// All retries failed - status already updated by CreateQuizForFolder
-	return lastErr
+	if err := q.Ack(ctx, d); err != nil {
+		return err
+	}
+	services.TrackJob(models.JobTypeQuiz, job.ID, job.OwnerID, job.FolderID, models.JobStateCompleted, attempt, "")
+	return nil
 }
@@ -0,0 +1,93 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cogniscan/backend/internal/services"
+)
+
+const (
+	reembedIntervalDefault  = 30 * time.Second
+	reembedBatchSizeDefault = 50
+	// reembedPause spaces out embedding calls so re-embedding does not starve live traffic
+	reembedPause = 200 * time.Millisecond
+)
+
+// StartReembedWorker starts the background re-embedding worker. Every interval it
+// refreshes the embedding versions, re-embeds up to batchSize captions that are
+// behind the target version, activates the pending version once it covers every
+// caption and deletes the chunks of replaced versions after a grace period. Runs on
+// every instance; captions are claimed with leases.
+func StartReembedWorker(ctx context.Context, interval time.Duration, batchSize int) {
+	if services.ActiveEmbeddingVersion() == nil {
+		log.Println("[ReembedWorker] Embedding versions not initialized, worker not started")
+		return
+	}
+	if interval <= 0 {
+		interval = reembedIntervalDefault
+	}
+	if batchSize <= 0 {
+		batchSize = reembedBatchSizeDefault
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("[ReembedWorker] Exiting")
+				return
+			case <-ticker.C:
+				runReembedRound(ctx, interval, batchSize)
+			}
+		}
+	}()
+
+	log.Printf("[ReembedWorker] Started (interval %s, batch size %d)", interval, batchSize)
+}
+
+// runReembedRound re-embeds one batch of outdated captions, then tries the switch-over
+// and clears out versions switched away from
+func runReembedRound(ctx context.Context, interval time.Duration, batchSize int) {
+	if err := services.RefreshEmbeddingVersions(ctx); err != nil {
+		log.Printf("[ReembedWorker] Failed to refresh embedding versions: %v", err)
+		return
+	}
+	if services.GetAIProvider() == nil {
+		return
+	}
+
+	reembedded := 0
+	for i := 0; i < batchSize; i++ {
+		if ctx.Err() != nil {
+			return
+		}
+		found, err := services.ReembedNextCaption(ctx)
+		if err != nil {
+			log.Printf("[ReembedWorker] %v", err)
+		}
+		if !found {
+			break
+		}
+		if err == nil {
+			reembedded++
+		}
+		time.Sleep(reembedPause)
+	}
+	if reembedded > 0 {
+		log.Printf("[ReembedWorker] Re-embedded %d captions", reembedded)
+	}
+
+	if services.PendingEmbeddingVersion() != nil {
+		if _, err := services.ActivatePendingEmbeddingVersion(ctx); err != nil {
+			log.Printf("[ReembedWorker] Failed to activate pending embedding version: %v", err)
+		}
+	}
+	if err := services.DeleteRetiredEmbeddings(ctx, interval); err != nil {
+		log.Printf("[ReembedWorker] %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cogniscan/backend/internal/services"
+)
+
+const (
+	trashPurgeIntervalDefault = time.Hour
+	// trashPurgeBatchSize caps the entries purged per round so one round stays short
+	trashPurgeBatchSize = 100
+)
+
+// StartTrashPurger starts the background worker that permanently deletes trash
+// entries older than the retention window (TRASH_RETENTION), along with their blobs
+// and embeddings. Runs on every instance; entries are claimed with leases.
+func StartTrashPurger(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = trashPurgeIntervalDefault
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			runTrashPurgeRound(ctx)
+			select {
+			case <-ctx.Done():
+				log.Println("[TrashPurger] Exiting")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	log.Printf("[TrashPurger] Started (interval %s, retention %s)", interval, services.TrashRetention())
+}
+
+// runTrashPurgeRound purges expired entries until none are left or a batch is done
+func runTrashPurgeRound(ctx context.Context) {
+	purged, err := services.PurgeExpiredTrash(ctx, trashPurgeBatchSize)
+	if err != nil {
+		log.Printf("[TrashPurger] %v", err)
+	}
+	if purged > 0 {
+		log.Printf("[TrashPurger] Purged %d trash entries", purged)
+	}
+}